@@ -3,40 +3,123 @@ package alerting
 import (
 	"context"
 	"fmt"
+	"log/slog"
+	"sync"
 
 	"github.com/nanzhong/tester"
 	"golang.org/x/sync/errgroup"
 )
 
 type Alert struct {
-	Run  *tester.Run
+	// Run is the run that the failed test was part of, if it is known.
+	Run *tester.Run
+	// Test is the failed test.
 	Test *tester.Test
 
 	BaseURL string
+	// Owner is the owner of the test that the alert is routed to, if any.
+	Owner *Owner
 }
 
 type Alerter interface {
 	Fire(context.Context, *Alert) error
 }
 
+// QuarantineLister lists the quarantined tests, whose failures are not alerted
+// on.
+type QuarantineLister interface {
+	ListQuarantined(ctx context.Context) ([]*tester.QuarantinedTest, error)
+}
+
+// Option is used to configure an AlertManager on creation.
+type Option func(*AlertManager)
+
+// WithAlertBufferSize allows configuring how many alerts can be queued by
+// FireAsync before further alerts are dropped.
+func WithAlertBufferSize(n int) Option {
+	return func(a *AlertManager) {
+		a.bufferSize = n
+	}
+}
+
+// WithQuarantineLister allows configuring where quarantined tests are listed
+// from, so that their failures are not alerted on.
+func WithQuarantineLister(lister QuarantineLister) Option {
+	return func(a *AlertManager) {
+		a.quarantine = lister
+	}
+}
+
+// WithOwners allows configuring the owners of tests that alerts are routed
+// to. Alerts for tests without an owner are routed to the alerters' defaults.
+func WithOwners(owners []*Owner) Option {
+	return func(a *AlertManager) {
+		a.owners = owners
+	}
+}
+
+// WithLogger allows configuring the logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(a *AlertManager) {
+		a.logger = logger
+	}
+}
+
 type AlertManager struct {
 	baseURL  string
 	alerters []Alerter
+
+	bufferSize int
+	queue      chan *Alert
+	quarantine QuarantineLister
+	logger     *slog.Logger
+
+	// ownersMu guards owners, which may be replaced by UpdateOwners.
+	ownersMu sync.RWMutex
+	owners   []*Owner
 }
 
-func NewAlertManager(baseURL string, alerters []Alerter) *AlertManager {
-	return &AlertManager{
-		baseURL:  baseURL,
-		alerters: alerters,
+func NewAlertManager(baseURL string, alerters []Alerter, opts ...Option) *AlertManager {
+	manager := &AlertManager{
+		baseURL:    baseURL,
+		alerters:   alerters,
+		bufferSize: 100,
+		logger:     slog.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(manager)
 	}
+	manager.queue = make(chan *Alert, manager.bufferSize)
+
+	return manager
 }
 
 func (a *AlertManager) RegisterAlerter(alerter Alerter) {
 	a.alerters = append(a.alerters, alerter)
 }
 
+// UpdateOwners replaces the owners of tests that alerts are routed to.
+func (a *AlertManager) UpdateOwners(owners []*Owner) {
+	a.ownersMu.Lock()
+	defer a.ownersMu.Unlock()
+	a.owners = owners
+}
+
+func (a *AlertManager) ownerOf(test *tester.Test) *Owner {
+	a.ownersMu.RLock()
+	defer a.ownersMu.RUnlock()
+	return ownerOf(a.owners, test)
+}
+
 func (a *AlertManager) Fire(ctx context.Context, alert *Alert) error {
+	if a.quarantined(ctx, alert) {
+		a.logger.Info("not alerting on quarantined test", "run_id", alert.Test.RunID, "package", alert.Test.Package, "test", alert.Test.Result.Name)
+		return nil
+	}
+
 	alert.BaseURL = a.baseURL
+	alert.Owner = a.ownerOf(alert.Test)
 
 	var eg errgroup.Group
 	for _, alerter := range a.alerters {
@@ -51,3 +134,59 @@ func (a *AlertManager) Fire(ctx context.Context, alert *Alert) error {
 	}
 	return nil
 }
+
+// quarantined returns whether the alert is for a quarantined test. Alerts are
+// fired if the quarantined tests cannot be listed, since it is better to alert
+// on a quarantined test than to miss alerting on a failure.
+func (a *AlertManager) quarantined(ctx context.Context, alert *Alert) bool {
+	if a.quarantine == nil || alert.Test == nil || alert.Test.Result == nil {
+		return false
+	}
+
+	quarantined, err := a.quarantine.ListQuarantined(ctx)
+	if err != nil {
+		a.logger.Error("failed to list quarantined tests", "err", err)
+		return false
+	}
+	for _, test := range quarantined {
+		if test.Package == alert.Test.Package && test.Name == alert.Test.Result.Name {
+			return true
+		}
+	}
+	return false
+}
+
+// FireAsync queues the alert to be fired by the workers started with
+// StartWorkers. It does not block, and drops the alert if the queue is full.
+func (a *AlertManager) FireAsync(ctx context.Context, alert *Alert) {
+	if len(a.alerters) == 0 {
+		return
+	}
+
+	select {
+	case a.queue <- alert:
+	case <-ctx.Done():
+	default:
+		a.logger.Warn("alert buffer full, dropping alert", "run_id", alert.Test.RunID, "package", alert.Test.Package, "test_id", alert.Test.ID)
+	}
+}
+
+// StartWorkers starts n workers in the background that fire queued alerts
+// until the context is done.
+func (a *AlertManager) StartWorkers(ctx context.Context, n int) {
+	for i := 0; i < n; i++ {
+		go func() {
+			for {
+				select {
+				case alert := <-a.queue:
+					err := a.Fire(ctx, alert)
+					if err != nil {
+						a.logger.Error("failed to fire alert", "run_id", alert.Test.RunID, "package", alert.Test.Package, "err", err)
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+}
@@ -0,0 +1,203 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nanzhong/tester"
+	"github.com/stretchr/testify/assert"
+)
+
+type alerterFunc func(ctx context.Context, alert *Alert) error
+
+func (f alerterFunc) Fire(ctx context.Context, alert *Alert) error {
+	return f(ctx, alert)
+}
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newTestAlert() *Alert {
+	return &Alert{
+		Run:  &tester.Run{ID: uuid.New(), Package: "pkg"},
+		Test: &tester.Test{ID: uuid.New(), Package: "pkg"},
+	}
+}
+
+func TestAlertManager_FireAsync(t *testing.T) {
+	t.Run("workers fire queued alerts", func(t *testing.T) {
+		var (
+			mu    sync.Mutex
+			fired = make(map[uuid.UUID]string)
+		)
+		alerter := alerterFunc(func(ctx context.Context, alert *Alert) error {
+			mu.Lock()
+			defer mu.Unlock()
+			fired[alert.Test.ID] = alert.BaseURL
+			return nil
+		})
+
+		manager := NewAlertManager("http://tester", []Alerter{alerter}, WithAlertBufferSize(10))
+		var alerts []*Alert
+		for i := 0; i < 10; i++ {
+			alert := newTestAlert()
+			alerts = append(alerts, alert)
+			manager.FireAsync(context.Background(), alert)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		manager.StartWorkers(ctx, 2)
+
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(fired) == len(alerts)
+		}, time.Second, 10*time.Millisecond)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, alert := range alerts {
+			assert.Equal(t, "http://tester", fired[alert.Test.ID])
+		}
+	})
+
+	t.Run("full buffer drops alerts", func(t *testing.T) {
+		var (
+			logs  lockedBuffer
+			mu    sync.Mutex
+			fired []uuid.UUID
+		)
+		alerter := alerterFunc(func(ctx context.Context, alert *Alert) error {
+			mu.Lock()
+			defer mu.Unlock()
+			fired = append(fired, alert.Test.ID)
+			return nil
+		})
+
+		manager := NewAlertManager(
+			"http://tester",
+			[]Alerter{alerter},
+			WithAlertBufferSize(1),
+			WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		)
+		queued, dropped := newTestAlert(), newTestAlert()
+		manager.FireAsync(context.Background(), queued)
+		manager.FireAsync(context.Background(), dropped)
+		assert.True(t, strings.Contains(logs.String(), "alert buffer full"))
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		manager.StartWorkers(ctx, 1)
+
+		assert.Eventually(t, func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(fired) == 1
+		}, time.Second, 10*time.Millisecond)
+
+		// Give the worker a chance to (incorrectly) fire the dropped alert.
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []uuid.UUID{queued.Test.ID}, fired)
+	})
+}
+
+type quarantineListerFunc func(ctx context.Context) ([]*tester.QuarantinedTest, error)
+
+func (f quarantineListerFunc) ListQuarantined(ctx context.Context) ([]*tester.QuarantinedTest, error) {
+	return f(ctx)
+}
+
+func TestAlertManager_Fire_quarantined(t *testing.T) {
+	newAlert := func(pkg, name string) *Alert {
+		return &Alert{
+			Run: &tester.Run{ID: uuid.New(), Package: pkg},
+			Test: &tester.Test{
+				ID:      uuid.New(),
+				Package: pkg,
+				Result:  &tester.T{TB: tester.TB{Name: name, State: tester.TBStateFailed}},
+			},
+		}
+	}
+
+	for _, tc := range []struct {
+		name        string
+		lister      quarantineListerFunc
+		alert       *Alert
+		expectFired bool
+	}{
+		{
+			name: "quarantined",
+			lister: func(context.Context) ([]*tester.QuarantinedTest, error) {
+				return []*tester.QuarantinedTest{{Package: "pkg", Name: "TestA"}}, nil
+			},
+			alert: newAlert("pkg", "TestA"),
+		},
+		{
+			name: "same name in other package",
+			lister: func(context.Context) ([]*tester.QuarantinedTest, error) {
+				return []*tester.QuarantinedTest{{Package: "other", Name: "TestA"}}, nil
+			},
+			alert:       newAlert("pkg", "TestA"),
+			expectFired: true,
+		},
+		{
+			name: "not quarantined",
+			lister: func(context.Context) ([]*tester.QuarantinedTest, error) {
+				return []*tester.QuarantinedTest{{Package: "pkg", Name: "TestB"}}, nil
+			},
+			alert:       newAlert("pkg", "TestA"),
+			expectFired: true,
+		},
+		{
+			name: "listing fails",
+			lister: func(context.Context) ([]*tester.QuarantinedTest, error) {
+				return nil, errors.New("boom")
+			},
+			alert:       newAlert("pkg", "TestA"),
+			expectFired: true,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var fired bool
+			alerter := alerterFunc(func(ctx context.Context, alert *Alert) error {
+				fired = true
+				return nil
+			})
+
+			manager := NewAlertManager(
+				"http://tester",
+				[]Alerter{alerter},
+				WithQuarantineLister(tc.lister),
+				WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))),
+			)
+			assert.NoError(t, manager.Fire(context.Background(), tc.alert))
+			assert.Equal(t, tc.expectFired, fired)
+		})
+	}
+}
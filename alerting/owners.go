@@ -0,0 +1,52 @@
+package alerting
+
+import (
+	"strings"
+
+	"github.com/nanzhong/tester"
+)
+
+// Owner is the owner of a set of tests, which alerts for failures of those
+// tests are routed to. An owner owns the tests of its package whose names
+// begin with its test prefix. Either may be empty to match any package or
+// test.
+type Owner struct {
+	Name       string `json:"name"`
+	Package    string `json:"package,omitempty"`
+	TestPrefix string `json:"test_prefix,omitempty"`
+	// SlackChannels are the channels that slack alerts are sent to instead
+	// of the package's channels.
+	SlackChannels []string `json:"slack_channels,omitempty"`
+}
+
+func (o *Owner) matches(test *tester.Test) bool {
+	if o.Package != "" && o.Package != test.Package {
+		return false
+	}
+	return strings.HasPrefix(test.Result.Name, o.TestPrefix)
+}
+
+// moreSpecific returns whether o is more specific than other. Owners with a
+// longer test prefix are more specific, followed by owners of a package.
+func (o *Owner) moreSpecific(other *Owner) bool {
+	if len(o.TestPrefix) != len(other.TestPrefix) {
+		return len(o.TestPrefix) > len(other.TestPrefix)
+	}
+	return o.Package != "" && other.Package == ""
+}
+
+// ownerOf returns the most specific of the owners that owns the test, or nil
+// if none do. The first of equally specific owners is returned.
+func ownerOf(owners []*Owner, test *tester.Test) *Owner {
+	if test == nil || test.Result == nil {
+		return nil
+	}
+
+	var owner *Owner
+	for _, o := range owners {
+		if o.matches(test) && (owner == nil || o.moreSpecific(owner)) {
+			owner = o
+		}
+	}
+	return owner
+}
@@ -0,0 +1,145 @@
+package alerting
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nanzhong/tester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOwnerOf(t *testing.T) {
+	var (
+		pkgOwner      = &Owner{Name: "pkg", Package: "pkg"}
+		prefixOwner   = &Owner{Name: "prefix", TestPrefix: "TestPayments"}
+		pkgPrefix     = &Owner{Name: "pkg-prefix", Package: "pkg", TestPrefix: "TestPayments"}
+		longerPrefix  = &Owner{Name: "longer-prefix", TestPrefix: "TestPaymentsRefund"}
+		otherPkgOwner = &Owner{Name: "other", Package: "other", TestPrefix: "TestPaymentsRefundPartial"}
+		owners        = []*Owner{pkgOwner, prefixOwner, pkgPrefix, longerPrefix, otherPkgOwner}
+	)
+	newTest := func(pkg, name string) *tester.Test {
+		return &tester.Test{Package: pkg, Result: &tester.T{TB: tester.TB{Name: name}}}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		owners []*Owner
+		test   *tester.Test
+		owner  *Owner
+	}{
+		{
+			name:   "package",
+			owners: owners,
+			test:   newTest("pkg", "TestA"),
+			owner:  pkgOwner,
+		},
+		{
+			name:   "prefix",
+			owners: owners,
+			test:   newTest("shared", "TestPaymentsCharge"),
+			owner:  prefixOwner,
+		},
+		{
+			name:   "package and prefix over prefix",
+			owners: owners,
+			test:   newTest("pkg", "TestPaymentsCharge"),
+			owner:  pkgPrefix,
+		},
+		{
+			name:   "longest prefix",
+			owners: owners,
+			test:   newTest("pkg", "TestPaymentsRefundPartial"),
+			owner:  longerPrefix,
+		},
+		{
+			name:   "subtest",
+			owners: owners,
+			test:   newTest("shared", "TestPaymentsRefund/partial"),
+			owner:  longerPrefix,
+		},
+		{
+			name:   "first of equally specific",
+			owners: []*Owner{{Name: "first", Package: "pkg"}, {Name: "second", Package: "pkg"}},
+			test:   newTest("pkg", "TestA"),
+			owner:  &Owner{Name: "first", Package: "pkg"},
+		},
+		{
+			name:   "no match",
+			owners: owners,
+			test:   newTest("shared", "TestA"),
+		},
+		{
+			name:  "no owners",
+			test:  newTest("pkg", "TestA"),
+			owner: nil,
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.owner, ownerOf(tc.owners, tc.test))
+		})
+	}
+}
+
+func TestAlertManager_Fire_owner(t *testing.T) {
+	owner := &Owner{Name: "payments", Package: "pkg", TestPrefix: "TestPayments", SlackChannels: []string{"payments"}}
+
+	for _, tc := range []struct {
+		name  string
+		test  string
+		owner *Owner
+	}{
+		{name: "owned", test: "TestPaymentsCharge", owner: owner},
+		{name: "default", test: "TestA"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var fired *Alert
+			alerter := alerterFunc(func(ctx context.Context, alert *Alert) error {
+				fired = alert
+				return nil
+			})
+			manager := NewAlertManager("http://tester", []Alerter{alerter}, WithOwners([]*Owner{owner}))
+
+			err := manager.Fire(context.Background(), &Alert{
+				Run: &tester.Run{ID: uuid.New(), Package: "pkg"},
+				Test: &tester.Test{
+					ID:      uuid.New(),
+					Package: "pkg",
+					Result:  &tester.T{TB: tester.TB{Name: tc.test, State: tester.TBStateFailed}},
+				},
+			})
+			require.NoError(t, err)
+			require.NotNil(t, fired)
+			assert.Equal(t, tc.owner, fired.Owner)
+		})
+	}
+}
+
+func TestAlertManager_UpdateOwners(t *testing.T) {
+	var fired *Alert
+	alerter := alerterFunc(func(ctx context.Context, alert *Alert) error {
+		fired = alert
+		return nil
+	})
+	manager := NewAlertManager("http://tester", []Alerter{alerter})
+
+	alert := func() *Alert {
+		return &Alert{
+			Test: &tester.Test{
+				ID:      uuid.New(),
+				Package: "pkg",
+				Result:  &tester.T{TB: tester.TB{Name: "TestPaymentsCharge", State: tester.TBStateFailed}},
+			},
+		}
+	}
+	require.NoError(t, manager.Fire(context.Background(), alert()))
+	assert.Nil(t, fired.Owner)
+
+	owner := &Owner{Name: "payments", TestPrefix: "TestPayments"}
+	manager.UpdateOwners([]*Owner{owner})
+	require.NoError(t, manager.Fire(context.Background(), alert()))
+	assert.Equal(t, owner, fired.Owner)
+}
@@ -0,0 +1,161 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const defaultPagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// PDSeverity is the severity of a PagerDuty event.
+type PDSeverity string
+
+const (
+	// PDSeverityCritical is the critical PagerDuty event severity.
+	PDSeverityCritical PDSeverity = "critical"
+	// PDSeverityError is the error PagerDuty event severity.
+	PDSeverityError PDSeverity = "error"
+	// PDSeverityWarning is the warning PagerDuty event severity.
+	PDSeverityWarning PDSeverity = "warning"
+	// PDSeverityInfo is the info PagerDuty event severity.
+	PDSeverityInfo PDSeverity = "info"
+)
+
+// PDOption is used to configure a PagerDutyAlerter on creation.
+type PDOption func(*PagerDutyAlerter)
+
+// WithPDSeverity allows configuring the severity of triggered events.
+func WithPDSeverity(severity PDSeverity) PDOption {
+	return func(a *PagerDutyAlerter) {
+		a.severity = severity
+	}
+}
+
+// WithPDClient allows configuring the http client used to send events.
+func WithPDClient(client *http.Client) PDOption {
+	return func(a *PagerDutyAlerter) {
+		a.client = client
+	}
+}
+
+// WithPDClientURL allows configuring the url events are sent to.
+func WithPDClientURL(url string) PDOption {
+	return func(a *PagerDutyAlerter) {
+		a.url = url
+	}
+}
+
+// PagerDutyAlerter is an Alerter that triggers PagerDuty events using the
+// Events v2 API.
+type PagerDutyAlerter struct {
+	integrationKey string
+	severity       PDSeverity
+	client         *http.Client
+	url            string
+}
+
+var _ Alerter = (*PagerDutyAlerter)(nil)
+
+// NewPagerDutyAlerter constructs a new PagerDutyAlerter that triggers events
+// for the integration with the given key.
+func NewPagerDutyAlerter(integrationKey string, opts ...PDOption) *PagerDutyAlerter {
+	alerter := &PagerDutyAlerter{
+		integrationKey: integrationKey,
+		severity:       PDSeverityError,
+		client:         http.DefaultClient,
+		url:            defaultPagerDutyEventsURL,
+	}
+
+	for _, opt := range opts {
+		opt(alerter)
+	}
+
+	return alerter
+}
+
+type pdEvent struct {
+	RoutingKey  string    `json:"routing_key"`
+	EventAction string    `json:"event_action"`
+	DedupKey    string    `json:"dedup_key"`
+	Payload     pdPayload `json:"payload"`
+	Links       []pdLink  `json:"links,omitempty"`
+}
+
+type pdPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      PDSeverity        `json:"severity"`
+	Timestamp     string            `json:"timestamp,omitempty"`
+	Component     string            `json:"component"`
+	CustomDetails map[string]string `json:"custom_details,omitempty"`
+}
+
+type pdLink struct {
+	Href string `json:"href"`
+	Text string `json:"text"`
+}
+
+// Fire triggers a PagerDuty event for the alert.
+func (a *PagerDutyAlerter) Fire(ctx context.Context, alert *Alert) error {
+	testLink := fmt.Sprintf("%s/tests/%s", alert.BaseURL, alert.Test.ID)
+
+	event := pdEvent{
+		RoutingKey:  a.integrationKey,
+		EventAction: "trigger",
+		DedupKey:    alert.Test.ID.String(),
+		Payload: pdPayload{
+			Summary:   fmt.Sprintf("%s failed in %s", alert.Test.Result.Name, alert.Test.Package),
+			Source:    "tester",
+			Severity:  a.severity,
+			Component: alert.Test.Package,
+			CustomDetails: map[string]string{
+				"test_id": alert.Test.ID.String(),
+			},
+		},
+		Links: []pdLink{{
+			Href: testLink,
+			Text: alert.Test.Result.Name,
+		}},
+	}
+	if !alert.Test.Result.FinishedAt.IsZero() {
+		event.Payload.Timestamp = alert.Test.Result.FinishedAt.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	}
+	if alert.Run != nil {
+		event.Payload.CustomDetails["run_id"] = alert.Run.ID.String()
+		if len(alert.Run.Args) > 0 {
+			event.Payload.CustomDetails["args"] = strings.Join(alert.Run.Args, " ")
+		}
+	}
+	if alert.Test.Result.ErrorMessage != "" {
+		event.Payload.CustomDetails["error"] = alert.Test.Result.ErrorMessage
+	}
+	if alert.Owner != nil {
+		event.Payload.CustomDetails["owner"] = alert.Owner.Name
+	}
+
+	body, err := json.Marshal(&event)
+	if err != nil {
+		return fmt.Errorf("marshaling pagerduty event: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("constructing pagerduty request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending pagerduty event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("received unexpected status code sending pagerduty event: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package alerting
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nanzhong/tester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPagerDutyAlerter_Fire(t *testing.T) {
+	finishedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	alert := &Alert{
+		Run: &tester.Run{
+			ID:      uuid.New(),
+			Package: "pkg",
+			Args:    []string{"-test.run=TestA", "-test.v"},
+		},
+		Test: &tester.Test{
+			ID:      uuid.New(),
+			Package: "pkg",
+			Result: &tester.T{
+				TB: tester.TB{
+					Name:         "TestA",
+					FinishedAt:   finishedAt,
+					State:        tester.TBStateFailed,
+					ErrorMessage: "a_test.go:10: boom",
+				},
+			},
+		},
+		BaseURL: "http://tester",
+		Owner:   &Owner{Name: "payments"},
+	}
+
+	t.Run("triggers event", func(t *testing.T) {
+		var event pdEvent
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, http.MethodPost, r.Method)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&event))
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		defer ts.Close()
+
+		alerter := NewPagerDutyAlerter(
+			"key",
+			WithPDClient(ts.Client()),
+			WithPDClientURL(ts.URL),
+			WithPDSeverity(PDSeverityCritical),
+		)
+		err := alerter.Fire(context.Background(), alert)
+		require.NoError(t, err)
+
+		assert.Equal(t, pdEvent{
+			RoutingKey:  "key",
+			EventAction: "trigger",
+			DedupKey:    alert.Test.ID.String(),
+			Payload: pdPayload{
+				Summary:   "TestA failed in pkg",
+				Source:    "tester",
+				Severity:  PDSeverityCritical,
+				Timestamp: "2020-01-01T00:00:00.000Z",
+				Component: "pkg",
+				CustomDetails: map[string]string{
+					"run_id":  alert.Run.ID.String(),
+					"args":    "-test.run=TestA -test.v",
+					"test_id": alert.Test.ID.String(),
+					"error":   "a_test.go:10: boom",
+					"owner":   "payments",
+				},
+			},
+			Links: []pdLink{{
+				Href: "http://tester/tests/" + alert.Test.ID.String(),
+				Text: "TestA",
+			}},
+		}, event)
+	})
+
+	t.Run("unexpected status", func(t *testing.T) {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+		defer ts.Close()
+
+		alerter := NewPagerDutyAlerter("key", WithPDClient(ts.Client()), WithPDClientURL(ts.URL))
+		err := alerter.Fire(context.Background(), alert)
+		require.Error(t, err)
+	})
+}
@@ -1,11 +1,113 @@
 package main
 
-import "github.com/nanzhong/tester"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/alerting"
+	testerhttp "github.com/nanzhong/tester/http"
+)
 
 type config struct {
+	// Defaults are merged into each of the packages when the config is loaded.
+	Defaults  *packageDefaults  `json:"defaults,omitempty"`
 	Packages  []*tester.Package `json:"packages"`
 	Scheduler *schedulerConfig  `json:"scheduler"`
 	Slack     *slackConfig      `json:"slack"`
+	UI        *uiConfig         `json:"ui,omitempty"`
+	Metrics   *metricsConfig    `json:"metrics,omitempty"`
+	// Owners are the owners of tests that alerts for their failures are
+	// routed to.
+	Owners []*alerting.Owner `json:"owners,omitempty"`
+	// CORS enables CORS for the API if set.
+	CORS *corsConfig `json:"cors,omitempty"`
+
+	// packageDefinitions are the packages as configured, before their sha256
+	// sums are verified, which seed the packages stored in the db.
+	packageDefinitions []*tester.Package
+}
+
+// packageDefaults are the package fields that can be shared by all packages.
+// A package's own fields are merged with the defaults as follows:
+//   - run_delay, enabled, race and gorace: the package's value replaces the
+//     default if the package sets the field, even to its zero value.
+//   - options: merged by name, a package option replaces the default option of
+//     the same name and the package's other options follow the defaults.
+//   - labels: merged by key, the package's value replaces the default.
+type packageDefaults struct {
+	RunDelay time.Duration   `json:"run_delay,omitempty"`
+	Options  []tester.Option `json:"options,omitempty"`
+	Enabled  *bool           `json:"enabled,omitempty"`
+	Labels   tester.Labels   `json:"labels,omitempty"`
+	Race     bool            `json:"race,omitempty"`
+	GORACE   string          `json:"gorace,omitempty"`
+}
+
+// apply returns the package decoded from data, merged with the defaults.
+func (d *packageDefaults) apply(data json.RawMessage) (*tester.Package, error) {
+	if d == nil {
+		var pkg tester.Package
+		if err := json.Unmarshal(data, &pkg); err != nil {
+			return nil, err
+		}
+		return &pkg, nil
+	}
+
+	// Decoding the package over the default scalars leaves the defaults in
+	// place only for the fields the package does not set.
+	pkg := tester.Package{
+		RunDelay: d.RunDelay,
+		Race:     d.Race,
+		GORACE:   d.GORACE,
+	}
+	if d.Enabled != nil {
+		enabled := *d.Enabled
+		pkg.Enabled = &enabled
+	}
+	if err := json.Unmarshal(data, &pkg); err != nil {
+		return nil, err
+	}
+
+	if len(d.Options) > 0 {
+		options := append([]tester.Option{}, d.Options...)
+		for _, option := range pkg.Options {
+			replaced := false
+			for i := range options {
+				if options[i].Name == option.Name {
+					options[i] = option
+					replaced = true
+					break
+				}
+			}
+			if !replaced {
+				options = append(options, option)
+			}
+		}
+		pkg.Options = options
+	}
+
+	if len(d.Labels) > 0 {
+		labels := make(tester.Labels, len(d.Labels)+len(pkg.Labels))
+		for key, value := range d.Labels {
+			labels[key] = value
+		}
+		for key, value := range pkg.Labels {
+			labels[key] = value
+		}
+		pkg.Labels = labels
+	}
+	return &pkg, nil
 }
 
 type schedulerConfig struct {
@@ -16,3 +118,429 @@ type slackConfig struct {
 	DefaultChannels []string            `json:"default_channels"`
 	CustomChannels  map[string][]string `json:"custom_channels"`
 }
+
+// metricsConfig configures the prometheus metrics that are exported.
+type metricsConfig struct {
+	// RunDurationBuckets are the buckets of the run duration histogram in
+	// seconds.
+	RunDurationBuckets []float64 `json:"run_duration_buckets,omitempty"`
+}
+
+// corsConfig configures which origins browsers may call the API from.
+type corsConfig struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+	AllowedHeaders []string `json:"allowed_headers,omitempty"`
+}
+
+// httpConfig returns the CORS config for the http package.
+func (c *corsConfig) httpConfig() testerhttp.CORSConfig {
+	return testerhttp.CORSConfig{
+		AllowedOrigins: c.AllowedOrigins,
+		AllowedMethods: c.AllowedMethods,
+		AllowedHeaders: c.AllowedHeaders,
+	}
+}
+
+// validate checks that origins are either * or a scheme and host, which is
+// what browsers send in the Origin header.
+func (c *corsConfig) validate() error {
+	if len(c.AllowedOrigins) == 0 {
+		return errors.New("missing allowed origins")
+	}
+	var errs []error
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs = append(errs, fmt.Errorf("invalid allowed origin %s", origin))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// uiConfig configures what the UI presents. Durations are strings parsed by
+// time.ParseDuration, and fields that are not set keep their defaults.
+type uiConfig struct {
+	RunsPerPage    int                  `json:"runs_per_page,omitempty"`
+	HourSummaries  *summaryWindowConfig `json:"hour_summaries,omitempty"`
+	DaySummaries   *summaryWindowConfig `json:"day_summaries,omitempty"`
+	MonthSummaries *summaryWindowConfig `json:"month_summaries,omitempty"`
+	// SecurityHeaders overrides the security headers set on UI responses.
+	SecurityHeaders *securityHeadersConfig `json:"security_headers,omitempty"`
+}
+
+type securityHeadersConfig struct {
+	ContentSecurityPolicy string `json:"content_security_policy,omitempty"`
+	FrameOptions          string `json:"frame_options,omitempty"`
+	ReferrerPolicy        string `json:"referrer_policy,omitempty"`
+}
+
+type summaryWindowConfig struct {
+	Range  string `json:"range"`
+	Bucket string `json:"bucket"`
+}
+
+// httpConfig returns the UI config for the http package.
+func (c *uiConfig) httpConfig() (testerhttp.UIConfig, error) {
+	cfg := testerhttp.UIConfig{RunsPerPage: c.RunsPerPage}
+	if h := c.SecurityHeaders; h != nil {
+		cfg.SecurityHeaders = testerhttp.SecurityHeaders{
+			ContentSecurityPolicy: h.ContentSecurityPolicy,
+			FrameOptions:          h.FrameOptions,
+			ReferrerPolicy:        h.ReferrerPolicy,
+		}
+	}
+	var errs []error
+	for _, w := range []struct {
+		name   string
+		config *summaryWindowConfig
+		window *testerhttp.SummaryWindow
+	}{
+		{"hour", c.HourSummaries, &cfg.HourSummaries},
+		{"day", c.DaySummaries, &cfg.DaySummaries},
+		{"month", c.MonthSummaries, &cfg.MonthSummaries},
+	} {
+		if w.config == nil {
+			continue
+		}
+		var err error
+		if w.window.Range, err = time.ParseDuration(w.config.Range); err != nil {
+			errs = append(errs, fmt.Errorf("%s summaries: invalid range: %w", w.name, err))
+		}
+		if w.window.Bucket, err = time.ParseDuration(w.config.Bucket); err != nil {
+			errs = append(errs, fmt.Errorf("%s summaries: invalid bucket: %w", w.name, err))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return testerhttp.UIConfig{}, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// Validate checks the config for mistakes that would otherwise only surface
+// once the server is running, returning all of the problems found.
+func (c *config) Validate() []error {
+	var errs []error
+
+	names := make(map[string]struct{}, len(c.Packages))
+	for i, pkg := range c.Packages {
+		if pkg.Name == "" {
+			errs = append(errs, fmt.Errorf("package %d: missing name", i))
+		} else if _, ok := names[pkg.Name]; ok {
+			errs = append(errs, fmt.Errorf("package %s: duplicate name", pkg.Name))
+		}
+		names[pkg.Name] = struct{}{}
+
+		name := pkg.Name
+		if name == "" {
+			name = strconv.Itoa(i)
+		}
+		// The path is unused by packages with variants, which run the
+		// variants' test binaries instead.
+		if len(pkg.Variants) == 0 || pkg.Path != "" {
+			if err := validatePath(pkg.Path); err != nil {
+				errs = append(errs, fmt.Errorf("package %s: %w", name, err))
+			}
+		}
+		variants := make(map[string]struct{}, len(pkg.Variants))
+		for j, variant := range pkg.Variants {
+			if variant.Name == "" {
+				errs = append(errs, fmt.Errorf("package %s variant %d: missing name", name, j))
+				continue
+			}
+			if _, ok := variants[variant.Name]; ok {
+				errs = append(errs, fmt.Errorf("package %s variant %s: duplicate name", name, variant.Name))
+			}
+			variants[variant.Name] = struct{}{}
+			if err := validatePath(variant.Path); err != nil {
+				errs = append(errs, fmt.Errorf("package %s variant %s: %w", name, variant.Name, err))
+			}
+		}
+		if len(pkg.Variants) > 0 {
+			for _, option := range pkg.Options {
+				if option.Name == "variant" {
+					errs = append(errs, fmt.Errorf("package %s: option variant is reserved for packages with variants", name))
+				}
+			}
+		}
+		envVars := make(map[string]struct{}, len(pkg.Environment))
+		for j, v := range pkg.Environment {
+			if v.Name == "" || strings.Contains(v.Name, "=") {
+				errs = append(errs, fmt.Errorf("package %s env var %d: invalid name %q", name, j, v.Name))
+				continue
+			}
+			if _, ok := envVars[v.Name]; ok {
+				errs = append(errs, fmt.Errorf("package %s env var %s: duplicate name", name, v.Name))
+			}
+			envVars[v.Name] = struct{}{}
+		}
+		if pkg.RunDelay < 0 {
+			errs = append(errs, fmt.Errorf("package %s: negative run delay", name))
+		}
+		if pkg.Count < 0 {
+			errs = append(errs, fmt.Errorf("package %s: negative count", name))
+		}
+		if err := pkg.ValidateArgs(pkg.DefaultArgs()); err != nil {
+			errs = append(errs, fmt.Errorf("package %s: invalid option defaults: %w", name, err))
+		}
+	}
+
+	if c.Scheduler != nil && c.Scheduler.RunTimeout != "" {
+		timeout, err := time.ParseDuration(c.Scheduler.RunTimeout)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("scheduler: invalid run timeout: %w", err))
+		} else if timeout <= 0 {
+			errs = append(errs, fmt.Errorf("scheduler: run timeout must be positive"))
+		}
+	}
+
+	if c.UI != nil {
+		if _, err := c.UI.httpConfig(); err != nil {
+			errs = append(errs, fmt.Errorf("ui: %w", err))
+		}
+	}
+
+	if c.Metrics != nil && c.Metrics.RunDurationBuckets != nil {
+		if err := testerhttp.ValidateRunDurationBuckets(c.Metrics.RunDurationBuckets); err != nil {
+			errs = append(errs, fmt.Errorf("metrics: invalid run duration buckets: %w", err))
+		}
+	}
+
+	for i, owner := range c.Owners {
+		name := owner.Name
+		if name == "" {
+			errs = append(errs, fmt.Errorf("owner %d: missing name", i))
+			name = strconv.Itoa(i)
+		}
+		if owner.Package == "" && owner.TestPrefix == "" {
+			errs = append(errs, fmt.Errorf("owner %s: missing package or test prefix", name))
+		} else if _, ok := names[owner.Package]; owner.Package != "" && !ok {
+			errs = append(errs, fmt.Errorf("owner %s: unknown package %s", name, owner.Package))
+		}
+	}
+
+	if c.CORS != nil {
+		if err := c.CORS.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("cors: %w", err))
+		}
+	}
+
+	if c.Slack != nil {
+		for pkg := range c.Slack.CustomChannels {
+			if _, ok := names[pkg]; !ok {
+				errs = append(errs, fmt.Errorf("slack: custom channels for unknown package %s", pkg))
+			}
+		}
+	}
+	return errs
+}
+
+// invalidConfigError is returned when loading a config that fails to
+// validate. It keeps the problems found by Validate so that each of them can
+// be logged.
+type invalidConfigError struct {
+	path string
+	errs []error
+}
+
+func (e *invalidConfigError) Error() string {
+	return fmt.Sprintf("invalid config (%s):\n%s", e.path, errors.Join(e.errs...))
+}
+
+// configErrors returns the problems found in the config if err is an
+// invalidConfigError, or err itself otherwise.
+func configErrors(err error) []error {
+	var invalid *invalidConfigError
+	if errors.As(err, &invalid) {
+		return invalid.errs
+	}
+	return []error{err}
+}
+
+// validatePath checks that path is an absolute path to an existing file.
+func validatePath(path string) error {
+	if path == "" {
+		return errors.New("missing path")
+	}
+	if !filepath.IsAbs(path) {
+		return fmt.Errorf("path (%s) is not absolute", path)
+	}
+	finfo, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("invalid path: %w", err)
+	}
+	if finfo.IsDir() {
+		return fmt.Errorf("path (%s) is a directory", path)
+	}
+	return nil
+}
+
+// loadConfig reads and validates the config at path and verifies the sha256
+// sums of its packages. When skipSHA256Check is set, mismatched sha256 sums
+// are logged rather than failing, and the test binaries' actual sha256 sums
+// are used instead.
+func loadConfig(path string, skipSHA256Check bool) (*config, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("config (%s) does not exist", path)
+		}
+		return nil, fmt.Errorf("failed to read config (%s): %w", path, err)
+	}
+	defer file.Close()
+
+	// Packages are decoded separately so that the defaults can be applied.
+	var raw struct {
+		config
+		Packages []json.RawMessage `json:"packages"`
+	}
+	err = json.NewDecoder(file).Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config (%s): %w", path, err)
+	}
+
+	cfg := raw.config
+	for i, data := range raw.Packages {
+		pkg, err := cfg.Defaults.apply(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse config (%s) package %d: %w", path, i, err)
+		}
+		cfg.Packages = append(cfg.Packages, pkg)
+	}
+	if errs := cfg.Validate(); len(errs) > 0 {
+		return nil, &invalidConfigError{path: path, errs: errs}
+	}
+
+	for _, pkg := range cfg.Packages {
+		cfg.packageDefinitions = append(cfg.packageDefinitions, copyPackage(pkg))
+	}
+	for _, pkg := range cfg.Packages {
+		if err := verifySHA256Sums(pkg, skipSHA256Check, (*tester.Package).ComputeSHA256Sum); err != nil {
+			return nil, err
+		}
+	}
+	return &cfg, nil
+}
+
+// copyPackage returns a copy of the package that can be modified without
+// affecting it.
+func copyPackage(pkg *tester.Package) *tester.Package {
+	c := *pkg
+	c.Variants = append([]tester.Variant(nil), pkg.Variants...)
+	c.Environment = append([]tester.EnvVar(nil), pkg.Environment...)
+	return &c
+}
+
+// verifySHA256Sums sets the sha256 sums of the package's test binaries, or of
+// its variants' if it has any, after verifying them with verifySHA256Sum.
+// Sums are computed with compute.
+func verifySHA256Sums(pkg *tester.Package, skipMismatch bool, compute func(*tester.Package) (string, error)) error {
+	if len(pkg.Variants) == 0 {
+		var err error
+		pkg.SHA256Sum, err = verifySHA256Sum(pkg, pkg.Name, skipMismatch, compute)
+		return err
+	}
+
+	for i, variant := range pkg.Variants {
+		variantPkg, err := pkg.Variant(variant.Name)
+		if err != nil {
+			return err
+		}
+		pkg.Variants[i].SHA256Sum, err = verifySHA256Sum(variantPkg, fmt.Sprintf("%s variant %s", pkg.Name, variant.Name), skipMismatch, compute)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifySHA256Sum returns the sha256 sum of the package's test binary after
+// verifying that it matches the configured sha256 sum, if there is one. A
+// mismatch is only logged if skipMismatch is set.
+func verifySHA256Sum(pkg *tester.Package, name string, skipMismatch bool, compute func(*tester.Package) (string, error)) (string, error) {
+	sha256Sum, err := compute(pkg)
+	if err != nil {
+		return "", fmt.Errorf("failed to verify package %s (%s): %w", name, pkg.Path, err)
+	}
+	if pkg.SHA256Sum != "" && pkg.SHA256Sum != sha256Sum {
+		if skipMismatch {
+			log.Printf("skipping sha256 check, package %s (%s) does not match the configured sha256 sum: %s (expected) != %s (actual)", name, pkg.Path, pkg.SHA256Sum, sha256Sum)
+			return sha256Sum, nil
+		}
+		return "", fmt.Errorf("package %s (%s) does not match the configured sha256 sum: %s (expected) != %s (actual)", name, pkg.Path, pkg.SHA256Sum, sha256Sum)
+	}
+	return sha256Sum, nil
+}
+
+// mergePackages returns the updated packages along with any current packages
+// that are no longer configured. Removed packages are kept, but disabled, so
+// that their history remains accessible.
+func mergePackages(current, updated []*tester.Package) []*tester.Package {
+	configured := make(map[string]struct{}, len(updated))
+	for _, pkg := range updated {
+		configured[pkg.Name] = struct{}{}
+	}
+
+	merged := append([]*tester.Package{}, updated...)
+	for _, pkg := range current {
+		if _, ok := configured[pkg.Name]; ok {
+			continue
+		}
+
+		removed := *pkg
+		enabled := false
+		removed.Enabled = &enabled
+		merged = append(merged, &removed)
+	}
+	return merged
+}
+
+// watchConfig watches the config at path and calls reload with the updated
+// config whenever it changes, until the context is done. Configs that fail to
+// load are logged and ignored. skipSHA256Check is passed through to
+// loadConfig.
+func watchConfig(ctx context.Context, path string, skipSHA256Check bool, reload func(cfg *config)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating config watcher: %w", err)
+	}
+
+	// Watch the parent directory so that changes made by replacing the file
+	// (e.g. editors and config management writing atomically) are observed.
+	path = filepath.Clean(path)
+	err = watcher.Add(filepath.Dir(path))
+	if err != nil {
+		watcher.Close()
+		return fmt.Errorf("watching config (%s): %w", path, err)
+	}
+
+	go func() {
+		defer watcher.Close()
+
+		for {
+			select {
+			case event := <-watcher.Events:
+				if filepath.Clean(event.Name) != path ||
+					event.Op&(fsnotify.Write|fsnotify.Create) == 0 {
+					continue
+				}
+
+				cfg, err := loadConfig(path, skipSHA256Check)
+				if err != nil {
+					log.Printf("failed to reload config: %s", err)
+					continue
+				}
+				log.Printf("reloaded config (%s)", path)
+				reload(cfg)
+			case err := <-watcher.Errors:
+				log.Printf("config watcher error: %s", err)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
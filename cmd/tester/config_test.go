@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/alerting"
+	testerhttp "github.com/nanzhong/tester/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfig(t *testing.T, path string, cfg *config) {
+	data, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+}
+
+func TestLoadConfig_Defaults(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.test", "b.test", "c.test"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0755))
+	}
+
+	configPath := filepath.Join(dir, "config.json")
+	require.NoError(t, ioutil.WriteFile(configPath, []byte(`{
+  "defaults": {
+    "run_delay": 60000000000,
+    "race": true,
+    "labels": {"team": "core", "env": "staging"},
+    "options": [
+      {"name": "test.timeout", "default": "5m"},
+      {"name": "env", "default": "staging"}
+    ]
+  },
+  "packages": [
+    {"name": "a", "path": "`+filepath.Join(dir, "a.test")+`"},
+    {
+      "name": "b",
+      "path": "`+filepath.Join(dir, "b.test")+`",
+      "run_delay": 120000000000,
+      "race": false,
+      "enabled": false,
+      "labels": {"env": "prod"},
+      "options": [
+        {"name": "env", "default": "prod"},
+        {"name": "test.run", "default": "TestB"}
+      ]
+    }
+  ]
+}`), 0644))
+
+	cfg, err := loadConfig(configPath, false)
+	require.NoError(t, err)
+	require.Len(t, cfg.Packages, 2)
+
+	a := cfg.Packages[0]
+	assert.Equal(t, time.Minute, a.RunDelay)
+	assert.True(t, a.Race)
+	assert.True(t, a.IsEnabled())
+	assert.Equal(t, tester.Labels{"team": "core", "env": "staging"}, a.Labels)
+	assert.Equal(t, []tester.Option{
+		{Name: "test.timeout", Default: "5m"},
+		{Name: "env", Default: "staging"},
+	}, a.Options)
+	assert.NotEmpty(t, a.SHA256Sum)
+
+	b := cfg.Packages[1]
+	assert.Equal(t, 2*time.Minute, b.RunDelay)
+	assert.False(t, b.Race)
+	assert.False(t, b.IsEnabled())
+	assert.Equal(t, tester.Labels{"team": "core", "env": "prod"}, b.Labels)
+	assert.Equal(t, []tester.Option{
+		{Name: "test.timeout", Default: "5m"},
+		{Name: "env", Default: "prod"},
+		{Name: "test.run", Default: "TestB"},
+	}, b.Options)
+
+	// Packages must not share the defaults' slices and maps.
+	a.Labels["team"] = "other"
+	a.Options[0].Default = "1m"
+	assert.Equal(t, "core", cfg.Defaults.Labels["team"])
+	assert.Equal(t, "5m", cfg.Defaults.Options[0].Default)
+	assert.Equal(t, "5m", b.Options[0].Default)
+}
+
+func TestConfig_Validate(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "pkg.test")
+	require.NoError(t, ioutil.WriteFile(bin, []byte("pkg"), 0755))
+
+	t.Run("valid", func(t *testing.T) {
+		cfg := &config{
+			Packages: []*tester.Package{
+				{Name: "a", Path: bin, Options: []tester.Option{{Name: "test.run", Default: "TestA"}}},
+				{Name: "b", Path: bin, Environment: []tester.EnvVar{{Name: "DB_URL", Value: "postgres://db"}, {Name: "TOKEN", Secret: true}}},
+				{Name: "c", Variants: []tester.Variant{{Name: "go1", Path: bin}, {Name: "go2", Path: bin}}},
+			},
+			Scheduler: &schedulerConfig{RunTimeout: "1m"},
+			Slack:     &slackConfig{CustomChannels: map[string][]string{"a": {"a-alerts"}}},
+			Owners: []*alerting.Owner{
+				{Name: "team-a", Package: "a"},
+				{Name: "payments", TestPrefix: "TestPayments", SlackChannels: []string{"payments"}},
+			},
+			UI: &uiConfig{
+				RunsPerPage:     20,
+				HourSummaries:   &summaryWindowConfig{Range: "2h", Bucket: "10m"},
+				SecurityHeaders: &securityHeadersConfig{FrameOptions: "SAMEORIGIN"},
+			},
+			Metrics: &metricsConfig{RunDurationBuckets: []float64{0.01, 1, 60}},
+			CORS:    &corsConfig{AllowedOrigins: []string{"https://dashboard.example.com", "http://localhost:3000"}},
+		}
+		assert.Empty(t, cfg.Validate())
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		cfg := &config{
+			Packages: []*tester.Package{
+				{Path: bin},
+				{Name: "a", Path: bin},
+				{Name: "a", Path: bin},
+				{Name: "b"},
+				{Name: "c", Path: "pkg.test"},
+				{Name: "d", Path: filepath.Join(dir, "missing.test")},
+				{Name: "e", Path: dir},
+				{Name: "f", Path: bin, RunDelay: -time.Second, Count: -1},
+				{Name: "g", Path: bin, Options: []tester.Option{{Name: "bad option", Default: "value"}}},
+				{
+					Name:     "h",
+					Options:  []tester.Option{{Name: "variant"}},
+					Variants: []tester.Variant{{Path: bin}, {Name: "go1", Path: bin}, {Name: "go1", Path: "go1.test"}},
+				},
+				{Name: "i", Path: bin, Environment: []tester.EnvVar{{Name: "A=B"}, {Name: "X"}, {Name: "X"}}},
+			},
+			Scheduler: &schedulerConfig{RunTimeout: "soon"},
+			Slack:     &slackConfig{CustomChannels: map[string][]string{"z": {"z-alerts"}}},
+			Owners: []*alerting.Owner{
+				{Package: "a"},
+				{Name: "everyone"},
+				{Name: "team-z", Package: "z"},
+			},
+			UI: &uiConfig{
+				HourSummaries: &summaryWindowConfig{Range: "48h", Bucket: "1h"},
+				DaySummaries:  &summaryWindowConfig{Range: "1d", Bucket: "1h"},
+			},
+			Metrics: &metricsConfig{RunDurationBuckets: []float64{1, 0.5}},
+			CORS:    &corsConfig{AllowedOrigins: []string{"https://dashboard.example.com", "dashboard.example.com"}},
+		}
+		errs := cfg.Validate()
+		assert.Len(t, errs, 23)
+		err := errors.Join(errs...)
+		for _, msg := range []string{
+			"package 0: missing name",
+			"package a: duplicate name",
+			"package b: missing path",
+			"package c: path (pkg.test) is not absolute",
+			"package d: invalid path",
+			"package e: path (" + dir + ") is a directory",
+			"package f: negative run delay",
+			"package f: negative count",
+			"package g: invalid option defaults",
+			"package h variant 0: missing name",
+			"package h variant go1: duplicate name",
+			"package h variant go1: path (go1.test) is not absolute",
+			"package h: option variant is reserved",
+			`package i env var 0: invalid name "A=B"`,
+			"package i env var X: duplicate name",
+			"scheduler: invalid run timeout",
+			"owner 0: missing name",
+			"owner everyone: missing package or test prefix",
+			"owner team-z: unknown package z",
+			"slack: custom channels for unknown package z",
+			"ui: day summaries: invalid range",
+			"metrics: invalid run duration buckets",
+			"cors: invalid allowed origin dashboard.example.com",
+		} {
+			assert.Contains(t, err.Error(), msg)
+		}
+	})
+
+	t.Run("load fails on invalid config", func(t *testing.T) {
+		configPath := filepath.Join(dir, "config.json")
+		writeConfig(t, configPath, &config{
+			Packages: []*tester.Package{{Name: "a", Path: bin}, {Name: "a", Path: bin}},
+		})
+		_, err := loadConfig(configPath, false)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "package a: duplicate name")
+		assert.Len(t, configErrors(err), 1)
+	})
+}
+
+func TestLoadConfig_Variants(t *testing.T) {
+	dir := t.TempDir()
+	var variants []tester.Variant
+	for _, name := range []string{"go1", "go2"} {
+		path := filepath.Join(dir, name+".test")
+		require.NoError(t, ioutil.WriteFile(path, []byte(name), 0755))
+		variants = append(variants, tester.Variant{Name: name, Path: path})
+	}
+
+	configPath := filepath.Join(dir, "config.json")
+	writeConfig(t, configPath, &config{
+		Packages: []*tester.Package{{Name: "pkg", Variants: variants}},
+	})
+	cfg, err := loadConfig(configPath, false)
+	require.NoError(t, err)
+	require.Len(t, cfg.Packages[0].Variants, 2)
+	for _, variant := range cfg.Packages[0].Variants {
+		sha256Sum, err := (&tester.Package{Path: variant.Path}).ComputeSHA256Sum()
+		require.NoError(t, err)
+		assert.Equal(t, sha256Sum, variant.SHA256Sum)
+	}
+
+	variants[1].SHA256Sum = "bad"
+	writeConfig(t, configPath, &config{
+		Packages: []*tester.Package{{Name: "pkg", Variants: variants}},
+	})
+	_, err = loadConfig(configPath, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "package pkg variant go2")
+}
+
+func TestLoadConfig_SHA256Check(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "pkg.test")
+	require.NoError(t, ioutil.WriteFile(bin, []byte("pkg"), 0755))
+	matching := fmt.Sprintf("%x", sha256.Sum256([]byte("pkg")))
+	mismatching := fmt.Sprintf("%x", sha256.Sum256([]byte("corrupt")))
+
+	configPath := filepath.Join(dir, "config.json")
+	for _, tc := range []struct {
+		name            string
+		sha256Sum       string
+		skipSHA256Check bool
+		expectErr       bool
+	}{
+		{name: "matching", sha256Sum: matching},
+		{name: "mismatching", sha256Sum: mismatching, expectErr: true},
+		{name: "matching skipped", sha256Sum: matching, skipSHA256Check: true},
+		{name: "mismatching skipped", sha256Sum: mismatching, skipSHA256Check: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			writeConfig(t, configPath, &config{
+				Packages: []*tester.Package{{Name: "pkg", Path: bin, SHA256Sum: tc.sha256Sum}},
+			})
+			cfg, err := loadConfig(configPath, tc.skipSHA256Check)
+			if tc.expectErr {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "package pkg ("+bin+") does not match the configured sha256 sum")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, matching, cfg.Packages[0].SHA256Sum)
+		})
+	}
+}
+
+func TestMergePackages(t *testing.T) {
+	current := []*tester.Package{{Name: "a"}, {Name: "b"}}
+	updated := []*tester.Package{{Name: "b", RunDelay: time.Minute}, {Name: "c"}}
+
+	merged := mergePackages(current, updated)
+	require.Len(t, merged, 3)
+
+	assert.Equal(t, updated[0], merged[0])
+	assert.Equal(t, updated[1], merged[1])
+	assert.Equal(t, "a", merged[2].Name)
+	assert.False(t, merged[2].IsEnabled())
+	assert.True(t, current[0].IsEnabled(), "current package should not be modified")
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tester-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{"a.test", "b.test"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0755))
+	}
+
+	configPath := filepath.Join(dir, "config.json")
+	writeConfig(t, configPath, &config{
+		Packages: []*tester.Package{{Name: "a", Path: filepath.Join(dir, "a.test")}},
+	})
+	cfg, err := loadConfig(configPath, false)
+	require.NoError(t, err)
+
+	api := testerhttp.NewAPIHandler(nil, cfg.Packages)
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	packages := cfg.Packages
+	err = watchConfig(ctx, configPath, false, func(updated *config) {
+		packages = mergePackages(packages, updated.Packages)
+		api.UpdatePackages(packages)
+	})
+	require.NoError(t, err)
+
+	writeConfig(t, configPath, &config{
+		Packages: []*tester.Package{{Name: "b", Path: filepath.Join(dir, "b.test")}},
+	})
+
+	listPackages := func() map[string]*testerhttp.PackageResponse {
+		resp, err := ts.Client().Get(ts.URL + "/api/packages")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var packages []*testerhttp.PackageResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&packages))
+
+		byName := make(map[string]*testerhttp.PackageResponse)
+		for _, pkg := range packages {
+			byName[pkg.Name] = pkg
+		}
+		return byName
+	}
+
+	require.Eventually(t, func() bool {
+		_, ok := listPackages()["b"]
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	packagesByName := listPackages()
+	require.Contains(t, packagesByName, "a")
+	require.NotNil(t, packagesByName["a"].Enabled)
+	assert.False(t, *packagesByName["a"].Enabled)
+}
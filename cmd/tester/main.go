@@ -1,6 +1,8 @@
 package main
 
 import (
+	"fmt"
+	"log/slog"
 	"os"
 	"strings"
 
@@ -12,14 +14,30 @@ var rootCmd = &cobra.Command{
 	Use:   "tester",
 	Short: "tester helps with managing to test runs",
 	Long:  "tester is tool that helps manage go test running by providing support for repeated test runing and a web UI for viewing results",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		logger, err := newLogger(viper.GetString("log-level"), viper.GetString("log-format"))
+		if err != nil {
+			return err
+		}
+		slog.SetDefault(logger)
+		return nil
+	},
 }
 
 func init() {
 	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
 	viper.AutomaticEnv()
 
+	rootCmd.PersistentFlags().String("log-level", "info", "The minimum level to log at (debug, info, warn, error)")
+	viper.BindPFlag("log-level", rootCmd.PersistentFlags().Lookup("log-level"))
+
+	rootCmd.PersistentFlags().String("log-format", "text", "The format to log in (text, json)")
+	viper.BindPFlag("log-format", rootCmd.PersistentFlags().Lookup("log-format"))
+
 	rootCmd.AddCommand(serveCmd)
 	rootCmd.AddCommand(runCmd)
+	rootCmd.AddCommand(runOnceCmd)
+	rootCmd.AddCommand(runsCmd)
 }
 
 func main() {
@@ -27,3 +45,20 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func newLogger(level, format string) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+	handlerOpts := &slog.HandlerOptions{Level: lvl}
+
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, handlerOpts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, handlerOpts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/db"
+)
+
+// packageLoader loads the package definitions stored in the db into the
+// packages that are served, validating them like the config and verifying the
+// sha256 sums of their test binaries like loadConfig. Sums are cached by the
+// path, size and modification time of the test binaries so that refreshing
+// packages doesn't rehash unchanged binaries.
+type packageLoader struct {
+	db              db.DB
+	skipSHA256Check bool
+
+	mu   sync.Mutex
+	sums map[string]cachedSHA256Sum
+}
+
+type cachedSHA256Sum struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+func newPackageLoader(db db.DB, skipSHA256Check bool) *packageLoader {
+	return &packageLoader{
+		db:              db,
+		skipSHA256Check: skipSHA256Check,
+		sums:            make(map[string]cachedSHA256Sum),
+	}
+}
+
+// load loads all of the stored packages. Packages that fail to load are
+// logged and left out.
+func (l *packageLoader) load(ctx context.Context) ([]*tester.Package, error) {
+	defs, err := l.db.ListPackages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listing packages: %w", err)
+	}
+
+	pkgs := make([]*tester.Package, 0, len(defs))
+	for _, def := range defs {
+		pkg, err := l.loadPackage(def)
+		if err != nil {
+			log.Printf("failed to load package %s: %s", def.Name, err)
+			continue
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// loadPackage validates the package definition and returns the package it
+// defines, with the sha256 sums of its test binaries set.
+func (l *packageLoader) loadPackage(def *tester.Package) (*tester.Package, error) {
+	if errs := (&config{Packages: []*tester.Package{def}}).Validate(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	pkg := copyPackage(def)
+	if err := verifySHA256Sums(pkg, l.skipSHA256Check, l.computeSHA256Sum); err != nil {
+		return nil, err
+	}
+	return pkg, nil
+}
+
+// computeSHA256Sum returns the sha256 sum of the package's test binary,
+// reusing the cached sum if the binary has not changed since it was computed.
+func (l *packageLoader) computeSHA256Sum(pkg *tester.Package) (string, error) {
+	info, err := os.Stat(pkg.Path)
+	if err != nil {
+		return "", err
+	}
+
+	l.mu.Lock()
+	cached, ok := l.sums[pkg.Path]
+	l.mu.Unlock()
+	if ok && cached.size == info.Size() && cached.modTime.Equal(info.ModTime()) {
+		return cached.sum, nil
+	}
+
+	sum, err := pkg.ComputeSHA256Sum()
+	if err != nil {
+		return "", err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sums[pkg.Path] = cachedSHA256Sum{size: info.Size(), modTime: info.ModTime(), sum: sum}
+	return sum, nil
+}
+
+// packageRefresher keeps the packages served up to date with the packages
+// stored in the db, refreshing them periodically and whenever triggered.
+// Packages that are deleted are kept, but disabled, as they are when removed
+// from the config.
+type packageRefresher struct {
+	loader  *packageLoader
+	trigger chan struct{}
+
+	mu       sync.Mutex
+	packages []*tester.Package
+}
+
+func newPackageRefresher(loader *packageLoader, packages []*tester.Package) *packageRefresher {
+	return &packageRefresher{
+		loader:   loader,
+		trigger:  make(chan struct{}, 1),
+		packages: packages,
+	}
+}
+
+// Trigger requests a refresh without waiting for it.
+func (r *packageRefresher) Trigger() {
+	select {
+	case r.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// refresh loads the stored packages and passes them to update.
+func (r *packageRefresher) refresh(ctx context.Context, update func([]*tester.Package)) error {
+	updated, err := r.loader.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.packages = mergePackages(r.packages, updated)
+	update(r.packages)
+	return nil
+}
+
+// Start refreshes the packages every interval and whenever triggered until
+// the context is done.
+func (r *packageRefresher) Start(ctx context.Context, interval time.Duration, update func([]*tester.Package)) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			case <-r.trigger:
+			}
+
+			if err := r.refresh(ctx, update); err != nil {
+				log.Printf("failed to refresh packages: %s", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackageLoader(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "pkg")
+	require.NoError(t, ioutil.WriteFile(bin, []byte("pkg"), 0755))
+	sha256Sum := fmt.Sprintf("%x", sha256.Sum256([]byte("pkg")))
+
+	t.Run("load", func(t *testing.T) {
+		mockDB := db.NewMockDB(ctrl)
+		loader := newPackageLoader(mockDB, false)
+
+		defs := []*tester.Package{
+			{Name: "pkg", Path: bin},
+			{Name: "missing", Path: filepath.Join(dir, "missing")},
+			{Name: "mismatch", Path: bin, SHA256Sum: fmt.Sprintf("%x", sha256.Sum256([]byte("corrupt")))},
+		}
+		mockDB.EXPECT().ListPackages(gomock.Any()).Return(defs, nil)
+
+		pkgs, err := loader.load(context.Background())
+		require.NoError(t, err)
+		require.Len(t, pkgs, 1)
+		assert.Equal(t, "pkg", pkgs[0].Name)
+		assert.Equal(t, sha256Sum, pkgs[0].SHA256Sum)
+		assert.Empty(t, defs[0].SHA256Sum, "definition should not be modified")
+	})
+
+	t.Run("invalid definition", func(t *testing.T) {
+		loader := newPackageLoader(db.NewMockDB(ctrl), false)
+
+		_, err := loader.loadPackage(&tester.Package{Name: "pkg", Path: bin, Count: -1})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "package pkg: negative count")
+	})
+
+	t.Run("cached sums", func(t *testing.T) {
+		loader := newPackageLoader(db.NewMockDB(ctrl), false)
+
+		pkg, err := loader.loadPackage(&tester.Package{Name: "pkg", Path: bin})
+		require.NoError(t, err)
+		assert.Equal(t, sha256Sum, pkg.SHA256Sum)
+
+		// Replacing the binary changes its modification time, so the sum is
+		// recomputed.
+		updated := filepath.Join(dir, "updated")
+		require.NoError(t, ioutil.WriteFile(updated, []byte("updated"), 0755))
+		pkg, err = loader.loadPackage(&tester.Package{Name: "pkg", Path: updated})
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("updated"))), pkg.SHA256Sum)
+		assert.Len(t, loader.sums, 2)
+	})
+}
+
+func TestPackageRefresher(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	dir := t.TempDir()
+	for _, name := range []string{"a", "b"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0755))
+	}
+
+	mockDB := db.NewMockDB(ctrl)
+	current := []*tester.Package{{Name: "a", Path: filepath.Join(dir, "a")}}
+	refresher := newPackageRefresher(newPackageLoader(mockDB, false), current)
+
+	mockDB.EXPECT().ListPackages(gomock.Any()).Return([]*tester.Package{{Name: "b", Path: filepath.Join(dir, "b")}}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	updates := make(chan []*tester.Package, 1)
+	refresher.Start(ctx, time.Hour, func(packages []*tester.Package) {
+		updates <- packages
+	})
+	refresher.Trigger()
+
+	select {
+	case packages := <-updates:
+		require.Len(t, packages, 2)
+		assert.Equal(t, "b", packages[0].Name)
+		assert.True(t, packages[0].IsEnabled())
+		assert.Equal(t, "a", packages[1].Name)
+		assert.False(t, packages[1].IsEnabled(), "deleted packages should be disabled")
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for packages to be refreshed")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/alerting"
+	"github.com/nanzhong/tester/scheduler"
+)
+
+// configReloader applies a reloaded config to the running server. Only some
+// of the config can change without a restart:
+//   - packages, of which newly configured ones are seeded into the db
+//   - the scheduler's run timeout
+//   - owners
+//   - slack's default and custom channels
+//
+// Changes to the ui, metrics and cors config, like changes to flags (eg.
+// --addr and --pg-dsn), only take effect once the server is restarted.
+type configReloader struct {
+	path            string
+	skipSHA256Check bool
+
+	seedPackages    func(ctx context.Context, packages []*tester.Package) error
+	refreshPackages func()
+	setRunTimeout   func(d time.Duration)
+	updateOwners    func(owners []*alerting.Owner)
+	// updateSlackChannels is nil when slack isn't configured.
+	updateSlackChannels func(defaultChannels []string, customChannels map[string][]string)
+
+	mu      sync.Mutex
+	current *config
+}
+
+// reload loads the config and applies it.
+func (r *configReloader) reload(ctx context.Context) error {
+	cfg, err := loadConfig(r.path, r.skipSHA256Check)
+	if err != nil {
+		return err
+	}
+	return r.apply(ctx, cfg)
+}
+
+// apply applies the parts of the config that can change without a restart,
+// logging changes to the parts that can't.
+func (r *configReloader) apply(ctx context.Context, cfg *config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Seeding adds newly configured packages and replaces the stored
+	// packages whose configuration changed, leaving the others, which may
+	// have been changed through the API, as they are.
+	if err := r.seedPackages(ctx, cfg.packageDefinitions); err != nil {
+		return fmt.Errorf("seeding packages: %w", err)
+	}
+	if r.current != nil {
+		for _, name := range changedPackages(r.current, cfg) {
+			log.Printf("configuration of package %s changed, replaced its stored definition", name)
+		}
+	}
+	r.refreshPackages()
+
+	runTimeout := scheduler.DefaultRunTimeout
+	if cfg.Scheduler != nil && cfg.Scheduler.RunTimeout != "" {
+		// The config has been validated when it was loaded.
+		runTimeout, _ = time.ParseDuration(cfg.Scheduler.RunTimeout)
+	}
+	r.setRunTimeout(runTimeout)
+	r.updateOwners(cfg.Owners)
+	if r.updateSlackChannels != nil {
+		var slack slackConfig
+		if cfg.Slack != nil {
+			slack = *cfg.Slack
+		}
+		r.updateSlackChannels(slack.DefaultChannels, slack.CustomChannels)
+	}
+
+	if r.current != nil {
+		for _, section := range restartRequiredChanges(r.current, cfg) {
+			log.Printf("config changes to %s require a restart to take effect", section)
+		}
+	}
+	r.current = cfg
+	return nil
+}
+
+// changedPackages returns the names of the packages configured by both
+// configs whose definitions changed between them.
+func changedPackages(current, updated *config) []string {
+	definitions := make(map[string]*tester.Package, len(current.packageDefinitions))
+	for _, pkg := range current.packageDefinitions {
+		definitions[pkg.Name] = pkg
+	}
+
+	var names []string
+	for _, pkg := range updated.packageDefinitions {
+		if def, ok := definitions[pkg.Name]; ok && !reflect.DeepEqual(def, pkg) {
+			names = append(names, pkg.Name)
+		}
+	}
+	return names
+}
+
+// restartRequiredChanges returns the sections of the config that changed
+// between the configs and only take effect on restart.
+func restartRequiredChanges(current, updated *config) []string {
+	var sections []string
+	if !reflect.DeepEqual(current.UI, updated.UI) {
+		sections = append(sections, "ui")
+	}
+	if !reflect.DeepEqual(current.Metrics, updated.Metrics) {
+		sections = append(sections, "metrics")
+	}
+	if !reflect.DeepEqual(current.CORS, updated.CORS) {
+		sections = append(sections, "cors")
+	}
+	return sections
+}
+
+// reloadConfigOnSignal reloads the config each time one of the signals is
+// received, until ctx is done. Configs that fail to load are logged and
+// ignored.
+func reloadConfigOnSignal(ctx context.Context, reloader *configReloader, sigs ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-c:
+				log.Printf("received %s, reloading config (%s)", sig, reloader.path)
+				if err := reloader.reload(ctx); err != nil {
+					for _, err := range configErrors(err) {
+						log.Print(err)
+					}
+					log.Printf("failed to reload config (%s)", reloader.path)
+				}
+			}
+		}
+	}()
+}
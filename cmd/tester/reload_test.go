@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/alerting"
+	"github.com/nanzhong/tester/scheduler"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingReloader is a configReloader that records what it applies.
+type recordingReloader struct {
+	*configReloader
+
+	seeded          [][]*tester.Package
+	refreshed       chan struct{}
+	runTimeout      time.Duration
+	owners          []*alerting.Owner
+	defaultChannels []string
+	customChannels  map[string][]string
+}
+
+func newRecordingReloader(path string) *recordingReloader {
+	r := &recordingReloader{refreshed: make(chan struct{}, 10)}
+	r.configReloader = &configReloader{
+		path: path,
+		seedPackages: func(ctx context.Context, packages []*tester.Package) error {
+			r.seeded = append(r.seeded, packages)
+			return nil
+		},
+		refreshPackages: func() { r.refreshed <- struct{}{} },
+		setRunTimeout:   func(d time.Duration) { r.runTimeout = d },
+		updateOwners:    func(owners []*alerting.Owner) { r.owners = owners },
+		updateSlackChannels: func(defaultChannels []string, customChannels map[string][]string) {
+			r.defaultChannels = defaultChannels
+			r.customChannels = customChannels
+		},
+	}
+	return r
+}
+
+func TestConfigReloader(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.test", "b.test"} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(name), 0755))
+	}
+	configPath := filepath.Join(dir, "config.json")
+	writeConfig(t, configPath, &config{
+		Packages:  []*tester.Package{{Name: "a", Path: filepath.Join(dir, "a.test")}},
+		Scheduler: &schedulerConfig{RunTimeout: "5m"},
+	})
+	r := newRecordingReloader(configPath)
+
+	require.NoError(t, r.reload(context.Background()))
+	require.Len(t, r.seeded, 1)
+	require.Len(t, r.seeded[0], 1)
+	assert.Equal(t, "a", r.seeded[0][0].Name)
+	assert.Empty(t, r.seeded[0][0].SHA256Sum, "seeded definitions should not include computed sums")
+	assert.Len(t, r.refreshed, 1)
+	assert.Equal(t, 5*time.Minute, r.runTimeout)
+	assert.Empty(t, r.owners)
+	assert.Empty(t, r.defaultChannels)
+
+	owners := []*alerting.Owner{{Name: "team-b", Package: "b"}}
+	writeConfig(t, configPath, &config{
+		Packages: []*tester.Package{
+			{Name: "a", Path: filepath.Join(dir, "a.test")},
+			{Name: "b", Path: filepath.Join(dir, "b.test")},
+		},
+		Slack:  &slackConfig{DefaultChannels: []string{"alerts"}, CustomChannels: map[string][]string{"b": {"b-alerts"}}},
+		Owners: owners,
+		UI:     &uiConfig{RunsPerPage: 10},
+	})
+	require.NoError(t, r.reload(context.Background()))
+	require.Len(t, r.seeded, 2)
+	assert.Len(t, r.seeded[1], 2)
+	assert.Len(t, r.refreshed, 2)
+	assert.Equal(t, scheduler.DefaultRunTimeout, r.runTimeout, "removed run timeout should revert to the default")
+	assert.Equal(t, owners, r.owners)
+	assert.Equal(t, []string{"alerts"}, r.defaultChannels)
+	assert.Equal(t, map[string][]string{"b": {"b-alerts"}}, r.customChannels)
+
+	// Changed package definitions are seeded to replace the stored ones.
+	writeConfig(t, configPath, &config{
+		Packages: []*tester.Package{
+			{Name: "a", Path: filepath.Join(dir, "a.test"), Count: 2},
+			{Name: "b", Path: filepath.Join(dir, "b.test")},
+		},
+		Slack:  &slackConfig{DefaultChannels: []string{"alerts"}, CustomChannels: map[string][]string{"b": {"b-alerts"}}},
+		Owners: owners,
+		UI:     &uiConfig{RunsPerPage: 10},
+	})
+	require.NoError(t, r.reload(context.Background()))
+	require.Len(t, r.seeded, 3)
+	require.Len(t, r.seeded[2], 2)
+	assert.Equal(t, 2, r.seeded[2][0].Count)
+	assert.Len(t, r.refreshed, 3)
+
+	// Invalid configs aren't applied.
+	writeConfig(t, configPath, &config{
+		Packages: []*tester.Package{{Name: "a", Path: filepath.Join(dir, "a.test")}, {Name: "a", Path: filepath.Join(dir, "a.test")}},
+	})
+	err := r.reload(context.Background())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "package a: duplicate name")
+	assert.Len(t, r.seeded, 3)
+	assert.Equal(t, owners, r.owners)
+}
+
+func TestChangedPackages(t *testing.T) {
+	current := &config{packageDefinitions: []*tester.Package{
+		{Name: "a", Path: "/bins/a.test"},
+		{Name: "b", Path: "/bins/b.test"},
+	}}
+	assert.Empty(t, changedPackages(current, &config{packageDefinitions: []*tester.Package{
+		{Name: "a", Path: "/bins/a.test"},
+		{Name: "c", Path: "/bins/c.test"},
+	}}))
+	assert.Equal(t, []string{"b"}, changedPackages(current, &config{packageDefinitions: []*tester.Package{
+		{Name: "a", Path: "/bins/a.test"},
+		{Name: "b", Path: "/bins/b.test", Environment: []tester.EnvVar{{Name: "TOKEN", Value: "secret", Secret: true}}},
+	}}))
+}
+
+func TestRestartRequiredChanges(t *testing.T) {
+	current := &config{UI: &uiConfig{RunsPerPage: 10}, CORS: &corsConfig{AllowedOrigins: []string{"https://a.example.com"}}}
+	assert.Empty(t, restartRequiredChanges(current, &config{
+		UI:       &uiConfig{RunsPerPage: 10},
+		CORS:     &corsConfig{AllowedOrigins: []string{"https://a.example.com"}},
+		Packages: []*tester.Package{{Name: "a"}},
+	}))
+	assert.Equal(t, []string{"ui", "metrics", "cors"}, restartRequiredChanges(current, &config{
+		UI:      &uiConfig{RunsPerPage: 20},
+		Metrics: &metricsConfig{RunDurationBuckets: []float64{1}},
+	}))
+}
+
+func TestReloadConfigOnSignal(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "a.test"), []byte("a"), 0755))
+	configPath := filepath.Join(dir, "config.json")
+	writeConfig(t, configPath, &config{
+		Packages: []*tester.Package{{Name: "a", Path: filepath.Join(dir, "a.test")}},
+	})
+	r := newRecordingReloader(configPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	reloadConfigOnSignal(ctx, r.configReloader, syscall.SIGHUP)
+
+	// The process keeps running, so the config can be reloaded repeatedly.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+		select {
+		case <-r.refreshed:
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for config to be reloaded")
+		}
+	}
+}
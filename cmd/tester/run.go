@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
@@ -18,13 +19,23 @@ var runCmd = &cobra.Command{
 	Short: "start a test runner",
 	Args:  cobra.ExactArgs(0),
 	Run: func(cmd *cobra.Command, args []string) {
-		opts := []runner.Option{runner.WithTesterAddr(viper.GetString("run-tester-addr"))}
+		opts := []runner.Option{
+			runner.WithTesterAddr(viper.GetString("run-tester-addr")),
+			runner.WithLogger(slog.Default().With("component", "runner")),
+		}
 		if apiKey := viper.GetString("run-api-key"); apiKey != "" {
 			opts = append(opts, runner.WithAPIKey(apiKey))
 		}
 		if testBinsPath := viper.GetString("run-test-bins-path"); testBinsPath != "" {
 			opts = append(opts, runner.WithTestBinsPath(testBinsPath))
 		}
+		if spoolPath := viper.GetString("run-spool-path"); spoolPath != "" {
+			opts = append(opts, runner.WithSpoolPath(spoolPath))
+		}
+		if retainPath := viper.GetString("run-retain-path"); retainPath != "" {
+			opts = append(opts, runner.WithRetainPath(retainPath))
+			opts = append(opts, runner.WithMaxRetainedRuns(viper.GetInt("run-max-retained-runs")))
+		}
 		if localTestBinsOnly := viper.GetBool("run-local-test-bins-only"); localTestBinsOnly {
 			opts = append(opts, runner.WithLocalTestBinsOnly())
 		}
@@ -34,6 +45,22 @@ var runCmd = &cobra.Command{
 		if packageBlacklist := viper.GetStringSlice("run-packages-exclude"); len(packageBlacklist) > 0 {
 			opts = append(opts, runner.WithPackageBlacklist(packageBlacklist))
 		}
+		if maxSubmissionAttempts := viper.GetInt("run-max-submission-attempts"); maxSubmissionAttempts > 0 {
+			opts = append(opts, runner.WithMaxSubmissionAttempts(maxSubmissionAttempts))
+		}
+		if submissionConcurrency := viper.GetInt("run-submission-concurrency"); submissionConcurrency > 0 {
+			opts = append(opts, runner.WithSubmissionConcurrency(submissionConcurrency))
+		}
+		opts = append(opts, runner.WithConcurrency(viper.GetInt("run-concurrency")))
+		opts = append(opts, runner.WithMaxRetryDelay(viper.GetDuration("run-max-retry-delay")))
+		opts = append(opts, runner.WithIdleTimeout(viper.GetDuration("run-reset-after")))
+		opts = append(opts, runner.WithMaxOutputBytes(viper.GetInt64("run-max-output-bytes")))
+		if viper.GetBool("run-verbose") {
+			opts = append(opts, runner.WithVerboseOutput(os.Stdout))
+		}
+		if viper.GetBool("run-submit-only-failed") {
+			opts = append(opts, runner.WithResultFilter(runner.SubmitOnlyFailed()))
+		}
 
 		runner, err := runner.New(opts...)
 		if err != nil {
@@ -58,7 +85,10 @@ var runCmd = &cobra.Command{
 		}()
 
 		log.Printf("starting test runner")
-		runner.Run()
+		if err := runner.Run(); err != nil {
+			// Exit non-zero so that a process supervisor restarts the runner.
+			log.Fatalf("test runner stopped: %s", err)
+		}
 		log.Printf("ending test runner")
 	},
 }
@@ -73,6 +103,15 @@ func init() {
 	runCmd.Flags().String("test-bins-path", "", "Path to look for and store test binaries")
 	viper.BindPFlag("run-test-bins-path", runCmd.Flags().Lookup("test-bins-path"))
 
+	runCmd.Flags().String("spool-path", "", "Path to store results that could not be submitted (defaults to <test-bins-path>/spool)")
+	viper.BindPFlag("run-spool-path", runCmd.Flags().Lookup("spool-path"))
+
+	runCmd.Flags().String("retain-path", "", "Path to retain the test binaries, args and environment of failed runs at for reproducing them")
+	viper.BindPFlag("run-retain-path", runCmd.Flags().Lookup("retain-path"))
+
+	runCmd.Flags().Int("max-retained-runs", 10, "Maximum number of failed runs to retain, after which the oldest are removed")
+	viper.BindPFlag("run-max-retained-runs", runCmd.Flags().Lookup("max-retained-runs"))
+
 	runCmd.Flags().Bool("local-test-bins-only", false, "Disables downloading remote test binaries")
 	viper.BindPFlag("run-local-test-bins-only", runCmd.Flags().Lookup("local-test-bins-only"))
 
@@ -81,4 +120,22 @@ func init() {
 
 	runCmd.Flags().StringSlice("packages-exclude", nil, "Blacklist of packages to exclude for claiming")
 	viper.BindPFlag("run-packages-exclude", runCmd.Flags().Lookup("packages-exclude"))
+
+	runCmd.Flags().Int("max-submission-attempts", 5, "Maximum number of attempts made to submit results")
+	viper.BindPFlag("run-max-submission-attempts", runCmd.Flags().Lookup("max-submission-attempts"))
+	runCmd.Flags().Int("submission-concurrency", 4, "Number of test results submitted concurrently")
+	viper.BindPFlag("run-submission-concurrency", runCmd.Flags().Lookup("submission-concurrency"))
+	runCmd.Flags().Int("concurrency", 1, "Number of runs claimed and run concurrently")
+	viper.BindPFlag("run-concurrency", runCmd.Flags().Lookup("concurrency"))
+	viper.BindEnv("run-concurrency", "TESTER_RUNNER_CONCURRENCY")
+	runCmd.Flags().Int64("max-output-bytes", 128<<20, "Maximum number of bytes of test output retained per run, 0 for no limit")
+	viper.BindPFlag("run-max-output-bytes", runCmd.Flags().Lookup("max-output-bytes"))
+	runCmd.Flags().Bool("submit-only-failed", false, "Only submit the results of failed tests")
+	viper.BindPFlag("run-submit-only-failed", runCmd.Flags().Lookup("submit-only-failed"))
+	runCmd.Flags().Bool("verbose", false, "Stream test output to stdout as tests run")
+	viper.BindPFlag("run-verbose", runCmd.Flags().Lookup("verbose"))
+	runCmd.Flags().Duration("max-retry-delay", 30*time.Second, "Maximum delay between retries of claiming runs and submitting results")
+	viper.BindPFlag("run-max-retry-delay", runCmd.Flags().Lookup("max-retry-delay"))
+	runCmd.Flags().Duration("reset-after", 0, "Exit with a non-zero status if no run is claimed within the duration, 0 to never exit")
+	viper.BindPFlag("run-reset-after", runCmd.Flags().Lookup("reset-after"))
 }
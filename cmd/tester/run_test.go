@@ -0,0 +1,15 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunConcurrency(t *testing.T) {
+	assert.Equal(t, 1, viper.GetInt("run-concurrency"))
+
+	t.Setenv("TESTER_RUNNER_CONCURRENCY", "3")
+	assert.Equal(t, 3, viper.GetInt("run-concurrency"))
+}
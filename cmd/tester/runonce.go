@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/runner"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var runOnceCmd = &cobra.Command{
+	Use:   "runonce --bin <path> [-- test args]",
+	Short: "run a test binary once locally and print the results",
+	Run: func(cmd *cobra.Command, args []string) {
+		bin := viper.GetString("runonce-bin")
+		if bin == "" {
+			log.Fatal("--bin is required")
+		}
+		format := viper.GetString("runonce-format")
+		if format != "table" && format != "json" {
+			log.Fatalf("invalid format %q", format)
+		}
+
+		ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer cancel()
+
+		opts := runner.ExecOptions{
+			Args:           args,
+			MaxOutputBytes: viper.GetInt64("runonce-max-output-bytes"),
+		}
+		if viper.GetBool("runonce-verbose") {
+			opts.Verbose = os.Stderr
+		}
+		result, err := runner.Exec(ctx, bin, opts)
+		if err != nil {
+			log.Fatalf("failed to run %s: %s", bin, err)
+		}
+
+		if format == "json" {
+			err = json.NewEncoder(os.Stdout).Encode(result.Tests)
+		} else {
+			err = printTestTable(os.Stdout, result.Tests)
+		}
+		if err != nil {
+			log.Fatalf("failed to print results: %s", err)
+		}
+
+		for _, test := range result.Tests {
+			if test.Result.State != tester.TBStatePassed && test.Result.State != tester.TBStateSkipped {
+				os.Exit(1)
+			}
+		}
+	},
+}
+
+// printTestTable prints the tests and their subtests as a table.
+func printTestTable(w io.Writer, tests []*tester.Test) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TEST\tSTATE\tDURATION")
+
+	var printT func(t *tester.T, level int)
+	printT = func(t *tester.T, level int) {
+		state := string(t.State)
+		if state == "" {
+			state = "unknown"
+		}
+		fmt.Fprintf(tw, "%s%s\t%s\t%s\n", strings.Repeat("  ", level), t.Name, state, t.Duration())
+		for _, sub := range t.SubTs {
+			printT(sub, level+1)
+		}
+	}
+	for _, test := range tests {
+		printT(test.Result, 0)
+	}
+	return tw.Flush()
+}
+
+func init() {
+	runOnceCmd.Flags().String("bin", "", "Path to the test binary to run")
+	viper.BindPFlag("runonce-bin", runOnceCmd.Flags().Lookup("bin"))
+
+	runOnceCmd.Flags().String("format", "table", "The format to print results in (table, json)")
+	viper.BindPFlag("runonce-format", runOnceCmd.Flags().Lookup("format"))
+
+	runOnceCmd.Flags().Int64("max-output-bytes", 0, "Maximum number of bytes of test output retained, 0 for no limit")
+	viper.BindPFlag("runonce-max-output-bytes", runOnceCmd.Flags().Lookup("max-output-bytes"))
+
+	runOnceCmd.Flags().Bool("verbose", false, "Stream test output to stderr as tests run")
+	viper.BindPFlag("runonce-verbose", runOnceCmd.Flags().Lookup("verbose"))
+}
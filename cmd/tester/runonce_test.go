@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/nanzhong/tester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrintTestTable(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []*tester.Test{
+		{Result: &tester.T{
+			TB: tester.TB{Name: "TestA", State: tester.TBStatePassed, StartedAt: start, FinishedAt: start.Add(time.Second)},
+			SubTs: []*tester.T{{
+				TB: tester.TB{Name: "TestA/sub", State: tester.TBStatePassed, StartedAt: start, FinishedAt: start.Add(500 * time.Millisecond)},
+			}},
+		}},
+		{Result: &tester.T{
+			TB: tester.TB{Name: "TestB", StartedAt: start, FinishedAt: start},
+		}},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, printTestTable(&out, tests))
+	assert.Equal(t, ""+
+		"TEST         STATE    DURATION\n"+
+		"TestA        passed   1s\n"+
+		"  TestA/sub  passed   500ms\n"+
+		"TestB        unknown  0s\n", out.String())
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nanzhong/tester"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var runsCmd = &cobra.Command{
+	Use:   "runs",
+	Short: "manage the runs of a tester server",
+}
+
+var runsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list runs",
+	Args:  cobra.ExactArgs(0),
+	Run: func(cmd *cobra.Command, args []string) {
+		client := newRunsClientFromFlags()
+		runs, err := client.listRuns(cmd.Context(), viper.GetString("runs-package"), viper.GetString("runs-state"))
+		if err != nil {
+			log.Fatalf("failed to list runs: %s", err)
+		}
+		if err := printRuns(os.Stdout, viper.GetString("runs-format"), runs); err != nil {
+			log.Fatalf("failed to print runs: %s", err)
+		}
+	},
+}
+
+var runsShowCmd = &cobra.Command{
+	Use:   "show <run id>",
+	Short: "show a run and its tests",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		client := newRunsClientFromFlags()
+		run, err := client.getRun(cmd.Context(), args[0])
+		if err != nil {
+			log.Fatalf("failed to get run: %s", err)
+		}
+		if err := printRun(os.Stdout, viper.GetString("runs-format"), run); err != nil {
+			log.Fatalf("failed to print run: %s", err)
+		}
+	},
+}
+
+var runsCancelCmd = &cobra.Command{
+	Use:   "cancel <run id>",
+	Short: "cancel a pending or running run",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		client := newRunsClientFromFlags()
+		if err := client.cancelRun(cmd.Context(), args[0]); err != nil {
+			log.Fatalf("failed to cancel run: %s", err)
+		}
+		fmt.Fprintf(os.Stdout, "canceled run %s\n", args[0])
+	},
+}
+
+var runsRerunFailedCmd = &cobra.Command{
+	Use:   "rerun-failed <run id>",
+	Short: "enqueue a run of the failed tests of a finished run",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		client := newRunsClientFromFlags()
+		run, err := client.rerunFailed(cmd.Context(), args[0])
+		if err != nil {
+			log.Fatalf("failed to rerun failed tests: %s", err)
+		}
+		fmt.Fprintf(os.Stdout, "enqueued run %s\n", run.ID)
+	},
+}
+
+// runsClient makes requests to the runs API of a tester server.
+type runsClient struct {
+	addr       string
+	apiKey     string
+	httpClient *http.Client
+}
+
+func newRunsClientFromFlags() *runsClient {
+	return &runsClient{
+		addr:       viper.GetString("runs-tester-addr"),
+		apiKey:     viper.GetString("runs-api-key"),
+		httpClient: &http.Client{Timeout: time.Minute},
+	}
+}
+
+func (c *runsClient) listRuns(ctx context.Context, pkg, state string) ([]*tester.Run, error) {
+	query := url.Values{}
+	if pkg != "" {
+		query.Set("package", pkg)
+	}
+	if state != "" {
+		query.Set("state", state)
+	}
+	path := "/api/runs"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	var runs []*tester.Run
+	if err := c.do(ctx, http.MethodGet, path, http.StatusOK, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+func (c *runsClient) getRun(ctx context.Context, runID string) (*tester.Run, error) {
+	var run tester.Run
+	if err := c.do(ctx, http.MethodGet, "/api/runs/"+url.PathEscape(runID), http.StatusOK, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+func (c *runsClient) cancelRun(ctx context.Context, runID string) error {
+	return c.do(ctx, http.MethodPost, "/api/runs/"+url.PathEscape(runID)+"/cancel", http.StatusOK, nil)
+}
+
+func (c *runsClient) rerunFailed(ctx context.Context, runID string) (*tester.Run, error) {
+	var run tester.Run
+	if err := c.do(ctx, http.MethodPost, "/api/runs/"+url.PathEscape(runID)+"/rerun-failed", http.StatusCreated, &run); err != nil {
+		return nil, err
+	}
+	return &run, nil
+}
+
+// do makes the request and decodes the response into out, if it is not nil.
+// Responses with a status other than the expected status are returned as
+// errors, with the error reported by the server.
+func (c *runsClient) do(ctx context.Context, method, path string, status int, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimSuffix(c.addr, "/")+path, nil)
+	if err != nil {
+		return fmt.Errorf("constructing request: %w", err)
+	}
+	req.Header.Set("User-Agent", "tester-runs")
+	if c.apiKey != "" {
+		req.SetBasicAuth("tester-runs", c.apiKey)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("making request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != status {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			return fmt.Errorf("unexpected response status %d", resp.StatusCode)
+		}
+		return fmt.Errorf("unexpected response status %d: %s", resp.StatusCode, apiErr.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// runState returns a short description of the state of the run.
+func runState(run *tester.Run) string {
+	switch {
+	case !run.DeadLetteredAt.IsZero():
+		return "dead_lettered"
+	case !run.FinishedAt.IsZero() && run.Error != "":
+		return "failed"
+	case !run.FinishedAt.IsZero():
+		return "finished"
+	case !run.StartedAt.IsZero():
+		return "running"
+	default:
+		return "pending"
+	}
+}
+
+// printRuns prints the runs as a table or json.
+func printRuns(w io.Writer, format string, runs []*tester.Run) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(runs)
+	case "table":
+	default:
+		return fmt.Errorf("invalid format %q", format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tPACKAGE\tSTATE\tENQUEUED\tENQUEUED BY")
+	for _, run := range runs {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", run.ID, runPackage(run), runState(run), run.EnqueuedAt.Format(time.RFC3339), run.EnqueuedBy)
+	}
+	return tw.Flush()
+}
+
+// printRun prints the run and its tests as a table or json.
+func printRun(w io.Writer, format string, run *tester.Run) error {
+	switch format {
+	case "json":
+		return json.NewEncoder(w).Encode(run)
+	case "table":
+	default:
+		return fmt.Errorf("invalid format %q", format)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID:\t%s\n", run.ID)
+	fmt.Fprintf(tw, "Package:\t%s\n", runPackage(run))
+	fmt.Fprintf(tw, "State:\t%s\n", runState(run))
+	fmt.Fprintf(tw, "Args:\t%s\n", strings.Join(run.Args, " "))
+	fmt.Fprintf(tw, "Enqueued:\t%s\n", run.EnqueuedAt.Format(time.RFC3339))
+	if run.EnqueuedBy != "" {
+		fmt.Fprintf(tw, "Enqueued by:\t%s\n", run.EnqueuedBy)
+	}
+	if run.Meta.Runner != "" {
+		fmt.Fprintf(tw, "Runner:\t%s\n", run.Meta.Runner)
+	}
+	if run.Error != "" {
+		fmt.Fprintf(tw, "Error:\t%s\n", run.Error)
+	}
+	if run.DeadLetterReason != "" {
+		fmt.Fprintf(tw, "Dead letter reason:\t%s\n", run.DeadLetterReason)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(run.Tests) == 0 {
+		return nil
+	}
+	fmt.Fprintln(w)
+	return printTestTable(w, run.Tests)
+}
+
+// runPackage returns the package of the run, with its variant if it has one.
+func runPackage(run *tester.Run) string {
+	if run.Variant == "" {
+		return run.Package
+	}
+	return fmt.Sprintf("%s (%s)", run.Package, run.Variant)
+}
+
+func init() {
+	runsCmd.PersistentFlags().String("tester-addr", "http://0.0.0.0:8080", "The address where the tester server is listening on")
+	viper.BindPFlag("runs-tester-addr", runsCmd.PersistentFlags().Lookup("tester-addr"))
+
+	runsCmd.PersistentFlags().String("api-key", "", "Symmetric key for API Auth")
+	viper.BindPFlag("runs-api-key", runsCmd.PersistentFlags().Lookup("api-key"))
+
+	runsCmd.PersistentFlags().String("format", "table", "The format to print runs in (table, json)")
+	viper.BindPFlag("runs-format", runsCmd.PersistentFlags().Lookup("format"))
+
+	runsListCmd.Flags().String("package", "", "Only list runs of the package")
+	viper.BindPFlag("runs-package", runsListCmd.Flags().Lookup("package"))
+
+	runsListCmd.Flags().String("state", "", "Only list runs in the state (pending, finished, dead_lettered)")
+	viper.BindPFlag("runs-state", runsListCmd.Flags().Lookup("state"))
+
+	runsCmd.AddCommand(runsListCmd)
+	runsCmd.AddCommand(runsShowCmd)
+	runsCmd.AddCommand(runsCancelCmd)
+	runsCmd.AddCommand(runsRerunFailedCmd)
+}
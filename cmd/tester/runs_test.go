@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/db"
+	testerhttp "github.com/nanzhong/tester/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withRunsClient(t *testing.T, f func(client *runsClient, mockDB *db.MockDB)) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := db.NewMockDB(ctrl)
+	api := testerhttp.NewAPIHandler(mockDB, []*tester.Package{{Name: "pkg"}}, testerhttp.WithAPIKey("key"))
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	f(&runsClient{addr: ts.URL + "/", apiKey: "key", httpClient: ts.Client()}, mockDB)
+}
+
+func TestRunsClient(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("list runs", func(t *testing.T) {
+		withRunsClient(t, func(client *runsClient, mockDB *db.MockDB) {
+			run := &tester.Run{ID: uuid.New(), Package: "pkg"}
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), "pkg").Return([]*tester.Run{run}, nil)
+
+			runs, err := client.listRuns(ctx, "pkg", "pending")
+			require.NoError(t, err)
+			require.Len(t, runs, 1)
+			assert.Equal(t, run.ID, runs[0].ID)
+		})
+	})
+
+	t.Run("get run", func(t *testing.T) {
+		withRunsClient(t, func(client *runsClient, mockDB *db.MockDB) {
+			run := &tester.Run{ID: uuid.New(), Package: "pkg"}
+			mockDB.EXPECT().GetRun(gomock.Any(), run.ID).Return(run, nil)
+
+			got, err := client.getRun(ctx, run.ID.String())
+			require.NoError(t, err)
+			assert.Equal(t, run.ID, got.ID)
+		})
+	})
+
+	t.Run("cancel run", func(t *testing.T) {
+		withRunsClient(t, func(client *runsClient, mockDB *db.MockDB) {
+			run := &tester.Run{ID: uuid.New(), Package: "pkg", StartedAt: time.Now()}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), run.ID).Return(run, nil)
+			mockDB.EXPECT().FailRun(gomock.Any(), run.ID, gomock.Any()).Return(nil)
+
+			require.NoError(t, client.cancelRun(ctx, run.ID.String()))
+		})
+	})
+
+	t.Run("rerun failed", func(t *testing.T) {
+		withRunsClient(t, func(client *runsClient, mockDB *db.MockDB) {
+			run := &tester.Run{
+				ID:         uuid.New(),
+				Package:    "pkg",
+				FinishedAt: time.Now(),
+				Tests: []*tester.Test{{
+					ID:     uuid.New(),
+					Result: &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStateFailed}},
+				}},
+			}
+			mockDB.EXPECT().GetRun(gomock.Any(), run.ID).Return(run, nil)
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Return(nil)
+
+			rerun, err := client.rerunFailed(ctx, run.ID.String())
+			require.NoError(t, err)
+			assert.NotEqual(t, run.ID, rerun.ID)
+			assert.Equal(t, []string{"-test.run=^(TestA)$"}, rerun.Args)
+		})
+	})
+
+	t.Run("api error", func(t *testing.T) {
+		withRunsClient(t, func(client *runsClient, mockDB *db.MockDB) {
+			runID := uuid.New()
+			mockDB.EXPECT().GetRun(gomock.Any(), runID).Return(nil, db.ErrNotFound)
+
+			_, err := client.getRun(ctx, runID.String())
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "unexpected response status 404")
+			assert.Contains(t, err.Error(), "unknown run "+runID.String())
+		})
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		withRunsClient(t, func(client *runsClient, mockDB *db.MockDB) {
+			client.apiKey = "wrong"
+
+			_, err := client.listRuns(ctx, "", "")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "unexpected response status 401")
+		})
+	})
+
+	t.Run("non api error", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		defer ts.Close()
+		client := &runsClient{addr: ts.URL, httpClient: ts.Client()}
+
+		err := client.cancelRun(ctx, uuid.New().String())
+		require.Error(t, err)
+		assert.Equal(t, "unexpected response status 404", err.Error())
+	})
+}
+
+func TestPrintRuns(t *testing.T) {
+	enqueuedAt := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	runs := []*tester.Run{
+		{ID: uuid.MustParse("00000000-0000-0000-0000-000000000001"), Package: "pkg", EnqueuedAt: enqueuedAt},
+		{ID: uuid.MustParse("00000000-0000-0000-0000-000000000002"), Package: "pkg", Variant: "race", EnqueuedAt: enqueuedAt, StartedAt: enqueuedAt, FinishedAt: enqueuedAt, Error: "canceled", EnqueuedBy: "slack:U1"},
+	}
+
+	var out bytes.Buffer
+	require.NoError(t, printRuns(&out, "table", runs))
+	assert.Equal(t, ""+
+		"ID                                    PACKAGE     STATE    ENQUEUED              ENQUEUED BY\n"+
+		"00000000-0000-0000-0000-000000000001  pkg         pending  2020-01-02T03:04:05Z  \n"+
+		"00000000-0000-0000-0000-000000000002  pkg (race)  failed   2020-01-02T03:04:05Z  slack:U1\n", out.String())
+
+	assert.Error(t, printRuns(&out, "yaml", runs))
+}
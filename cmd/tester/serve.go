@@ -2,19 +2,21 @@ package main
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/json"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"runtime"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/nanzhong/tester"
 	"github.com/nanzhong/tester/alerting"
 	"github.com/nanzhong/tester/db"
 	testerhttp "github.com/nanzhong/tester/http"
@@ -33,30 +35,23 @@ var serveCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(0),
 	Run: func(cmd *cobra.Command, args []string) {
 		configPath := viper.GetString("serve-config")
-		file, err := os.Open(configPath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				log.Fatalf("config (%s) does not exist", configPath)
+		if viper.GetBool("serve-check") {
+			err := checkServe(context.Background(), os.Stdout, configPath, viper.GetString("serve-pg-dsn"))
+			if err != nil {
+				fmt.Fprintf(os.Stdout, "check failed: %s\n", err)
+				os.Exit(1)
 			}
-			log.Fatalf("failed to read config (%s): %s", configPath, err)
-		}
-		var cfg config
-		err = json.NewDecoder(file).Decode(&cfg)
-		if err != nil {
-			log.Fatalf("failed to parse config (%s): %s", configPath, err)
+			fmt.Fprintln(os.Stdout, "check passed")
+			return
 		}
 
-		for _, pkg := range cfg.Packages {
-			pkgBin, err := os.Open(pkg.Path)
-			if err != nil {
-				log.Fatalf("failed to open %s for verification: %s", pkg.Path, err)
+		skipSHA256Check := viper.GetBool("serve-skip-sha256-check")
+		cfg, err := loadConfig(configPath, skipSHA256Check)
+		if err != nil {
+			for _, err := range configErrors(err) {
+				log.Print(err)
 			}
-
-			hash := sha256.New()
-			io.Copy(hash, pkgBin)
-			pkg.SHA256Sum = fmt.Sprintf("%x", hash.Sum(nil))
-
-			pkgBin.Close()
+			log.Fatalf("failed to load config (%s)", configPath)
 		}
 
 		l, err := net.Listen("tcp", viper.GetString("serve-addr"))
@@ -64,25 +59,81 @@ var serveCmd = &cobra.Command{
 			log.Fatalf("failed to listen on %s", viper.GetString("serve-addr"))
 		}
 
-		pool, err := pgxpool.Connect(context.Background(), viper.GetString("serve-pg-dsn"))
+		// The db may be unavailable for a while, eg. when it is being
+		// restarted at the same time, so keep retrying before giving up.
+		var (
+			pool    *pgxpool.Pool
+			dbStore *db.PG
+		)
+		connectCtx, cancelConnect := context.WithTimeout(context.Background(), viper.GetDuration("serve-pg-connect-timeout"))
+		err = db.Retry(connectCtx, &db.Backoff{Initial: time.Second, Max: 30 * time.Second}, func(ctx context.Context) error {
+			if pool == nil {
+				p, err := pgxpool.Connect(ctx, viper.GetString("serve-pg-dsn"))
+				if err != nil {
+					log.Printf("failed to connect to db, retrying: %s", err)
+					return err
+				}
+				pool = p
+				dbStore = db.NewPG(pool, db.WithLogger(slog.Default().With("component", "db")))
+			}
+			if err := dbStore.Init(ctx); err != nil {
+				log.Printf("failed to init db, retrying: %s", err)
+				return err
+			}
+			return nil
+		})
+		cancelConnect()
 		if err != nil {
-			log.Fatalf("failed to connect to db at %s: %s", viper.GetString("serve-addr"), err)
+			log.Fatalf("failed to set up db: %s", err)
 		}
 		defer pool.Close()
 
-		dbStore := db.NewPG(pool)
-		err = dbStore.Init(context.Background())
+		// Packages are stored in the db, which the config's packages only
+		// seed, so that they can be managed through the API.
+		if err := dbStore.SeedPackages(context.Background(), cfg.packageDefinitions); err != nil {
+			log.Fatalf("failed to seed packages: %s", err)
+		}
+		packageLoader := newPackageLoader(dbStore, skipSHA256Check)
+		packages, err := packageLoader.load(context.Background())
 		if err != nil {
-			log.Fatalf("failed to init db: %s", err)
+			log.Fatalf("failed to load packages: %s", err)
 		}
+		packageRefresher := newPackageRefresher(packageLoader, packages)
 
-		var httpOpts []testerhttp.Option
-		if apiKey := viper.GetString("serve-api-key"); apiKey != "" {
-			httpOpts = append(httpOpts, testerhttp.WithAPIKey(apiKey))
+		if cfg.Metrics != nil && cfg.Metrics.RunDurationBuckets != nil {
+			if err := testerhttp.SetRunDurationBuckets(cfg.Metrics.RunDurationBuckets); err != nil {
+				log.Fatalf("failed to configure run duration buckets: %s", err)
+			}
 		}
 
+		basePath := testerhttp.NormalizeBasePath(viper.GetString("serve-base-path"))
+		httpOpts := []testerhttp.Option{
+			testerhttp.WithLogger(slog.Default().With("component", "http")),
+			testerhttp.WithBasePath(basePath),
+			testerhttp.WithPackageLoader(packageLoader.loadPackage),
+			testerhttp.WithPackagesChanged(packageRefresher.Trigger),
+		}
+		if flakyWindow := viper.GetDuration("serve-flaky-window"); flakyWindow > 0 {
+			httpOpts = append(httpOpts, testerhttp.WithFlakyWindow(flakyWindow))
+		}
+		if apiKeys := viper.GetStringSlice("serve-api-key"); len(apiKeys) > 0 {
+			httpOpts = append(httpOpts, testerhttp.WithAPIKeys(apiKeys))
+		}
+		if runnerKeys := viper.GetStringSlice("serve-runner-key"); len(runnerKeys) > 0 {
+			httpOpts = append(httpOpts, testerhttp.WithRunnerKeys(runnerKeys))
+		}
+		if cfg.CORS != nil {
+			httpOpts = append(httpOpts, testerhttp.WithCORS(cfg.CORS.httpConfig()))
+		}
+		if n := viper.GetInt("serve-log-request-body-bytes"); n > 0 {
+			httpOpts = append(httpOpts, testerhttp.WithRequestBodyLogging(n))
+		}
+		httpOpts = append(httpOpts, testerhttp.WithMaxRequestBodySize(viper.GetInt64("serve-max-request-body-bytes")))
+
 		log.Print("configuring scheduler")
-		var schedulerOpts []scheduler.Option
+		schedulerOpts := []scheduler.Option{
+			scheduler.WithLogger(slog.Default().With("component", "scheduler")),
+		}
 		if cfg.Scheduler != nil {
 			if cfg.Scheduler.RunTimeout != "" {
 				timeout, err := time.ParseDuration(cfg.Scheduler.RunTimeout)
@@ -92,14 +143,26 @@ var serveCmd = &cobra.Command{
 				schedulerOpts = append(schedulerOpts, scheduler.WithRunTimeout(timeout))
 			}
 		}
-		scheduler := scheduler.NewScheduler(dbStore, cfg.Packages)
+		scheduler := scheduler.NewScheduler(dbStore, packages, schedulerOpts...)
 
 		log.Print("configuring alert manager")
 		var (
 			alerters []alerting.Alerter
-			baseURL  = viper.GetString("serve-base-url")
+			// Links are to the UI, which is served under the base path.
+			baseURL = strings.TrimSuffix(viper.GetString("serve-base-url"), "/") + basePath
+		)
+		if integrationKey := viper.GetString("serve-pagerduty-integration-key"); integrationKey != "" {
+			log.Print("configuring pagerduty")
+			alerters = append(alerters, alerting.NewPagerDutyAlerter(integrationKey))
+		}
+		alertManager := alerting.NewAlertManager(
+			baseURL,
+			alerters,
+			alerting.WithAlertBufferSize(viper.GetInt("serve-alert-buffer-size")),
+			alerting.WithQuarantineLister(dbStore),
+			alerting.WithOwners(cfg.Owners),
+			alerting.WithLogger(slog.Default().With("component", "alerting")),
 		)
-		alertManager := alerting.NewAlertManager(baseURL, alerters)
 		httpOpts = append(httpOpts, testerhttp.WithAlertManager(alertManager))
 
 		var slackApp *slack.App
@@ -116,29 +179,42 @@ var serveCmd = &cobra.Command{
 			if cfg.Slack.CustomChannels != nil {
 				opts = append(opts, slack.WithCustomChannels(cfg.Slack.CustomChannels))
 			}
-			slackApp = slack.NewApp(cfg.Packages, opts...)
+			slackApp = slack.NewApp(packages, opts...)
 			alertManager.RegisterAlerter(slackApp)
 			httpOpts = append(httpOpts, testerhttp.WithSlackApp(slackApp))
 		}
 
-		uiHandler := testerhttp.NewUIHandler(dbStore, cfg.Packages)
-		apiHandler := testerhttp.NewAPIHandler(dbStore, cfg.Packages, httpOpts...)
+		uiOpts := []testerhttp.Option{
+			testerhttp.WithLogger(slog.Default().With("component", "ui")),
+			testerhttp.WithBasePath(basePath),
+		}
+		if cfg.UI != nil {
+			// The config has been validated when it was loaded.
+			uiConfig, _ := cfg.UI.httpConfig()
+			uiOpts = append(uiOpts, testerhttp.WithUIConfig(uiConfig))
+		}
+		uiHandler := testerhttp.NewUIHandler(dbStore, packages, uiOpts...)
+		apiHandler := testerhttp.NewAPIHandler(dbStore, packages, httpOpts...)
 
 		mux := http.NewServeMux()
 		mux.Handle("/metrics", promhttp.Handler())
-		mux.Handle("/api/", apiHandler)
+		mux.Handle("/readyz", readyzHandler(dbStore.Healthy))
+		mux.Handle(basePath+"/api/", testerhttp.GzipMiddleware(apiHandler, testerhttp.DefaultGzipMinSize))
 
-		oktaAuthHandler := configureOktaAuth(uiHandler.RenderError)
+		oktaAuthHandler := configureOktaAuth(basePath+"/", uiHandler.RenderError)
 		if oktaAuthHandler != nil {
 			log.Println("configuring okta auth")
-			mux.HandleFunc("/oauth/callback", oktaAuthHandler.AuthCodeCallbackHandler)
-			mux.HandleFunc("/", oktaAuthHandler.Ensure(uiHandler.ServeHTTP))
+			mux.HandleFunc(basePath+"/oauth/callback", oktaAuthHandler.AuthCodeCallbackHandler)
+			mux.Handle(basePath+"/", testerhttp.GzipMiddleware(oktaAuthHandler.Ensure(uiHandler.ServeHTTP), testerhttp.DefaultGzipMinSize))
 		} else {
-			mux.Handle("/", uiHandler)
+			mux.Handle(basePath+"/", testerhttp.GzipMiddleware(uiHandler, testerhttp.DefaultGzipMinSize))
 		}
 
 		httpServer := http.Server{
-			Handler: mux,
+			Handler:           mux,
+			ReadHeaderTimeout: viper.GetDuration("serve-read-header-timeout"),
+			ReadTimeout:       viper.GetDuration("serve-read-timeout"),
+			WriteTimeout:      viper.GetDuration("serve-write-timeout"),
 		}
 
 		done := make(chan os.Signal, 1)
@@ -146,6 +222,46 @@ var serveCmd = &cobra.Command{
 		ctx, cancel := context.WithCancel(context.Background())
 		defer cancel()
 
+		dumpStacksOnSignal(ctx, os.Stderr, syscall.SIGQUIT)
+		dbStore.StartMetrics(ctx)
+		dbStore.StartHealthCheck(ctx)
+		alertManager.StartWorkers(ctx, viper.GetInt("serve-alert-workers"))
+
+		packageRefresher.Start(ctx, viper.GetDuration("serve-packages-refresh-interval"), func(packages []*tester.Package) {
+			scheduler.UpdatePackages(packages)
+			uiHandler.UpdatePackages(packages)
+			apiHandler.UpdatePackages(packages)
+			if slackApp != nil {
+				slackApp.UpdatePackages(packages)
+			}
+		})
+
+		reloader := &configReloader{
+			path:            configPath,
+			skipSHA256Check: skipSHA256Check,
+			seedPackages:    dbStore.SeedPackages,
+			refreshPackages: packageRefresher.Trigger,
+			setRunTimeout:   scheduler.SetRunTimeout,
+			updateOwners:    alertManager.UpdateOwners,
+			current:         cfg,
+		}
+		if slackApp != nil {
+			reloader.updateSlackChannels = slackApp.UpdateChannels
+		}
+		reloadConfigOnSignal(ctx, reloader, syscall.SIGHUP)
+
+		if viper.GetBool("serve-config-watch") {
+			log.Printf("watching config (%s) for changes", configPath)
+			err = watchConfig(ctx, configPath, skipSHA256Check, func(updated *config) {
+				if err := reloader.apply(ctx, updated); err != nil {
+					log.Printf("failed to apply config (%s): %s", configPath, err)
+				}
+			})
+			if err != nil {
+				log.Fatalf("failed to watch config: %s", err)
+			}
+		}
+
 		go func() {
 			defer close(done)
 			<-done
@@ -165,8 +281,7 @@ var serveCmd = &cobra.Command{
 				})
 				eg.Go(func() error {
 					log.Printf("attempting to shutdown scheduler")
-					scheduler.Stop()
-					return nil
+					return scheduler.Stop(shutdownCtx)
 				})
 				err := eg.Wait()
 				if err != nil {
@@ -182,9 +297,13 @@ var serveCmd = &cobra.Command{
 		})
 		eg.Go(func() error {
 			for {
-				if _, _, _, _, err := uiHandler.LoadSummaries(ctx); err != nil {
+				// Bound each refresh so that an unresponsive db does not
+				// wedge the loop.
+				refreshCtx, cancel := context.WithTimeout(ctx, time.Minute)
+				if _, _, _, _, err := uiHandler.LoadSummaries(refreshCtx); err != nil {
 					log.Printf("failed to refresh summaries %s", err)
 				}
+				cancel()
 
 				select {
 				case <-time.After(time.Minute):
@@ -203,27 +322,141 @@ var serveCmd = &cobra.Command{
 	},
 }
 
+// readyzHandler reports whether the server is ready to serve requests, which
+// requires the db to be healthy.
+func readyzHandler(healthy func() error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := healthy(); err != nil {
+			http.Error(w, fmt.Sprintf("db unhealthy: %s", err), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	}
+}
+
+// dumpStacksOnSignal writes the stack traces of all goroutines to w each time
+// one of the signals is received, until ctx is done. Unlike Go's default
+// SIGQUIT handling the process keeps running, so a hung server can be
+// inspected without being restarted.
+func dumpStacksOnSignal(ctx context.Context, w io.Writer, sigs ...os.Signal) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	go func() {
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-c:
+				fmt.Fprintf(w, "received %s, dumping goroutine stacks\n%s\n", sig, goroutineStacks())
+			}
+		}
+	}()
+}
+
+// goroutineStacks returns the stack traces of all goroutines, growing the
+// buffer until they fit.
+func goroutineStacks() []byte {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return buf[:n]
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// checkServe validates the config at configPath, verifies the checksums of
+// its packages' test binaries and checks that the db is reachable, writing a
+// report of each check to w.
+func checkServe(ctx context.Context, w io.Writer, configPath, pgDSN string) error {
+	cfg, err := loadConfig(configPath, false)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "config (%s): ok\n", configPath)
+	for _, pkg := range cfg.Packages {
+		if len(pkg.Variants) == 0 {
+			fmt.Fprintf(w, "  package %s (%s): sha256sum %s\n", pkg.Name, pkg.Path, pkg.SHA256Sum)
+			continue
+		}
+		for _, variant := range pkg.Variants {
+			fmt.Fprintf(w, "  package %s variant %s (%s): sha256sum %s\n", pkg.Name, variant.Name, variant.Path, variant.SHA256Sum)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	pool, err := pgxpool.Connect(ctx, pgDSN)
+	if err != nil {
+		return fmt.Errorf("failed to connect to db: %w", err)
+	}
+	defer pool.Close()
+	if _, err := pool.Exec(ctx, "SELECT 1"); err != nil {
+		return fmt.Errorf("failed to query db: %w", err)
+	}
+	fmt.Fprintln(w, "db: ok")
+	return nil
+}
+
 func init() {
 	serveCmd.Flags().String("config", "", "Path to the configuration file")
 	viper.BindPFlag("serve-config", serveCmd.Flags().Lookup("config"))
+	serveCmd.Flags().Bool("config-watch", false, "Watch the configuration file and reload it on change")
+	viper.BindPFlag("serve-config-watch", serveCmd.Flags().Lookup("config-watch"))
+	serveCmd.Flags().Bool("check", false, "Check the configuration, test binaries and db connectivity, then exit")
+	viper.BindPFlag("serve-check", serveCmd.Flags().Lookup("check"))
+	serveCmd.Flags().Duration("packages-refresh-interval", time.Minute, "How often to reload the packages stored in the db")
+	viper.BindPFlag("serve-packages-refresh-interval", serveCmd.Flags().Lookup("packages-refresh-interval"))
+	serveCmd.Flags().Bool("skip-sha256-check", false, "Use the test binaries' actual sha256 sums when they don't match the configured ones, for development")
+	viper.BindPFlag("serve-skip-sha256-check", serveCmd.Flags().Lookup("skip-sha256-check"))
 
 	serveCmd.Flags().String("addr", "0.0.0.0:8080", "The address to serve on")
 	viper.BindPFlag("serve-addr", serveCmd.Flags().Lookup("addr"))
 
 	serveCmd.Flags().String("base-url", "http://0.0.0.0:8080", "The base url to use for constructing link urls")
 	viper.BindPFlag("serve-base-url", serveCmd.Flags().Lookup("base-url"))
+	serveCmd.Flags().String("base-path", "", "The path prefix (eg. /tester) to serve the UI and API under, for serving behind a reverse proxy")
+	viper.BindPFlag("serve-base-path", serveCmd.Flags().Lookup("base-path"))
 
 	serveCmd.Flags().String("pg-dsn", "", "The postgresql dsn to use.")
 	viper.BindPFlag("serve-pg-dsn", serveCmd.Flags().Lookup("pg-dsn"))
+	serveCmd.Flags().Duration("pg-connect-timeout", 5*time.Minute, "How long to keep retrying to connect to the db on start")
+	viper.BindPFlag("serve-pg-connect-timeout", serveCmd.Flags().Lookup("pg-connect-timeout"))
 
-	serveCmd.Flags().String("api-key", "", "Symmetric key for API Auth")
+	serveCmd.Flags().StringSlice("api-key", nil, "Symmetric keys for API Auth, oldest first, any of which are accepted until rotated out")
 	viper.BindPFlag("serve-api-key", serveCmd.Flags().Lookup("api-key"))
+	serveCmd.Flags().StringSlice("runner-key", nil, "Symmetric keys for runners' API Auth, which are required for runners to be sent the values of package secrets")
+	viper.BindPFlag("serve-runner-key", serveCmd.Flags().Lookup("runner-key"))
+	serveCmd.Flags().Int("log-request-body-bytes", 0, "Log up to this many bytes of API request bodies at debug level, 0 to not log them")
+	viper.BindPFlag("serve-log-request-body-bytes", serveCmd.Flags().Lookup("log-request-body-bytes"))
+	serveCmd.Flags().Int64("max-request-body-bytes", testerhttp.DefaultMaxRequestBodySize, "Reject API requests with bodies larger than this many bytes with 413, 0 for no limit")
+	viper.BindPFlag("serve-max-request-body-bytes", serveCmd.Flags().Lookup("max-request-body-bytes"))
+
+	serveCmd.Flags().Duration("read-header-timeout", 10*time.Second, "How long to wait for a request's headers, 0 for no timeout")
+	viper.BindPFlag("serve-read-header-timeout", serveCmd.Flags().Lookup("read-header-timeout"))
+	serveCmd.Flags().Duration("read-timeout", time.Minute, "How long to wait for a whole request, including its body, 0 for no timeout")
+	viper.BindPFlag("serve-read-timeout", serveCmd.Flags().Lookup("read-timeout"))
+	serveCmd.Flags().Duration("write-timeout", 10*time.Minute, "How long to allow for writing a response, which must fit test binary downloads, 0 for no timeout")
+	viper.BindPFlag("serve-write-timeout", serveCmd.Flags().Lookup("write-timeout"))
 
 	serveCmd.Flags().String("slack-access-token", "", "Slack app access token")
 	viper.BindPFlag("serve-slack-access-token", serveCmd.Flags().Lookup("slack-access-token"))
 	serveCmd.Flags().String("slack-signing-secret", "", "Slack signing secret")
 	viper.BindPFlag("serve-slack-signing-secret", serveCmd.Flags().Lookup("slack-signing-secret"))
 
+	serveCmd.Flags().String("pagerduty-integration-key", "", "PagerDuty Events v2 integration key")
+	viper.BindPFlag("serve-pagerduty-integration-key", serveCmd.Flags().Lookup("pagerduty-integration-key"))
+
+	serveCmd.Flags().Duration("flaky-window", 24*time.Hour, "Window of test history checked for flaky tests, 0 disables flaky test detection")
+	viper.BindPFlag("serve-flaky-window", serveCmd.Flags().Lookup("flaky-window"))
+
+	serveCmd.Flags().Int("alert-workers", 4, "Number of workers firing alerts")
+	viper.BindPFlag("serve-alert-workers", serveCmd.Flags().Lookup("alert-workers"))
+	serveCmd.Flags().Int("alert-buffer-size", 100, "Number of alerts that can be queued before alerts are dropped")
+	viper.BindPFlag("serve-alert-buffer-size", serveCmd.Flags().Lookup("alert-buffer-size"))
+
 	serveCmd.Flags().String("okta-session-key", "", "Okta session key")
 	viper.BindPFlag("serve-okta-session-key", serveCmd.Flags().Lookup("okta-session-key"))
 	serveCmd.Flags().String("okta-client-id", "", "Okta client ID")
@@ -236,7 +469,7 @@ func init() {
 	viper.BindPFlag("serve-okta-redirect-uri", serveCmd.Flags().Lookup("okta-redirect-uri"))
 }
 
-func configureOktaAuth(errorWriter func(w http.ResponseWriter, r *http.Request, err error, status int)) *okta.AuthHandler {
+func configureOktaAuth(homePath string, errorWriter func(w http.ResponseWriter, r *http.Request, err error, status int)) *okta.AuthHandler {
 	sessionKey := viper.GetString("serve-okta-session-key")
 	clientID := viper.GetString("serve-okta-client-id")
 	clientSecret := viper.GetString("serve-okta-client-secret")
@@ -248,7 +481,7 @@ func configureOktaAuth(errorWriter func(w http.ResponseWriter, r *http.Request,
 		clientSecret != "" &&
 		issuer != "" &&
 		redirectURI != "" {
-		return okta.NewAuthHandler([]byte(sessionKey), clientID, clientSecret, issuer, redirectURI, errorWriter)
+		return okta.NewAuthHandler([]byte(sessionKey), clientID, clientSecret, issuer, redirectURI, homePath, errorWriter)
 	}
 	return nil
 }
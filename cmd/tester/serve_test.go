@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/nanzhong/tester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckServe(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "pkg.test")
+	require.NoError(t, ioutil.WriteFile(bin, []byte("pkg"), 0755))
+	sha256Sum, err := (&tester.Package{Path: bin}).ComputeSHA256Sum()
+	require.NoError(t, err)
+
+	validConfig := filepath.Join(dir, "valid.json")
+	writeConfig(t, validConfig, &config{
+		Packages: []*tester.Package{{Name: "pkg", Path: bin}},
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		configPath := filepath.Join(dir, "invalid.json")
+		writeConfig(t, configPath, &config{
+			Packages: []*tester.Package{{Name: "pkg", Path: bin, SHA256Sum: "bad"}},
+		})
+
+		var report bytes.Buffer
+		err := checkServe(context.Background(), &report, configPath, "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "does not match the configured sha256 sum")
+		assert.Empty(t, report.String())
+	})
+
+	t.Run("unreachable db", func(t *testing.T) {
+		var report bytes.Buffer
+		err := checkServe(context.Background(), &report, validConfig, "postgres://tester@127.0.0.1:1/tester?connect_timeout=1")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "failed to connect to db")
+		assert.Contains(t, report.String(), "config ("+validConfig+"): ok")
+		assert.Contains(t, report.String(), "package pkg ("+bin+"): sha256sum "+sha256Sum)
+		assert.NotContains(t, report.String(), "db: ok")
+	})
+
+	t.Run("ok", func(t *testing.T) {
+		pgDSN := os.Getenv("PG_DSN")
+		if pgDSN == "" {
+			t.Skip("PG_DSN not set, skipping db check. Set PG_DSN to run this test.")
+		}
+
+		var report bytes.Buffer
+		err := checkServe(context.Background(), &report, validConfig, pgDSN)
+		require.NoError(t, err)
+		assert.Contains(t, report.String(), "db: ok")
+	})
+}
+
+func TestReadyzHandler(t *testing.T) {
+	var healthErr error
+	handler := readyzHandler(func() error { return healthErr })
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	healthErr = errors.New("connection refused")
+	rec = httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "connection refused")
+}
+
+// chanWriter sends each write to a channel.
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestDumpStacksOnSignal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	w := make(chanWriter, 1)
+	dumpStacksOnSignal(ctx, w, syscall.SIGQUIT)
+
+	// The process keeps running, so stacks can be dumped repeatedly.
+	for i := 0; i < 2; i++ {
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGQUIT))
+
+		select {
+		case out := <-w:
+			assert.Contains(t, out, "received quit, dumping goroutine stacks")
+			assert.Contains(t, out, "goroutine ")
+			assert.Contains(t, out, "TestDumpStacksOnSignal")
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for stacks")
+		}
+	}
+}
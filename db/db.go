@@ -9,8 +9,25 @@ import (
 	"github.com/nanzhong/tester"
 )
 
-// ErrNotFound is returned when the requested item could not be found.
-var ErrNotFound = errors.New("not found")
+var (
+	// ErrNotFound is returned when the requested item could not be found.
+	ErrNotFound = errors.New("not found")
+	// ErrAlreadyExists is returned when adding an item that already exists.
+	ErrAlreadyExists = errors.New("already exists")
+)
+
+// TestFilter filters the tests listed by QueryTests. Fields that are not set
+// don't filter.
+type TestFilter struct {
+	Package string
+	State   tester.TBState
+	// Begin and End bound when the tests started, inclusively.
+	Begin time.Time
+	End   time.Time
+
+	Limit  int
+	Offset int
+}
 
 //go:generate mockgen -package=db -destination=db_mock.go . DB
 
@@ -20,19 +37,71 @@ type DB interface {
 
 	AddTest(ctx context.Context, test *tester.Test) error
 	GetTest(ctx context.Context, id uuid.UUID) (*tester.Test, error)
+	// QueryTests lists the tests matching the filter, ordered by when they
+	// started.
+	QueryTests(ctx context.Context, f TestFilter) ([]*tester.Test, error)
 	ListTests(ctx context.Context, limit int) ([]*tester.Test, error)
+	ListTestsByState(ctx context.Context, state tester.TBState, limit int) ([]*tester.Test, error)
+	ListTestsByLabels(ctx context.Context, labels tester.Labels, limit int) ([]*tester.Test, error)
+	SearchTests(ctx context.Context, query, pkg string, limit int) ([]*tester.Test, error)
+	ListTestsForRun(ctx context.Context, runID uuid.UUID, limit int) ([]*tester.Test, error)
+	// GetTestsByRunID lists the tests submitted for each of the runs, keyed
+	// by run ID.
+	GetTestsByRunID(ctx context.Context, runIDs []uuid.UUID) (map[uuid.UUID][]*tester.Test, error)
 	ListTestsForPackage(ctx context.Context, pkg string, limit int) ([]*tester.Test, error)
 	ListTestsForPackageInRange(ctx context.Context, pkg string, begin, end time.Time) ([]*tester.Test, error)
+	// CountTestsForPackage counts the tests of pkg in the given state, or in
+	// any state if state is empty.
+	CountTestsForPackage(ctx context.Context, pkg string, state tester.TBState) (int64, error)
+	// MarkFlakyTests marks the tests of pkg that started within window as
+	// flaky if a test of the same name both passed and failed within it,
+	// returning the names of the flaky tests.
+	MarkFlakyTests(ctx context.Context, pkg string, window time.Duration) ([]string, error)
+
+	// Quarantine quarantines the test of pkg with the top level name, whose
+	// failures are recorded but not alerted on. Quarantining a test that is
+	// already quarantined is a no-op.
+	Quarantine(ctx context.Context, pkg, name string) error
+	Unquarantine(ctx context.Context, pkg, name string) error
+	ListQuarantined(ctx context.Context) ([]*tester.QuarantinedTest, error)
+
+	// ListPackages lists the package definitions stored in the db, ordered
+	// by name.
+	ListPackages(ctx context.Context) ([]*tester.Package, error)
+	GetPackage(ctx context.Context, name string) (*tester.Package, error)
+	AddPackage(ctx context.Context, pkg *tester.Package) error
+	UpdatePackage(ctx context.Context, pkg *tester.Package) error
+	DeletePackage(ctx context.Context, name string) error
+	// SeedPackages adds the packages that are not stored in the db yet and
+	// replaces the definitions of stored packages that differ from the
+	// definitions they were last seeded with. Stored packages whose seeded
+	// definitions are unchanged are left as they are, including changes
+	// made to them since.
+	SeedPackages(ctx context.Context, pkgs []*tester.Package) error
 
 	EnqueueRun(ctx context.Context, run *tester.Run) error
-	StartRun(ctx context.Context, id uuid.UUID, runner string) error
+	// AddRun adds the run as it is, eg. already started and finished, unless
+	// a run with its ID already exists, in which case it does nothing.
+	AddRun(ctx context.Context, run *tester.Run) error
+	StartRun(ctx context.Context, id uuid.UUID, meta tester.RunMeta) error
 	ResetRun(ctx context.Context, id uuid.UUID) error
 	DeleteRun(ctx context.Context, id uuid.UUID) error
 	CompleteRun(ctx context.Context, id uuid.UUID) error
 	FailRun(ctx context.Context, id uuid.UUID, error string) error
+	DeadLetterRun(ctx context.Context, id uuid.UUID, reason string) error
 	GetRun(ctx context.Context, id uuid.UUID) (*tester.Run, error)
+	// GetRunMetadata gets the run without loading its tests, for callers that
+	// only need the state of the run.
+	GetRunMetadata(ctx context.Context, id uuid.UUID) (*tester.Run, error)
 	ListPendingRuns(ctx context.Context) ([]*tester.Run, error)
-	ListFinishedRuns(ctx context.Context, limit int) ([]*tester.Run, error)
-	ListRunsForPackage(ctx context.Context, pkg string, limit int) ([]*tester.Run, error)
+	ListPendingRunsForPackage(ctx context.Context, pkg string) ([]*tester.Run, error)
+	// ListFinishedRuns and ListDeadLetteredRuns list runs of all packages if
+	// pkg is empty.
+	ListFinishedRuns(ctx context.Context, pkg string, limit, offset int) ([]*tester.Run, error)
+	ListDeadLetteredRuns(ctx context.Context, pkg string, limit, offset int) ([]*tester.Run, error)
+	ListRunsForPackage(ctx context.Context, pkg string, limit, offset int) ([]*tester.Run, error)
 	ListRunSummariesInRange(ctx context.Context, begin, end time.Time, window time.Duration) ([]*tester.RunSummary, error)
+	// GetPackagePassRateHistory returns the pass rate of the finished runs of
+	// the package started within [begin, end] in buckets of the given size.
+	GetPackagePassRateHistory(ctx context.Context, pkg string, begin, end time.Time, bucket time.Duration) ([]*tester.PackageHistory, error)
 }
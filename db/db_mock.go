@@ -36,6 +36,34 @@ func (m *MockDB) EXPECT() *MockDBMockRecorder {
 	return m.recorder
 }
 
+// AddPackage mocks base method
+func (m *MockDB) AddPackage(arg0 context.Context, arg1 *tester.Package) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddPackage", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddPackage indicates an expected call of AddPackage
+func (mr *MockDBMockRecorder) AddPackage(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddPackage", reflect.TypeOf((*MockDB)(nil).AddPackage), arg0, arg1)
+}
+
+// AddRun mocks base method
+func (m *MockDB) AddRun(arg0 context.Context, arg1 *tester.Run) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddRun", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddRun indicates an expected call of AddRun
+func (mr *MockDBMockRecorder) AddRun(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddRun", reflect.TypeOf((*MockDB)(nil).AddRun), arg0, arg1)
+}
+
 // AddTest mocks base method
 func (m *MockDB) AddTest(arg0 context.Context, arg1 *tester.Test) error {
 	m.ctrl.T.Helper()
@@ -64,6 +92,49 @@ func (mr *MockDBMockRecorder) CompleteRun(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteRun", reflect.TypeOf((*MockDB)(nil).CompleteRun), arg0, arg1)
 }
 
+// CountTestsForPackage mocks base method
+func (m *MockDB) CountTestsForPackage(arg0 context.Context, arg1 string, arg2 tester.TBState) (int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountTestsForPackage", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountTestsForPackage indicates an expected call of CountTestsForPackage
+func (mr *MockDBMockRecorder) CountTestsForPackage(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountTestsForPackage", reflect.TypeOf((*MockDB)(nil).CountTestsForPackage), arg0, arg1, arg2)
+}
+
+// DeadLetterRun mocks base method
+func (m *MockDB) DeadLetterRun(arg0 context.Context, arg1 uuid.UUID, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeadLetterRun", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeadLetterRun indicates an expected call of DeadLetterRun
+func (mr *MockDBMockRecorder) DeadLetterRun(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeadLetterRun", reflect.TypeOf((*MockDB)(nil).DeadLetterRun), arg0, arg1, arg2)
+}
+
+// DeletePackage mocks base method
+func (m *MockDB) DeletePackage(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeletePackage", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeletePackage indicates an expected call of DeletePackage
+func (mr *MockDBMockRecorder) DeletePackage(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeletePackage", reflect.TypeOf((*MockDB)(nil).DeletePackage), arg0, arg1)
+}
+
 // DeleteRun mocks base method
 func (m *MockDB) DeleteRun(arg0 context.Context, arg1 uuid.UUID) error {
 	m.ctrl.T.Helper()
@@ -106,6 +177,36 @@ func (mr *MockDBMockRecorder) FailRun(arg0, arg1, arg2 interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailRun", reflect.TypeOf((*MockDB)(nil).FailRun), arg0, arg1, arg2)
 }
 
+// GetPackage mocks base method
+func (m *MockDB) GetPackage(arg0 context.Context, arg1 string) (*tester.Package, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPackage", arg0, arg1)
+	ret0, _ := ret[0].(*tester.Package)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPackage indicates an expected call of GetPackage
+func (mr *MockDBMockRecorder) GetPackage(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPackage", reflect.TypeOf((*MockDB)(nil).GetPackage), arg0, arg1)
+}
+
+// GetPackagePassRateHistory mocks base method
+func (m *MockDB) GetPackagePassRateHistory(arg0 context.Context, arg1 string, arg2, arg3 time.Time, arg4 time.Duration) ([]*tester.PackageHistory, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPackagePassRateHistory", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].([]*tester.PackageHistory)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetPackagePassRateHistory indicates an expected call of GetPackagePassRateHistory
+func (mr *MockDBMockRecorder) GetPackagePassRateHistory(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPackagePassRateHistory", reflect.TypeOf((*MockDB)(nil).GetPackagePassRateHistory), arg0, arg1, arg2, arg3, arg4)
+}
+
 // GetRun mocks base method
 func (m *MockDB) GetRun(arg0 context.Context, arg1 uuid.UUID) (*tester.Run, error) {
 	m.ctrl.T.Helper()
@@ -121,6 +222,21 @@ func (mr *MockDBMockRecorder) GetRun(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRun", reflect.TypeOf((*MockDB)(nil).GetRun), arg0, arg1)
 }
 
+// GetRunMetadata mocks base method
+func (m *MockDB) GetRunMetadata(arg0 context.Context, arg1 uuid.UUID) (*tester.Run, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRunMetadata", arg0, arg1)
+	ret0, _ := ret[0].(*tester.Run)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRunMetadata indicates an expected call of GetRunMetadata
+func (mr *MockDBMockRecorder) GetRunMetadata(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRunMetadata", reflect.TypeOf((*MockDB)(nil).GetRunMetadata), arg0, arg1)
+}
+
 // GetTest mocks base method
 func (m *MockDB) GetTest(arg0 context.Context, arg1 uuid.UUID) (*tester.Test, error) {
 	m.ctrl.T.Helper()
@@ -136,6 +252,21 @@ func (mr *MockDBMockRecorder) GetTest(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTest", reflect.TypeOf((*MockDB)(nil).GetTest), arg0, arg1)
 }
 
+// GetTestsByRunID mocks base method
+func (m *MockDB) GetTestsByRunID(arg0 context.Context, arg1 []uuid.UUID) (map[uuid.UUID][]*tester.Test, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTestsByRunID", arg0, arg1)
+	ret0, _ := ret[0].(map[uuid.UUID][]*tester.Test)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTestsByRunID indicates an expected call of GetTestsByRunID
+func (mr *MockDBMockRecorder) GetTestsByRunID(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTestsByRunID", reflect.TypeOf((*MockDB)(nil).GetTestsByRunID), arg0, arg1)
+}
+
 // Init mocks base method
 func (m *MockDB) Init(arg0 context.Context) error {
 	m.ctrl.T.Helper()
@@ -150,19 +281,49 @@ func (mr *MockDBMockRecorder) Init(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Init", reflect.TypeOf((*MockDB)(nil).Init), arg0)
 }
 
+// ListDeadLetteredRuns mocks base method
+func (m *MockDB) ListDeadLetteredRuns(arg0 context.Context, arg1 string, arg2, arg3 int) ([]*tester.Run, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListDeadLetteredRuns", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]*tester.Run)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListDeadLetteredRuns indicates an expected call of ListDeadLetteredRuns
+func (mr *MockDBMockRecorder) ListDeadLetteredRuns(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListDeadLetteredRuns", reflect.TypeOf((*MockDB)(nil).ListDeadLetteredRuns), arg0, arg1, arg2, arg3)
+}
+
 // ListFinishedRuns mocks base method
-func (m *MockDB) ListFinishedRuns(arg0 context.Context, arg1 int) ([]*tester.Run, error) {
+func (m *MockDB) ListFinishedRuns(arg0 context.Context, arg1 string, arg2, arg3 int) ([]*tester.Run, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ListFinishedRuns", arg0, arg1)
+	ret := m.ctrl.Call(m, "ListFinishedRuns", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].([]*tester.Run)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ListFinishedRuns indicates an expected call of ListFinishedRuns
-func (mr *MockDBMockRecorder) ListFinishedRuns(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockDBMockRecorder) ListFinishedRuns(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListFinishedRuns", reflect.TypeOf((*MockDB)(nil).ListFinishedRuns), arg0, arg1, arg2, arg3)
+}
+
+// ListPackages mocks base method
+func (m *MockDB) ListPackages(arg0 context.Context) ([]*tester.Package, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPackages", arg0)
+	ret0, _ := ret[0].([]*tester.Package)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPackages indicates an expected call of ListPackages
+func (mr *MockDBMockRecorder) ListPackages(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListFinishedRuns", reflect.TypeOf((*MockDB)(nil).ListFinishedRuns), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPackages", reflect.TypeOf((*MockDB)(nil).ListPackages), arg0)
 }
 
 // ListPendingRuns mocks base method
@@ -180,6 +341,36 @@ func (mr *MockDBMockRecorder) ListPendingRuns(arg0 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPendingRuns", reflect.TypeOf((*MockDB)(nil).ListPendingRuns), arg0)
 }
 
+// ListPendingRunsForPackage mocks base method
+func (m *MockDB) ListPendingRunsForPackage(arg0 context.Context, arg1 string) ([]*tester.Run, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListPendingRunsForPackage", arg0, arg1)
+	ret0, _ := ret[0].([]*tester.Run)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListPendingRunsForPackage indicates an expected call of ListPendingRunsForPackage
+func (mr *MockDBMockRecorder) ListPendingRunsForPackage(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListPendingRunsForPackage", reflect.TypeOf((*MockDB)(nil).ListPendingRunsForPackage), arg0, arg1)
+}
+
+// ListQuarantined mocks base method
+func (m *MockDB) ListQuarantined(arg0 context.Context) ([]*tester.QuarantinedTest, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListQuarantined", arg0)
+	ret0, _ := ret[0].([]*tester.QuarantinedTest)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListQuarantined indicates an expected call of ListQuarantined
+func (mr *MockDBMockRecorder) ListQuarantined(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListQuarantined", reflect.TypeOf((*MockDB)(nil).ListQuarantined), arg0)
+}
+
 // ListRunSummariesInRange mocks base method
 func (m *MockDB) ListRunSummariesInRange(arg0 context.Context, arg1, arg2 time.Time, arg3 time.Duration) ([]*tester.RunSummary, error) {
 	m.ctrl.T.Helper()
@@ -196,18 +387,18 @@ func (mr *MockDBMockRecorder) ListRunSummariesInRange(arg0, arg1, arg2, arg3 int
 }
 
 // ListRunsForPackage mocks base method
-func (m *MockDB) ListRunsForPackage(arg0 context.Context, arg1 string, arg2 int) ([]*tester.Run, error) {
+func (m *MockDB) ListRunsForPackage(arg0 context.Context, arg1 string, arg2, arg3 int) ([]*tester.Run, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ListRunsForPackage", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "ListRunsForPackage", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].([]*tester.Run)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // ListRunsForPackage indicates an expected call of ListRunsForPackage
-func (mr *MockDBMockRecorder) ListRunsForPackage(arg0, arg1, arg2 interface{}) *gomock.Call {
+func (mr *MockDBMockRecorder) ListRunsForPackage(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRunsForPackage", reflect.TypeOf((*MockDB)(nil).ListRunsForPackage), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListRunsForPackage", reflect.TypeOf((*MockDB)(nil).ListRunsForPackage), arg0, arg1, arg2, arg3)
 }
 
 // ListTests mocks base method
@@ -225,6 +416,36 @@ func (mr *MockDBMockRecorder) ListTests(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTests", reflect.TypeOf((*MockDB)(nil).ListTests), arg0, arg1)
 }
 
+// ListTestsByLabels mocks base method
+func (m *MockDB) ListTestsByLabels(arg0 context.Context, arg1 tester.Labels, arg2 int) ([]*tester.Test, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTestsByLabels", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]*tester.Test)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTestsByLabels indicates an expected call of ListTestsByLabels
+func (mr *MockDBMockRecorder) ListTestsByLabels(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTestsByLabels", reflect.TypeOf((*MockDB)(nil).ListTestsByLabels), arg0, arg1, arg2)
+}
+
+// ListTestsByState mocks base method
+func (m *MockDB) ListTestsByState(arg0 context.Context, arg1 tester.TBState, arg2 int) ([]*tester.Test, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTestsByState", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]*tester.Test)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTestsByState indicates an expected call of ListTestsByState
+func (mr *MockDBMockRecorder) ListTestsByState(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTestsByState", reflect.TypeOf((*MockDB)(nil).ListTestsByState), arg0, arg1, arg2)
+}
+
 // ListTestsForPackage mocks base method
 func (m *MockDB) ListTestsForPackage(arg0 context.Context, arg1 string, arg2 int) ([]*tester.Test, error) {
 	m.ctrl.T.Helper()
@@ -255,6 +476,65 @@ func (mr *MockDBMockRecorder) ListTestsForPackageInRange(arg0, arg1, arg2, arg3
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTestsForPackageInRange", reflect.TypeOf((*MockDB)(nil).ListTestsForPackageInRange), arg0, arg1, arg2, arg3)
 }
 
+// ListTestsForRun mocks base method
+func (m *MockDB) ListTestsForRun(arg0 context.Context, arg1 uuid.UUID, arg2 int) ([]*tester.Test, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListTestsForRun", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]*tester.Test)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListTestsForRun indicates an expected call of ListTestsForRun
+func (mr *MockDBMockRecorder) ListTestsForRun(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListTestsForRun", reflect.TypeOf((*MockDB)(nil).ListTestsForRun), arg0, arg1, arg2)
+}
+
+// MarkFlakyTests mocks base method
+func (m *MockDB) MarkFlakyTests(arg0 context.Context, arg1 string, arg2 time.Duration) ([]string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MarkFlakyTests", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// MarkFlakyTests indicates an expected call of MarkFlakyTests
+func (mr *MockDBMockRecorder) MarkFlakyTests(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MarkFlakyTests", reflect.TypeOf((*MockDB)(nil).MarkFlakyTests), arg0, arg1, arg2)
+}
+
+// Quarantine mocks base method
+func (m *MockDB) Quarantine(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Quarantine", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Quarantine indicates an expected call of Quarantine
+func (mr *MockDBMockRecorder) Quarantine(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Quarantine", reflect.TypeOf((*MockDB)(nil).Quarantine), arg0, arg1, arg2)
+}
+
+// QueryTests mocks base method
+func (m *MockDB) QueryTests(arg0 context.Context, arg1 TestFilter) ([]*tester.Test, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "QueryTests", arg0, arg1)
+	ret0, _ := ret[0].([]*tester.Test)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// QueryTests indicates an expected call of QueryTests
+func (mr *MockDBMockRecorder) QueryTests(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "QueryTests", reflect.TypeOf((*MockDB)(nil).QueryTests), arg0, arg1)
+}
+
 // ResetRun mocks base method
 func (m *MockDB) ResetRun(arg0 context.Context, arg1 uuid.UUID) error {
 	m.ctrl.T.Helper()
@@ -269,8 +549,37 @@ func (mr *MockDBMockRecorder) ResetRun(arg0, arg1 interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetRun", reflect.TypeOf((*MockDB)(nil).ResetRun), arg0, arg1)
 }
 
+// SearchTests mocks base method
+func (m *MockDB) SearchTests(arg0 context.Context, arg1, arg2 string, arg3 int) ([]*tester.Test, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchTests", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].([]*tester.Test)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchTests indicates an expected call of SearchTests
+func (mr *MockDBMockRecorder) SearchTests(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchTests", reflect.TypeOf((*MockDB)(nil).SearchTests), arg0, arg1, arg2, arg3)
+}
+
+// SeedPackages mocks base method
+func (m *MockDB) SeedPackages(arg0 context.Context, arg1 []*tester.Package) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SeedPackages", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SeedPackages indicates an expected call of SeedPackages
+func (mr *MockDBMockRecorder) SeedPackages(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SeedPackages", reflect.TypeOf((*MockDB)(nil).SeedPackages), arg0, arg1)
+}
+
 // StartRun mocks base method
-func (m *MockDB) StartRun(arg0 context.Context, arg1 uuid.UUID, arg2 string) error {
+func (m *MockDB) StartRun(arg0 context.Context, arg1 uuid.UUID, arg2 tester.RunMeta) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "StartRun", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
@@ -282,3 +591,31 @@ func (mr *MockDBMockRecorder) StartRun(arg0, arg1, arg2 interface{}) *gomock.Cal
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StartRun", reflect.TypeOf((*MockDB)(nil).StartRun), arg0, arg1, arg2)
 }
+
+// Unquarantine mocks base method
+func (m *MockDB) Unquarantine(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Unquarantine", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Unquarantine indicates an expected call of Unquarantine
+func (mr *MockDBMockRecorder) Unquarantine(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Unquarantine", reflect.TypeOf((*MockDB)(nil).Unquarantine), arg0, arg1, arg2)
+}
+
+// UpdatePackage mocks base method
+func (m *MockDB) UpdatePackage(arg0 context.Context, arg1 *tester.Package) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdatePackage", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdatePackage indicates an expected call of UpdatePackage
+func (mr *MockDBMockRecorder) UpdatePackage(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePackage", reflect.TypeOf((*MockDB)(nil).UpdatePackage), arg0, arg1)
+}
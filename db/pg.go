@@ -4,7 +4,10 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"math"
+	"strings"
+	"sync"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
@@ -14,28 +17,109 @@ import (
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/jackc/tern/migrate"
 	"github.com/nanzhong/tester"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var psq = sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
 
+// PGPoolTotalConnsMetric is the metric for the total number of connections in
+// the pool.
+var PGPoolTotalConnsMetric = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "tester",
+		Subsystem: "pg_pool",
+		Name:      "total_conns",
+		Help:      "Total number of connections in the pool.",
+	},
+)
+
+// PGPoolIdleConnsMetric is the metric for the number of idle connections in
+// the pool.
+var PGPoolIdleConnsMetric = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "tester",
+		Subsystem: "pg_pool",
+		Name:      "idle_conns",
+		Help:      "Number of idle connections in the pool.",
+	},
+)
+
+// PGPoolAcquireDurationMetric is the metric for the average time taken to
+// acquire a connection from the pool since the previous sample.
+var PGPoolAcquireDurationMetric = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: "tester",
+		Subsystem: "pg_pool",
+		Name:      "acquire_duration_ms",
+		Help:      "Average time taken to acquire a connection since the previous sample.",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(PGPoolTotalConnsMetric)
+	prometheus.MustRegister(PGPoolIdleConnsMetric)
+	prometheus.MustRegister(PGPoolAcquireDurationMetric)
+}
+
 type pger interface {
 	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
 	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
 	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
 }
 
+// PGOption is used to configure a PG on creation.
+type PGOption func(*PG)
+
+// WithLogger allows configuring a custom logger.
+func WithLogger(logger *slog.Logger) PGOption {
+	return func(p *PG) {
+		p.logger = logger
+	}
+}
+
+// WithPoolMetricsInterval allows configuring how often connection pool
+// metrics are sampled.
+func WithPoolMetricsInterval(d time.Duration) PGOption {
+	return func(p *PG) {
+		p.poolMetricsInterval = d
+	}
+}
+
+// WithHealthCheckInterval allows configuring how often the health of the db
+// is checked.
+func WithHealthCheckInterval(d time.Duration) PGOption {
+	return func(p *PG) {
+		p.healthCheckInterval = d
+	}
+}
+
 type PG struct {
-	pool *pgxpool.Pool
-	now  func() time.Time
+	pool                *pgxpool.Pool
+	now                 func() time.Time
+	logger              *slog.Logger
+	poolMetricsInterval time.Duration
+	healthCheckInterval time.Duration
+
+	healthMu  sync.RWMutex
+	healthErr error
 }
 
 var _ DB = (*PG)(nil)
 
-func NewPG(pool *pgxpool.Pool) *PG {
-	return &PG{
-		pool: pool,
-		now:  time.Now,
+func NewPG(pool *pgxpool.Pool, opts ...PGOption) *PG {
+	pg := &PG{
+		pool:                pool,
+		now:                 time.Now,
+		logger:              slog.Default(),
+		poolMetricsInterval: 10 * time.Second,
+		healthCheckInterval: 10 * time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(pg)
 	}
+
+	return pg
 }
 
 func (p *PG) Init(ctx context.Context) error {
@@ -53,10 +137,104 @@ func (p *PG) Init(ctx context.Context) error {
 	for _, migration := range pgMigrations {
 		m.AppendMigration(migration.name, migration.up, migration.down)
 	}
+	m.OnStart = func(sequence int32, name, direction, sql string) {
+		p.logger.Info("running migration", "sequence", sequence, "name", name, "direction", direction)
+	}
 
 	return m.Migrate(ctx)
 }
 
+// Ping checks that the db can be queried.
+func (p *PG) Ping(ctx context.Context) error {
+	_, err := p.pool.Exec(ctx, "SELECT 1")
+	return err
+}
+
+// Healthy returns the error of the most recent health check, or nil if the db
+// was healthy.
+func (p *PG) Healthy() error {
+	p.healthMu.RLock()
+	defer p.healthMu.RUnlock()
+	return p.healthErr
+}
+
+func (p *PG) setHealth(err error) {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+	p.healthErr = err
+}
+
+// StartHealthCheck starts checking the health of the db in the background
+// until the context is done. While the db is unhealthy, it is checked with
+// backoff, and once it recovers Init is run again in case the db was restored
+// without the latest migrations.
+func (p *PG) StartHealthCheck(ctx context.Context) {
+	go func() {
+		b := &Backoff{Initial: time.Second, Max: p.healthCheckInterval}
+		for {
+			checkCtx, cancel := context.WithTimeout(ctx, p.healthCheckInterval)
+			err := p.Ping(checkCtx)
+			if err == nil && p.Healthy() != nil {
+				if err = p.Init(checkCtx); err == nil {
+					p.logger.Info("db recovered")
+				}
+			}
+			cancel()
+			if ctx.Err() != nil {
+				return
+			}
+			p.setHealth(err)
+
+			wait := p.healthCheckInterval
+			if err != nil {
+				wait = b.Next()
+				p.logger.Error("db health check failed", "err", err, "retry_in", wait)
+			} else {
+				b.Reset()
+			}
+
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// StartMetrics starts sampling connection pool metrics in the background until
+// the context is done.
+func (p *PG) StartMetrics(ctx context.Context) {
+	go func() {
+		var (
+			lastAcquireCount    int64
+			lastAcquireDuration time.Duration
+		)
+		for {
+			stat := p.pool.Stat()
+			PGPoolTotalConnsMetric.Set(float64(stat.TotalConns()))
+			PGPoolIdleConnsMetric.Set(float64(stat.IdleConns()))
+
+			acquireCount := stat.AcquireCount() - lastAcquireCount
+			acquireDuration := stat.AcquireDuration() - lastAcquireDuration
+			if acquireCount > 0 {
+				avg := acquireDuration / time.Duration(acquireCount)
+				PGPoolAcquireDurationMetric.Set(float64(avg) / float64(time.Millisecond))
+			} else {
+				PGPoolAcquireDurationMetric.Set(0)
+			}
+			lastAcquireCount = stat.AcquireCount()
+			lastAcquireDuration = stat.AcquireDuration()
+
+			select {
+			case <-time.After(p.poolMetricsInterval):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
 func (p *PG) tx(ctx context.Context, f func(tx pgx.Tx) error) error {
 	tx, err := p.pool.Begin(ctx)
 	if err != nil {
@@ -112,6 +290,10 @@ func (p *PG) GetTest(ctx context.Context, id uuid.UUID) (*tester.Test, error) {
 }
 
 func (p *PG) listTests(ctx context.Context, pg pger, pred interface{}, limit int) ([]*tester.Test, error) {
+	return p.listTestsPage(ctx, pg, pred, limit, 0)
+}
+
+func (p *PG) listTestsPage(ctx context.Context, pg pger, pred interface{}, limit, offset int) ([]*tester.Test, error) {
 	var tests []*tester.Test
 	q := psq.Select((&pgTest{}).Columns()...).
 		From("tests").
@@ -124,6 +306,9 @@ func (p *PG) listTests(ctx context.Context, pg pger, pred interface{}, limit int
 	if limit > 0 {
 		q = q.Limit(uint64(limit))
 	}
+	if offset > 0 {
+		q = q.Offset(uint64(offset))
+	}
 
 	sql, args, err := q.ToSql()
 	if err != nil {
@@ -150,24 +335,360 @@ func (p *PG) listTests(ctx context.Context, pg pger, pred interface{}, limit int
 	return tests, nil
 }
 
+func (p *PG) QueryTests(ctx context.Context, f TestFilter) ([]*tester.Test, error) {
+	pred := sq.And{}
+	if f.Package != "" {
+		pred = append(pred, sq.Eq{"package": f.Package})
+	}
+	if f.State != "" {
+		pred = append(pred, sq.Expr("result->>'state' = ?", f.State))
+	}
+	if !f.Begin.IsZero() {
+		pred = append(pred, sq.Expr("result->'started_at' >= ?", f.Begin))
+	}
+	if !f.End.IsZero() {
+		pred = append(pred, sq.Expr("result->'started_at' <= ?", f.End))
+	}
+
+	var where interface{}
+	if len(pred) > 0 {
+		where = pred
+	}
+	return p.listTestsPage(ctx, p.pool, where, f.Limit, f.Offset)
+}
+
 func (p *PG) ListTests(ctx context.Context, limit int) ([]*tester.Test, error) {
-	return p.listTests(ctx, p.pool, nil, limit)
+	return p.QueryTests(ctx, TestFilter{Limit: limit})
+}
+
+func (p *PG) ListTestsByState(ctx context.Context, state tester.TBState, limit int) ([]*tester.Test, error) {
+	return p.QueryTests(ctx, TestFilter{State: state, Limit: limit})
+}
+
+// ListTestsByLabels lists tests that have all of the given labels.
+func (p *PG) ListTestsByLabels(ctx context.Context, labels tester.Labels, limit int) ([]*tester.Test, error) {
+	return p.listTests(ctx, p.pool, sq.Expr("labels @> ?", labels), limit)
+}
+
+// ListTestsForRun lists the tests submitted for a run.
+func (p *PG) ListTestsForRun(ctx context.Context, runID uuid.UUID, limit int) ([]*tester.Test, error) {
+	return p.listTests(ctx, p.pool, sq.Eq{"run_id": runID}, limit)
+}
+
+// testsByRunIDBatchSize is the number of runs whose tests are fetched by each
+// query of GetTestsByRunID, which bounds the size of the query's parameters.
+const testsByRunIDBatchSize = 100
+
+func (p *PG) GetTestsByRunID(ctx context.Context, runIDs []uuid.UUID) (map[uuid.UUID][]*tester.Test, error) {
+	testsByRunID := make(map[uuid.UUID][]*tester.Test, len(runIDs))
+	for start := 0; start < len(runIDs); start += testsByRunIDBatchSize {
+		batch := runIDs[start:min(start+testsByRunIDBatchSize, len(runIDs))]
+		// The IDs are bound as a single array parameter, rather than as a
+		// parameter each with IN.
+		ids := make([]string, len(batch))
+		for i, id := range batch {
+			ids[i] = id.String()
+		}
+
+		tests, err := p.listTests(ctx, p.pool, sq.Expr("run_id = ANY(?)", ids), 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, test := range tests {
+			testsByRunID[test.RunID] = append(testsByRunID[test.RunID], test)
+		}
+	}
+	return testsByRunID, nil
+}
+
+// SearchTests lists tests with names containing the query, ignoring case,
+// optionally limited to tests of the given package.
+func (p *PG) SearchTests(ctx context.Context, query, pkg string, limit int) ([]*tester.Test, error) {
+	pred := sq.And{sq.Expr("result->>'name' ILIKE '%' || ? || '%'", likeEscaper.Replace(query))}
+	if pkg != "" {
+		pred = append(pred, sq.Eq{"package": pkg})
+	}
+	return p.listTests(ctx, p.pool, pred, limit)
 }
 
+// likeEscaper escapes the characters that have special meaning in LIKE
+// patterns.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
 func (p *PG) ListTestsForPackage(ctx context.Context, pkg string, limit int) ([]*tester.Test, error) {
-	return p.listTests(ctx, p.pool, sq.Eq{"package": pkg}, limit)
+	return p.QueryTests(ctx, TestFilter{Package: pkg, Limit: limit})
 }
 
 func (p *PG) ListTestsInDateRange(ctx context.Context, from, to time.Time) ([]*tester.Test, error) {
-	return p.listTests(ctx, p.pool, nil, 0)
+	return p.QueryTests(ctx, TestFilter{Begin: from, End: to})
 }
 
 func (p *PG) ListTestsForPackageInRange(ctx context.Context, pkg string, from, to time.Time) ([]*tester.Test, error) {
-	return p.listTests(ctx, p.pool, sq.And{
-		sq.Eq{"package": pkg},
-		sq.Expr("result->'started_at' >= ?", from),
-		sq.Expr("result->'started_at' <= ?", to),
-	}, 0)
+	return p.QueryTests(ctx, TestFilter{Package: pkg, Begin: from, End: to})
+}
+
+func (p *PG) CountTestsForPackage(ctx context.Context, pkg string, state tester.TBState) (int64, error) {
+	q := psq.Select("COUNT(*)").
+		From("tests").
+		Where(sq.Eq{"package": pkg})
+	if state != "" {
+		q = q.Where(sq.Expr("result->>'state' = ?", state))
+	}
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return 0, err
+	}
+
+	var count int64
+	if err := p.pool.QueryRow(ctx, sql, args...).Scan(&count); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (p *PG) MarkFlakyTests(ctx context.Context, pkg string, window time.Duration) ([]string, error) {
+	// The update is a data modifying CTE, which is executed even though its
+	// results are not used.
+	rows, err := p.pool.Query(ctx, `
+WITH flaky AS (
+	SELECT result->>'name' AS name
+	FROM tests
+	WHERE package = $1 AND (result->>'started_at')::timestamptz >= $2
+	GROUP BY result->>'name'
+	HAVING bool_or(result->>'state' = $3) AND bool_or(result->>'state' = $4)
+), marked AS (
+	UPDATE tests SET result = jsonb_set(result, '{flaky}', 'true')
+	FROM flaky
+	WHERE tests.package = $1
+		AND tests.result->>'name' = flaky.name
+		AND (tests.result->>'started_at')::timestamptz >= $2
+		AND tests.result->'flaky' IS DISTINCT FROM 'true'::jsonb
+)
+SELECT name FROM flaky ORDER BY name`,
+		pkg, p.now().Add(-window), string(tester.TBStatePassed), string(tester.TBStateFailed))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+func (p *PG) Quarantine(ctx context.Context, pkg, name string) error {
+	q := psq.Insert("quarantined_tests").
+		Columns("package", "name", "quarantined_at").
+		Values(pkg, name, p.now()).
+		Suffix("ON CONFLICT (package, name) DO NOTHING")
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = p.pool.Exec(ctx, sql, args...)
+	return err
+}
+
+func (p *PG) Unquarantine(ctx context.Context, pkg, name string) error {
+	q := psq.Delete("quarantined_tests").
+		Where(sq.Eq{"package": pkg, "name": name})
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := p.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PG) ListQuarantined(ctx context.Context) ([]*tester.QuarantinedTest, error) {
+	q := psq.Select("package", "name", "quarantined_at").
+		From("quarantined_tests").
+		OrderBy("package", "name")
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := p.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var quarantined []*tester.QuarantinedTest
+	for rows.Next() {
+		var test tester.QuarantinedTest
+		if err := rows.Scan(&test.Package, &test.Name, &test.QuarantinedAt); err != nil {
+			return nil, err
+		}
+		quarantined = append(quarantined, &test)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return quarantined, nil
+}
+
+func (p *PG) ListPackages(ctx context.Context) ([]*tester.Package, error) {
+	q := psq.Select("definition").
+		From("packages").
+		OrderBy("name")
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := p.pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pkgs []*tester.Package
+	for rows.Next() {
+		var pkg tester.Package
+		if err := rows.Scan(&pkg); err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, &pkg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return pkgs, nil
+}
+
+func (p *PG) GetPackage(ctx context.Context, name string) (*tester.Package, error) {
+	q := psq.Select("definition").
+		From("packages").
+		Where(sq.Eq{"name": name})
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	var pkg tester.Package
+	if err := p.pool.QueryRow(ctx, sql, args...).Scan(&pkg); err != nil {
+		if err == pgx.ErrNoRows {
+			err = ErrNotFound
+		}
+		return nil, err
+	}
+	return &pkg, nil
+}
+
+func (p *PG) AddPackage(ctx context.Context, pkg *tester.Package) error {
+	now := p.now()
+	q := psq.Insert("packages").
+		Columns("name", "definition", "created_at", "updated_at").
+		Values(pkg.Name, pkg, now, now).
+		Suffix("ON CONFLICT (name) DO NOTHING")
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := p.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrAlreadyExists
+	}
+	return nil
+}
+
+func (p *PG) UpdatePackage(ctx context.Context, pkg *tester.Package) error {
+	q := psq.Update("packages").
+		SetMap(map[string]interface{}{
+			"definition": pkg,
+			"updated_at": p.now(),
+		}).
+		Where(sq.Eq{"name": pkg.Name})
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := p.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PG) DeletePackage(ctx context.Context, name string) error {
+	q := psq.Delete("packages").
+		Where(sq.Eq{"name": name})
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+
+	res, err := p.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (p *PG) SeedPackages(ctx context.Context, pkgs []*tester.Package) error {
+	if len(pkgs) == 0 {
+		return nil
+	}
+
+	// The seeded definitions are kept so that stored packages are only
+	// replaced when their seeded definition changes, which leaves the changes
+	// made to them through the API in place otherwise.
+	now := p.now()
+	q := psq.Insert("packages").
+		Columns("name", "definition", "seeded_definition", "created_at", "updated_at").
+		Suffix(`ON CONFLICT (name) DO UPDATE
+SET definition = EXCLUDED.definition, seeded_definition = EXCLUDED.seeded_definition, updated_at = EXCLUDED.updated_at
+WHERE packages.seeded_definition IS DISTINCT FROM EXCLUDED.seeded_definition`)
+	for _, pkg := range pkgs {
+		q = q.Values(pkg.Name, pkg, pkg, now, now)
+	}
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = p.pool.Exec(ctx, sql, args...)
+	return err
 }
 
 func (p *PG) EnqueueRun(ctx context.Context, run *tester.Run) error {
@@ -185,40 +706,73 @@ func (p *PG) EnqueueRun(ctx context.Context, run *tester.Run) error {
 	return err
 }
 
-func (p *PG) StartRun(ctx context.Context, id uuid.UUID, runner string) error {
+func (p *PG) AddRun(ctx context.Context, run *tester.Run) error {
+	r := (*pgRun)(run)
+	q := psq.Insert("runs").
+		Columns(r.Columns()...).
+		Values(r.Values()...).
+		Suffix("ON CONFLICT (id) DO NOTHING")
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
+
+	_, err = p.pool.Exec(ctx, sql, args...)
+	return err
+}
+
+// StartRun starts the run, returning ErrNotFound if the run does not exist or
+// has already been started. Concurrent calls for the same run are serialized
+// with a transaction scoped advisory lock, so that only one caller can start
+// it.
+func (p *PG) StartRun(ctx context.Context, id uuid.UUID, meta tester.RunMeta) error {
 	return p.tx(ctx, func(tx pgx.Tx) error {
+		var locked bool
+		err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock(hashtext($1::text))", id).Scan(&locked)
+		if err != nil {
+			return fmt.Errorf("locking run: %w", err)
+		}
+		if !locked {
+			// Another caller is starting the run.
+			return ErrNotFound
+		}
+
 		r := &pgRun{}
 		q := psq.Select(r.Columns()...).
 			From("runs").
-			Where("id = ?", id)
+			Where("id = ?", id).
+			Where("started_at IS NULL")
 
 		sql, args, err := q.ToSql()
 		if err != nil {
 			return err
 		}
 
-		row := p.pool.QueryRow(ctx, sql, args...)
+		row := tx.QueryRow(ctx, sql, args...)
 		err = r.Scan(row)
 		if err != nil {
 			return err
 		}
 
-		r.Meta.Runner = runner
+		r.Meta.Runner = meta.Runner
+		r.Meta.RunnerID = meta.RunnerID
+		r.Meta.Race = meta.Race
 
 		uq := psq.Update("runs").
 			Set("started_at", p.now()).
 			Set("meta", r.Meta).
-			Where("id = ?", id)
+			Where("id = ?", id).
+			Where("started_at IS NULL")
 
 		sql, args, err = uq.ToSql()
 		if err != nil {
 			return err
 		}
 
-		_, err = p.pool.Exec(ctx, sql, args...)
+		_, err = tx.Exec(ctx, sql, args...)
 		return err
 	})
-
 }
 
 func (p *PG) ResetRun(ctx context.Context, id uuid.UUID) error {
@@ -291,40 +845,68 @@ func (p *PG) FailRun(ctx context.Context, id uuid.UUID, error string) error {
 	return err
 }
 
-func (p *PG) GetRun(ctx context.Context, id uuid.UUID) (*tester.Run, error) {
-	var run *tester.Run
-	err := p.tx(ctx, func(tx pgx.Tx) error {
-		r := &pgRun{}
-		q := psq.Select(r.Columns()...).
-			From("runs").
-			Where("id = ?", id)
+// DeadLetterRun gives up on a run that was never started, recording the
+// reason. Dead lettered runs are no longer pending.
+func (p *PG) DeadLetterRun(ctx context.Context, id uuid.UUID, reason string) error {
+	q := psq.Update("runs").
+		SetMap(map[string]interface{}{
+			"dead_lettered_at":   sql.NullTime{Valid: true, Time: p.now()},
+			"dead_letter_reason": sql.NullString{Valid: true, String: reason},
+		}).
+		Where("id = ?", id).
+		Where("started_at IS NULL").
+		Where("dead_lettered_at IS NULL")
 
-		sql, args, err := q.ToSql()
-		if err != nil {
-			return err
-		}
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return err
+	}
 
-		row := p.pool.QueryRow(ctx, sql, args...)
-		err = r.Scan(row)
-		if err != nil {
-			return err
-		}
-		run = (*tester.Run)(r)
-		tests, err := p.listTests(ctx, tx, sq.Eq{"run_id": id}, 0)
-		if err != nil {
-			return err
-		}
+	res, err := p.pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
 
-		run.Tests = tests
-		return nil
-	})
+func (p *PG) GetRun(ctx context.Context, id uuid.UUID) (*tester.Run, error) {
+	run, err := p.GetRunMetadata(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	run.Tests, err = p.ListTestsForRun(ctx, id, 0)
 	if err != nil {
 		return nil, err
 	}
 	return run, nil
 }
 
-func (p *PG) listRuns(ctx context.Context, pg pger, pred interface{}, order string, limit int) ([]*tester.Run, error) {
+func (p *PG) GetRunMetadata(ctx context.Context, id uuid.UUID) (*tester.Run, error) {
+	r := &pgRun{}
+	q := psq.Select(r.Columns()...).
+		From("runs").
+		Where("id = ?", id)
+
+	sql, args, err := q.ToSql()
+	if err != nil {
+		return nil, err
+	}
+
+	row := p.pool.QueryRow(ctx, sql, args...)
+	err = r.Scan(row)
+	if err != nil {
+		return nil, err
+	}
+	return (*tester.Run)(r), nil
+}
+
+// listRuns lists runs without their tests, which can be loaded with
+// ListTestsForRun.
+func (p *PG) listRuns(ctx context.Context, pg pger, pred interface{}, order string, limit, offset int) ([]*tester.Run, error) {
 	var runs []*tester.Run
 	q := psq.Select((&pgRun{}).Columns()...).
 		From("runs")
@@ -338,6 +920,9 @@ func (p *PG) listRuns(ctx context.Context, pg pger, pred interface{}, order stri
 	if limit > 0 {
 		q = q.Limit(uint64(limit))
 	}
+	if offset > 0 {
+		q = q.Offset(uint64(offset))
+	}
 
 	sql, args, err := q.ToSql()
 	if err != nil {
@@ -350,7 +935,6 @@ func (p *PG) listRuns(ctx context.Context, pg pger, pred interface{}, order stri
 	}
 	defer rows.Close()
 
-	runMap := make(map[uuid.UUID]*tester.Run)
 	for rows.Next() {
 		r := &pgRun{}
 		err := r.Scan(rows)
@@ -358,66 +942,44 @@ func (p *PG) listRuns(ctx context.Context, pg pger, pred interface{}, order stri
 			return nil, err
 		}
 		runs = append(runs, (*tester.Run)(r))
-		runMap[r.ID] = (*tester.Run)(r)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
 	}
+	return runs, nil
+}
 
-	var runIDs []uuid.UUID
-	for id := range runMap {
-		runIDs = append(runIDs, id)
-	}
-
-	tests, err := p.listTests(ctx, pg, sq.Eq{"run_id": runIDs}, 0)
-	if err != nil {
-		return nil, err
-	}
+func (p *PG) ListPendingRuns(ctx context.Context) ([]*tester.Run, error) {
+	return p.listRuns(ctx, p.pool, "finished_at IS NULL AND dead_lettered_at IS NULL", "priority DESC, enqueued_at ASC", 0, 0)
+}
 
-	for _, test := range tests {
-		runMap[test.RunID].Tests = append(runMap[test.RunID].Tests, test)
-	}
+func (p *PG) ListPendingRunsForPackage(ctx context.Context, pkg string) ([]*tester.Run, error) {
+	return p.listRuns(ctx, p.pool, sq.And{
+		sq.Eq{"package": pkg},
+		sq.Expr("finished_at IS NULL AND dead_lettered_at IS NULL"),
+	}, "priority DESC, enqueued_at ASC", 0, 0)
+}
 
-	return runs, nil
+func (p *PG) ListFinishedRuns(ctx context.Context, pkg string, limit, offset int) ([]*tester.Run, error) {
+	return p.listRuns(ctx, p.pool, withPackage(sq.Expr("finished_at IS NOT NULL"), pkg), "finished_at DESC", limit, offset)
 }
 
-func (p *PG) ListPendingRuns(ctx context.Context) ([]*tester.Run, error) {
-	var runs []*tester.Run
-	err := p.tx(ctx, func(tx pgx.Tx) error {
-		var err error
-		runs, err = p.listRuns(ctx, tx, "finished_at IS NULL", "enqueued_at ASC", 0)
-		return err
-	})
-	if err != nil {
-		return nil, err
-	}
-	return runs, nil
+func (p *PG) ListDeadLetteredRuns(ctx context.Context, pkg string, limit, offset int) ([]*tester.Run, error) {
+	return p.listRuns(ctx, p.pool, withPackage(sq.Expr("dead_lettered_at IS NOT NULL"), pkg), "dead_lettered_at DESC", limit, offset)
 }
 
-func (p *PG) ListFinishedRuns(ctx context.Context, limit int) ([]*tester.Run, error) {
-	var runs []*tester.Run
-	err := p.tx(ctx, func(tx pgx.Tx) error {
-		var err error
-		runs, err = p.listRuns(ctx, tx, "finished_at IS NOT NULL", "finished_at DESC", limit)
-		return err
-	})
-	if err != nil {
-		return nil, err
-	}
-	return runs, nil
+func (p *PG) ListRunsForPackage(ctx context.Context, pkg string, limit, offset int) ([]*tester.Run, error) {
+	// Runs are listed in the order they ran, with runs that have not started
+	// yet ordered by when they were enqueued.
+	return p.listRuns(ctx, p.pool, sq.Eq{"package": pkg}, "COALESCE(started_at, enqueued_at) DESC", limit, offset)
 }
 
-func (p *PG) ListRunsForPackage(ctx context.Context, pkg string, limit int) ([]*tester.Run, error) {
-	var runs []*tester.Run
-	err := p.tx(ctx, func(tx pgx.Tx) error {
-		var err error
-		runs, err = p.listRuns(ctx, tx, sq.Eq{"package": pkg}, "enqueued_at DESC", limit)
-		return err
-	})
-	if err != nil {
-		return nil, err
+// withPackage restricts pred to runs of pkg, if pkg is not empty.
+func withPackage(pred sq.Sqlizer, pkg string) sq.Sqlizer {
+	if pkg == "" {
+		return pred
 	}
-	return runs, nil
+	return sq.And{sq.Eq{"package": pkg}, pred}
 }
 
 func (p *PG) ListRunSummariesInRange(ctx context.Context, begin, end time.Time, window time.Duration) ([]*tester.RunSummary, error) {
@@ -471,6 +1033,11 @@ func (p *PG) ListRunSummariesInRange(ctx context.Context, begin, end time.Time,
 			runStartedAt = runStartedAt.UTC()
 
 			bucketIndex := int(runStartedAt.Sub(begin) / window)
+			// Runs started exactly at the end of the range are included in
+			// the last bucket.
+			if bucketIndex >= len(summaries) {
+				bucketIndex = len(summaries) - 1
+			}
 			summary := summaries[bucketIndex]
 
 			packageSummary, ok := summary.PackageSummary[packageName]
@@ -495,6 +1062,7 @@ func (p *PG) ListRunSummariesInRange(ctx context.Context, begin, end time.Time,
 			case tester.TBStatePassed:
 				packageSummary.PassedTests[result.Name] = append(packageSummary.PassedTests[result.Name], testID)
 			case tester.TBStateFailed:
+				packageSummary.FailedRunIDs = append(packageSummary.FailedRunIDs, runID)
 				packageSummary.FailedTests[result.Name] = append(packageSummary.FailedTests[result.Name], testID)
 			case tester.TBStateSkipped:
 				packageSummary.SkippedTests[result.Name] = append(packageSummary.SkippedTests[result.Name], testID)
@@ -508,32 +1076,80 @@ func (p *PG) ListRunSummariesInRange(ctx context.Context, begin, end time.Time,
 
 	for _, summary := range summaries {
 		for _, packageSummary := range summary.PackageSummary {
-			if len(packageSummary.RunIDs) == 0 {
-				continue
-			}
+			packageSummary.RunIDs = uniqueIDs(packageSummary.RunIDs)
+			packageSummary.ErrorRunIDs = uniqueIDs(packageSummary.ErrorRunIDs)
+			packageSummary.FailedRunIDs = uniqueIDs(packageSummary.FailedRunIDs)
+		}
+	}
+	return summaries, nil
+}
 
-			var runIDs []uuid.UUID
-			uniqueRunIDs := make(map[uuid.UUID]struct{})
-			for _, id := range packageSummary.RunIDs {
-				if _, exists := uniqueRunIDs[id]; exists {
-					continue
-				}
-				uniqueRunIDs[id] = struct{}{}
-				runIDs = append(runIDs, id)
-			}
-			packageSummary.RunIDs = runIDs
+func (p *PG) GetPackagePassRateHistory(ctx context.Context, pkg string, begin, end time.Time, bucket time.Duration) ([]*tester.PackageHistory, error) {
+	begin = begin.UTC()
+	end = end.UTC()
 
-			var errorRunIDs []uuid.UUID
-			uniqueErrorRunIDs := make(map[uuid.UUID]struct{})
-			for _, id := range packageSummary.ErrorRunIDs {
-				if _, exists := uniqueErrorRunIDs[id]; exists {
-					continue
-				}
-				uniqueErrorRunIDs[id] = struct{}{}
-				errorRunIDs = append(errorRunIDs, id)
-			}
-			packageSummary.ErrorRunIDs = errorRunIDs
+	buckets := int(math.Ceil(float64(end.Sub(begin)) / float64(bucket)))
+	history := make([]*tester.PackageHistory, buckets)
+	for i := 0; i < buckets; i++ {
+		history[i] = &tester.PackageHistory{
+			Time: begin.Add(time.Duration(i) * bucket),
 		}
 	}
-	return summaries, nil
+	if buckets == 0 {
+		return history, nil
+	}
+
+	// Runs started exactly at the end of the range are included in the last
+	// bucket.
+	rows, err := p.pool.Query(ctx, `
+WITH run_results AS (
+	SELECT runs.started_at,
+		runs.error IS NOT NULL OR coalesce(bool_or(tests.result->>'state' = $4), false) AS failed
+	FROM runs
+	LEFT JOIN tests ON tests.run_id = runs.id
+	WHERE runs.package = $1
+		AND runs.started_at >= $2
+		AND runs.started_at <= $3
+		AND runs.finished_at IS NOT NULL
+	GROUP BY runs.id
+)
+SELECT least(floor(extract(epoch FROM started_at - $2::timestamptz) / $5)::int, $6::int) AS bucket,
+	count(*),
+	count(*) FILTER (WHERE failed)
+FROM run_results
+GROUP BY bucket
+ORDER BY bucket`,
+		pkg, begin, end, string(tester.TBStateFailed), bucket.Seconds(), buckets-1)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var index, total, failed int
+		if err := rows.Scan(&index, &total, &failed); err != nil {
+			return nil, err
+		}
+		history[index].TotalRuns = total
+		history[index].FailedRuns = failed
+		history[index].PassRate = float64(total-failed) / float64(total)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+// uniqueIDs returns ids with duplicates removed, preserving order.
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	var unique []uuid.UUID
+	seen := make(map[uuid.UUID]struct{})
+	for _, id := range ids {
+		if _, exists := seen[id]; exists {
+			continue
+		}
+		seen[id] = struct{}{}
+		unique = append(unique, id)
+	}
+	return unique
 }
@@ -1,5 +1,11 @@
 package db
 
+// Runs are claimed without any schema support. PG.StartRun takes a
+// transaction scoped advisory lock keyed on hashtext(id::text) with
+// pg_try_advisory_xact_lock before checking that the run has not been
+// started, so concurrent claims of the same run are serialized and only one
+// of them starts it. The lock is released when the transaction ends, and
+// callers that fail to take it treat the run as already claimed.
 var pgMigrations = []struct {
 	name string
 	up   string
@@ -51,6 +57,123 @@ ALTER TABLE runs ADD COLUMN meta jsonb NOT NULL DEFAULT '{}'::jsonb;
 `,
 		down: `
 ALTER TABLE runs DROP COLUMN meta;
+`,
+	},
+	{
+		name: "add error_message column to tests",
+		up: `
+ALTER TABLE tests ADD COLUMN error_message text GENERATED ALWAYS AS (result->>'error_message') STORED;
+`,
+		down: `
+ALTER TABLE tests DROP COLUMN error_message;
+`,
+	},
+	{
+		name: "add dead letter columns to runs",
+		up: `
+ALTER TABLE runs ADD COLUMN dead_lettered_at timestamptz;
+ALTER TABLE runs ADD COLUMN dead_letter_reason text;
+CREATE INDEX ON runs (dead_lettered_at);
+`,
+		down: `
+ALTER TABLE runs DROP COLUMN dead_lettered_at, DROP COLUMN dead_letter_reason;
+`,
+	},
+	{
+		name: "add labels columns to runs and tests",
+		up: `
+ALTER TABLE runs ADD COLUMN labels jsonb NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE tests ADD COLUMN labels jsonb NOT NULL DEFAULT '{}'::jsonb;
+CREATE INDEX ON tests USING GIN (labels);
+`,
+		down: `
+ALTER TABLE runs DROP COLUMN labels;
+ALTER TABLE tests DROP COLUMN labels;
+`,
+	},
+	{
+		name: "add count column to runs",
+		up: `
+ALTER TABLE runs ADD COLUMN count integer NOT NULL DEFAULT 0;
+`,
+		down: `
+ALTER TABLE runs DROP COLUMN count;
+`,
+	},
+	{
+		name: "add test name search index",
+		up: `
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+CREATE INDEX tests_name_trgm_idx ON tests USING GIN ((result->>'name') gin_trgm_ops);
+`,
+		down: `
+DROP INDEX tests_name_trgm_idx;
+`,
+	},
+	{
+		name: "add variant column to runs",
+		up: `
+ALTER TABLE runs ADD COLUMN variant text NOT NULL DEFAULT '';
+`,
+		down: `
+ALTER TABLE runs DROP COLUMN variant;
+`,
+	},
+	{
+		name: "add priority and enqueued_by columns to runs",
+		up: `
+ALTER TABLE runs ADD COLUMN priority integer NOT NULL DEFAULT 0;
+ALTER TABLE runs ADD COLUMN enqueued_by text NOT NULL DEFAULT '';
+`,
+		down: `
+ALTER TABLE runs DROP COLUMN priority;
+ALTER TABLE runs DROP COLUMN enqueued_by;
+`,
+	},
+	{
+		name: "add alert_channels column to runs",
+		up: `
+ALTER TABLE runs ADD COLUMN alert_channels text[] NOT NULL DEFAULT '{}';
+`,
+		down: `
+ALTER TABLE runs DROP COLUMN alert_channels;
+`,
+	},
+	{
+		name: "create quarantined_tests table",
+		up: `
+CREATE TABLE quarantined_tests (
+  package text NOT NULL,
+  name text NOT NULL,
+  quarantined_at timestamptz NOT NULL,
+  PRIMARY KEY (package, name)
+);
+`,
+		down: `
+DROP TABLE quarantined_tests;
+`,
+	},
+	{
+		name: "create packages table",
+		up: `
+CREATE TABLE packages (
+  name text PRIMARY KEY,
+  definition jsonb NOT NULL,
+  created_at timestamptz NOT NULL,
+  updated_at timestamptz NOT NULL
+);
+`,
+		down: `
+DROP TABLE packages;
+`,
+	},
+	{
+		name: "add seeded_definition column to packages",
+		up: `
+ALTER TABLE packages ADD COLUMN seeded_definition jsonb;
+`,
+		down: `
+ALTER TABLE packages DROP COLUMN seeded_definition;
 `,
 	},
 }
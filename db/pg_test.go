@@ -4,14 +4,17 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/nanzhong/tester"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -97,6 +100,7 @@ func TestPG_Test(t *testing.T) {
 			Logs: []tester.TBLog{
 				{Time: testTime, Name: "name", Output: []byte("output")},
 			},
+			Labels: tester.Labels{"team": "payments", "tier": "1"},
 		}
 		test2 := &tester.Test{
 			ID:      uuid.New(),
@@ -152,6 +156,34 @@ func TestPG_Test(t *testing.T) {
 				)
 			})
 
+			t.Run("ListTestsByState", func(t *testing.T) {
+				listPassedTests, err := pg.ListTestsByState(ctx, tester.TBStatePassed, 0)
+				require.NoError(t, err)
+				assert.True(
+					t,
+					cmp.Equal([]*tester.Test{test1, test2}, listPassedTests),
+					"expected to be equal", cmp.Diff([]*tester.Test{test1, test2}, listPassedTests),
+				)
+
+				listFailedTests, err := pg.ListTestsByState(ctx, tester.TBStateFailed, 0)
+				require.NoError(t, err)
+				assert.Empty(t, listFailedTests)
+			})
+
+			t.Run("ListTestsByLabels", func(t *testing.T) {
+				listLabelTests, err := pg.ListTestsByLabels(ctx, tester.Labels{"team": "payments"}, 0)
+				require.NoError(t, err)
+				assert.True(
+					t,
+					cmp.Equal([]*tester.Test{test1}, listLabelTests),
+					"expected to be equal", cmp.Diff([]*tester.Test{test1}, listLabelTests),
+				)
+
+				listLabelTests, err = pg.ListTestsByLabels(ctx, tester.Labels{"team": "other"}, 0)
+				require.NoError(t, err)
+				assert.Empty(t, listLabelTests)
+			})
+
 			t.Run("ListTestsForPackageInRange", func(t *testing.T) {
 				listPkgTestsInRange, err := pg.ListTestsForPackageInRange(ctx, "pkg-2", testTime, testTime)
 				require.NoError(t, err)
@@ -161,18 +193,338 @@ func TestPG_Test(t *testing.T) {
 					"expected to be equal", cmp.Diff([]*tester.Test{test2}, listPkgTestsInRange),
 				)
 			})
+
+			t.Run("QueryTests", func(t *testing.T) {
+				for _, tc := range []struct {
+					name   string
+					filter TestFilter
+					expect []*tester.Test
+				}{
+					{name: "all", expect: []*tester.Test{test1, test2}},
+					{name: "package", filter: TestFilter{Package: "pkg-1"}, expect: []*tester.Test{test1}},
+					{name: "state", filter: TestFilter{State: tester.TBStateFailed}},
+					{name: "package and state", filter: TestFilter{Package: "pkg-2", State: tester.TBStatePassed}, expect: []*tester.Test{test2}},
+					{name: "in range", filter: TestFilter{Begin: testTime, End: testTime}, expect: []*tester.Test{test1, test2}},
+					{name: "after range", filter: TestFilter{Begin: testTime.Add(time.Second)}},
+					{name: "before range", filter: TestFilter{End: testTime.Add(-time.Second)}},
+				} {
+					tc := tc
+					t.Run(tc.name, func(t *testing.T) {
+						tests, err := pg.QueryTests(ctx, tc.filter)
+						require.NoError(t, err)
+						assert.True(
+							t,
+							cmp.Equal(tc.expect, tests, cmpopts.EquateEmpty()),
+							"expected to be equal", cmp.Diff(tc.expect, tests, cmpopts.EquateEmpty()),
+						)
+					})
+				}
+
+				t.Run("limit and offset", func(t *testing.T) {
+					first, err := pg.QueryTests(ctx, TestFilter{Limit: 1})
+					require.NoError(t, err)
+					require.Len(t, first, 1)
+
+					second, err := pg.QueryTests(ctx, TestFilter{Limit: 1, Offset: 1})
+					require.NoError(t, err)
+					require.Len(t, second, 1)
+					assert.NotEqual(t, first[0].ID, second[0].ID)
+				})
+			})
 		})
 	})
 }
 
+func TestPG_SearchTests(t *testing.T) {
+	testTime := time.Now().Truncate(time.Millisecond)
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		ctx := context.Background()
+
+		var tests []*tester.Test
+		for _, tc := range []struct{ pkg, name string }{
+			{"pkg-1", "TestMyTest"},
+			{"pkg-2", "TestMyTest/sub"},
+			{"pkg-1", "TestOther"},
+			{"pkg-1", "Test_100%"},
+		} {
+			test := &tester.Test{
+				ID:      uuid.New(),
+				Package: tc.pkg,
+				RunID:   uuid.New(),
+				Result: &tester.T{
+					TB: tester.TB{
+						Name:       tc.name,
+						StartedAt:  testTime,
+						FinishedAt: testTime,
+						State:      tester.TBStatePassed,
+					},
+				},
+			}
+			require.NoError(t, pg.AddTest(ctx, test))
+			tests = append(tests, test)
+		}
+
+		for _, tc := range []struct {
+			name     string
+			query    string
+			pkg      string
+			limit    int
+			expected []*tester.Test
+		}{
+			{name: "partial match", query: "MyTest", expected: tests[:2]},
+			{name: "case insensitive", query: "mytest", expected: tests[:2]},
+			{name: "package", query: "mytest", pkg: "pkg-1", expected: tests[:1]},
+			{name: "limit", query: "Test", limit: 1, expected: tests[:1]},
+			{name: "wildcards are literal", query: "_100%", expected: tests[3:]},
+			{name: "no match", query: "missing"},
+		} {
+			t.Run(tc.name, func(t *testing.T) {
+				found, err := pg.SearchTests(ctx, tc.query, tc.pkg, tc.limit)
+				require.NoError(t, err)
+
+				var foundIDs, expectedIDs []uuid.UUID
+				for _, test := range found {
+					foundIDs = append(foundIDs, test.ID)
+				}
+				for _, test := range tc.expected {
+					expectedIDs = append(expectedIDs, test.ID)
+				}
+				if tc.limit > 0 {
+					assert.Len(t, foundIDs, tc.limit)
+					return
+				}
+				assert.ElementsMatch(t, expectedIDs, foundIDs)
+			})
+		}
+	})
+}
+
+func TestPG_MarkFlakyTests(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		ctx := context.Background()
+		pg.now = func() time.Time { return now }
+
+		addTest := func(pkg, name string, state tester.TBState, startedAt time.Time) *tester.Test {
+			test := &tester.Test{
+				ID:      uuid.New(),
+				Package: pkg,
+				RunID:   uuid.New(),
+				Result: &tester.T{
+					TB: tester.TB{
+						Name:       name,
+						StartedAt:  startedAt,
+						FinishedAt: startedAt,
+						State:      state,
+					},
+				},
+			}
+			require.NoError(t, pg.AddTest(ctx, test))
+			return test
+		}
+
+		old := addTest("pkg-1", "TestA", tester.TBStatePassed, now.Add(-2*time.Hour))
+		flaky := []*tester.Test{
+			addTest("pkg-1", "TestA", tester.TBStateFailed, now.Add(-30*time.Minute)),
+			addTest("pkg-1", "TestA", tester.TBStatePassed, now.Add(-10*time.Minute)),
+			addTest("pkg-1", "TestC", tester.TBStatePassed, now.Add(-10*time.Minute)),
+			addTest("pkg-1", "TestC", tester.TBStateFailed, now.Add(-5*time.Minute)),
+		}
+		stable := []*tester.Test{
+			addTest("pkg-1", "TestB", tester.TBStatePassed, now.Add(-30*time.Minute)),
+			addTest("pkg-1", "TestB", tester.TBStateSkipped, now.Add(-10*time.Minute)),
+			// Failures that passed outside the window are not flaky.
+			addTest("pkg-1", "TestD", tester.TBStateFailed, now.Add(-10*time.Minute)),
+			addTest("pkg-2", "TestA", tester.TBStateFailed, now.Add(-10*time.Minute)),
+		}
+		addTest("pkg-1", "TestD", tester.TBStatePassed, now.Add(-3*time.Hour))
+
+		names, err := pg.MarkFlakyTests(ctx, "pkg-1", time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"TestA", "TestC"}, names)
+
+		for _, test := range flaky {
+			found, err := pg.GetTest(ctx, test.ID)
+			require.NoError(t, err)
+			assert.True(t, found.Result.Flaky, test.Result.Name)
+		}
+		for _, test := range append(stable, old) {
+			found, err := pg.GetTest(ctx, test.ID)
+			require.NoError(t, err)
+			assert.False(t, found.Result.Flaky, test.Result.Name)
+		}
+
+		// Marking is idempotent.
+		names, err = pg.MarkFlakyTests(ctx, "pkg-1", time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"TestA", "TestC"}, names)
+
+		names, err = pg.MarkFlakyTests(ctx, "pkg-2", time.Hour)
+		require.NoError(t, err)
+		assert.Empty(t, names)
+	})
+}
+
+func TestPG_CountTestsForPackage(t *testing.T) {
+	withPG(t, func(tb testing.TB, pg *PG) {
+		ctx := context.Background()
+
+		const n = 5
+		for i := 0; i < n; i++ {
+			state := tester.TBStatePassed
+			if i%2 == 0 {
+				state = tester.TBStateFailed
+			}
+			require.NoError(t, pg.AddTest(ctx, &tester.Test{
+				ID:      uuid.New(),
+				Package: "pkg-1",
+				RunID:   uuid.New(),
+				Result:  &tester.T{TB: tester.TB{Name: fmt.Sprintf("Test%d", i), State: state}},
+			}))
+		}
+		require.NoError(t, pg.AddTest(ctx, &tester.Test{
+			ID:      uuid.New(),
+			Package: "pkg-2",
+			RunID:   uuid.New(),
+			Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+		}))
+
+		for _, tc := range []struct {
+			pkg      string
+			state    tester.TBState
+			expected int64
+		}{
+			{pkg: "pkg-1", expected: n},
+			{pkg: "pkg-1", state: tester.TBStateFailed, expected: 3},
+			{pkg: "pkg-1", state: tester.TBStatePassed, expected: 2},
+			{pkg: "pkg-1", state: tester.TBStateSkipped, expected: 0},
+			{pkg: "pkg-2", expected: 1},
+			{pkg: "pkg-3", expected: 0},
+		} {
+			count, err := pg.CountTestsForPackage(ctx, tc.pkg, tc.state)
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, count, "%s %s", tc.pkg, tc.state)
+		}
+	})
+}
+
+func TestPG_Quarantine(t *testing.T) {
+	ctx := context.Background()
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		pg.now = func() time.Time { return now }
+
+		require.NoError(t, pg.Quarantine(ctx, "pkg", "TestB"))
+		require.NoError(t, pg.Quarantine(ctx, "pkg", "TestA"))
+		require.NoError(t, pg.Quarantine(ctx, "other", "TestA"))
+		// Quarantining again is a no-op.
+		require.NoError(t, pg.Quarantine(ctx, "pkg", "TestA"))
+
+		quarantined, err := pg.ListQuarantined(ctx)
+		require.NoError(t, err)
+		var names []string
+		for _, test := range quarantined {
+			names = append(names, test.Package+"."+test.Name)
+			assert.True(t, test.QuarantinedAt.Equal(now))
+		}
+		assert.Equal(t, []string{"other.TestA", "pkg.TestA", "pkg.TestB"}, names)
+
+		require.NoError(t, pg.Unquarantine(ctx, "pkg", "TestA"))
+		assert.Equal(t, ErrNotFound, pg.Unquarantine(ctx, "pkg", "TestA"))
+
+		quarantined, err = pg.ListQuarantined(ctx)
+		require.NoError(t, err)
+		require.Len(t, quarantined, 2)
+		assert.Equal(t, "other", quarantined[0].Package)
+		assert.Equal(t, "TestB", quarantined[1].Name)
+	})
+}
+
+func TestPG_Packages(t *testing.T) {
+	ctx := context.Background()
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		enabled := false
+		pkgB := &tester.Package{
+			Name:     "pkgB",
+			Path:     "/bins/pkgB",
+			RunDelay: time.Minute,
+			Options:  []tester.Option{{Name: "run", Default: "TestA"}},
+			Enabled:  &enabled,
+			Labels:   tester.Labels{"team": "core"},
+			Variants: []tester.Variant{{Name: "race", Path: "/bins/pkgB-race"}},
+		}
+		pkgA := &tester.Package{Name: "pkgA", Path: "/bins/pkgA"}
+
+		require.NoError(t, pg.AddPackage(ctx, pkgB))
+		require.NoError(t, pg.AddPackage(ctx, pkgA))
+		assert.Equal(t, ErrAlreadyExists, pg.AddPackage(ctx, pkgA))
+
+		pkg, err := pg.GetPackage(ctx, "pkgB")
+		require.NoError(t, err)
+		assert.Equal(t, pkgB, pkg)
+		_, err = pg.GetPackage(ctx, "unknown")
+		assert.Equal(t, ErrNotFound, err)
+
+		pkgs, err := pg.ListPackages(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []*tester.Package{pkgA, pkgB}, pkgs)
+
+		updated := *pkgA
+		updated.Count = 3
+		require.NoError(t, pg.UpdatePackage(ctx, &updated))
+		assert.Equal(t, ErrNotFound, pg.UpdatePackage(ctx, &tester.Package{Name: "unknown"}))
+		pkg, err = pg.GetPackage(ctx, "pkgA")
+		require.NoError(t, err)
+		assert.Equal(t, 3, pkg.Count)
+
+		// Seeding adds packages that are not stored yet, and replaces the
+		// stored packages that were not seeded with the same definition.
+		pkgC := &tester.Package{Name: "pkgC", Path: "/bins/pkgC"}
+		require.NoError(t, pg.SeedPackages(ctx, []*tester.Package{pkgA, pkgC}))
+		pkgs, err = pg.ListPackages(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []*tester.Package{pkgA, pkgB, pkgC}, pkgs)
+
+		// Changes made since seeding are kept while the seeded definitions
+		// are unchanged.
+		require.NoError(t, pg.UpdatePackage(ctx, &updated))
+		require.NoError(t, pg.SeedPackages(ctx, []*tester.Package{pkgA, pkgC}))
+		pkg, err = pg.GetPackage(ctx, "pkgA")
+		require.NoError(t, err)
+		assert.Equal(t, 3, pkg.Count)
+
+		// Changed seeded definitions replace the stored definitions.
+		seeded := *pkgA
+		seeded.Path = "/bins/pkgA-v2"
+		changedC := *pkgC
+		changedC.Count = 2
+		require.NoError(t, pg.SeedPackages(ctx, []*tester.Package{&seeded, &changedC}))
+		pkgs, err = pg.ListPackages(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []*tester.Package{&seeded, pkgB, &changedC}, pkgs)
+
+		require.NoError(t, pg.DeletePackage(ctx, "pkgA"))
+		assert.Equal(t, ErrNotFound, pg.DeletePackage(ctx, "pkgA"))
+		pkgs, err = pg.ListPackages(ctx)
+		require.NoError(t, err)
+		require.Len(t, pkgs, 2)
+		assert.Equal(t, "pkgB", pkgs[0].Name)
+	})
+}
+
 func TestPG_EnqueueRun_GetRun(t *testing.T) {
 	ctx := context.Background()
 
 	withPG(t, func(tb testing.TB, pg *PG) {
 		run := &tester.Run{
-			ID:      uuid.New(),
-			Package: "pkg",
-			Args:    []string{"one", "two"},
+			ID:            uuid.New(),
+			Package:       "pkg",
+			Args:          []string{"one", "two"},
+			AlertChannels: []string{"C123"},
 		}
 
 		err := pg.EnqueueRun(ctx, run)
@@ -181,6 +533,36 @@ func TestPG_EnqueueRun_GetRun(t *testing.T) {
 		run, err = pg.GetRun(ctx, run.ID)
 		require.NoError(t, err)
 		assert.NotEmpty(t, run.EnqueuedAt)
+		assert.Equal(t, []string{"C123"}, run.AlertChannels)
+	})
+}
+
+func TestPG_AddRun(t *testing.T) {
+	ctx := context.Background()
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		run := &tester.Run{
+			ID:         uuid.New(),
+			Package:    "pkg",
+			EnqueuedAt: now,
+			StartedAt:  now,
+			FinishedAt: now,
+			Meta:       tester.RunMeta{Runner: "external"},
+		}
+		require.NoError(t, pg.AddRun(ctx, run))
+
+		// Adding a run that already exists leaves it as it is.
+		other := *run
+		other.Package = "other"
+		require.NoError(t, pg.AddRun(ctx, &other))
+
+		getRun, err := pg.GetRunMetadata(ctx, run.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "pkg", getRun.Package)
+		assert.Equal(t, "external", getRun.Meta.Runner)
+		assert.True(t, now.Equal(getRun.StartedAt))
+		assert.True(t, now.Equal(getRun.FinishedAt))
 	})
 }
 
@@ -197,13 +579,57 @@ func TestPG_StartRun(t *testing.T) {
 		err := pg.EnqueueRun(ctx, run)
 		require.NoError(t, err)
 
-		err = pg.StartRun(ctx, run.ID, "runner")
+		err = pg.StartRun(ctx, run.ID, tester.RunMeta{Runner: "runner"})
 		require.NoError(t, err)
 
 		getRun, err := pg.GetRun(ctx, run.ID)
 		require.NoError(t, err)
 		assert.NotEmpty(t, getRun.StartedAt)
 		assert.Equal(t, "runner", getRun.Meta.Runner)
+
+		err = pg.StartRun(ctx, run.ID, tester.RunMeta{Runner: "other"})
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
+func TestPG_StartRun_concurrent(t *testing.T) {
+	ctx := context.Background()
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		for i := 0; i < 10; i++ {
+			run := &tester.Run{
+				ID:      uuid.New(),
+				Package: "pkg",
+			}
+			require.NoError(t, pg.EnqueueRun(ctx, run))
+
+			var (
+				wg    sync.WaitGroup
+				start = make(chan struct{})
+				errs  = make([]error, 2)
+			)
+			for j := range errs {
+				j := j
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					errs[j] = pg.StartRun(ctx, run.ID, tester.RunMeta{Runner: fmt.Sprintf("runner-%d", j)})
+				}()
+			}
+			close(start)
+			wg.Wait()
+
+			var started int
+			for _, err := range errs {
+				if err == nil {
+					started++
+					continue
+				}
+				assert.Equal(t, ErrNotFound, err)
+			}
+			assert.Equal(t, 1, started, "exactly one claim should start the run")
+		}
 	})
 }
 
@@ -220,7 +646,7 @@ func TestPG_ResetRun(t *testing.T) {
 		err := pg.EnqueueRun(ctx, run)
 		require.NoError(t, err)
 
-		err = pg.StartRun(ctx, run.ID, "runner")
+		err = pg.StartRun(ctx, run.ID, tester.RunMeta{Runner: "runner"})
 		require.NoError(t, err)
 
 		err = pg.ResetRun(ctx, run.ID)
@@ -255,6 +681,41 @@ func TestPG_DeleteRun(t *testing.T) {
 	})
 }
 
+func TestPG_DeadLetterRun(t *testing.T) {
+	ctx := context.Background()
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		run := &tester.Run{
+			ID:      uuid.New(),
+			Package: "pkg",
+			Args:    []string{"one", "two"},
+		}
+
+		err := pg.EnqueueRun(ctx, run)
+		require.NoError(t, err)
+
+		err = pg.DeadLetterRun(ctx, run.ID, "reason")
+		require.NoError(t, err)
+
+		getRun, err := pg.GetRun(ctx, run.ID)
+		require.NoError(t, err)
+		assert.NotEmpty(t, getRun.DeadLetteredAt)
+		assert.Equal(t, "reason", getRun.DeadLetterReason)
+
+		pendingRuns, err := pg.ListPendingRuns(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, pendingRuns)
+
+		deadLetteredRuns, err := pg.ListDeadLetteredRuns(ctx, "", 0, 0)
+		require.NoError(t, err)
+		require.Len(t, deadLetteredRuns, 1)
+		assert.Equal(t, run.ID, deadLetteredRuns[0].ID)
+
+		err = pg.DeadLetterRun(ctx, run.ID, "reason")
+		assert.Equal(t, ErrNotFound, err)
+	})
+}
+
 func TestPG_CompleteRun(t *testing.T) {
 	ctx := context.Background()
 
@@ -268,7 +729,7 @@ func TestPG_CompleteRun(t *testing.T) {
 		err := pg.EnqueueRun(ctx, run)
 		require.NoError(t, err)
 
-		err = pg.StartRun(ctx, run.ID, "")
+		err = pg.StartRun(ctx, run.ID, tester.RunMeta{})
 		require.NoError(t, err)
 
 		err = pg.CompleteRun(ctx, run.ID)
@@ -293,7 +754,7 @@ func TestPG_FailRun(t *testing.T) {
 		err := pg.EnqueueRun(ctx, run)
 		require.NoError(t, err)
 
-		err = pg.StartRun(ctx, run.ID, "")
+		err = pg.StartRun(ctx, run.ID, tester.RunMeta{})
 		require.NoError(t, err)
 
 		err = pg.FailRun(ctx, run.ID, "error")
@@ -329,7 +790,7 @@ func TestPG_ListRuns(t *testing.T) {
 			err := pg.EnqueueRun(ctx, r)
 			require.NoError(t, err)
 
-			err = pg.StartRun(ctx, r.ID, "")
+			err = pg.StartRun(ctx, r.ID, tester.RunMeta{})
 			require.NoError(t, err)
 		}
 
@@ -353,38 +814,326 @@ func TestPG_ListRuns(t *testing.T) {
 		})
 
 		t.Run("ListPendingRuns", func(t *testing.T) {
-			runs, err := pg.ListFinishedRuns(ctx, 0)
+			runs, err := pg.ListFinishedRuns(ctx, "", 0, 0)
 			require.NoError(t, err)
 			assert.ElementsMatch(t, []*tester.Run{runComplete, runFail}, runs)
 		})
 	})
 }
 
-func TestPG_ListRunsForPackage(t *testing.T) {
+func TestPG_ListPendingRunsForPackage(t *testing.T) {
 	ctx := context.Background()
 
 	withPG(t, func(tb testing.TB, pg *PG) {
-		runs := []*tester.Run{
-			{
-				ID:      uuid.New(),
-				Package: "pkg-1",
-			},
-			{
-				ID:      uuid.New(),
-				Package: "pkg-2",
-			},
+		pending := &tester.Run{ID: uuid.New(), Package: "pkg-1"}
+		started := &tester.Run{ID: uuid.New(), Package: "pkg-1"}
+		completed := &tester.Run{ID: uuid.New(), Package: "pkg-1"}
+		deadLettered := &tester.Run{ID: uuid.New(), Package: "pkg-1"}
+		otherPackage := &tester.Run{ID: uuid.New(), Package: "pkg-2"}
+		for _, r := range []*tester.Run{pending, started, completed, deadLettered, otherPackage} {
+			require.NoError(t, pg.EnqueueRun(ctx, r))
 		}
+		require.NoError(t, pg.StartRun(ctx, started.ID, tester.RunMeta{}))
+		require.NoError(t, pg.StartRun(ctx, completed.ID, tester.RunMeta{}))
+		require.NoError(t, pg.CompleteRun(ctx, completed.ID))
+		require.NoError(t, pg.DeadLetterRun(ctx, deadLettered.ID, "reason"))
 
+		runs, err := pg.ListPendingRunsForPackage(ctx, "pkg-1")
+		require.NoError(t, err)
+		var ids []uuid.UUID
 		for _, r := range runs {
+			ids = append(ids, r.ID)
+		}
+		assert.ElementsMatch(t, []uuid.UUID{pending.ID, started.ID}, ids)
+
+		runs, err = pg.ListPendingRunsForPackage(ctx, "pkg-3")
+		require.NoError(t, err)
+		assert.Empty(t, runs)
+
+		// All pending runs are still listed for cleanup.
+		runs, err = pg.ListPendingRuns(ctx)
+		require.NoError(t, err)
+		assert.Len(t, runs, 3)
+	})
+}
+
+func TestPG_ListPendingRuns_priority(t *testing.T) {
+	ctx := context.Background()
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		first := &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: now.Add(-2 * time.Minute)}
+		second := &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: now.Add(-time.Minute)}
+		urgent := &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: now, Priority: 10, EnqueuedBy: "slack:U123"}
+		for _, r := range []*tester.Run{first, second, urgent} {
+			require.NoError(t, pg.EnqueueRun(ctx, r))
+		}
+
+		runs, err := pg.ListPendingRuns(ctx)
+		require.NoError(t, err)
+		require.Len(t, runs, 3)
+		assert.Equal(t, urgent.ID, runs[0].ID)
+		assert.Equal(t, 10, runs[0].Priority)
+		assert.Equal(t, "slack:U123", runs[0].EnqueuedBy)
+		assert.Equal(t, first.ID, runs[1].ID)
+		assert.Equal(t, second.ID, runs[2].ID)
+
+		runs, err = pg.ListPendingRunsForPackage(ctx, "pkg")
+		require.NoError(t, err)
+		require.Len(t, runs, 3)
+		assert.Equal(t, urgent.ID, runs[0].ID)
+	})
+}
+
+func TestPG_ListRunsForPackage(t *testing.T) {
+	ctx := context.Background()
+	base := time.Now().UTC().Truncate(time.Millisecond)
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		// enqueue returns a run of pkg enqueued at the given offset from
+		// base, started at the given offset if it is not 0.
+		enqueue := func(pkg string, enqueuedAt, startedAt time.Duration) *tester.Run {
+			run := &tester.Run{ID: uuid.New(), Package: pkg, EnqueuedAt: base.Add(enqueuedAt)}
+			require.NoError(t, pg.EnqueueRun(ctx, run))
+			if startedAt != 0 {
+				pg.now = func() time.Time { return base.Add(startedAt) }
+				require.NoError(t, pg.StartRun(ctx, run.ID, tester.RunMeta{}))
+			}
+			return run
+		}
+
+		// Runs that were enqueued first may start last.
+		startedLast := enqueue("pkg-1", 0, 5*time.Minute)
+		startedFirst := enqueue("pkg-1", time.Minute, 2*time.Minute)
+		pending := enqueue("pkg-1", 4*time.Minute, 0)
+		pendingLatest := enqueue("pkg-1", 6*time.Minute, 0)
+		enqueue("pkg-2", 7*time.Minute, 8*time.Minute)
+
+		ids := func(runs []*tester.Run) []uuid.UUID {
+			var ids []uuid.UUID
+			for _, r := range runs {
+				ids = append(ids, r.ID)
+			}
+			return ids
+		}
+
+		runs, err := pg.ListRunsForPackage(ctx, "pkg-1", 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{pendingLatest.ID, startedLast.ID, pending.ID, startedFirst.ID}, ids(runs))
+
+		runs, err = pg.ListRunsForPackage(ctx, "pkg-1", 2, 1)
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{startedLast.ID, pending.ID}, ids(runs))
+
+		runs, err = pg.ListRunsForPackage(ctx, "pkg-3", 0, 0)
+		require.NoError(t, err)
+		assert.Empty(t, runs)
+	})
+}
+
+func TestPG_ListFinishedRuns_paging(t *testing.T) {
+	ctx := context.Background()
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		var pkg1, pkg2 []uuid.UUID
+		for i := 0; i < 6; i++ {
+			pkg := "pkg-1"
+			if i%2 == 1 {
+				pkg = "pkg-2"
+			}
+			run := &tester.Run{ID: uuid.New(), Package: pkg}
+			require.NoError(t, pg.EnqueueRun(ctx, run))
+			require.NoError(t, pg.StartRun(ctx, run.ID, tester.RunMeta{}))
+			require.NoError(t, pg.CompleteRun(ctx, run.ID))
+			if pkg == "pkg-1" {
+				pkg1 = append(pkg1, run.ID)
+			} else {
+				pkg2 = append(pkg2, run.ID)
+			}
+		}
+		deadLettered := &tester.Run{ID: uuid.New(), Package: "pkg-2"}
+		require.NoError(t, pg.EnqueueRun(ctx, deadLettered))
+		require.NoError(t, pg.DeadLetterRun(ctx, deadLettered.ID, "reason"))
+
+		ids := func(runs []*tester.Run) []uuid.UUID {
+			var ids []uuid.UUID
+			for _, r := range runs {
+				ids = append(ids, r.ID)
+			}
+			return ids
+		}
+
+		runs, err := pg.ListFinishedRuns(ctx, "", 4, 0)
+		require.NoError(t, err)
+		assert.Len(t, runs, 4)
+		runs, err = pg.ListFinishedRuns(ctx, "", 4, 4)
+		require.NoError(t, err)
+		assert.Len(t, runs, 2)
+
+		runs, err = pg.ListFinishedRuns(ctx, "pkg-1", 2, 0)
+		require.NoError(t, err)
+		first := ids(runs)
+		runs, err = pg.ListFinishedRuns(ctx, "pkg-1", 2, 2)
+		require.NoError(t, err)
+		assert.ElementsMatch(t, pkg1, append(first, ids(runs)...))
+
+		runs, err = pg.ListDeadLetteredRuns(ctx, "pkg-1", 0, 0)
+		require.NoError(t, err)
+		assert.Empty(t, runs)
+		runs, err = pg.ListDeadLetteredRuns(ctx, "pkg-2", 0, 0)
+		require.NoError(t, err)
+		assert.Equal(t, []uuid.UUID{deadLettered.ID}, ids(runs))
+
+		runs, err = pg.ListRunsForPackage(ctx, "pkg-2", 0, 1)
+		require.NoError(t, err)
+		assert.Len(t, runs, len(pkg2))
+	})
+}
+
+func TestPG_ListTestsForRun(t *testing.T) {
+	ctx := context.Background()
+	testTime := time.Now().Truncate(time.Millisecond)
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		run := &tester.Run{
+			ID:      uuid.New(),
+			Package: "pkg",
+		}
+		otherRun := &tester.Run{
+			ID:      uuid.New(),
+			Package: "pkg",
+		}
+
+		var tests []*tester.Test
+		for _, r := range []*tester.Run{run, otherRun} {
 			err := pg.EnqueueRun(ctx, r)
 			require.NoError(t, err)
-			r, err = pg.GetRun(ctx, r.ID)
+
+			test := &tester.Test{
+				ID:      uuid.New(),
+				Package: "pkg",
+				RunID:   r.ID,
+				Result: &tester.T{
+					TB: tester.TB{
+						StartedAt:  testTime,
+						FinishedAt: testTime,
+						State:      tester.TBStatePassed,
+					},
+				},
+			}
+			err = pg.AddTest(ctx, test)
 			require.NoError(t, err)
+			tests = append(tests, test)
 		}
 
-		runs, err := pg.ListRunsForPackage(ctx, "pkg-1", 0)
-		require.NoError(t, err)
-		assert.ElementsMatch(t, []*tester.Run{runs[0]}, runs)
+		t.Run("ListTestsForRun", func(t *testing.T) {
+			runTests, err := pg.ListTestsForRun(ctx, run.ID, 0)
+			require.NoError(t, err)
+			assert.True(
+				t,
+				cmp.Equal([]*tester.Test{tests[0]}, runTests),
+				"expected to be equal", cmp.Diff([]*tester.Test{tests[0]}, runTests),
+			)
+		})
+
+		t.Run("GetRun loads tests", func(t *testing.T) {
+			getRun, err := pg.GetRun(ctx, run.ID)
+			require.NoError(t, err)
+			assert.True(
+				t,
+				cmp.Equal([]*tester.Test{tests[0]}, getRun.Tests),
+				"expected to be equal", cmp.Diff([]*tester.Test{tests[0]}, getRun.Tests),
+			)
+		})
+
+		t.Run("GetRunMetadata does not load tests", func(t *testing.T) {
+			getRun, err := pg.GetRunMetadata(ctx, run.ID)
+			require.NoError(t, err)
+			assert.Equal(t, run.ID, getRun.ID)
+			assert.Nil(t, getRun.Tests)
+
+			_, err = pg.GetRunMetadata(ctx, uuid.New())
+			assert.Equal(t, ErrNotFound, err)
+		})
+
+		t.Run("listing runs does not load tests", func(t *testing.T) {
+			runs, err := pg.ListRunsForPackage(ctx, "pkg", 0, 0)
+			require.NoError(t, err)
+			require.Len(t, runs, 2)
+			for _, r := range runs {
+				assert.Empty(t, r.Tests)
+			}
+		})
+	})
+}
+
+// addRunsWithTests enqueues n runs with a passed test each.
+func addRunsWithTests(tb testing.TB, pg *PG, n int) []uuid.UUID {
+	ctx := context.Background()
+	testTime := time.Now().Truncate(time.Millisecond)
+
+	runIDs := make([]uuid.UUID, n)
+	for i := range runIDs {
+		run := &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: testTime}
+		require.NoError(tb, pg.EnqueueRun(ctx, run))
+		require.NoError(tb, pg.AddTest(ctx, &tester.Test{
+			ID:      uuid.New(),
+			Package: "pkg",
+			RunID:   run.ID,
+			Result: &tester.T{
+				TB: tester.TB{Name: "TestA", StartedAt: testTime, FinishedAt: testTime, State: tester.TBStatePassed},
+			},
+		}))
+		runIDs[i] = run.ID
+	}
+	return runIDs
+}
+
+func TestPG_GetTestsByRunID(t *testing.T) {
+	ctx := context.Background()
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		// More runs than are fetched by a single batch.
+		runIDs := addRunsWithTests(t, pg, 2*testsByRunIDBatchSize+50)
+
+		t.Run("no runs", func(t *testing.T) {
+			tests, err := pg.GetTestsByRunID(ctx, nil)
+			require.NoError(t, err)
+			assert.Empty(t, tests)
+		})
+
+		t.Run("single run", func(t *testing.T) {
+			tests, err := pg.GetTestsByRunID(ctx, runIDs[:1])
+			require.NoError(t, err)
+			require.Len(t, tests, 1)
+			require.Len(t, tests[runIDs[0]], 1)
+			assert.Equal(t, runIDs[0], tests[runIDs[0]][0].RunID)
+		})
+
+		t.Run("multiple batches", func(t *testing.T) {
+			tests, err := pg.GetTestsByRunID(ctx, append(runIDs, uuid.New()))
+			require.NoError(t, err)
+			assert.Len(t, tests, len(runIDs))
+			for _, runID := range runIDs {
+				require.Len(t, tests[runID], 1)
+				assert.Equal(t, runID, tests[runID][0].RunID)
+			}
+		})
+	})
+}
+
+func BenchmarkPG_GetTestsByRunID(b *testing.B) {
+	ctx := context.Background()
+
+	withPG(b, func(tb testing.TB, pg *PG) {
+		runIDs := addRunsWithTests(b, pg, 1000)
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			tests, err := pg.GetTestsByRunID(ctx, runIDs)
+			require.NoError(b, err)
+			require.Len(b, tests, len(runIDs))
+		}
 	})
 }
 
@@ -572,6 +1321,7 @@ func TestPG_ListRunSummariesInRange(t *testing.T) {
 						Package:      "pkg-1",
 						RunIDs:       []uuid.UUID{pkg1run1.ID, pkg1run2.ID},
 						ErrorRunIDs:  nil,
+						FailedRunIDs: []uuid.UUID{pkg1run1.ID, pkg1run2.ID},
 						PassedTests:  map[string][]uuid.UUID{"test-pass": {pkg1run1.Tests[0].ID, pkg1run2.Tests[0].ID}},
 						FailedTests:  map[string][]uuid.UUID{"test-fail": {pkg1run1.Tests[1].ID, pkg1run2.Tests[1].ID}},
 						SkippedTests: map[string][]uuid.UUID{"test-skip": {pkg1run1.Tests[2].ID, pkg1run2.Tests[2].ID}},
@@ -580,4 +1330,97 @@ func TestPG_ListRunSummariesInRange(t *testing.T) {
 			}, summaries[0])
 		})
 	})
+
+	t.Run("dedups errored runs", func(t *testing.T) {
+		withPG(t, func(tb testing.TB, pg *PG) {
+			begin := time.Now().UTC()
+			run := &tester.Run{
+				ID:         uuid.New(),
+				Package:    "pkg-1",
+				EnqueuedAt: begin,
+				StartedAt:  begin,
+				FinishedAt: begin,
+				Error:      "failed",
+			}
+			require.NoError(t, pg.EnqueueRun(ctx, run))
+			for _, name := range []string{"test-a", "test-b"} {
+				require.NoError(t, pg.AddTest(ctx, &tester.Test{
+					ID:      uuid.New(),
+					RunID:   run.ID,
+					Package: run.Package,
+					Result:  &tester.T{TB: tester.TB{Name: name, State: tester.TBStateFailed}},
+				}))
+			}
+
+			// All of the package's runs errored, so it has no run IDs, but
+			// its errored runs are still only listed once.
+			summaries, err := pg.ListRunSummariesInRange(ctx, begin, begin.Add(time.Minute), time.Minute)
+			require.NoError(t, err)
+			require.Len(t, summaries, 1)
+			require.Contains(t, summaries[0].PackageSummary, "pkg-1")
+			packageSummary := summaries[0].PackageSummary["pkg-1"]
+			assert.Empty(t, packageSummary.RunIDs)
+			assert.Equal(t, []uuid.UUID{run.ID}, packageSummary.ErrorRunIDs)
+		})
+	})
+}
+
+func TestPG_GetPackagePassRateHistory(t *testing.T) {
+	ctx := context.Background()
+
+	withPG(t, func(tb testing.TB, pg *PG) {
+		begin := time.Now().UTC().Truncate(time.Second)
+		end := begin.Add(3 * time.Minute)
+
+		addRun := func(pkg string, startedAt time.Time, runErr string, states ...tester.TBState) {
+			run := &tester.Run{
+				ID:         uuid.New(),
+				Package:    pkg,
+				EnqueuedAt: begin,
+				StartedAt:  startedAt,
+				FinishedAt: startedAt,
+				Error:      runErr,
+			}
+			require.NoError(t, pg.EnqueueRun(ctx, run))
+			for i, state := range states {
+				require.NoError(t, pg.AddTest(ctx, &tester.Test{
+					ID:      uuid.New(),
+					RunID:   run.ID,
+					Package: pkg,
+					Result: &tester.T{
+						TB: tester.TB{Name: fmt.Sprintf("test-%d", i), State: state},
+					},
+				}))
+			}
+		}
+
+		addRun("pkg-1", begin, "", tester.TBStatePassed, tester.TBStateSkipped)
+		addRun("pkg-1", begin.Add(15*time.Second), "", tester.TBStatePassed, tester.TBStateFailed)
+		addRun("pkg-1", begin.Add(30*time.Second), "failed")
+		addRun("pkg-1", begin.Add(45*time.Second), "", tester.TBStatePassed)
+		addRun("pkg-1", end, "", tester.TBStateFailed)
+		addRun("pkg-2", begin, "", tester.TBStateFailed)
+
+		history, err := pg.GetPackagePassRateHistory(ctx, "pkg-1", begin, end, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, []*tester.PackageHistory{
+			{Time: begin, PassRate: 0.5, TotalRuns: 4, FailedRuns: 2},
+			{Time: begin.Add(time.Minute)},
+			{Time: begin.Add(2 * time.Minute), PassRate: 0, TotalRuns: 1, FailedRuns: 1},
+		}, history)
+	})
+}
+
+func TestPG_StartMetrics(t *testing.T) {
+	withPG(t, func(tb testing.TB, pg *PG) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		pg.poolMetricsInterval = 10 * time.Millisecond
+		pg.StartMetrics(ctx)
+
+		assert.Eventually(t, func() bool {
+			return testutil.ToFloat64(PGPoolTotalConnsMetric) > 0
+		}, time.Second, 10*time.Millisecond)
+	})
 }
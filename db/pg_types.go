@@ -17,6 +17,7 @@ func (t *pgTest) Columns() []string {
 		"run_id",
 		"result",
 		"logs",
+		"labels",
 	}
 }
 
@@ -27,6 +28,7 @@ func (t *pgTest) Values() []interface{} {
 		t.RunID,
 		t.Result,
 		t.Logs,
+		nonNilLabels(t.Labels),
 	}
 }
 
@@ -37,11 +39,19 @@ func (t *pgTest) Scan(row pgx.Row) error {
 		&t.RunID,
 		&t.Result,
 		&t.Logs,
+		&t.Labels,
 	)
-	if err != nil && err == pgx.ErrNoRows {
-		err = ErrNotFound
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			err = ErrNotFound
+		}
+		return err
+	}
+
+	if len(t.Labels) == 0 {
+		t.Labels = nil
 	}
-	return err
+	return nil
 }
 
 type pgRun tester.Run
@@ -56,6 +66,14 @@ func (r *pgRun) Columns() []string {
 		"started_at",
 		"finished_at",
 		"error",
+		"dead_lettered_at",
+		"dead_letter_reason",
+		"labels",
+		"count",
+		"variant",
+		"priority",
+		"enqueued_by",
+		"alert_channels",
 	}
 }
 
@@ -63,6 +81,8 @@ func (r *pgRun) Values() []interface{} {
 	startedAt := sql.NullTime{Valid: !r.StartedAt.IsZero(), Time: r.StartedAt}
 	finishedAt := sql.NullTime{Valid: !r.FinishedAt.IsZero(), Time: r.FinishedAt}
 	error := sql.NullString{Valid: r.Error != "", String: r.Error}
+	deadLetteredAt := sql.NullTime{Valid: !r.DeadLetteredAt.IsZero(), Time: r.DeadLetteredAt}
+	deadLetterReason := sql.NullString{Valid: r.DeadLetterReason != "", String: r.DeadLetterReason}
 
 	return []interface{}{
 		r.ID,
@@ -73,14 +93,24 @@ func (r *pgRun) Values() []interface{} {
 		startedAt,
 		finishedAt,
 		error,
+		deadLetteredAt,
+		deadLetterReason,
+		nonNilLabels(r.Labels),
+		r.Count,
+		r.Variant,
+		r.Priority,
+		r.EnqueuedBy,
+		pq.Array(r.AlertChannels),
 	}
 }
 
 func (r *pgRun) Scan(row pgx.Row) error {
 	var (
-		startedAt  sql.NullTime
-		finishedAt sql.NullTime
-		error      sql.NullString
+		startedAt        sql.NullTime
+		finishedAt       sql.NullTime
+		error            sql.NullString
+		deadLetteredAt   sql.NullTime
+		deadLetterReason sql.NullString
 	)
 
 	err := row.Scan(
@@ -92,6 +122,14 @@ func (r *pgRun) Scan(row pgx.Row) error {
 		&startedAt,
 		&finishedAt,
 		&error,
+		&deadLetteredAt,
+		&deadLetterReason,
+		&r.Labels,
+		&r.Count,
+		&r.Variant,
+		&r.Priority,
+		&r.EnqueuedBy,
+		pq.Array(&r.AlertChannels),
 	)
 	if err != nil {
 		if err == pgx.ErrNoRows {
@@ -109,5 +147,23 @@ func (r *pgRun) Scan(row pgx.Row) error {
 	if error.Valid {
 		r.Error = error.String
 	}
+	if deadLetteredAt.Valid {
+		r.DeadLetteredAt = deadLetteredAt.Time
+	}
+	if deadLetterReason.Valid {
+		r.DeadLetterReason = deadLetterReason.String
+	}
+	if len(r.Labels) == 0 {
+		r.Labels = nil
+	}
 	return nil
 }
+
+// nonNilLabels ensures that missing labels are stored as an empty object
+// rather than a json null.
+func nonNilLabels(labels tester.Labels) tester.Labels {
+	if labels == nil {
+		return tester.Labels{}
+	}
+	return labels
+}
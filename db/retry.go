@@ -0,0 +1,53 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Backoff computes exponentially increasing delays between retries, starting
+// at Initial and doubling up to Max.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+
+	next time.Duration
+}
+
+// Next returns the delay before the next retry.
+func (b *Backoff) Next() time.Duration {
+	if b.next == 0 {
+		b.next = b.Initial
+	}
+	delay := b.next
+	if b.next *= 2; b.next > b.Max {
+		b.next = b.Max
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	return delay
+}
+
+// Reset restarts the delays from Initial.
+func (b *Backoff) Reset() {
+	b.next = 0
+}
+
+// Retry calls fn until it succeeds, waiting between attempts as determined by
+// b. It gives up once ctx is done, returning the last error of fn.
+func Retry(ctx context.Context, b *Backoff, fn func(ctx context.Context) error) error {
+	for {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-time.After(b.Next()):
+		case <-ctx.Done():
+			return fmt.Errorf("giving up retrying: %w", err)
+		}
+	}
+}
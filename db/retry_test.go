@@ -0,0 +1,55 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackoff(t *testing.T) {
+	b := &Backoff{Initial: time.Second, Max: 5 * time.Second}
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delays = append(delays, b.Next())
+	}
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second}, delays)
+
+	b.Reset()
+	assert.Equal(t, time.Second, b.Next())
+
+	b = &Backoff{Initial: time.Minute, Max: time.Second}
+	assert.Equal(t, time.Second, b.Next())
+}
+
+func TestRetry(t *testing.T) {
+	t.Run("succeeds", func(t *testing.T) {
+		var attempts int
+		err := Retry(context.Background(), &Backoff{Initial: time.Millisecond, Max: time.Millisecond}, func(context.Context) error {
+			attempts++
+			if attempts < 3 {
+				return errors.New("boom")
+			}
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("gives up when done", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		boom := errors.New("boom")
+		var attempts int
+		err := Retry(ctx, &Backoff{Initial: time.Millisecond, Max: 10 * time.Millisecond}, func(context.Context) error {
+			attempts++
+			return boom
+		})
+		assert.True(t, errors.Is(err, boom))
+		assert.Greater(t, attempts, 1)
+	})
+}
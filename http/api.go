@@ -2,12 +2,18 @@ package http
 
 import (
 	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
@@ -18,21 +24,42 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 )
 
+// ExternalRunner is the runner recorded for synthetic runs created for test
+// results submitted by external runners.
+const ExternalRunner = "external"
+
 // APIHandler is the http handler for presenting the API.
 type APIHandler struct {
 	http.Handler
 
 	db           db.DB
-	packages     map[string]*tester.Package
+	packages     *packageStore
 	alertManager *alerting.AlertManager
 	slackApp     *slack.App
-	apiKey       string
+	flakyWindow  time.Duration
+	runners      *RunnerRegistry
+	basePath     string
+	logger       *slog.Logger
+
+	// loadPackage turns package definitions into the packages served, and
+	// packagesChanged is called after the stored packages change.
+	loadPackage     func(*tester.Package) (*tester.Package, error)
+	packagesChanged func()
+
+	// apiKeys are ordered from oldest to newest.
+	apiKeysMu sync.RWMutex
+	apiKeys   []string
+	// runnerKeys authenticate runners, which are sent the values of
+	// packages' secrets.
+	runnerKeys []string
 }
 
 // NewAPIHandler constructs a new `APIHandler`.
 func NewAPIHandler(db db.DB, packages []*tester.Package, opts ...Option) *APIHandler {
 	defOpts := &options{
-		alertManager: &alerting.AlertManager{},
+		alertManager:       alerting.NewAlertManager("", nil),
+		maxRequestBodySize: DefaultMaxRequestBodySize,
+		logger:             slog.Default(),
 	}
 
 	for _, opt := range opts {
@@ -41,40 +68,87 @@ func NewAPIHandler(db db.DB, packages []*tester.Package, opts ...Option) *APIHan
 
 	handler := &APIHandler{
 		db:           db,
-		packages:     make(map[string]*tester.Package),
+		packages:     newPackageStore(packages),
 		alertManager: defOpts.alertManager,
 		slackApp:     defOpts.slackApp,
-		apiKey:       defOpts.apiKey,
-	}
+		apiKeys:      defOpts.apiKeys,
+		runnerKeys:   defOpts.runnerKeys,
+		flakyWindow:  defOpts.flakyWindow,
+		runners:      NewRunnerRegistry(),
+		basePath:     defOpts.basePath,
+		logger:       defOpts.logger,
 
-	for _, pkg := range packages {
-		handler.packages[pkg.Name] = pkg
+		loadPackage:     defOpts.packageLoader,
+		packagesChanged: defOpts.packagesChanged,
+	}
+	if handler.loadPackage == nil {
+		handler.loadPackage = loadPackageDefinition
 	}
 
 	r := mux.NewRouter()
 
 	if handler.slackApp != nil {
-		r.HandleFunc("/api/slack/command", LogHandlerFunc(handler.slackApp.HandleSlackCommand)).Methods(http.MethodPost)
+		r.HandleFunc(handler.basePath+"/api/slack/command", LogHandlerFunc(handler.logger, handler.slackApp.HandleSlackCommand)).Methods(http.MethodPost)
 	}
 
-	ar := r.PathPrefix("/api").Subrouter()
-	if handler.apiKey != "" {
+	ar := r.PathPrefix(handler.basePath + "/api").Subrouter()
+	if defOpts.maxRequestBodySize > 0 {
+		ar.Use(MaxRequestBodySizeMiddleware(defOpts.maxRequestBodySize))
+	}
+	if len(handler.apiKeys) > 0 || len(handler.runnerKeys) > 0 {
 		ar.Use(handler.ensureAuth)
 	}
-	ar.HandleFunc("/tests", LogHandlerFunc(handler.submitTest)).Methods(http.MethodPost)
-	ar.HandleFunc("/tests", LogHandlerFunc(handler.listTests)).Methods(http.MethodGet)
-	ar.HandleFunc("/tests/{test_id}", LogHandlerFunc(handler.getTest)).Methods(http.MethodGet)
-	ar.HandleFunc("/runs/claim", LogHandlerFunc(handler.claimRun)).Methods(http.MethodPost)
-	ar.HandleFunc("/runs/{run_id}/complete", LogHandlerFunc(handler.completeRun)).Methods(http.MethodPost)
-	ar.HandleFunc("/runs/{run_id}/fail", LogHandlerFunc(handler.failRun)).Methods(http.MethodPost)
-	ar.HandleFunc("/packages/{package_name}", LogHandlerFunc(handler.getPackage)).Methods(http.MethodGet)
-	ar.HandleFunc("/packages/{package_name}/download", LogHandlerFunc(handler.downloadPackage)).Methods(http.MethodGet)
+	if defOpts.requestBodyLogBytes > 0 {
+		ar.Use(handler.logRequestBodies(defOpts.requestBodyLogBytes))
+	}
+	ar.Use(handler.trackRunners)
+	ar.HandleFunc("/auth/rotate", LogHandlerFunc(handler.logger, handler.rotateAPIKey)).Methods(http.MethodPost)
+	ar.HandleFunc("/tests", LogHandlerFunc(handler.logger, handler.submitTest)).Methods(http.MethodPost)
+	ar.HandleFunc("/tests", LogHandlerFunc(handler.logger, handler.listTests)).Methods(http.MethodGet)
+	ar.HandleFunc("/tests/search", LogHandlerFunc(handler.logger, handler.searchTests)).Methods(http.MethodGet)
+	ar.HandleFunc("/tests/{test_id}", LogHandlerFunc(handler.logger, handler.getTest)).Methods(http.MethodGet)
+	ar.HandleFunc("/runner/status", LogHandlerFunc(handler.logger, handler.runnerStatus)).Methods(http.MethodGet)
+	ar.HandleFunc("/runs/claim", LogHandlerFunc(handler.logger, handler.claimRun)).Methods(http.MethodPost)
+	ar.HandleFunc("/runs/{run_id}/complete", LogHandlerFunc(handler.logger, handler.completeRun)).Methods(http.MethodPost)
+	ar.HandleFunc("/runs/{run_id}/fail", LogHandlerFunc(handler.logger, handler.failRun)).Methods(http.MethodPost)
+	ar.HandleFunc("/runs/{run_id}/reset", LogHandlerFunc(handler.logger, handler.resetRun)).Methods(http.MethodPost)
+	ar.HandleFunc("/runs", LogHandlerFunc(handler.logger, handler.listRuns)).Methods(http.MethodGet)
+	ar.HandleFunc("/runs/{run_id}", LogHandlerFunc(handler.logger, handler.getRun)).Methods(http.MethodGet)
+	ar.HandleFunc("/runs/{run_id}/cancel", LogHandlerFunc(handler.logger, handler.cancelRun)).Methods(http.MethodPost)
+	ar.HandleFunc("/runs/{run_id}/rerun-failed", LogHandlerFunc(handler.logger, handler.rerunFailedRun)).Methods(http.MethodPost)
+	ar.HandleFunc("/summaries", LogHandlerFunc(handler.logger, handler.listRunSummaries)).Methods(http.MethodGet)
+	ar.HandleFunc("/packages", LogHandlerFunc(handler.logger, handler.listPackages)).Methods(http.MethodGet)
+	ar.HandleFunc("/packages", LogHandlerFunc(handler.logger, handler.createPackage)).Methods(http.MethodPost)
+	ar.HandleFunc("/packages/{package_name}", LogHandlerFunc(handler.logger, handler.getPackage)).Methods(http.MethodGet)
+	ar.HandleFunc("/packages/{package_name}", LogHandlerFunc(handler.logger, handler.updatePackage)).Methods(http.MethodPut)
+	ar.HandleFunc("/packages/{package_name}", LogHandlerFunc(handler.logger, handler.deletePackage)).Methods(http.MethodDelete)
+	ar.HandleFunc("/packages/{package_name}/download", LogHandlerFunc(handler.logger, handler.downloadPackage)).Methods(http.MethodGet)
+	ar.HandleFunc("/packages/{package_name}/stats", LogHandlerFunc(handler.logger, handler.getPackageStats)).Methods(http.MethodGet)
+	ar.HandleFunc("/packages/{package_name}/history", LogHandlerFunc(handler.logger, handler.getPackageHistory)).Methods(http.MethodGet)
+	ar.HandleFunc("/packages/{package_name}/slow", LogHandlerFunc(handler.logger, handler.listSlowTests)).Methods(http.MethodGet)
+	ar.HandleFunc("/packages/{package_name}/verify", LogHandlerFunc(handler.logger, handler.verifyPackage)).Methods(http.MethodGet)
+	ar.HandleFunc("/packages/{package_name}/enable", LogHandlerFunc(handler.logger, handler.enablePackage(true))).Methods(http.MethodPut)
+	ar.HandleFunc("/packages/{package_name}/disable", LogHandlerFunc(handler.logger, handler.enablePackage(false))).Methods(http.MethodPut)
+	ar.HandleFunc("/packages/{package_name}/env", LogHandlerFunc(handler.logger, handler.setPackageEnv)).Methods(http.MethodPost)
+	ar.HandleFunc("/quarantine", LogHandlerFunc(handler.logger, handler.listQuarantined)).Methods(http.MethodGet)
+	ar.HandleFunc("/quarantine/{package_name}/{test_name:.+}", LogHandlerFunc(handler.logger, handler.quarantineTest)).Methods(http.MethodPut)
+	ar.HandleFunc("/quarantine/{package_name}/{test_name:.+}", LogHandlerFunc(handler.logger, handler.unquarantineTest)).Methods(http.MethodDelete)
 
 	handler.Handler = r
+	if defOpts.cors != nil {
+		// Preflight requests are answered before routing, since routes only
+		// match their own methods.
+		handler.Handler = CORSMiddleware(*defOpts.cors)(r)
+	}
 
 	return handler
 }
 
+// UpdatePackages replaces the packages served by the handler.
+func (h *APIHandler) UpdatePackages(packages []*tester.Package) {
+	h.packages.Reload(packages)
+}
+
 func (h *APIHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	h.Handler.ServeHTTP(w, r)
 }
@@ -83,23 +157,63 @@ func (h *APIHandler) submitTest(w http.ResponseWriter, r *http.Request) {
 	var test tester.Test
 	err := json.NewDecoder(r.Body).Decode(&test)
 	if err != nil {
-		renderAPIError(w, http.StatusBadRequest, fmt.Errorf("decoding json: %w", err))
+		renderAPIError(w, decodeErrorStatus(err, http.StatusBadRequest), fmt.Errorf("decoding json: %w", err))
 		return
 	}
 
-	run, err := h.db.GetRun(r.Context(), test.RunID)
-	if err != nil {
+	if test.Result == nil {
+		renderAPIError(w, http.StatusBadRequest, errors.New("missing test result"))
+		return
+	}
+	if test.ID == uuid.Nil {
+		test.ID = uuid.New()
+	}
+
+	// Results from external runners can be submitted with autorun, in which
+	// case a synthetic run is created for them if the run does not exist.
+	autorun := r.URL.Query().Get("autorun") == "true"
+
+	var run *tester.Run
+	if test.RunID == uuid.Nil {
+		err = db.ErrNotFound
+	} else {
+		run, err = h.db.GetRunMetadata(r.Context(), test.RunID)
+	}
+	switch {
+	case errors.Is(err, db.ErrNotFound) && autorun:
+		if test.Package == "" {
+			renderAPIError(w, http.StatusBadRequest, errors.New("missing package"))
+			return
+		}
+		run, err = h.createExternalRun(r.Context(), &test)
+		if err != nil {
+			requestLogger(h.logger, r).Error("failed to create external run", "run_id", test.RunID, "package", test.Package, "err", err)
+			renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("creating run: %w", err))
+			return
+		}
+	case errors.Is(err, db.ErrNotFound):
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("unknown run %s", test.RunID))
+		return
+	case err != nil:
 		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("getting run: %w", err))
 		return
 	}
-	if !run.FinishedAt.IsZero() {
+	if autorun && test.Package != "" && test.Package != run.Package {
+		renderAPIError(w, http.StatusConflict, fmt.Errorf("run %s is for package %s, not %s", run.ID, run.Package, test.Package))
+		return
+	}
+	// External runs are completed when they are created, but further results
+	// can still be submitted for them.
+	external := autorun && run.Meta.Runner == ExternalRunner
+	if !run.FinishedAt.IsZero() && !external {
 		renderAPIError(w, http.StatusBadRequest, errors.New("cannot submit test for finished run"))
 		return
 	}
+	test.Labels = run.Labels
 
 	err = h.db.AddTest(r.Context(), &test)
 	if err != nil {
-		log.Printf("failed to add test: %s", err)
+		requestLogger(h.logger, r).Error("failed to add test", "run_id", test.RunID, "package", test.Package, "err", err)
 		renderAPIError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -110,24 +224,129 @@ func (h *APIHandler) submitTest(w http.ResponseWriter, r *http.Request) {
 	}
 	RunDurationMetric.With(runLabels).Observe(test.Result.FinishedAt.Sub(test.Result.StartedAt).Seconds())
 	RunLastMetric.With(runLabels).Set(float64(test.Result.StartedAt.Unix()))
+	ResultsMetric.With(prometheus.Labels{
+		"package": test.Package,
+		"name":    test.Result.Name,
+		"state":   string(test.Result.State),
+	}).Inc()
 
 	if test.Result.State == tester.TBStateFailed {
-		go func() {
-			err := h.alertManager.Fire(context.Background(), &alerting.Alert{Run: run, Test: &test})
-			if err != nil {
-				log.Printf("failed to fire alert: %s", err)
-			}
-		}()
+		h.alertManager.FireAsync(context.Background(), &alerting.Alert{Run: run, Test: &test})
+	}
+	// Flaky tests are marked when runs finish, but external runs are finished
+	// as soon as they are created, so their tests are checked as they are
+	// submitted.
+	if h.flakyWindow > 0 && external && test.Result.State != tester.TBStateSkipped {
+		go h.markFlakyTests(test.Package)
 	}
 
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(&test)
 }
 
+// markFlakyTests marks the recent tests of pkg that are flaky.
+func (h *APIHandler) markFlakyTests(pkg string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	names, err := h.db.MarkFlakyTests(ctx, pkg, h.flakyWindow)
+	if err != nil {
+		h.logger.Error("failed to mark flaky tests", "package", pkg, "err", err)
+		return
+	}
+	if len(names) > 0 {
+		h.logger.Debug("marked flaky tests", "package", pkg, "tests", strings.Join(names, ", "))
+	}
+}
+
+// createExternalRun creates a synthetic run for a test submitted by an
+// external runner, already started and finished so that it is never claimed.
+// External runners submit the results of a run concurrently, so the run may
+// have been created by another submission in the meantime, in which case that
+// run is returned instead.
+func (h *APIHandler) createExternalRun(ctx context.Context, test *tester.Test) (*tester.Run, error) {
+	now := time.Now()
+	run := &tester.Run{
+		ID:         test.RunID,
+		Package:    test.Package,
+		EnqueuedAt: now,
+		StartedAt:  now,
+		FinishedAt: now,
+		Meta:       tester.RunMeta{Runner: ExternalRunner},
+	}
+	if run.ID == uuid.Nil {
+		run.ID = uuid.New()
+	}
+	test.RunID = run.ID
+	if pkg, ok := h.packages.Get(test.Package); ok {
+		run.Labels = pkg.Labels
+	}
+
+	if err := h.db.AddRun(ctx, run); err != nil {
+		return nil, fmt.Errorf("adding run: %w", err)
+	}
+	run, err := h.db.GetRunMetadata(ctx, run.ID)
+	if err != nil {
+		return nil, fmt.Errorf("getting run: %w", err)
+	}
+	return run, nil
+}
+
 func (h *APIHandler) listTests(w http.ResponseWriter, r *http.Request) {
-	tests, err := h.db.ListTests(r.Context(), 0)
+	labels, err := tester.ParseLabels(r.URL.Query()["label"])
+	if err != nil {
+		renderAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	state := tester.TBState(r.URL.Query().Get("state"))
+	if state != "" && !state.Valid() {
+		renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid state: %s", state))
+		return
+	}
+
+	var tests []*tester.Test
+	switch {
+	case len(labels) > 0:
+		tests, err = h.db.ListTestsByLabels(r.Context(), labels, 0)
+		if state != "" {
+			tests = filterTestsByState(tests, state)
+		}
+	case state != "":
+		tests, err = h.db.ListTestsByState(r.Context(), state, 0)
+	default:
+		tests, err = h.db.ListTests(r.Context(), 0)
+	}
+	if err != nil {
+		requestLogger(h.logger, r).Error("failed to list tests", "err", err)
+		renderAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tests)
+}
+
+func (h *APIHandler) searchTests(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		renderAPIError(w, http.StatusBadRequest, errors.New("missing search query"))
+		return
+	}
+
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", l))
+			return
+		}
+	}
+
+	pkg := r.URL.Query().Get("package")
+	tests, err := h.db.SearchTests(r.Context(), query, pkg, limit)
 	if err != nil {
-		log.Printf("failed to list tests: %s", err)
+		requestLogger(h.logger, r).Error("failed to search tests", "query", query, "package", pkg, "err", err)
 		renderAPIError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -136,6 +355,16 @@ func (h *APIHandler) listTests(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(tests)
 }
 
+func filterTestsByState(tests []*tester.Test, state tester.TBState) []*tester.Test {
+	var filtered []*tester.Test
+	for _, test := range tests {
+		if test.Result != nil && test.Result.State == state {
+			filtered = append(filtered, test)
+		}
+	}
+	return filtered
+}
+
 func (h *APIHandler) getTest(w http.ResponseWriter, r *http.Request) {
 	testID, err := uuid.Parse(mux.Vars(r)["test_id"])
 	if err != nil {
@@ -148,7 +377,7 @@ func (h *APIHandler) getTest(w http.ResponseWriter, r *http.Request) {
 		if err == db.ErrNotFound {
 			renderAPIError(w, http.StatusNotFound, err)
 		} else {
-			log.Printf("failed to get tests: %s", err)
+			requestLogger(h.logger, r).Error("failed to get test", "test_id", testID, "err", err)
 			renderAPIError(w, http.StatusInternalServerError, err)
 		}
 		return
@@ -167,14 +396,14 @@ func (h *APIHandler) claimRun(w http.ResponseWriter, r *http.Request) {
 	var claimRunRequest ClaimRunRequest
 	err := json.NewDecoder(r.Body).Decode(&claimRunRequest)
 	if err != nil {
-		log.Printf("failed to parse claim run request: %s", err)
-		renderAPIError(w, http.StatusInternalServerError, err)
+		requestLogger(h.logger, r).Error("failed to parse claim run request", "err", err)
+		renderAPIError(w, decodeErrorStatus(err, http.StatusInternalServerError), err)
 		return
 	}
 
 	var packages []string
 	if len(claimRunRequest.PackageWhitelist) == 0 {
-		for _, pkg := range h.packages {
+		for _, pkg := range h.packages.List() {
 			packages = append(packages, pkg.Name)
 		}
 	} else {
@@ -190,9 +419,21 @@ func (h *APIHandler) claimRun(w http.ResponseWriter, r *http.Request) {
 		unsupportedPackages[pkg] = struct{}{}
 	}
 
+	// Prefer the stable runner ID for identifying the runner, falling back to
+	// the user agent for runners that don't provide one.
+	meta := tester.RunMeta{Runner: r.Header.Get("User-Agent")}
+	if runnerID := r.Header.Get(RunnerIDHeader); runnerID != "" {
+		meta.RunnerID, err = uuid.Parse(runnerID)
+		if err != nil {
+			renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid runner id: %w", err))
+			return
+		}
+		meta.Runner = runnerID
+	}
+
 	runs, err := h.db.ListPendingRuns(r.Context())
 	if err != nil {
-		log.Printf("failed to list runs: %s", err)
+		requestLogger(h.logger, r).Error("failed to list runs", "err", err)
 		renderAPIError(w, http.StatusInternalServerError, err)
 		return
 	}
@@ -207,7 +448,21 @@ func (h *APIHandler) claimRun(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if _, supported := supportedPackages[run.Package]; supported {
-			h.db.StartRun(r.Context(), run.ID, r.Header.Get("User-Agent"))
+			run.Meta = meta
+			if pkg, ok := h.packages.Get(run.Package); ok {
+				run.Meta.Race = pkg.Race
+			}
+			err := h.db.StartRun(r.Context(), run.ID, run.Meta)
+			if err != nil {
+				if errors.Is(err, db.ErrNotFound) {
+					// The run was claimed concurrently, try the next one.
+					continue
+				}
+				requestLogger(h.logger, r).Error("failed to start run", "run_id", run.ID, "err", err)
+				renderAPIError(w, http.StatusInternalServerError, err)
+				return
+			}
+			h.runners.Claimed(r, run.ID)
 			w.WriteHeader(http.StatusOK)
 			json.NewEncoder(w).Encode(run)
 			return
@@ -224,7 +479,7 @@ func (h *APIHandler) completeRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	run, err := h.db.GetRun(r.Context(), runID)
+	run, err := h.db.GetRunMetadata(r.Context(), runID)
 	if err != nil {
 		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("getting run: %w", err))
 		return
@@ -236,10 +491,14 @@ func (h *APIHandler) completeRun(w http.ResponseWriter, r *http.Request) {
 
 	err = h.db.CompleteRun(r.Context(), runID)
 	if err != nil {
-		log.Printf("failed to complete run: %s", err)
+		requestLogger(h.logger, r).Error("failed to complete run", "run_id", runID, "err", err)
 		renderAPIError(w, http.StatusInternalServerError, err)
 		return
 	}
+	h.runners.Finished(runID)
+	if h.flakyWindow > 0 {
+		go h.markFlakyTests(run.Package)
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -251,7 +510,7 @@ func (h *APIHandler) failRun(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	run, err := h.db.GetRun(r.Context(), runID)
+	run, err := h.db.GetRunMetadata(r.Context(), runID)
 	if err != nil {
 		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("getting run: %w", err))
 		return
@@ -264,64 +523,1160 @@ func (h *APIHandler) failRun(w http.ResponseWriter, r *http.Request) {
 	var errorMessage string
 	err = json.NewDecoder(r.Body).Decode(&errorMessage)
 	if err != nil {
-		log.Printf("failed to parse fail run request: %s", err)
-		renderAPIError(w, http.StatusInternalServerError, err)
+		requestLogger(h.logger, r).Error("failed to parse fail run request", "run_id", runID, "err", err)
+		renderAPIError(w, decodeErrorStatus(err, http.StatusInternalServerError), err)
 		return
 	}
 
 	err = h.db.FailRun(r.Context(), runID, errorMessage)
 	if err != nil {
-		log.Printf("failed to fail run: %s", err)
+		requestLogger(h.logger, r).Error("failed to fail run", "run_id", runID, "err", err)
 		renderAPIError(w, http.StatusInternalServerError, err)
 		return
 	}
+	h.runners.Finished(runID)
+	if h.flakyWindow > 0 {
+		go h.markFlakyTests(run.Package)
+	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func (h *APIHandler) getPackage(w http.ResponseWriter, r *http.Request) {
-	pkgName := mux.Vars(r)["package_name"]
-	pkg, ok := h.packages[pkgName]
-	if !ok {
-		renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+func (h *APIHandler) resetRun(w http.ResponseWriter, r *http.Request) {
+	runID, err := uuid.Parse(mux.Vars(r)["run_id"])
+	if err != nil {
+		renderAPIError(w, http.StatusNotFound, err)
+		return
+	}
+
+	status, err := resetUnfinishedRun(r.Context(), h.db, runID)
+	if err != nil {
+		if status == http.StatusInternalServerError {
+			requestLogger(h.logger, r).Error("failed to reset run", "run_id", runID, "err", err)
+		}
+		renderAPIError(w, status, err)
 		return
 	}
+	h.runners.Finished(runID)
 
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(&pkg)
 }
 
-func (h *APIHandler) downloadPackage(w http.ResponseWriter, r *http.Request) {
-	pkgName := mux.Vars(r)["package_name"]
-	pkg, ok := h.packages[pkgName]
-	if !ok {
-		renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+// defaultListRunsLimit is the number of runs of each state listed by listRuns
+// if the request doesn't set a limit.
+const defaultListRunsLimit = 50
+
+// listRuns lists the runs, optionally only those of a package or in a state
+// (pending, finished or dead_lettered), with up to limit runs of each state.
+func (h *APIHandler) listRuns(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	pkg := query.Get("package")
+	state := query.Get("state")
+	switch state {
+	case "", runStatePending, runStateFinished, runStateDeadLettered:
+	default:
+		renderAPIError(w, http.StatusBadRequest, fmt.Errorf("unknown run state: %s", state))
 		return
 	}
+	limit := defaultListRunsLimit
+	if l := query.Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 {
+			renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", l))
+			return
+		}
+	}
 
-	http.ServeFile(w, r, pkg.Path)
+	runs := []*tester.Run{}
+	if state == "" || state == runStatePending {
+		var (
+			pending []*tester.Run
+			err     error
+		)
+		if pkg == "" {
+			pending, err = h.db.ListPendingRuns(r.Context())
+		} else {
+			pending, err = h.db.ListPendingRunsForPackage(r.Context(), pkg)
+		}
+		if err != nil {
+			renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("listing pending runs: %w", err))
+			return
+		}
+		if len(pending) > limit {
+			pending = pending[:limit]
+		}
+		runs = append(runs, pending...)
+	}
+	if state == "" || state == runStateFinished {
+		finished, err := h.db.ListFinishedRuns(r.Context(), pkg, limit, 0)
+		if err != nil {
+			renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("listing finished runs: %w", err))
+			return
+		}
+		runs = append(runs, finished...)
+	}
+	if state == "" || state == runStateDeadLettered {
+		deadLettered, err := h.db.ListDeadLetteredRuns(r.Context(), pkg, limit, 0)
+		if err != nil {
+			renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("listing dead lettered runs: %w", err))
+			return
+		}
+		runs = append(runs, deadLettered...)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(runs)
 }
 
-func (h *APIHandler) ensureAuth(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		username, password, ok := r.BasicAuth()
-		if !ok || password != h.apiKey {
-			renderAPIError(w, http.StatusUnauthorized, fmt.Errorf("user %s is unauthorized", username))
+// getRun gets the run along with its tests.
+func (h *APIHandler) getRun(w http.ResponseWriter, r *http.Request) {
+	runID, err := uuid.Parse(mux.Vars(r)["run_id"])
+	if err != nil {
+		renderAPIError(w, http.StatusNotFound, err)
+		return
+	}
+
+	run, err := h.db.GetRun(r.Context(), runID)
+	if errors.Is(err, db.ErrNotFound) {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("unknown run %s", runID))
+		return
+	}
+	if err != nil {
+		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("getting run: %w", err))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(run)
+}
+
+// runCanceledMessage is the error or dead letter reason recorded for canceled
+// runs.
+const runCanceledMessage = "canceled"
+
+// cancelRun cancels a run that has not finished. Pending runs are dead
+// lettered, so that they are never claimed, and running runs are failed, so
+// that their runner's further results are rejected.
+func (h *APIHandler) cancelRun(w http.ResponseWriter, r *http.Request) {
+	runID, err := uuid.Parse(mux.Vars(r)["run_id"])
+	if err != nil {
+		renderAPIError(w, http.StatusNotFound, err)
+		return
+	}
+
+	run, err := h.db.GetRunMetadata(r.Context(), runID)
+	if errors.Is(err, db.ErrNotFound) {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("unknown run %s", runID))
+		return
+	}
+	if err != nil {
+		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("getting run: %w", err))
+		return
+	}
+	if !run.FinishedAt.IsZero() || !run.DeadLetteredAt.IsZero() {
+		renderAPIError(w, http.StatusConflict, errors.New("cannot cancel already finished run"))
+		return
+	}
+
+	if run.StartedAt.IsZero() {
+		err = h.db.DeadLetterRun(r.Context(), runID, runCanceledMessage)
+		if errors.Is(err, db.ErrNotFound) {
+			// The run was claimed or dead lettered since it was fetched.
+			renderAPIError(w, http.StatusConflict, errors.New("run was claimed or dead lettered while canceling, try again"))
 			return
 		}
-		next.ServeHTTP(w, r)
+	} else {
+		err = h.db.FailRun(r.Context(), runID, runCanceledMessage)
+	}
+	if err != nil {
+		requestLogger(h.logger, r).Error("failed to cancel run", "run_id", runID, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	h.runners.Finished(runID)
+	requestLogger(h.logger, r).Info("canceled run", "run_id", runID, "package", run.Package)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// rerunFailedRun enqueues a run of the failed top level tests of a finished
+// run, with the same package, variant and args, other than -test.run.
+func (h *APIHandler) rerunFailedRun(w http.ResponseWriter, r *http.Request) {
+	runID, err := uuid.Parse(mux.Vars(r)["run_id"])
+	if err != nil {
+		renderAPIError(w, http.StatusNotFound, err)
+		return
+	}
+
+	run, err := h.db.GetRun(r.Context(), runID)
+	if errors.Is(err, db.ErrNotFound) {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("unknown run %s", runID))
+		return
+	}
+	if err != nil {
+		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("getting run: %w", err))
+		return
+	}
+	if run.FinishedAt.IsZero() {
+		renderAPIError(w, http.StatusConflict, errors.New("cannot rerun failed tests of unfinished run"))
+		return
+	}
+
+	// Tests are repeated in runs with a count, so each is only matched once.
+	var failed []string
+	seen := make(map[string]bool)
+	for _, test := range run.Tests {
+		if test.Result == nil || test.Result.State != tester.TBStateFailed || seen[test.Result.Name] {
+			continue
+		}
+		seen[test.Result.Name] = true
+		failed = append(failed, regexp.QuoteMeta(test.Result.Name))
+	}
+	if len(failed) == 0 {
+		renderAPIError(w, http.StatusConflict, errors.New("run has no failed tests"))
+		return
+	}
+
+	var args []string
+	for _, arg := range run.Args {
+		if !isTestRunArg(arg) {
+			args = append(args, arg)
+		}
+	}
+	args = append(args, fmt.Sprintf("-test.run=^(%s)$", strings.Join(failed, "|")))
+
+	rerun := &tester.Run{
+		ID:            uuid.New(),
+		Package:       run.Package,
+		Args:          args,
+		EnqueuedAt:    time.Now(),
+		Labels:        run.Labels,
+		Count:         run.Count,
+		Variant:       run.Variant,
+		Priority:      run.Priority,
+		EnqueuedBy:    "rerun:" + run.ID.String(),
+		AlertChannels: run.AlertChannels,
+	}
+	if err := h.db.EnqueueRun(r.Context(), rerun); err != nil {
+		requestLogger(h.logger, r).Error("failed to enqueue rerun", "run_id", runID, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("enqueueing run: %w", err))
+		return
+	}
+	requestLogger(h.logger, r).Info("enqueued rerun of failed tests", "run_id", runID, "rerun_id", rerun.ID, "package", rerun.Package)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rerun)
+}
+
+// isTestRunArg returns whether the arg sets the tests run by a test binary.
+func isTestRunArg(arg string) bool {
+	name := strings.TrimLeft(arg, "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name = name[:i]
+	}
+	return name == "test.run" || name == "run"
+}
+
+// runnerStatus lists the runners that have made requests to the API, and
+// whether they have been seen recently.
+func (h *APIHandler) runnerStatus(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.runners.List())
+}
+
+// resetUnfinishedRun resets the run back to pending so that it can be claimed
+// again, eg. when its runner died. It returns the status describing why the
+// run could not be reset, if it could not be.
+func resetUnfinishedRun(ctx context.Context, store db.DB, runID uuid.UUID) (int, error) {
+	run, err := store.GetRunMetadata(ctx, runID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return http.StatusNotFound, err
+		}
+		return http.StatusInternalServerError, fmt.Errorf("getting run: %w", err)
+	}
+	if !run.FinishedAt.IsZero() {
+		return http.StatusConflict, errors.New("cannot reset already finished run")
+	}
+
+	if err := store.ResetRun(ctx, runID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			// The run finished since it was checked.
+			return http.StatusConflict, errors.New("cannot reset already finished run")
+		}
+		return http.StatusInternalServerError, fmt.Errorf("resetting run: %w", err)
+	}
+	return http.StatusOK, nil
+}
+
+// maxSummaryBuckets limits the number of run summaries that can be requested
+// at once, since each is computed from the runs in its window.
+const maxSummaryBuckets = 1000
+
+// listRunSummaries lists the run summaries for each window between the begin
+// and end unix timestamps, where window is in seconds.
+func (h *APIHandler) listRunSummaries(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	begin, err := strconv.ParseInt(query.Get("begin"), 10, 64)
+	if err != nil {
+		renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid begin: %w", err))
+		return
+	}
+	end, err := strconv.ParseInt(query.Get("end"), 10, 64)
+	if err != nil {
+		renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid end: %w", err))
+		return
+	}
+	if end <= begin {
+		renderAPIError(w, http.StatusBadRequest, errors.New("end must be after begin"))
+		return
+	}
+	windowSeconds, err := strconv.ParseFloat(query.Get("window"), 64)
+	if err != nil || windowSeconds <= 0 {
+		renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid window: %s", query.Get("window")))
+		return
+	}
+	window := time.Duration(windowSeconds * float64(time.Second))
+	if float64(end-begin)/windowSeconds > maxSummaryBuckets {
+		renderAPIError(w, http.StatusBadRequest, fmt.Errorf("too many windows, at most %d can be requested", maxSummaryBuckets))
+		return
+	}
+
+	summaries, err := h.db.ListRunSummariesInRange(r.Context(), time.Unix(begin, 0), time.Unix(end, 0), window)
+	if err != nil {
+		requestLogger(h.logger, r).Error("failed to list run summaries", "err", err)
+		renderAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(summaries)
+}
+
+// PackageResponse is the API representation of a package. It embeds the
+// package, rather than mirroring its fields, so that responses include fields
+// as they are added to packages, eg. the variants runners need to run them.
+// The paths of the package's test binaries are internal to the server and
+// omitted, as are the values of secrets, except in responses to runners
+// authenticated with a runner key.
+type PackageResponse struct {
+	tester.Package
+	// Path shadows the package's path so that it is omitted.
+	Path string `json:"path,omitempty"`
+}
+
+func newPackageResponse(pkg *tester.Package) *PackageResponse {
+	resp := &PackageResponse{Package: *pkg}
+	resp.Package.Path = ""
+	resp.Variants = nil
+	for _, variant := range pkg.Variants {
+		variant.Path = ""
+		resp.Variants = append(resp.Variants, variant)
+	}
+	resp.Environment = pkg.RedactedEnvironment()
+	return resp
+}
+
+func (h *APIHandler) listPackages(w http.ResponseWriter, r *http.Request) {
+	allPackages := h.packages.List()
+	packages := make([]*PackageResponse, 0, len(allPackages))
+	for _, pkg := range allPackages {
+		packages = append(packages, newPackageResponse(pkg))
+	}
+	sort.Slice(packages, func(i, j int) bool {
+		return packages[i].Name < packages[j].Name
 	})
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&packages)
 }
 
-func renderAPIError(w http.ResponseWriter, status int, err error) {
-	aerr := apiError{
-		Status: status,
-		Error:  err.Error(),
+func (h *APIHandler) getPackage(w http.ResponseWriter, r *http.Request) {
+	pkgName := mux.Vars(r)["package_name"]
+	pkg, ok := h.packages.Get(pkgName)
+	if !ok {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+		return
+	}
+
+	resp := newPackageResponse(pkg)
+	// Runners need the values of secrets to set them for the test binaries.
+	// The runner ID header can be set by any client, so runners are only
+	// trusted when they authenticate with a runner key.
+	if h.isRunnerAuth(r) {
+		resp.Environment = pkg.Environment
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// PackageStatsResponse is the totals for runs of a package over a time range.
+type PackageStatsResponse struct {
+	Package      string    `json:"package"`
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Runs         int       `json:"runs"`
+	ErrorRuns    int       `json:"error_runs"`
+	FailedRuns   int       `json:"failed_runs"`
+	PassedTests  int       `json:"passed_tests"`
+	FailedTests  int       `json:"failed_tests"`
+	SkippedTests int       `json:"skipped_tests"`
+	PassRate     float64   `json:"pass_rate"`
+}
+
+func (h *APIHandler) getPackageStats(w http.ResponseWriter, r *http.Request) {
+	pkgName := mux.Vars(r)["package_name"]
+	if _, ok := h.packages.Get(pkgName); !ok {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+		return
+	}
+
+	from, err := time.Parse(time.RFC3339, r.URL.Query().Get("from"))
+	if err != nil {
+		renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid from: %w", err))
+		return
+	}
+	to, err := time.Parse(time.RFC3339, r.URL.Query().Get("to"))
+	if err != nil {
+		renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid to: %w", err))
+		return
+	}
+	if !from.Before(to) {
+		renderAPIError(w, http.StatusBadRequest, errors.New("from must be before to"))
+		return
+	}
+
+	summaries, err := h.db.ListRunSummariesInRange(r.Context(), from, to, to.Sub(from))
+	if err != nil {
+		requestLogger(h.logger, r).Error("failed to list run summaries", "package", pkgName, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	stats := PackageStatsResponse{
+		Package: pkgName,
+		From:    from,
+		To:      to,
+	}
+	for _, summary := range summaries {
+		pkgSummary, ok := summary.PackageSummary[pkgName]
+		if !ok {
+			continue
+		}
+		stats.Runs += len(pkgSummary.RunIDs)
+		stats.ErrorRuns += len(pkgSummary.ErrorRunIDs)
+		stats.FailedRuns += len(pkgSummary.FailedRunIDs)
+		stats.PassedTests += pkgSummary.NumPassedTests()
+		stats.FailedTests += pkgSummary.NumFailedTests()
+		stats.SkippedTests += pkgSummary.NumSkippedTests()
+	}
+	if total := stats.PassedTests + stats.FailedTests + stats.SkippedTests; total > 0 {
+		stats.PassRate = float64(stats.PassedTests) / float64(total)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&stats)
+}
+
+const (
+	defaultHistoryWindow = time.Hour
+	defaultHistoryRange  = 7 * 24 * time.Hour
+)
+
+// getPackageHistory returns the pass rate of the runs of a package over the
+// range up to now, bucketed by window. Both are durations, which may also be
+// given in days (eg. "7d"), and default to 1h and 7d respectively.
+func (h *APIHandler) getPackageHistory(w http.ResponseWriter, r *http.Request) {
+	pkgName := mux.Vars(r)["package_name"]
+	if _, ok := h.packages.Get(pkgName); !ok {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+		return
+	}
+
+	query := r.URL.Query()
+	window := defaultHistoryWindow
+	if value := query.Get("window"); value != "" {
+		d, err := parseDays(value)
+		if err != nil || d <= 0 {
+			renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid window: %s", value))
+			return
+		}
+		window = d
+	}
+	historyRange := defaultHistoryRange
+	if value := query.Get("range"); value != "" {
+		d, err := parseDays(value)
+		if err != nil || d <= 0 {
+			renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid range: %s", value))
+			return
+		}
+		historyRange = d
+	}
+	if historyRange/window > maxSummaryBuckets {
+		renderAPIError(w, http.StatusBadRequest, fmt.Errorf("too many windows, at most %d can be requested", maxSummaryBuckets))
+		return
+	}
+
+	end := time.Now().UTC()
+	history, err := h.db.GetPackagePassRateHistory(r.Context(), pkgName, end.Add(-historyRange), end, window)
+	if err != nil {
+		requestLogger(h.logger, r).Error("failed to get package history", "package", pkgName, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(history)
+}
+
+// SlowTestResponse summarizes the durations of a test over a window.
+// Durations are encoded as strings (eg. "1m30s").
+type SlowTestResponse struct {
+	Name   string `json:"name"`
+	Count  int    `json:"count"`
+	Median string `json:"median"`
+	P95    string `json:"p95"`
+	Max    string `json:"max"`
+	// PriorCount and PriorMedian summarize the test over the prior window, and
+	// are omitted if it didn't run in the prior window.
+	PriorCount  int    `json:"prior_count,omitempty"`
+	PriorMedian string `json:"prior_median,omitempty"`
+	Regressed   bool   `json:"regressed"`
+}
+
+func newSlowTestResponse(d *testDurations) *SlowTestResponse {
+	resp := &SlowTestResponse{
+		Name:       d.Name,
+		Count:      d.Count,
+		Median:     d.Median.String(),
+		P95:        d.P95.String(),
+		Max:        d.Max.String(),
+		PriorCount: d.PriorCount,
+		Regressed:  d.Regressed,
+	}
+	if d.PriorCount > 0 {
+		resp.PriorMedian = d.PriorMedian.String()
+	}
+	return resp
+}
+
+// SlowTestsResponse lists the slowest tests of a package over a window, and
+// the tests whose durations have regressed compared to the prior window.
+type SlowTestsResponse struct {
+	Package     string              `json:"package"`
+	From        time.Time           `json:"from"`
+	To          time.Time           `json:"to"`
+	Tests       []*SlowTestResponse `json:"tests"`
+	Regressions []*SlowTestResponse `json:"regressions"`
+}
+
+// listSlowTests lists the slowest tests of a package by median and then p95
+// duration over the window up to now, which may be given in days (eg. "7d")
+// and defaults to 7d. At most limit tests are listed, while all of the
+// regressed tests are.
+func (h *APIHandler) listSlowTests(w http.ResponseWriter, r *http.Request) {
+	pkgName := mux.Vars(r)["package_name"]
+	if _, ok := h.packages.Get(pkgName); !ok {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+		return
+	}
+
+	query := r.URL.Query()
+	window, err := parseSlowTestsWindow(query.Get("window"))
+	if err != nil {
+		renderAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	limit := defaultSlowTestsLimit
+	if l := query.Get("limit"); l != "" {
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit <= 0 || limit > maxSlowTestsLimit {
+			renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %s", l))
+			return
+		}
+	}
+
+	end := time.Now().UTC()
+	durations, err := listSlowTests(r.Context(), h.db, pkgName, end, window)
+	if err != nil {
+		requestLogger(h.logger, r).Error("failed to list slow tests", "package", pkgName, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	resp := SlowTestsResponse{
+		Package:     pkgName,
+		From:        end.Add(-window),
+		To:          end,
+		Tests:       []*SlowTestResponse{},
+		Regressions: []*SlowTestResponse{},
+	}
+	for i, d := range durations {
+		if i == limit {
+			break
+		}
+		resp.Tests = append(resp.Tests, newSlowTestResponse(d))
+	}
+	for _, d := range durationRegressions(durations) {
+		resp.Regressions = append(resp.Regressions, newSlowTestResponse(d))
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&resp)
+}
+
+// parseDays parses a duration like time.ParseDuration, additionally accepting
+// a whole number of days (eg. "7d").
+func parseDays(value string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid days: %w", err)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(value)
+}
+
+// VerifyPackageResponse is the result of verifying a package's test binary
+// against its expected sha256 sum.
+type VerifyPackageResponse struct {
+	Package  string `json:"package"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	Valid    bool   `json:"valid"`
+}
+
+func (h *APIHandler) verifyPackage(w http.ResponseWriter, r *http.Request) {
+	pkgName := mux.Vars(r)["package_name"]
+	pkg, ok := h.packages.Get(pkgName)
+	if !ok {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+		return
+	}
+
+	sha256Sum, err := pkg.ComputeSHA256Sum()
+	if err != nil {
+		requestLogger(h.logger, r).Error("failed to verify package", "package", pkgName, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&VerifyPackageResponse{
+		Package:  pkg.Name,
+		Expected: pkg.SHA256Sum,
+		Actual:   sha256Sum,
+		Valid:    pkg.SHA256Sum == sha256Sum,
+	})
+}
+
+// enablePackage enables or disables the package, both in its stored
+// definition and in the package served.
+func (h *APIHandler) enablePackage(enabled bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		pkgName := mux.Vars(r)["package_name"]
+		pkg, ok := h.packages.Get(pkgName)
+		if !ok {
+			renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+			return
+		}
+
+		def, err := h.db.GetPackage(r.Context(), pkgName)
+		if errors.Is(err, db.ErrNotFound) {
+			renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s is not stored", pkgName))
+			return
+		}
+		if err != nil {
+			renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("getting package: %w", err))
+			return
+		}
+		def.Enabled = &enabled
+		if err := h.db.UpdatePackage(r.Context(), def); err != nil {
+			requestLogger(h.logger, r).Error("failed to toggle package", "package", pkgName, "err", err)
+			renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("updating package: %w", err))
+			return
+		}
+
+		// The served package is replaced rather than modified since it is
+		// shared with concurrent requests.
+		toggled := *pkg
+		toggled.Enabled = &enabled
+		h.packages.Set(&toggled)
+		h.notifyPackagesChanged()
+		requestLogger(h.logger, r).Info("toggled package", "package", pkgName, "enabled", enabled)
+
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(newPackageResponse(&toggled))
+	}
+}
+
+// setPackageEnv sets the non-secret env vars in the request body on the
+// package, both in its stored definition and in the package served. Env vars
+// that aren't set already are added. Secrets can only be set by updating the
+// package.
+func (h *APIHandler) setPackageEnv(w http.ResponseWriter, r *http.Request) {
+	pkgName := mux.Vars(r)["package_name"]
+	pkg, ok := h.packages.Get(pkgName)
+	if !ok {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+		return
+	}
+
+	var envVars []tester.EnvVar
+	if err := json.NewDecoder(r.Body).Decode(&envVars); err != nil {
+		renderAPIError(w, decodeErrorStatus(err, http.StatusBadRequest), err)
+		return
+	}
+	for _, v := range envVars {
+		if v.Name == "" || strings.Contains(v.Name, "=") {
+			renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid env var name %q", v.Name))
+			return
+		}
+		if v.Secret {
+			renderAPIError(w, http.StatusBadRequest, fmt.Errorf("env var %s: secrets can only be set by updating the package", v.Name))
+			return
+		}
+	}
+
+	def, err := h.db.GetPackage(r.Context(), pkgName)
+	if errors.Is(err, db.ErrNotFound) {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s is not stored", pkgName))
+		return
+	}
+	if err != nil {
+		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("getting package: %w", err))
+		return
+	}
+	def.Environment, err = setEnvVars(def.Environment, envVars)
+	if err != nil {
+		renderAPIError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.db.UpdatePackage(r.Context(), def); err != nil {
+		requestLogger(h.logger, r).Error("failed to set package env", "package", pkgName, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("updating package: %w", err))
+		return
+	}
+
+	// The served package is replaced rather than modified since it is
+	// shared with concurrent requests.
+	updated := *pkg
+	updated.Environment = def.Environment
+	h.packages.Set(&updated)
+	h.notifyPackagesChanged()
+
+	names := make([]string, len(envVars))
+	for i, v := range envVars {
+		names[i] = v.Name
+	}
+	requestLogger(h.logger, r).Info("set package env", "package", pkgName, "env", strings.Join(names, ", "))
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(newPackageResponse(&updated))
+}
+
+// setEnvVars returns a copy of env with the env vars set, replacing those of
+// the same name and appending the rest. Secrets can't be replaced.
+func setEnvVars(env, envVars []tester.EnvVar) ([]tester.EnvVar, error) {
+	updated := append([]tester.EnvVar(nil), env...)
+	for _, v := range envVars {
+		replaced := false
+		for i := range updated {
+			if updated[i].Name != v.Name {
+				continue
+			}
+			if updated[i].Secret {
+				return nil, fmt.Errorf("env var %s: secrets can only be set by updating the package", v.Name)
+			}
+			updated[i] = v
+			replaced = true
+		}
+		if !replaced {
+			updated = append(updated, v)
+		}
+	}
+	return updated, nil
+}
+
+// loadPackageDefinition serves package definitions as they are, as long as
+// they are named.
+func loadPackageDefinition(pkg *tester.Package) (*tester.Package, error) {
+	if pkg.Name == "" {
+		return nil, errors.New("missing name")
+	}
+	return pkg, nil
+}
+
+// notifyPackagesChanged calls the configured packagesChanged func, if any.
+func (h *APIHandler) notifyPackagesChanged() {
+	if h.packagesChanged != nil {
+		h.packagesChanged()
+	}
+}
+
+// decodePackageDefinition decodes the package definition in the request body
+// and loads the package it defines, rendering an error if either fails.
+func (h *APIHandler) decodePackageDefinition(w http.ResponseWriter, r *http.Request) (def, pkg *tester.Package, ok bool) {
+	if err := json.NewDecoder(r.Body).Decode(&def); err != nil || def == nil {
+		if err == nil {
+			err = errors.New("missing package definition")
+		}
+		renderAPIError(w, decodeErrorStatus(err, http.StatusBadRequest), err)
+		return nil, nil, false
+	}
+	if name, ok := mux.Vars(r)["package_name"]; ok {
+		if def.Name == "" {
+			def.Name = name
+		}
+		if def.Name != name {
+			renderAPIError(w, http.StatusBadRequest, fmt.Errorf("package name %s does not match %s", def.Name, name))
+			return nil, nil, false
+		}
+	}
+
+	// The definition is copied so that loading can't change what is stored,
+	// eg. by filling in computed sha256 sums.
+	loaded := *def
+	pkg, err := h.loadPackage(&loaded)
+	if err != nil {
+		renderAPIError(w, http.StatusBadRequest, fmt.Errorf("invalid package: %w", err))
+		return nil, nil, false
+	}
+	return def, pkg, true
+}
+
+// createPackage stores a new package definition and starts serving the
+// package.
+func (h *APIHandler) createPackage(w http.ResponseWriter, r *http.Request) {
+	def, pkg, ok := h.decodePackageDefinition(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.AddPackage(r.Context(), def); err != nil {
+		if errors.Is(err, db.ErrAlreadyExists) {
+			renderAPIError(w, http.StatusConflict, fmt.Errorf("package %s already exists", def.Name))
+			return
+		}
+		requestLogger(h.logger, r).Error("failed to add package", "package", def.Name, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("adding package: %w", err))
+		return
+	}
+	h.packages.Set(pkg)
+	h.notifyPackagesChanged()
+	requestLogger(h.logger, r).Info("created package", "package", pkg.Name)
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(newPackageResponse(pkg))
+}
+
+// updatePackage replaces the stored definition of a package and the package
+// served.
+func (h *APIHandler) updatePackage(w http.ResponseWriter, r *http.Request) {
+	def, pkg, ok := h.decodePackageDefinition(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.UpdatePackage(r.Context(), def); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", def.Name))
+			return
+		}
+		requestLogger(h.logger, r).Error("failed to update package", "package", def.Name, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("updating package: %w", err))
+		return
+	}
+	h.packages.Set(pkg)
+	h.notifyPackagesChanged()
+	requestLogger(h.logger, r).Info("updated package", "package", pkg.Name)
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(newPackageResponse(pkg))
+}
+
+// deletePackage deletes the stored definition of a package. The package is
+// still served, but disabled, so that its history remains accessible.
+func (h *APIHandler) deletePackage(w http.ResponseWriter, r *http.Request) {
+	pkgName := mux.Vars(r)["package_name"]
+	if err := h.db.DeletePackage(r.Context(), pkgName); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+			return
+		}
+		requestLogger(h.logger, r).Error("failed to delete package", "package", pkgName, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, fmt.Errorf("deleting package: %w", err))
+		return
+	}
+	if pkg, ok := h.packages.Get(pkgName); ok {
+		disabled := *pkg
+		enabled := false
+		disabled.Enabled = &enabled
+		h.packages.Set(&disabled)
+	}
+	h.notifyPackagesChanged()
+	requestLogger(h.logger, r).Info("deleted package", "package", pkgName)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *APIHandler) listQuarantined(w http.ResponseWriter, r *http.Request) {
+	quarantined, err := h.db.ListQuarantined(r.Context())
+	if err != nil {
+		requestLogger(h.logger, r).Error("failed to list quarantined tests", "err", err)
+		renderAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if quarantined == nil {
+		quarantined = []*tester.QuarantinedTest{}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(&quarantined)
+}
+
+func (h *APIHandler) quarantineTest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pkgName, testName := vars["package_name"], vars["test_name"]
+	if _, ok := h.packages.Get(pkgName); !ok {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+		return
+	}
+
+	if err := h.db.Quarantine(r.Context(), pkgName, testName); err != nil {
+		requestLogger(h.logger, r).Error("failed to quarantine test", "package", pkgName, "test", testName, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	requestLogger(h.logger, r).Info("quarantined test", "package", pkgName, "test", testName)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *APIHandler) unquarantineTest(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pkgName, testName := vars["package_name"], vars["test_name"]
+	if err := h.db.Unquarantine(r.Context(), pkgName, testName); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			renderAPIError(w, http.StatusNotFound, fmt.Errorf("test %s in package %s is not quarantined", testName, pkgName))
+			return
+		}
+		requestLogger(h.logger, r).Error("failed to unquarantine test", "package", pkgName, "test", testName, "err", err)
+		renderAPIError(w, http.StatusInternalServerError, err)
+		return
+	}
+	requestLogger(h.logger, r).Info("unquarantined test", "package", pkgName, "test", testName)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (h *APIHandler) downloadPackage(w http.ResponseWriter, r *http.Request) {
+	pkgName := mux.Vars(r)["package_name"]
+	pkg, ok := h.packages.Get(pkgName)
+	if !ok {
+		renderAPIError(w, http.StatusNotFound, fmt.Errorf("package %s not found", pkgName))
+		return
+	}
+	pkg, err := pkg.Variant(r.URL.Query().Get("variant"))
+	if err != nil {
+		renderAPIError(w, http.StatusNotFound, err)
+		return
+	}
+
+	// The sha256 sum identifies the test binary, allowing runners that already
+	// have it to skip the download using If-None-Match. http.ServeFile honours
+	// the ETag when evaluating conditional requests.
+	if pkg.SHA256Sum != "" {
+		w.Header().Set("ETag", fmt.Sprintf("%q", pkg.SHA256Sum))
+	}
+	// Test binaries are served as is rather than compressed, which also keeps
+	// range requests and the content length meaningful.
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeFile(w, r, pkg.Path)
+}
+
+func (h *APIHandler) ensureAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok {
+			renderAPIError(w, http.StatusUnauthorized, fmt.Errorf("user %s is unauthorized", username))
+			return
+		}
+		switch {
+		case h.validAPIKey(password):
+		case h.validRunnerKey(password):
+			// Runner keys are only accepted for what runners do, so that
+			// they can't be used to manage packages or api keys.
+			if !h.isRunnerRoute(r) {
+				renderAPIError(w, http.StatusForbidden, fmt.Errorf("user %s is not allowed to %s %s with a runner key", username, r.Method, r.URL.Path))
+				return
+			}
+		default:
+			renderAPIError(w, http.StatusUnauthorized, fmt.Errorf("user %s is unauthorized", username))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logRequestBodies logs up to maxBytes of the bodies of requests, except for
+// package definitions, which include the values of secrets.
+func (h *APIHandler) logRequestBodies(maxBytes int) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		logged := RequestBodyLoggingMiddleware(h.logger, maxBytes)(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if isRoute(r, h.basePath, packageDefinitionRoutes) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			logged.ServeHTTP(w, r)
+		})
+	}
+}
+
+// packageDefinitionRoutes are the routes, by method and path template
+// relative to the api, whose request bodies define packages' environments.
+var packageDefinitionRoutes = map[string][]string{
+	http.MethodPost: {"/packages", "/packages/{package_name}/env"},
+	http.MethodPut:  {"/packages/{package_name}"},
+}
+
+// runnerRoutes are the routes, by method and path template relative to the
+// api, that runners use.
+var runnerRoutes = map[string][]string{
+	http.MethodGet:  {"/packages/{package_name}", "/packages/{package_name}/download"},
+	http.MethodPost: {"/runs/claim", "/runs/{run_id}/complete", "/runs/{run_id}/fail", "/tests"},
+}
+
+// isRunnerRoute returns whether the request is for one of the runnerRoutes.
+func (h *APIHandler) isRunnerRoute(r *http.Request) bool {
+	return isRoute(r, h.basePath, runnerRoutes)
+}
+
+// isRoute returns whether the request is for one of the routes, by method and
+// path template relative to the api served under basePath.
+func isRoute(r *http.Request, basePath string, routes map[string][]string) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	tmpl, err := route.GetPathTemplate()
+	if err != nil {
+		return false
+	}
+	tmpl = strings.TrimPrefix(tmpl, basePath+"/api")
+	for _, path := range routes[r.Method] {
+		if tmpl == path {
+			return true
+		}
+	}
+	return false
+}
+
+// trackRunners records the runners making requests in the runner registry.
+// Requests for the runner status and for managing runs are not tracked, since
+// they are made by operators rather than runners.
+func (h *APIHandler) trackRunners(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !h.isOperatorRequest(r) {
+			h.runners.Seen(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isOperatorRequest returns whether the request is one made by operators, eg.
+// through the runs command, rather than by runners.
+func (h *APIHandler) isOperatorRequest(r *http.Request) bool {
+	path := strings.TrimPrefix(r.URL.Path, h.basePath+"/api")
+	switch {
+	case path == "/runner/status":
+		return true
+	case r.Method == http.MethodGet && (path == "/runs" || strings.HasPrefix(path, "/runs/")):
+		return true
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "/runs/") &&
+		(strings.HasSuffix(path, "/cancel") || strings.HasSuffix(path, "/rerun-failed")):
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *APIHandler) validAPIKey(key string) bool {
+	h.apiKeysMu.RLock()
+	defer h.apiKeysMu.RUnlock()
+
+	valid := false
+	for _, apiKey := range h.apiKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(apiKey)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func (h *APIHandler) validRunnerKey(key string) bool {
+	valid := false
+	for _, runnerKey := range h.runnerKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(runnerKey)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// isRunnerAuth returns whether the request is authenticated with a runner key.
+func (h *APIHandler) isRunnerAuth(r *http.Request) bool {
+	_, password, ok := r.BasicAuth()
+	return ok && h.validRunnerKey(password)
+}
+
+// rotateAPIKey removes the oldest api key, so that it is no longer accepted.
+// Rotations must be authenticated with the newest key, which is the one
+// clients are being moved to, and which is never removed since that would
+// lock out all clients.
+func (h *APIHandler) rotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	h.apiKeysMu.Lock()
+	defer h.apiKeysMu.Unlock()
+
+	_, password, _ := r.BasicAuth()
+	if len(h.apiKeys) == 0 || subtle.ConstantTimeCompare([]byte(password), []byte(h.apiKeys[len(h.apiKeys)-1])) != 1 {
+		renderAPIError(w, http.StatusForbidden, errors.New("api keys can only be rotated with the newest api key"))
+		return
+	}
+	if len(h.apiKeys) < 2 {
+		renderAPIError(w, http.StatusConflict, errors.New("cannot rotate out the only api key"))
+		return
+	}
+	h.apiKeys = h.apiKeys[1:]
+	requestLogger(h.logger, r).Info("rotated out api key", "remaining_keys", len(h.apiKeys))
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func renderAPIError(w http.ResponseWriter, status int, err error) {
+	aerr := apiError{
+		Status: status,
+		Error:  err.Error(),
 	}
 	w.WriteHeader(status)
 	json.NewEncoder(w).Encode(&aerr)
 }
 
+// decodeErrorStatus returns the status of a response to a request whose body
+// failed to decode with err, 413 if the body was too large and status
+// otherwise.
+func decodeErrorStatus(err error, status int) int {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return status
+}
+
 type apiError struct {
 	Status int    `json:"status"`
 	Error  string `json:"error"`
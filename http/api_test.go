@@ -2,21 +2,31 @@ package http
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/google/uuid"
 	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/alerting"
 	"github.com/nanzhong/tester/db"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"gotest.tools/assert"
 )
@@ -57,6 +67,22 @@ func addAuth(r *http.Request) {
 	r.Header.Set("User-Agent", testUserAgent)
 }
 
+// waitingDB is a db whose first lookups of runs wait until ready is done, so
+// that concurrent requests all look up runs before any of them adds one.
+type waitingDB struct {
+	*db.MockDB
+	ready *sync.WaitGroup
+}
+
+func (d *waitingDB) GetRunMetadata(ctx context.Context, id uuid.UUID) (*tester.Run, error) {
+	run, err := d.MockDB.GetRunMetadata(ctx, id)
+	if errors.Is(err, db.ErrNotFound) {
+		d.ready.Done()
+		d.ready.Wait()
+	}
+	return run, err
+}
+
 func TestSubmitTest(t *testing.T) {
 	t.Run("api auth", func(t *testing.T) {
 		test := &tester.Test{}
@@ -83,7 +109,8 @@ func TestSubmitTest(t *testing.T) {
 		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
 			now := time.Now().UTC().Round(time.Second)
 			test := &tester.Test{
-				RunID: uuid.New(),
+				RunID:  uuid.New(),
+				Result: &tester.T{TB: tester.TB{Name: "TestA"}},
 			}
 			reqBody, err := json.Marshal(test)
 			require.NoError(t, err)
@@ -93,7 +120,7 @@ func TestSubmitTest(t *testing.T) {
 
 			addAuth(req)
 
-			mockDB.EXPECT().GetRun(gomock.Any(), test.RunID).Return(&tester.Run{FinishedAt: now}, nil)
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(&tester.Run{FinishedAt: now}, nil)
 
 			resp, err := ts.Client().Do(req)
 			require.NoError(t, err)
@@ -132,14 +159,18 @@ func TestSubmitTest(t *testing.T) {
 
 			addAuth(req)
 
-			mockDB.EXPECT().GetRun(gomock.Any(), test.RunID).Return(&tester.Run{}, nil)
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(&tester.Run{}, nil)
 			mockDB.EXPECT().AddTest(gomock.Any(), gomock.Eq(test)).Return(nil)
 
+			results := ResultsMetric.With(prometheus.Labels{"package": "pkg", "name": "TestA", "state": "passed"})
+			before := testutil.ToFloat64(results)
+
 			resp, err := ts.Client().Do(req)
 			require.NoError(t, err)
 			defer resp.Body.Close()
 
 			assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+			assert.Equal(t, before+1, testutil.ToFloat64(results))
 
 			var respTest tester.Test
 			err = json.NewDecoder(resp.Body).Decode(&respTest)
@@ -147,6 +178,427 @@ func TestSubmitTest(t *testing.T) {
 			assert.DeepEqual(t, test, &respTest)
 		})
 	})
+
+	t.Run("inherits run labels", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			test := &tester.Test{
+				ID:      uuid.New(),
+				Package: "pkg",
+				RunID:   uuid.New(),
+				Result: &tester.T{
+					TB: tester.TB{Name: "TestA", State: tester.TBStatePassed},
+				},
+				Labels: tester.Labels{"team": "ignored"},
+			}
+			reqBody, err := json.Marshal(test)
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/tests", ts.URL), bytes.NewBuffer(reqBody))
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			runLabels := tester.Labels{"team": "payments"}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(&tester.Run{Labels: runLabels}, nil)
+			mockDB.EXPECT().AddTest(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, addTest *tester.Test) error {
+				assert.DeepEqual(t, runLabels, addTest.Labels)
+				return nil
+			})
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+		})
+	})
+
+	t.Run("failed test alerts with run", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		alerts := make(chan *alerting.Alert, 1)
+		alertManager := alerting.NewAlertManager("http://tester", []alerting.Alerter{alerterFunc(func(_ context.Context, alert *alerting.Alert) error {
+			alerts <- alert
+			return nil
+		})})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		alertManager.StartWorkers(ctx, 1)
+
+		mockDB := db.NewMockDB(ctrl)
+		ts := httptest.NewServer(NewAPIHandler(mockDB, nil, WithAPIKey(testKey), WithAlertManager(alertManager)))
+		defer ts.Close()
+
+		test := &tester.Test{
+			ID:      uuid.New(),
+			Package: "pkg",
+			RunID:   uuid.New(),
+			Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStateFailed}},
+		}
+		run := &tester.Run{ID: test.RunID, Package: "pkg", Args: []string{"-test.run=TestA"}}
+		mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(run, nil)
+		mockDB.EXPECT().AddTest(gomock.Any(), gomock.Any()).Return(nil)
+
+		reqBody, err := json.Marshal(test)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/tests", ts.URL), bytes.NewBuffer(reqBody))
+		require.NoError(t, err)
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		select {
+		case alert := <-alerts:
+			assert.DeepEqual(t, run, alert.Run)
+			assert.Equal(t, test.ID, alert.Test.ID)
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for alert")
+		}
+	})
+
+	submit := func(t *testing.T, ts *httptest.Server, path string, test *tester.Test) *http.Response {
+		reqBody, err := json.Marshal(test)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s%s", ts.URL, path), bytes.NewBuffer(reqBody))
+		require.NoError(t, err)
+
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("missing result", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			resp := submit(t, ts, "/api/tests", &tester.Test{Package: "pkg", RunID: uuid.New()})
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	})
+
+	t.Run("unknown run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			test := &tester.Test{
+				Package: "pkg",
+				RunID:   uuid.New(),
+				Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+			}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(nil, db.ErrNotFound)
+
+			resp := submit(t, ts, "/api/tests", test)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+			var respErr apiError
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&respErr))
+			assert.Equal(t, fmt.Sprintf("unknown run %s", test.RunID), respErr.Error)
+		})
+	})
+
+	t.Run("missing run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			test := &tester.Test{
+				Package: "pkg",
+				Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+			}
+
+			resp := submit(t, ts, "/api/tests", test)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("get run error", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			test := &tester.Test{
+				Package: "pkg",
+				RunID:   uuid.New(),
+				Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+			}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(nil, errors.New("boom"))
+
+			resp := submit(t, ts, "/api/tests?autorun=true", test)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		})
+	})
+
+	t.Run("autorun unknown run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Set(&tester.Package{Name: "pkg", Labels: tester.Labels{"team": "payments"}})
+
+			test := &tester.Test{
+				ID:      uuid.New(),
+				Package: "pkg",
+				RunID:   uuid.New(),
+				Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+			}
+			var added *tester.Run
+			gomock.InOrder(
+				mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(nil, db.ErrNotFound),
+				mockDB.EXPECT().AddRun(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, run *tester.Run) error {
+					assert.Equal(t, test.RunID, run.ID)
+					assert.Equal(t, "pkg", run.Package)
+					assert.DeepEqual(t, tester.Labels{"team": "payments"}, run.Labels)
+					assert.DeepEqual(t, tester.RunMeta{Runner: ExternalRunner}, run.Meta)
+					// The run is added finished, so that it is never claimed.
+					assert.Assert(t, !run.StartedAt.IsZero())
+					assert.Assert(t, !run.FinishedAt.IsZero())
+					added = run
+					return nil
+				}),
+				mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).DoAndReturn(func(context.Context, uuid.UUID) (*tester.Run, error) {
+					return added, nil
+				}),
+				mockDB.EXPECT().AddTest(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, addTest *tester.Test) error {
+					assert.Equal(t, test.RunID, addTest.RunID)
+					assert.DeepEqual(t, tester.Labels{"team": "payments"}, addTest.Labels)
+					return nil
+				}),
+			)
+
+			resp := submit(t, ts, "/api/tests?autorun=true", test)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+		})
+	})
+
+	t.Run("autorun without run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			test := &tester.Test{
+				Package: "external",
+				Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+			}
+
+			var (
+				runID uuid.UUID
+				added *tester.Run
+			)
+			mockDB.EXPECT().AddRun(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, run *tester.Run) error {
+				runID = run.ID
+				assert.Assert(t, runID != uuid.Nil)
+				added = run
+				return nil
+			})
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, uuid.UUID) (*tester.Run, error) {
+				return added, nil
+			})
+			mockDB.EXPECT().AddTest(gomock.Any(), gomock.Any()).Return(nil)
+
+			resp := submit(t, ts, "/api/tests?autorun=true", test)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+			var respTest tester.Test
+			err := json.NewDecoder(resp.Body).Decode(&respTest)
+			require.NoError(t, err)
+			assert.Equal(t, runID, respTest.RunID)
+			assert.Assert(t, respTest.ID != uuid.Nil)
+		})
+	})
+
+	t.Run("marks flaky tests of external runs", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := db.NewMockDB(ctrl)
+		api := NewAPIHandler(mockDB, nil, WithAPIKey(testKey), WithFlakyWindow(time.Hour))
+		ts := httptest.NewServer(api)
+		defer ts.Close()
+
+		test := &tester.Test{
+			Package: "pkg",
+			RunID:   uuid.New(),
+			Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+		}
+		mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(&tester.Run{
+			ID:         test.RunID,
+			Package:    "pkg",
+			Meta:       tester.RunMeta{Runner: ExternalRunner},
+			FinishedAt: time.Now(),
+		}, nil)
+		mockDB.EXPECT().AddTest(gomock.Any(), gomock.Any()).Return(nil)
+		marked := expectMarkFlakyTests(mockDB, "pkg")
+
+		resp := submit(t, ts, "/api/tests?autorun=true", test)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+
+		waitMarked(t, marked)
+	})
+
+	t.Run("does not mark flaky tests of unfinished runs", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		// The mock fails the test if MarkFlakyTests is called, as it is
+		// not expected.
+		mockDB := db.NewMockDB(ctrl)
+		api := NewAPIHandler(mockDB, nil, WithAPIKey(testKey), WithFlakyWindow(time.Hour))
+		ts := httptest.NewServer(api)
+		defer ts.Close()
+
+		test := &tester.Test{
+			Package: "pkg",
+			RunID:   uuid.New(),
+			Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+		}
+		mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(&tester.Run{ID: test.RunID}, nil)
+		mockDB.EXPECT().AddTest(gomock.Any(), gomock.Any()).Return(nil)
+
+		resp := submit(t, ts, "/api/tests", test)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	})
+
+	t.Run("autorun concurrent submissions", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			// The db stores the first run added with an ID, like the
+			// insert that ignores conflicting IDs does.
+			var (
+				mu     sync.Mutex
+				stored *tester.Run
+				adds   int
+			)
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Any()).DoAndReturn(func(context.Context, uuid.UUID) (*tester.Run, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				if stored == nil {
+					return nil, db.ErrNotFound
+				}
+				return stored, nil
+			}).AnyTimes()
+			mockDB.EXPECT().AddRun(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, run *tester.Run) error {
+				mu.Lock()
+				defer mu.Unlock()
+				adds++
+				if stored == nil {
+					stored = run
+				}
+				return nil
+			}).AnyTimes()
+			mockDB.EXPECT().AddTest(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+			runID := uuid.New()
+			// Both submissions see that the run doesn't exist before
+			// either of them creates it.
+			var ready sync.WaitGroup
+			ready.Add(2)
+			api.db = &waitingDB{MockDB: mockDB, ready: &ready}
+
+			var wg sync.WaitGroup
+			statuses := make([]int, 2)
+			for i, name := range []string{"TestA", "TestB"} {
+				i, test := i, &tester.Test{
+					Package: "external",
+					RunID:   runID,
+					Result:  &tester.T{TB: tester.TB{Name: name, State: tester.TBStatePassed}},
+				}
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					resp := submit(t, ts, "/api/tests?autorun=true", test)
+					defer resp.Body.Close()
+					statuses[i] = resp.StatusCode
+				}()
+			}
+			wg.Wait()
+
+			assert.DeepEqual(t, []int{http.StatusAccepted, http.StatusAccepted}, statuses)
+			assert.Equal(t, 2, adds)
+			assert.Equal(t, runID, stored.ID)
+		})
+	})
+
+	t.Run("autorun run of another package", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			test := &tester.Test{
+				Package: "external",
+				RunID:   uuid.New(),
+				Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+			}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(&tester.Run{
+				ID:         test.RunID,
+				Package:    "other",
+				Meta:       tester.RunMeta{Runner: ExternalRunner},
+				FinishedAt: time.Now(),
+			}, nil)
+
+			resp := submit(t, ts, "/api/tests?autorun=true", test)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusConflict, resp.StatusCode)
+		})
+	})
+
+	t.Run("autorun missing package", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			test := &tester.Test{
+				Result: &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+			}
+
+			resp := submit(t, ts, "/api/tests?autorun=true", test)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	})
+
+	t.Run("autorun reuses external run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			now := time.Now()
+			test := &tester.Test{
+				Package: "external",
+				RunID:   uuid.New(),
+				Result:  &tester.T{TB: tester.TB{Name: "TestB", State: tester.TBStatePassed}},
+			}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(&tester.Run{
+				ID:         test.RunID,
+				Package:    "external",
+				Meta:       tester.RunMeta{Runner: ExternalRunner},
+				FinishedAt: now,
+			}, nil)
+			mockDB.EXPECT().AddTest(gomock.Any(), gomock.Any()).Return(nil)
+
+			resp := submit(t, ts, "/api/tests?autorun=true", test)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+		})
+	})
+
+	t.Run("autorun finished run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			test := &tester.Test{
+				Package: "pkg",
+				RunID:   uuid.New(),
+				Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+			}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), test.RunID).Return(&tester.Run{
+				ID:         test.RunID,
+				Package:    "pkg",
+				Meta:       tester.RunMeta{Runner: "runner"},
+				FinishedAt: time.Now(),
+			}, nil)
+
+			resp := submit(t, ts, "/api/tests?autorun=true", test)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	})
 }
 
 func TestListTests(t *testing.T) {
@@ -195,19 +647,108 @@ func TestListTests(t *testing.T) {
 			assert.DeepEqual(t, tests, respTests)
 		})
 	})
-}
+	for _, state := range []tester.TBState{tester.TBStatePassed, tester.TBStateFailed, tester.TBStateSkipped} {
+		state := state
+		t.Run(fmt.Sprintf("filter by state %s", state), func(t *testing.T) {
+			withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+				now := time.Now().UTC().Round(time.Second)
+				tests := []*tester.Test{{
+					ID:      uuid.New(),
+					Package: "pkg",
+					RunID:   uuid.New(),
+					Result: &tester.T{
+						TB: tester.TB{
+							Name:       "TestA",
+							StartedAt:  now,
+							FinishedAt: now,
+							State:      state,
+						},
+					},
+				}}
 
-func TestGetTest(t *testing.T) {
-	t.Run("api auth", func(t *testing.T) {
-		assertAPIAuth(t, http.MethodGet, fmt.Sprintf("/api/tests/%s", uuid.New()), nil)
+				mockDB.EXPECT().ListTestsByState(gomock.Any(), state, 0).Return(tests, nil)
+
+				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/tests?state=%s", ts.URL, state), nil)
+				require.NoError(t, err)
+
+				addAuth(req)
+
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+				var respTests []*tester.Test
+				err = json.NewDecoder(resp.Body).Decode(&respTests)
+				require.NoError(t, err)
+				assert.DeepEqual(t, tests, respTests)
+			})
+		})
+	}
+
+	t.Run("invalid state", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/tests?state=invalid", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
 	})
 
-	t.Run("test not found", func(t *testing.T) {
+	t.Run("filter by labels", func(t *testing.T) {
 		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
-			missingID := uuid.New()
-			mockDB.EXPECT().GetTest(gomock.Any(), gomock.Eq(missingID)).Return(nil, db.ErrNotFound)
+			labels := tester.Labels{"team": "payments", "tier": "1"}
+			newTest := func(state tester.TBState) *tester.Test {
+				return &tester.Test{
+					ID:      uuid.New(),
+					Package: "pkg",
+					RunID:   uuid.New(),
+					Result: &tester.T{
+						TB: tester.TB{Name: "TestA", State: state},
+					},
+					Labels: labels,
+				}
+			}
+			tests := []*tester.Test{newTest(tester.TBStatePassed), newTest(tester.TBStateFailed)}
 
-			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/tests/%s", ts.URL, missingID), nil)
+			mockDB.EXPECT().ListTestsByLabels(gomock.Any(), labels, 0).Return(tests, nil).Times(2)
+
+			for _, tc := range []struct {
+				query    string
+				expected []*tester.Test
+			}{
+				{query: "label=team:payments&label=tier:1", expected: tests},
+				{query: "label=team:payments&label=tier:1&state=failed", expected: tests[1:]},
+			} {
+				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/tests?%s", ts.URL, tc.query), nil)
+				require.NoError(t, err)
+
+				addAuth(req)
+
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+				var respTests []*tester.Test
+				err = json.NewDecoder(resp.Body).Decode(&respTests)
+				require.NoError(t, err)
+				assert.DeepEqual(t, tc.expected, respTests)
+			}
+		})
+	})
+
+	t.Run("invalid label", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/tests?label=invalid", ts.URL), nil)
 			require.NoError(t, err)
 
 			addAuth(req)
@@ -216,49 +757,145 @@ func TestGetTest(t *testing.T) {
 			require.NoError(t, err)
 			defer resp.Body.Close()
 
-			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
 		})
 	})
+}
+
+func TestSearchTests(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, "/api/tests/search?q=TestA", nil)
+	})
+
+	search := func(t *testing.T, ts *httptest.Server, query string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/tests/search?%s", ts.URL, query), nil)
+		require.NoError(t, err)
+
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		return resp
+	}
 
 	t.Run("happy path", func(t *testing.T) {
 		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
 			now := time.Now().UTC().Round(time.Second)
-			test := &tester.Test{
+			tests := []*tester.Test{{
 				ID:      uuid.New(),
 				Package: "pkg",
 				RunID:   uuid.New(),
 				Result: &tester.T{
 					TB: tester.TB{
-						Name:       "TestA",
+						Name:       "TestMyTest",
 						StartedAt:  now,
 						FinishedAt: now,
 						State:      tester.TBStatePassed,
 					},
 				},
-				Logs: []tester.TBLog{{
-					Time:   now,
-					Name:   "TestA",
-					Output: []byte("output"),
-				}},
-			}
+			}}
 
-			mockDB.EXPECT().GetTest(gomock.Any(), test.ID).Return(test, nil)
+			mockDB.EXPECT().SearchTests(gomock.Any(), "mytest", "pkg", 5).Return(tests, nil)
 
-			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/tests/%s", ts.URL, test.ID), nil)
+			resp := search(t, ts, "q=mytest&package=pkg&limit=5")
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var respTests []*tester.Test
+			err := json.NewDecoder(resp.Body).Decode(&respTests)
 			require.NoError(t, err)
+			assert.DeepEqual(t, tests, respTests)
+		})
+	})
 
-			addAuth(req)
+	t.Run("default limit", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			mockDB.EXPECT().SearchTests(gomock.Any(), "TestA", "", 20).Return(nil, nil)
 
-			resp, err := ts.Client().Do(req)
-			require.NoError(t, err)
+			resp := search(t, ts, "q=TestA")
 			defer resp.Body.Close()
-
 			assert.Equal(t, http.StatusOK, resp.StatusCode)
-
-			var respTest tester.Test
-			err = json.NewDecoder(resp.Body).Decode(&respTest)
-			require.NoError(t, err)
-			assert.DeepEqual(t, test, &respTest)
+		})
+	})
+
+	for name, query := range map[string]string{
+		"empty query":   "q=",
+		"missing query": "package=pkg",
+		"invalid limit": "q=TestA&limit=none",
+	} {
+		query := query
+		t.Run(name, func(t *testing.T) {
+			withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+				resp := search(t, ts, query)
+				defer resp.Body.Close()
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			})
+		})
+	}
+}
+
+func TestGetTest(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, fmt.Sprintf("/api/tests/%s", uuid.New()), nil)
+	})
+
+	t.Run("test not found", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			missingID := uuid.New()
+			mockDB.EXPECT().GetTest(gomock.Any(), gomock.Eq(missingID)).Return(nil, db.ErrNotFound)
+
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/tests/%s", ts.URL, missingID), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			now := time.Now().UTC().Round(time.Second)
+			test := &tester.Test{
+				ID:      uuid.New(),
+				Package: "pkg",
+				RunID:   uuid.New(),
+				Result: &tester.T{
+					TB: tester.TB{
+						Name:       "TestA",
+						StartedAt:  now,
+						FinishedAt: now,
+						State:      tester.TBStatePassed,
+					},
+				},
+				Logs: []tester.TBLog{{
+					Time:   now,
+					Name:   "TestA",
+					Output: []byte("output"),
+				}},
+			}
+
+			mockDB.EXPECT().GetTest(gomock.Any(), test.ID).Return(test, nil)
+
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/tests/%s", ts.URL, test.ID), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var respTest tester.Test
+			err = json.NewDecoder(resp.Body).Decode(&respTest)
+			require.NoError(t, err)
+			assert.DeepEqual(t, test, &respTest)
 		})
 	})
 }
@@ -277,9 +914,9 @@ func TestClaimRun(t *testing.T) {
 
 	t.Run("happy path - no whitelist", func(t *testing.T) {
 		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
-			api.packages = map[string]*tester.Package{"pkg": {
+			api.packages.Reload([]*tester.Package{{
 				Name: "pkg",
-			}}
+			}})
 
 			now := time.Now().UTC().Round(time.Second)
 			run := &tester.Run{
@@ -289,7 +926,7 @@ func TestClaimRun(t *testing.T) {
 			}
 
 			mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return([]*tester.Run{run}, nil)
-			mockDB.EXPECT().StartRun(gomock.Any(), run.ID, testUserAgent).Return(nil)
+			mockDB.EXPECT().StartRun(gomock.Any(), run.ID, tester.RunMeta{Runner: testUserAgent}).Return(nil)
 
 			claimReq := ClaimRunRequest{
 				PackageWhitelist: []string{},
@@ -316,12 +953,102 @@ func TestClaimRun(t *testing.T) {
 		})
 	})
 
-	t.Run("happy path - whitelist", func(t *testing.T) {
+	t.Run("happy path - race enabled package", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{{
+				Name: "pkg",
+				Race: true,
+			}})
+
+			run := &tester.Run{
+				ID:         uuid.New(),
+				Package:    "pkg",
+				EnqueuedAt: time.Now().UTC().Round(time.Second),
+			}
+
+			mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return([]*tester.Run{run}, nil)
+			mockDB.EXPECT().StartRun(gomock.Any(), run.ID, tester.RunMeta{Runner: testUserAgent, Race: true}).Return(nil)
+
+			reqBody, err := json.Marshal(&ClaimRunRequest{})
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/claim", ts.URL), bytes.NewBuffer(reqBody))
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var respRun tester.Run
+			err = json.NewDecoder(resp.Body).Decode(&respRun)
+			require.NoError(t, err)
+			assert.Assert(t, respRun.Meta.Race)
+		})
+	})
+
+	t.Run("happy path - runner id", func(t *testing.T) {
 		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
-			api.packages = map[string]*tester.Package{
-				"pkg1": {Name: "pkg1"},
-				"pkg2": {Name: "pkg2"},
+			api.packages.Reload([]*tester.Package{{
+				Name: "pkg",
+			}})
+
+			now := time.Now().UTC().Round(time.Second)
+			run := &tester.Run{
+				ID:         uuid.New(),
+				Package:    "pkg",
+				EnqueuedAt: now,
 			}
+			runnerID := uuid.New()
+
+			mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return([]*tester.Run{run}, nil)
+			mockDB.EXPECT().StartRun(gomock.Any(), run.ID, tester.RunMeta{Runner: runnerID.String(), RunnerID: runnerID}).Return(nil)
+
+			reqBody, err := json.Marshal(&ClaimRunRequest{})
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/claim", ts.URL), bytes.NewBuffer(reqBody))
+			require.NoError(t, err)
+
+			addAuth(req)
+			req.Header.Set(RunnerIDHeader, runnerID.String())
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	})
+
+	t.Run("invalid runner id", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			reqBody, err := json.Marshal(&ClaimRunRequest{})
+			require.NoError(t, err)
+
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/claim", ts.URL), bytes.NewBuffer(reqBody))
+			require.NoError(t, err)
+
+			addAuth(req)
+			req.Header.Set(RunnerIDHeader, "invalid")
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	})
+
+	t.Run("happy path - whitelist", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{
+				{Name: "pkg1"},
+				{Name: "pkg2"},
+			})
 
 			now := time.Now().UTC().Round(time.Second)
 			runs := []*tester.Run{
@@ -338,7 +1065,7 @@ func TestClaimRun(t *testing.T) {
 			}
 
 			mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return(runs, nil)
-			mockDB.EXPECT().StartRun(gomock.Any(), runs[1].ID, testUserAgent).Return(nil)
+			mockDB.EXPECT().StartRun(gomock.Any(), runs[1].ID, tester.RunMeta{Runner: testUserAgent}).Return(nil)
 
 			claimReq := ClaimRunRequest{
 				PackageWhitelist: []string{"pkg2"},
@@ -365,13 +1092,67 @@ func TestClaimRun(t *testing.T) {
 		})
 	})
 
-	t.Run("happy path - blacklist", func(t *testing.T) {
+	t.Run("skips concurrently claimed run", func(t *testing.T) {
 		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
-			api.packages = map[string]*tester.Package{
-				"pkg1": {Name: "pkg1"},
-				"pkg2": {Name: "pkg2"},
+			api.packages.Reload([]*tester.Package{
+				{Name: "pkg"},
+			})
+
+			now := time.Now().UTC().Round(time.Second)
+			runs := []*tester.Run{
+				{ID: uuid.New(), Package: "pkg", EnqueuedAt: now},
+				{ID: uuid.New(), Package: "pkg", EnqueuedAt: now},
 			}
 
+			mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return(runs, nil)
+			mockDB.EXPECT().StartRun(gomock.Any(), runs[0].ID, gomock.Any()).Return(db.ErrNotFound)
+			mockDB.EXPECT().StartRun(gomock.Any(), runs[1].ID, gomock.Any()).Return(nil)
+
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/claim", ts.URL), bytes.NewBufferString("{}"))
+			require.NoError(t, err)
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			var respRun tester.Run
+			err = json.NewDecoder(resp.Body).Decode(&respRun)
+			require.NoError(t, err)
+			assert.Equal(t, runs[1].ID, respRun.ID)
+		})
+	})
+
+	t.Run("start run error", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{
+				{Name: "pkg"},
+			})
+
+			run := &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: time.Now()}
+			mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return([]*tester.Run{run}, nil)
+			mockDB.EXPECT().StartRun(gomock.Any(), run.ID, gomock.Any()).Return(errors.New("boom"))
+
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/claim", ts.URL), bytes.NewBufferString("{}"))
+			require.NoError(t, err)
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		})
+	})
+
+	t.Run("happy path - blacklist", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{
+				{Name: "pkg1"},
+				{Name: "pkg2"},
+			})
+
 			now := time.Now().UTC().Round(time.Second)
 			runs := []*tester.Run{
 				{
@@ -387,7 +1168,7 @@ func TestClaimRun(t *testing.T) {
 			}
 
 			mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return(runs, nil)
-			mockDB.EXPECT().StartRun(gomock.Any(), runs[1].ID, testUserAgent).Return(nil)
+			mockDB.EXPECT().StartRun(gomock.Any(), runs[1].ID, tester.RunMeta{Runner: testUserAgent}).Return(nil)
 
 			claimReq := ClaimRunRequest{
 				PackageWhitelist: []string{"pkg1", "pkg2"},
@@ -415,6 +1196,26 @@ func TestClaimRun(t *testing.T) {
 	})
 }
 
+// expectMarkFlakyTests expects the flaky tests of pkg to be marked, which
+// closes the returned channel.
+func expectMarkFlakyTests(mockDB *db.MockDB, pkg string) <-chan struct{} {
+	marked := make(chan struct{})
+	mockDB.EXPECT().MarkFlakyTests(gomock.Any(), pkg, time.Hour).DoAndReturn(func(context.Context, string, time.Duration) ([]string, error) {
+		close(marked)
+		return []string{"TestA"}, nil
+	})
+	return marked
+}
+
+func waitMarked(t *testing.T, marked <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-marked:
+	case <-time.After(5 * time.Second):
+		t.Fatal("flaky tests were not marked")
+	}
+}
+
 func TestCompleteRun(t *testing.T) {
 	t.Run("api auth", func(t *testing.T) {
 		assertAPIAuth(t, http.MethodPost, fmt.Sprintf("/api/runs/%s/complete", uuid.New()), nil)
@@ -427,7 +1228,7 @@ func TestCompleteRun(t *testing.T) {
 				ID:         uuid.New(),
 				FinishedAt: now,
 			}
-			mockDB.EXPECT().GetRun(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
 
 			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/%s/complete", ts.URL, run.ID), nil)
 			require.NoError(t, err)
@@ -447,7 +1248,7 @@ func TestCompleteRun(t *testing.T) {
 			run := &tester.Run{
 				ID: uuid.New(),
 			}
-			mockDB.EXPECT().GetRun(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
 			mockDB.EXPECT().CompleteRun(gomock.Any(), gomock.Eq(run.ID)).Return(nil)
 
 			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/%s/complete", ts.URL, run.ID), nil)
@@ -462,6 +1263,33 @@ func TestCompleteRun(t *testing.T) {
 			assert.Equal(t, http.StatusOK, resp.StatusCode)
 		})
 	})
+
+	t.Run("marks flaky tests", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := db.NewMockDB(ctrl)
+		api := NewAPIHandler(mockDB, nil, WithAPIKey(testKey), WithFlakyWindow(time.Hour))
+		ts := httptest.NewServer(api)
+		defer ts.Close()
+
+		run := &tester.Run{ID: uuid.New(), Package: "pkg"}
+		mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+		mockDB.EXPECT().CompleteRun(gomock.Any(), gomock.Eq(run.ID)).Return(nil)
+		marked := expectMarkFlakyTests(mockDB, "pkg")
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/%s/complete", ts.URL, run.ID), nil)
+		require.NoError(t, err)
+
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		waitMarked(t, marked)
+	})
 }
 
 func TestFailRun(t *testing.T) {
@@ -480,7 +1308,7 @@ func TestFailRun(t *testing.T) {
 				ID:         uuid.New(),
 				FinishedAt: now,
 			}
-			mockDB.EXPECT().GetRun(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
 
 			errorMsg := "error"
 			reqBody, err := json.Marshal(&errorMsg)
@@ -505,7 +1333,7 @@ func TestFailRun(t *testing.T) {
 			run := &tester.Run{
 				ID: uuid.New(),
 			}
-			mockDB.EXPECT().GetRun(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
 			mockDB.EXPECT().FailRun(gomock.Any(), gomock.Eq(run.ID), gomock.Eq(errorMsg)).Return(nil)
 
 			reqBody, err := json.Marshal(&errorMsg)
@@ -523,65 +1351,1500 @@ func TestFailRun(t *testing.T) {
 			assert.Equal(t, http.StatusOK, resp.StatusCode)
 		})
 	})
+
+	t.Run("marks flaky tests", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := db.NewMockDB(ctrl)
+		api := NewAPIHandler(mockDB, nil, WithAPIKey(testKey), WithFlakyWindow(time.Hour))
+		ts := httptest.NewServer(api)
+		defer ts.Close()
+
+		errorMsg := "error"
+		run := &tester.Run{ID: uuid.New(), Package: "pkg"}
+		mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+		mockDB.EXPECT().FailRun(gomock.Any(), gomock.Eq(run.ID), gomock.Eq(errorMsg)).Return(nil)
+		marked := expectMarkFlakyTests(mockDB, "pkg")
+
+		reqBody, err := json.Marshal(&errorMsg)
+		require.NoError(t, err)
+
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/%s/fail", ts.URL, run.ID), bytes.NewBuffer(reqBody))
+		require.NoError(t, err)
+
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		waitMarked(t, marked)
+	})
 }
 
-func TestGetPackage(t *testing.T) {
+func TestResetRun(t *testing.T) {
 	t.Run("api auth", func(t *testing.T) {
-		assertAPIAuth(t, http.MethodGet, "/api/packages/pkg", nil)
+		assertAPIAuth(t, http.MethodPost, fmt.Sprintf("/api/runs/%s/reset", uuid.New()), nil)
 	})
 
-	t.Run("package not found", func(t *testing.T) {
-		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
-			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg", ts.URL), nil)
-			require.NoError(t, err)
+	reset := func(t *testing.T, ts *httptest.Server, runID uuid.UUID) int {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/%s/reset", ts.URL, runID), nil)
+		require.NoError(t, err)
 
-			addAuth(req)
+		addAuth(req)
 
-			resp, err := ts.Client().Do(req)
-			require.NoError(t, err)
-			defer resp.Body.Close()
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
 
-			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	t.Run("missing run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			runID := uuid.New()
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(runID)).Return(nil, db.ErrNotFound)
+
+			assert.Equal(t, http.StatusNotFound, reset(t, ts, runID))
 		})
 	})
 
-	t.Run("happy path", func(t *testing.T) {
+	t.Run("already finished run", func(t *testing.T) {
 		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
-			pkg := &tester.Package{
-				Name:      "pkg",
-				Path:      "testdata/fake_test_bin",
-				SHA256Sum: "b5d54c39e66671c9731b9f471e585d8262cd4f54963f0c93082d8dcf334d4c78",
-				RunDelay:  5,
-				Options: []tester.Option{{
-					Name:        "timeout",
-					Description: "timeout",
-					Default:     "5m",
-				}},
-			}
-
-			api.packages = map[string]*tester.Package{
-				"pkg": pkg,
+			run := &tester.Run{
+				ID:         uuid.New(),
+				FinishedAt: time.Now(),
 			}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
 
-			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/%s", ts.URL, pkg.Name), nil)
-			require.NoError(t, err)
+			assert.Equal(t, http.StatusConflict, reset(t, ts, run.ID))
+		})
+	})
 
-			addAuth(req)
+	t.Run("finished concurrently", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			run := &tester.Run{ID: uuid.New(), StartedAt: time.Now()}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+			mockDB.EXPECT().ResetRun(gomock.Any(), gomock.Eq(run.ID)).Return(db.ErrNotFound)
 
-			resp, err := ts.Client().Do(req)
-			require.NoError(t, err)
-			defer resp.Body.Close()
+			assert.Equal(t, http.StatusConflict, reset(t, ts, run.ID))
+		})
+	})
 
-			assert.Equal(t, http.StatusOK, resp.StatusCode)
+	t.Run("happy path", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			run := &tester.Run{ID: uuid.New(), StartedAt: time.Now()}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+			mockDB.EXPECT().ResetRun(gomock.Any(), gomock.Eq(run.ID)).Return(nil)
 
-			var respPackage tester.Package
-			err = json.NewDecoder(resp.Body).Decode(&respPackage)
-			assert.DeepEqual(t, pkg, &respPackage)
+			assert.Equal(t, http.StatusOK, reset(t, ts, run.ID))
 		})
 	})
 }
 
-func TestDownloadPackage(t *testing.T) {
+func TestListRuns(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, "/api/runs", nil)
+	})
+
+	list := func(t *testing.T, ts *httptest.Server, query string) (int, []*tester.Run) {
+		req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/runs?%s", ts.URL, query), nil)
+		require.NoError(t, err)
+
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return resp.StatusCode, nil
+		}
+		var runs []*tester.Run
+		err = json.NewDecoder(resp.Body).Decode(&runs)
+		require.NoError(t, err)
+		return resp.StatusCode, runs
+	}
+
+	t.Run("invalid params", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			for _, query := range []string{"state=unknown", "limit=0", "limit=abc"} {
+				status, _ := list(t, ts, query)
+				assert.Equal(t, http.StatusBadRequest, status, query)
+			}
+		})
+	})
+
+	t.Run("all states", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			pending := []*tester.Run{{ID: uuid.New(), Package: "pkg"}, {ID: uuid.New(), Package: "pkg"}}
+			finished := []*tester.Run{{ID: uuid.New(), Package: "pkg"}}
+			deadLettered := []*tester.Run{{ID: uuid.New(), Package: "pkg"}}
+			gomock.InOrder(
+				mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return(pending, nil),
+				mockDB.EXPECT().ListFinishedRuns(gomock.Any(), "", 1, 0).Return(finished, nil),
+				mockDB.EXPECT().ListDeadLetteredRuns(gomock.Any(), "", 1, 0).Return(deadLettered, nil),
+			)
+
+			status, runs := list(t, ts, "limit=1")
+			assert.Equal(t, http.StatusOK, status)
+			require.Len(t, runs, 3)
+			assert.Equal(t, pending[0].ID, runs[0].ID)
+			assert.Equal(t, finished[0].ID, runs[1].ID)
+			assert.Equal(t, deadLettered[0].ID, runs[2].ID)
+		})
+	})
+
+	t.Run("filter by package and state", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			pending := []*tester.Run{{ID: uuid.New(), Package: "pkg"}}
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), "pkg").Return(pending, nil)
+
+			status, runs := list(t, ts, "package=pkg&state=pending")
+			assert.Equal(t, http.StatusOK, status)
+			require.Len(t, runs, 1)
+			assert.Equal(t, pending[0].ID, runs[0].ID)
+		})
+	})
+
+	t.Run("no runs", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			mockDB.EXPECT().ListFinishedRuns(gomock.Any(), "pkg", defaultListRunsLimit, 0).Return(nil, nil)
+
+			status, runs := list(t, ts, "package=pkg&state=finished")
+			assert.Equal(t, http.StatusOK, status)
+			require.NotNil(t, runs)
+			assert.Equal(t, 0, len(runs))
+		})
+	})
+}
+
+func TestGetRun(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, fmt.Sprintf("/api/runs/%s", uuid.New()), nil)
+	})
+
+	t.Run("run not found", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			runID := uuid.New()
+			mockDB.EXPECT().GetRun(gomock.Any(), gomock.Eq(runID)).Return(nil, db.ErrNotFound)
+
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/runs/%s", ts.URL, runID), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			run := &tester.Run{
+				ID:      uuid.New(),
+				Package: "pkg",
+				Tests: []*tester.Test{{
+					ID:     uuid.New(),
+					Result: &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+				}},
+			}
+			mockDB.EXPECT().GetRun(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/runs/%s", ts.URL, run.ID), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var respRun tester.Run
+			err = json.NewDecoder(resp.Body).Decode(&respRun)
+			require.NoError(t, err)
+			assert.Equal(t, run.ID, respRun.ID)
+			require.Len(t, respRun.Tests, 1)
+			assert.Equal(t, "TestA", respRun.Tests[0].Result.Name)
+		})
+	})
+}
+
+func TestCancelRun(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodPost, fmt.Sprintf("/api/runs/%s/cancel", uuid.New()), nil)
+	})
+
+	cancel := func(t *testing.T, ts *httptest.Server, runID uuid.UUID) int {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/%s/cancel", ts.URL, runID), nil)
+		require.NoError(t, err)
+
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	t.Run("missing run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			runID := uuid.New()
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(runID)).Return(nil, db.ErrNotFound)
+
+			assert.Equal(t, http.StatusNotFound, cancel(t, ts, runID))
+		})
+	})
+
+	t.Run("already finished run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			for _, run := range []*tester.Run{
+				{ID: uuid.New(), StartedAt: time.Now(), FinishedAt: time.Now()},
+				{ID: uuid.New(), DeadLetteredAt: time.Now()},
+			} {
+				mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+
+				assert.Equal(t, http.StatusConflict, cancel(t, ts, run.ID))
+			}
+		})
+	})
+
+	t.Run("pending run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			run := &tester.Run{ID: uuid.New()}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+			mockDB.EXPECT().DeadLetterRun(gomock.Any(), gomock.Eq(run.ID), runCanceledMessage).Return(nil)
+
+			assert.Equal(t, http.StatusOK, cancel(t, ts, run.ID))
+		})
+	})
+
+	t.Run("pending run claimed concurrently", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			run := &tester.Run{ID: uuid.New()}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+			mockDB.EXPECT().DeadLetterRun(gomock.Any(), gomock.Eq(run.ID), runCanceledMessage).Return(db.ErrNotFound)
+
+			assert.Equal(t, http.StatusConflict, cancel(t, ts, run.ID))
+		})
+	})
+
+	t.Run("running run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			run := &tester.Run{ID: uuid.New(), StartedAt: time.Now()}
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+			mockDB.EXPECT().FailRun(gomock.Any(), gomock.Eq(run.ID), runCanceledMessage).Return(nil)
+
+			assert.Equal(t, http.StatusOK, cancel(t, ts, run.ID))
+		})
+	})
+}
+
+func TestRerunFailedRun(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodPost, fmt.Sprintf("/api/runs/%s/rerun-failed", uuid.New()), nil)
+	})
+
+	rerun := func(t *testing.T, ts *httptest.Server, runID uuid.UUID) (int, *tester.Run) {
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/runs/%s/rerun-failed", ts.URL, runID), nil)
+		require.NoError(t, err)
+
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			return resp.StatusCode, nil
+		}
+		var run tester.Run
+		err = json.NewDecoder(resp.Body).Decode(&run)
+		require.NoError(t, err)
+		return resp.StatusCode, &run
+	}
+
+	failedTest := func(name string) *tester.Test {
+		return &tester.Test{
+			ID:     uuid.New(),
+			Result: &tester.T{TB: tester.TB{Name: name, State: tester.TBStateFailed}},
+		}
+	}
+
+	t.Run("missing run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			runID := uuid.New()
+			mockDB.EXPECT().GetRun(gomock.Any(), gomock.Eq(runID)).Return(nil, db.ErrNotFound)
+
+			status, _ := rerun(t, ts, runID)
+			assert.Equal(t, http.StatusNotFound, status)
+		})
+	})
+
+	t.Run("unfinished run", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			run := &tester.Run{ID: uuid.New(), StartedAt: time.Now(), Tests: []*tester.Test{failedTest("TestA")}}
+			mockDB.EXPECT().GetRun(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+
+			status, _ := rerun(t, ts, run.ID)
+			assert.Equal(t, http.StatusConflict, status)
+		})
+	})
+
+	t.Run("no failed tests", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			run := &tester.Run{
+				ID:         uuid.New(),
+				FinishedAt: time.Now(),
+				Tests: []*tester.Test{{
+					ID:     uuid.New(),
+					Result: &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+				}},
+			}
+			mockDB.EXPECT().GetRun(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+
+			status, _ := rerun(t, ts, run.ID)
+			assert.Equal(t, http.StatusConflict, status)
+		})
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			run := &tester.Run{
+				ID:            uuid.New(),
+				Package:       "pkg",
+				Args:          []string{"-test.v", "-test.run=TestOld", "-test.timeout=1m"},
+				FinishedAt:    time.Now(),
+				Labels:        tester.Labels{"team": "core"},
+				Count:         2,
+				Variant:       "race",
+				Priority:      1,
+				AlertChannels: []string{"C123"},
+				Tests: []*tester.Test{
+					failedTest("TestA"),
+					failedTest("TestA"),
+					failedTest("TestB/sub.case"),
+					{ID: uuid.New(), Result: &tester.T{TB: tester.TB{Name: "TestC", State: tester.TBStatePassed}}},
+				},
+			}
+			mockDB.EXPECT().GetRun(gomock.Any(), gomock.Eq(run.ID)).Return(run, nil)
+
+			var enqueued *tester.Run
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, r *tester.Run) error {
+				enqueued = r
+				return nil
+			})
+
+			status, respRun := rerun(t, ts, run.ID)
+			assert.Equal(t, http.StatusCreated, status)
+			require.NotNil(t, enqueued)
+			assert.Equal(t, enqueued.ID, respRun.ID)
+			assert.Assert(t, enqueued.ID != run.ID)
+			assert.Equal(t, "pkg", enqueued.Package)
+			assert.DeepEqual(t, []string{"-test.v", "-test.timeout=1m", `-test.run=^(TestA|TestB/sub\.case)$`}, enqueued.Args)
+			assert.DeepEqual(t, run.Labels, enqueued.Labels)
+			assert.Equal(t, 2, enqueued.Count)
+			assert.Equal(t, "race", enqueued.Variant)
+			assert.Equal(t, 1, enqueued.Priority)
+			assert.Equal(t, "rerun:"+run.ID.String(), enqueued.EnqueuedBy)
+			assert.DeepEqual(t, []string{"C123"}, enqueued.AlertChannels)
+		})
+	})
+}
+
+func TestListRunSummaries(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, "/api/summaries?begin=0&end=3600&window=60", nil)
+	})
+
+	t.Run("invalid params", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			for _, query := range []string{
+				"end=3600&window=60",
+				"begin=0&window=60",
+				"begin=3600&end=0&window=60",
+				"begin=0&end=3600",
+				"begin=0&end=3600&window=0",
+				"begin=0&end=3600&window=1",
+			} {
+				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/summaries?%s", ts.URL, query), nil)
+				require.NoError(t, err)
+
+				addAuth(req)
+
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				resp.Body.Close()
+
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+			}
+		})
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			begin := time.Unix(1577836800, 0)
+			summaries := []*tester.RunSummary{{
+				Time:     begin.UTC(),
+				Duration: time.Hour,
+				PackageSummary: map[string]*tester.PackageSummary{
+					"pkg": {Package: "pkg", RunIDs: []uuid.UUID{uuid.New()}},
+				},
+			}}
+			mockDB.EXPECT().ListRunSummariesInRange(gomock.Any(), begin, begin.Add(time.Hour), time.Hour).Return(summaries, nil)
+
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/summaries?begin=%d&end=%d&window=3600", ts.URL, begin.Unix(), begin.Add(time.Hour).Unix()), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var respSummaries []*tester.RunSummary
+			err = json.NewDecoder(resp.Body).Decode(&respSummaries)
+			require.NoError(t, err)
+			assert.DeepEqual(t, summaries, respSummaries)
+		})
+	})
+}
+
+func TestListPackages(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, "/api/packages", nil)
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{
+				{
+					Name:      "b",
+					Path:      "testdata/b",
+					SHA256Sum: "b-sum",
+				},
+				{
+					Name:      "a",
+					Path:      "testdata/a",
+					SHA256Sum: "a-sum",
+					RunDelay:  5,
+				},
+				{
+					Name: "c",
+					Variants: []tester.Variant{
+						{Name: "go1", Path: "testdata/c-go1", SHA256Sum: "c-go1-sum"},
+						{Name: "go2", Path: "testdata/c-go2", SHA256Sum: "c-go2-sum"},
+					},
+				},
+			})
+
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Assert(t, !bytes.Contains(body, []byte("path")), "response leaks package path: %s", body)
+
+			var respPackages []PackageResponse
+			err = json.Unmarshal(body, &respPackages)
+			require.NoError(t, err)
+			assert.DeepEqual(t, []PackageResponse{
+				{Package: tester.Package{Name: "a", SHA256Sum: "a-sum", RunDelay: 5}},
+				{Package: tester.Package{Name: "b", SHA256Sum: "b-sum"}},
+				{Package: tester.Package{Name: "c", Variants: []tester.Variant{
+					{Name: "go1", SHA256Sum: "c-go1-sum"},
+					{Name: "go2", SHA256Sum: "c-go2-sum"},
+				}}},
+			}, respPackages)
+		})
+	})
+}
+
+func TestGetPackage(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, "/api/packages/pkg", nil)
+	})
+
+	t.Run("package not found", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			pkg := &tester.Package{
+				Name:      "pkg",
+				Path:      "testdata/fake_test_bin",
+				SHA256Sum: "b5d54c39e66671c9731b9f471e585d8262cd4f54963f0c93082d8dcf334d4c78",
+				RunDelay:  5,
+				Options: []tester.Option{{
+					Name:        "timeout",
+					Description: "timeout",
+					Default:     "5m",
+				}},
+			}
+
+			api.packages.Reload([]*tester.Package{
+				pkg,
+			})
+
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/%s", ts.URL, pkg.Name), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			var respFields map[string]interface{}
+			err = json.Unmarshal(body, &respFields)
+			require.NoError(t, err)
+			_, hasPath := respFields["path"]
+			assert.Assert(t, !hasPath, "response leaks package path: %s", body)
+
+			var respPackage PackageResponse
+			err = json.Unmarshal(body, &respPackage)
+			require.NoError(t, err)
+			assert.DeepEqual(t, newPackageResponse(pkg), &respPackage)
+		})
+	})
+}
+
+func TestGetPackage_environment(t *testing.T) {
+	const runnerKey = "runner-key"
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := NewAPIHandler(db.NewMockDB(ctrl), []*tester.Package{{
+		Name: "pkg",
+		Environment: []tester.EnvVar{
+			{Name: "DB_URL", Value: "postgres://db"},
+			{Name: "TOKEN", Value: "secret", Secret: true},
+		},
+	}}, WithAPIKey(testKey), WithRunnerKeys([]string{runnerKey}))
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	redacted := []tester.EnvVar{{Name: "DB_URL", Value: "postgres://db"}, {Name: "TOKEN", Secret: true, Redacted: true}}
+	for _, tc := range []struct {
+		name     string
+		key      string
+		runnerID string
+		expected []tester.EnvVar
+	}{
+		{
+			name:     "redacted",
+			key:      testKey,
+			expected: redacted,
+		},
+		{
+			// Any client can claim to be a runner by setting the header.
+			name:     "runner id without runner key",
+			key:      testKey,
+			runnerID: uuid.New().String(),
+			expected: redacted,
+		},
+		{
+			name:     "runner key",
+			key:      runnerKey,
+			runnerID: uuid.New().String(),
+			expected: []tester.EnvVar{{Name: "DB_URL", Value: "postgres://db"}, {Name: "TOKEN", Value: "secret", Secret: true}},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg", ts.URL), nil)
+			require.NoError(t, err)
+			req.SetBasicAuth(testUserAgent, tc.key)
+			if tc.runnerID != "" {
+				req.Header.Set(RunnerIDHeader, tc.runnerID)
+			}
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var respPackage PackageResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&respPackage))
+			assert.DeepEqual(t, tc.expected, respPackage.Environment)
+		})
+	}
+}
+
+func TestGetPackageStats(t *testing.T) {
+	var (
+		from = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		to   = from.Add(24 * time.Hour)
+	)
+
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, "/api/packages/pkg/stats", nil)
+	})
+
+	t.Run("package not found", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg/stats", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	for _, tc := range []struct {
+		name  string
+		query string
+	}{
+		{name: "missing range", query: ""},
+		{name: "invalid from", query: fmt.Sprintf("from=invalid&to=%s", to.Format(time.RFC3339))},
+		{name: "invalid to", query: fmt.Sprintf("from=%s&to=invalid", from.Format(time.RFC3339))},
+		{name: "from after to", query: fmt.Sprintf("from=%s&to=%s", to.Format(time.RFC3339), from.Format(time.RFC3339))},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+				api.packages.Reload([]*tester.Package{{Name: "pkg"}})
+
+				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg/stats?%s", ts.URL, tc.query), nil)
+				require.NoError(t, err)
+
+				addAuth(req)
+
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			})
+		})
+	}
+
+	t.Run("happy path", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{{Name: "pkg"}})
+
+			failedRunID := uuid.New()
+			mockDB.EXPECT().ListRunSummariesInRange(gomock.Any(), from, to, 24*time.Hour).Return([]*tester.RunSummary{{
+				Time:     from,
+				Duration: 24 * time.Hour,
+				PackageSummary: map[string]*tester.PackageSummary{
+					"pkg": {
+						Package:      "pkg",
+						RunIDs:       []uuid.UUID{uuid.New(), failedRunID},
+						ErrorRunIDs:  []uuid.UUID{uuid.New()},
+						FailedRunIDs: []uuid.UUID{failedRunID},
+						PassedTests:  map[string][]uuid.UUID{"TestA": {uuid.New(), uuid.New()}},
+						FailedTests:  map[string][]uuid.UUID{"TestB": {uuid.New()}},
+						SkippedTests: map[string][]uuid.UUID{"TestC": {uuid.New()}},
+					},
+					"other": {
+						Package: "other",
+						RunIDs:  []uuid.UUID{uuid.New()},
+					},
+				},
+			}}, nil)
+
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg/stats?from=%s&to=%s", ts.URL, from.Format(time.RFC3339), to.Format(time.RFC3339)), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var respStats PackageStatsResponse
+			err = json.NewDecoder(resp.Body).Decode(&respStats)
+			require.NoError(t, err)
+			assert.DeepEqual(t, PackageStatsResponse{
+				Package:      "pkg",
+				From:         from,
+				To:           to,
+				Runs:         2,
+				ErrorRuns:    1,
+				FailedRuns:   1,
+				PassedTests:  2,
+				FailedTests:  1,
+				SkippedTests: 1,
+				PassRate:     0.5,
+			}, respStats)
+		})
+	})
+}
+
+func TestVerifyPackage(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, "/api/packages/pkg/verify", nil)
+	})
+
+	t.Run("package not found", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg/verify", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	fakeTestBinPath := fmt.Sprintf("%s/fake_test_bin", t.TempDir())
+	err := ioutil.WriteFile(fakeTestBinPath, []byte("fake"), 0644)
+	require.NoError(t, err)
+	fakeTestBinSHA256Sum := fmt.Sprintf("%x", sha256.Sum256([]byte("fake")))
+
+	for _, tc := range []struct {
+		name      string
+		sha256Sum string
+		valid     bool
+	}{
+		{name: "match", sha256Sum: fakeTestBinSHA256Sum, valid: true},
+		{name: "mismatch", sha256Sum: "invalid", valid: false},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+				api.packages.Reload([]*tester.Package{
+					{
+						Name:      "pkg",
+						Path:      fakeTestBinPath,
+						SHA256Sum: tc.sha256Sum,
+					},
+				})
+
+				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg/verify", ts.URL), nil)
+				require.NoError(t, err)
+
+				addAuth(req)
+
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+				var respVerify VerifyPackageResponse
+				err = json.NewDecoder(resp.Body).Decode(&respVerify)
+				require.NoError(t, err)
+				assert.DeepEqual(t, VerifyPackageResponse{
+					Package:  "pkg",
+					Expected: tc.sha256Sum,
+					Actual:   fakeTestBinSHA256Sum,
+					Valid:    tc.valid,
+				}, respVerify)
+			})
+		})
+	}
+}
+
+func TestEnablePackage(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodPut, "/api/packages/pkg/enable", nil)
+		assertAPIAuth(t, http.MethodPut, "/api/packages/pkg/disable", nil)
+	})
+
+	t.Run("package not found", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/packages/pkg/enable", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("package not stored", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{{Name: "pkg"}})
+			mockDB.EXPECT().GetPackage(gomock.Any(), "pkg").Return(nil, db.ErrNotFound)
+
+			req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/packages/pkg/disable", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			pkg := &tester.Package{Name: "pkg", SHA256Sum: "sum"}
+			api.packages.Reload([]*tester.Package{
+				pkg,
+			})
+			assert.Assert(t, pkg.IsEnabled())
+
+			for _, action := range []string{"disable", "enable"} {
+				// The stored definition is updated, leaving the sha256 sum
+				// computed for the served package out of it.
+				mockDB.EXPECT().GetPackage(gomock.Any(), "pkg").Return(&tester.Package{Name: "pkg"}, nil)
+				enabled := action == "enable"
+				mockDB.EXPECT().UpdatePackage(gomock.Any(), &tester.Package{Name: "pkg", Enabled: &enabled}).Return(nil)
+
+				req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/packages/%s/%s", ts.URL, pkg.Name, action), nil)
+				require.NoError(t, err)
+
+				addAuth(req)
+
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+				var respPackage tester.Package
+				err = json.NewDecoder(resp.Body).Decode(&respPackage)
+				require.NoError(t, err)
+				served, ok := api.packages.Get("pkg")
+				assert.Assert(t, ok)
+				assert.Equal(t, action == "enable", served.IsEnabled())
+				assert.Equal(t, "sum", served.SHA256Sum)
+				assert.Equal(t, action == "enable", respPackage.IsEnabled())
+			}
+		})
+	})
+}
+
+func TestSetPackageEnv(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodPost, "/api/packages/pkg/env", nil)
+	})
+
+	post := func(t *testing.T, ts *httptest.Server, path string, envVars []tester.EnvVar) (int, *PackageResponse) {
+		body, err := json.Marshal(envVars)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, ts.URL+path, bytes.NewReader(body))
+		require.NoError(t, err)
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var respPackage PackageResponse
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&respPackage))
+		}
+		return resp.StatusCode, &respPackage
+	}
+
+	stored := func() *tester.Package {
+		return &tester.Package{
+			Name: "pkg",
+			Environment: []tester.EnvVar{
+				{Name: "DB_URL", Value: "postgres://db"},
+				{Name: "TOKEN", Value: "secret", Secret: true},
+			},
+		}
+	}
+
+	t.Run("package not found", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			status, _ := post(t, ts, "/api/packages/pkg/env", []tester.EnvVar{{Name: "A", Value: "a"}})
+			assert.Equal(t, http.StatusNotFound, status)
+		})
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{stored()})
+
+			for _, envVars := range [][]tester.EnvVar{
+				{{Name: ""}},
+				{{Name: "A=B"}},
+				{{Name: "A", Value: "a", Secret: true}},
+			} {
+				status, _ := post(t, ts, "/api/packages/pkg/env", envVars)
+				assert.Equal(t, http.StatusBadRequest, status, envVars)
+			}
+
+			// Secrets can't be replaced with non-secret values.
+			mockDB.EXPECT().GetPackage(gomock.Any(), "pkg").Return(stored(), nil)
+			status, _ := post(t, ts, "/api/packages/pkg/env", []tester.EnvVar{{Name: "TOKEN", Value: "leaked"}})
+			assert.Equal(t, http.StatusBadRequest, status)
+		})
+	})
+
+	t.Run("happy path", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{stored()})
+
+			expected := []tester.EnvVar{
+				{Name: "DB_URL", Value: "postgres://other"},
+				{Name: "TOKEN", Value: "secret", Secret: true},
+				{Name: "FEATURE", Value: "on"},
+			}
+			mockDB.EXPECT().GetPackage(gomock.Any(), "pkg").Return(stored(), nil)
+			mockDB.EXPECT().UpdatePackage(gomock.Any(), &tester.Package{Name: "pkg", Environment: expected}).Return(nil)
+
+			status, respPackage := post(t, ts, "/api/packages/pkg/env", []tester.EnvVar{
+				{Name: "DB_URL", Value: "postgres://other"},
+				{Name: "FEATURE", Value: "on"},
+			})
+			assert.Equal(t, http.StatusOK, status)
+			assert.DeepEqual(t, []tester.EnvVar{
+				{Name: "DB_URL", Value: "postgres://other"},
+				{Name: "TOKEN", Secret: true, Redacted: true},
+				{Name: "FEATURE", Value: "on"},
+			}, respPackage.Environment)
+
+			served, ok := api.packages.Get("pkg")
+			assert.Assert(t, ok)
+			assert.DeepEqual(t, expected, served.Environment)
+		})
+	})
+}
+
+func TestPackageCRUD(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodPost, "/api/packages", nil)
+		assertAPIAuth(t, http.MethodPut, "/api/packages/pkg", nil)
+		assertAPIAuth(t, http.MethodDelete, "/api/packages/pkg", nil)
+	})
+
+	do := func(t *testing.T, ts *httptest.Server, method, path string, body interface{}) (int, *PackageResponse) {
+		var reqBody io.Reader
+		if body != nil {
+			data, err := json.Marshal(body)
+			require.NoError(t, err)
+			reqBody = bytes.NewReader(data)
+		}
+		req, err := http.NewRequest(method, fmt.Sprintf("%s%s", ts.URL, path), reqBody)
+		require.NoError(t, err)
+
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+			return resp.StatusCode, nil
+		}
+		var pkg PackageResponse
+		if err := json.NewDecoder(resp.Body).Decode(&pkg); err != nil {
+			return resp.StatusCode, nil
+		}
+		return resp.StatusCode, &pkg
+	}
+
+	t.Run("create", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			def := &tester.Package{Name: "pkg", Path: "/bins/pkg", Labels: tester.Labels{"team": "core"}}
+			mockDB.EXPECT().AddPackage(gomock.Any(), def).Return(nil)
+
+			status, resp := do(t, ts, http.MethodPost, "/api/packages", def)
+			assert.Equal(t, http.StatusCreated, status)
+			assert.Equal(t, "pkg", resp.Name)
+
+			pkg, ok := api.packages.Get("pkg")
+			assert.Assert(t, ok)
+			assert.DeepEqual(t, def, pkg)
+		})
+	})
+
+	t.Run("create existing", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			mockDB.EXPECT().AddPackage(gomock.Any(), gomock.Any()).Return(db.ErrAlreadyExists)
+
+			status, _ := do(t, ts, http.MethodPost, "/api/packages", &tester.Package{Name: "pkg"})
+			assert.Equal(t, http.StatusConflict, status)
+		})
+	})
+
+	t.Run("create invalid", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			status, _ := do(t, ts, http.MethodPost, "/api/packages", &tester.Package{Path: "/bins/pkg"})
+			assert.Equal(t, http.StatusBadRequest, status)
+
+			status, _ = do(t, ts, http.MethodPost, "/api/packages", nil)
+			assert.Equal(t, http.StatusBadRequest, status)
+		})
+	})
+
+	t.Run("update", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{{Name: "pkg", Path: "/bins/pkg"}})
+			def := &tester.Package{Name: "pkg", Path: "/bins/pkg", Count: 3}
+			mockDB.EXPECT().UpdatePackage(gomock.Any(), def).Return(nil)
+
+			// The name defaults to the one in the path.
+			status, resp := do(t, ts, http.MethodPut, "/api/packages/pkg", &tester.Package{Path: "/bins/pkg", Count: 3})
+			assert.Equal(t, http.StatusOK, status)
+			assert.Equal(t, 3, resp.Count)
+
+			pkg, ok := api.packages.Get("pkg")
+			assert.Assert(t, ok)
+			assert.Equal(t, 3, pkg.Count)
+		})
+	})
+
+	t.Run("update mismatched name", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			status, _ := do(t, ts, http.MethodPut, "/api/packages/pkg", &tester.Package{Name: "other"})
+			assert.Equal(t, http.StatusBadRequest, status)
+		})
+	})
+
+	t.Run("update unknown", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			mockDB.EXPECT().UpdatePackage(gomock.Any(), gomock.Any()).Return(db.ErrNotFound)
+
+			status, _ := do(t, ts, http.MethodPut, "/api/packages/pkg", &tester.Package{Name: "pkg"})
+			assert.Equal(t, http.StatusNotFound, status)
+		})
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{{Name: "pkg"}, {Name: "other"}})
+			mockDB.EXPECT().DeletePackage(gomock.Any(), "pkg").Return(nil)
+
+			status, _ := do(t, ts, http.MethodDelete, "/api/packages/pkg", nil)
+			assert.Equal(t, http.StatusOK, status)
+
+			pkg, ok := api.packages.Get("pkg")
+			assert.Assert(t, ok)
+			assert.Assert(t, !pkg.IsEnabled())
+			pkg, ok = api.packages.Get("other")
+			assert.Assert(t, ok)
+			assert.Assert(t, pkg.IsEnabled())
+
+			mockDB.EXPECT().DeletePackage(gomock.Any(), "pkg").Return(db.ErrNotFound)
+			status, _ = do(t, ts, http.MethodDelete, "/api/packages/pkg", nil)
+			assert.Equal(t, http.StatusNotFound, status)
+		})
+	})
+
+	t.Run("loader and changed hook", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := db.NewMockDB(ctrl)
+		var changed int
+		api := NewAPIHandler(mockDB, nil, WithAPIKey(testKey),
+			WithPackageLoader(func(pkg *tester.Package) (*tester.Package, error) {
+				if pkg.Path == "" {
+					return nil, errors.New("missing path")
+				}
+				pkg.SHA256Sum = "computed"
+				return pkg, nil
+			}),
+			WithPackagesChanged(func() { changed++ }),
+		)
+		ts := httptest.NewServer(api)
+		defer ts.Close()
+
+		status, _ := do(t, ts, http.MethodPost, "/api/packages", &tester.Package{Name: "pkg"})
+		assert.Equal(t, http.StatusBadRequest, status)
+		assert.Equal(t, 0, changed)
+
+		// The stored definition doesn't include what the loader computed.
+		mockDB.EXPECT().AddPackage(gomock.Any(), &tester.Package{Name: "pkg", Path: "/bins/pkg"}).Return(nil)
+		status, resp := do(t, ts, http.MethodPost, "/api/packages", &tester.Package{Name: "pkg", Path: "/bins/pkg"})
+		assert.Equal(t, http.StatusCreated, status)
+		assert.Equal(t, "computed", resp.SHA256Sum)
+		assert.Equal(t, 1, changed)
+	})
+}
+
+func TestGetPackageHistory(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, "/api/packages/pkg/history", nil)
+	})
+
+	t.Run("package not found", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg/history", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	for _, tc := range []struct {
+		name  string
+		query string
+	}{
+		{name: "invalid window", query: "window=soon"},
+		{name: "negative window", query: "window=-1h"},
+		{name: "invalid range", query: "range=7x"},
+		{name: "invalid range days", query: "range=sevend"},
+		{name: "too many windows", query: "window=1m&range=30d"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+				api.packages.Reload([]*tester.Package{{Name: "pkg"}})
+
+				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg/history?%s", ts.URL, tc.query), nil)
+				require.NoError(t, err)
+
+				addAuth(req)
+
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			})
+		})
+	}
+
+	for _, tc := range []struct {
+		name   string
+		query  string
+		window time.Duration
+		rng    time.Duration
+	}{
+		{name: "defaults", window: time.Hour, rng: 7 * 24 * time.Hour},
+		{name: "window and range", query: "window=6h&range=2d", window: 6 * time.Hour, rng: 48 * time.Hour},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+				api.packages.Reload([]*tester.Package{{Name: "pkg"}})
+
+				begin := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+				history := []*tester.PackageHistory{
+					{Time: begin, PassRate: 0.75, TotalRuns: 4, FailedRuns: 1},
+					{Time: begin.Add(tc.window)},
+				}
+				mockDB.EXPECT().
+					GetPackagePassRateHistory(gomock.Any(), "pkg", gomock.Any(), gomock.Any(), tc.window).
+					DoAndReturn(func(_ context.Context, _ string, b, e time.Time, _ time.Duration) ([]*tester.PackageHistory, error) {
+						assert.Equal(t, tc.rng, e.Sub(b))
+						return history, nil
+					})
+
+				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg/history?%s", ts.URL, tc.query), nil)
+				require.NoError(t, err)
+
+				addAuth(req)
+
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+				var fields []map[string]interface{}
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&fields))
+				assert.DeepEqual(t, []map[string]interface{}{
+					{"time": "2020-01-01T00:00:00Z", "pass_rate": 0.75, "total_runs": float64(4), "failed_runs": float64(1)},
+					{"time": begin.Add(tc.window).Format(time.RFC3339), "pass_rate": float64(0), "total_runs": float64(0), "failed_runs": float64(0)},
+				}, fields)
+			})
+		})
+	}
+}
+
+func TestListSlowTests(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, "/api/packages/pkg/slow", nil)
+	})
+
+	t.Run("package not found", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg/slow", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	for _, tc := range []struct {
+		name  string
+		query string
+	}{
+		{name: "invalid window", query: "window=soon"},
+		{name: "window too large", query: "window=60d"},
+		{name: "invalid limit", query: "limit=0"},
+		{name: "limit too large", query: "limit=1000"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+				api.packages.Reload([]*tester.Package{{Name: "pkg"}})
+
+				req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg/slow?%s", ts.URL, tc.query), nil)
+				require.NoError(t, err)
+
+				addAuth(req)
+
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			})
+		})
+	}
+
+	t.Run("slow tests", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{{Name: "pkg"}})
+
+			var begin time.Time
+			newTest := func(name string, inWindow bool, d time.Duration) *tester.Test {
+				startedAt := begin.Add(time.Hour)
+				if !inWindow {
+					startedAt = begin.Add(-time.Hour)
+				}
+				return &tester.Test{
+					ID:      uuid.New(),
+					Package: "pkg",
+					Result: &tester.T{TB: tester.TB{
+						Name:       name,
+						StartedAt:  startedAt,
+						FinishedAt: startedAt.Add(d),
+						State:      tester.TBStatePassed,
+					}},
+				}
+			}
+			mockDB.EXPECT().
+				ListTestsForPackageInRange(gomock.Any(), "pkg", gomock.Any(), gomock.Any()).
+				DoAndReturn(func(_ context.Context, _ string, b, e time.Time) ([]*tester.Test, error) {
+					assert.Equal(t, 2*24*time.Hour, e.Sub(b), "range should cover the window and the prior window")
+					begin = e.Add(-24 * time.Hour)
+					return []*tester.Test{
+						newTest("TestFast", true, time.Second),
+						newTest("TestSlow", true, time.Minute),
+						newTest("TestSlow", false, 20*time.Second),
+						newTest("TestMedium", true, 10*time.Second),
+					}, nil
+				})
+
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/pkg/slow?window=1d&limit=2", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var slow SlowTestsResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&slow))
+			assert.Equal(t, "pkg", slow.Package)
+			assert.Equal(t, 24*time.Hour, slow.To.Sub(slow.From))
+			regressed := &SlowTestResponse{
+				Name:        "TestSlow",
+				Count:       1,
+				Median:      "1m0s",
+				P95:         "1m0s",
+				Max:         "1m0s",
+				PriorCount:  1,
+				PriorMedian: "20s",
+				Regressed:   true,
+			}
+			assert.DeepEqual(t, []*SlowTestResponse{
+				regressed,
+				{Name: "TestMedium", Count: 1, Median: "10s", P95: "10s", Max: "10s"},
+			}, slow.Tests)
+			assert.DeepEqual(t, []*SlowTestResponse{regressed}, slow.Regressions)
+		})
+	})
+}
+
+func TestAPIHandler_logsRequestID(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var logs bytes.Buffer
+	mockDB := db.NewMockDB(ctrl)
+	api := NewAPIHandler(mockDB, []*tester.Package{{Name: "pkg"}}, WithAPIKey(testKey), WithLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+	mockDB.EXPECT().Quarantine(gomock.Any(), "pkg", "TestA").Return(nil)
+
+	req := httptest.NewRequest(http.MethodPut, "/api/quarantine/pkg/TestA", nil)
+	req.Header.Set(RequestIDHeader, "request-id")
+	addAuth(req)
+	resp := httptest.NewRecorder()
+	api.ServeHTTP(resp, req)
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "request-id", resp.Header().Get(RequestIDHeader))
+
+	var logged []string
+	decoder := json.NewDecoder(&logs)
+	for decoder.More() {
+		var entry struct {
+			Msg       string `json:"msg"`
+			RequestID string `json:"request_id"`
+		}
+		require.NoError(t, decoder.Decode(&entry))
+		assert.Equal(t, "request-id", entry.RequestID, entry.Msg)
+		logged = append(logged, entry.Msg)
+	}
+	assert.DeepEqual(t, []string{"quarantined test", "handled request"}, logged)
+}
+
+func TestQuarantine(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, "/api/quarantine", nil)
+		assertAPIAuth(t, http.MethodPut, "/api/quarantine/pkg/TestA", nil)
+		assertAPIAuth(t, http.MethodDelete, "/api/quarantine/pkg/TestA", nil)
+	})
+
+	t.Run("list", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			quarantined := []*tester.QuarantinedTest{
+				{Package: "pkg", Name: "TestA", QuarantinedAt: time.Now().UTC().Truncate(time.Second)},
+			}
+			mockDB.EXPECT().ListQuarantined(gomock.Any()).Return(quarantined, nil)
+
+			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/quarantine", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			var respQuarantined []*tester.QuarantinedTest
+			err = json.NewDecoder(resp.Body).Decode(&respQuarantined)
+			require.NoError(t, err)
+			assert.DeepEqual(t, quarantined, respQuarantined)
+		})
+	})
+
+	t.Run("quarantine package not found", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/quarantine/pkg/TestA", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			api.packages.Reload([]*tester.Package{
+				{Name: "pkg"},
+			})
+			mockDB.EXPECT().Quarantine(gomock.Any(), "pkg", "TestA/sub").Return(nil)
+
+			req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("%s/api/quarantine/pkg/TestA/sub", ts.URL), nil)
+			require.NoError(t, err)
+
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	})
+
+	t.Run("unquarantine", func(t *testing.T) {
+		for _, tc := range []struct {
+			name           string
+			err            error
+			expectedStatus int
+		}{
+			{name: "happy path", expectedStatus: http.StatusOK},
+			{name: "not quarantined", err: db.ErrNotFound, expectedStatus: http.StatusNotFound},
+			{name: "db error", err: errors.New("boom"), expectedStatus: http.StatusInternalServerError},
+		} {
+			tc := tc
+			t.Run(tc.name, func(t *testing.T) {
+				withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+					mockDB.EXPECT().Unquarantine(gomock.Any(), "pkg", "TestA").Return(tc.err)
+
+					req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("%s/api/quarantine/pkg/TestA", ts.URL), nil)
+					require.NoError(t, err)
+
+					addAuth(req)
+
+					resp, err := ts.Client().Do(req)
+					require.NoError(t, err)
+					defer resp.Body.Close()
+
+					assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+				})
+			})
+		}
+	})
+}
+
+func TestDownloadPackage(t *testing.T) {
 	t.Run("api auth", func(t *testing.T) {
 		assertAPIAuth(t, http.MethodGet, "/api/packages/pkg/download", nil)
 	})
@@ -628,9 +2891,9 @@ func TestDownloadPackage(t *testing.T) {
 				}},
 			}
 
-			api.packages = map[string]*tester.Package{
-				"pkg": pkg,
-			}
+			api.packages.Reload([]*tester.Package{
+				pkg,
+			})
 
 			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/%s/download", ts.URL, pkg.Name), nil)
 			require.NoError(t, err)
@@ -657,6 +2920,455 @@ func TestDownloadPackage(t *testing.T) {
 
 			assert.DeepEqual(t, fBytes, respBuf.Bytes())
 			assert.DeepEqual(t, fakeTestBinSHA256Sum, fmt.Sprintf("%x", hash.Sum(nil)))
+			assert.Equal(t, fmt.Sprintf("%q", fakeTestBinSHA256Sum), resp.Header.Get("ETag"))
+		})
+	})
+
+	t.Run("conditional", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			fakeTestBinPath := fmt.Sprintf("%s/fake_test_bin", t.TempDir())
+			require.NoError(t, ioutil.WriteFile(fakeTestBinPath, []byte("fake"), 0755))
+			pkg := &tester.Package{
+				Name: "pkg",
+				Path: fakeTestBinPath,
+			}
+			pkg.SHA256Sum, _ = pkg.ComputeSHA256Sum()
+			api.packages.Reload([]*tester.Package{pkg})
+
+			for _, tc := range []struct {
+				name        string
+				ifNoneMatch string
+				status      int
+			}{
+				{name: "matching etag", ifNoneMatch: fmt.Sprintf("%q", pkg.SHA256Sum), status: http.StatusNotModified},
+				{name: "stale etag", ifNoneMatch: `"stale"`, status: http.StatusOK},
+			} {
+				t.Run(tc.name, func(t *testing.T) {
+					req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/%s/download", ts.URL, pkg.Name), nil)
+					require.NoError(t, err)
+					req.Header.Set("If-None-Match", tc.ifNoneMatch)
+
+					addAuth(req)
+
+					resp, err := ts.Client().Do(req)
+					require.NoError(t, err)
+					defer resp.Body.Close()
+
+					assert.Equal(t, tc.status, resp.StatusCode)
+					assert.Equal(t, fmt.Sprintf("%q", pkg.SHA256Sum), resp.Header.Get("ETag"))
+				})
+			}
+		})
+	})
+
+	t.Run("variants", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			pkg := &tester.Package{Name: "pkg"}
+			for _, name := range []string{"go1", "go2"} {
+				path := fmt.Sprintf("%s/%s.test", t.TempDir(), name)
+				require.NoError(t, ioutil.WriteFile(path, []byte(name), 0755))
+				pkg.Variants = append(pkg.Variants, tester.Variant{Name: name, Path: path})
+			}
+			api.packages.Reload([]*tester.Package{pkg})
+
+			for _, tc := range []struct {
+				variant string
+				status  int
+				body    string
+			}{
+				{variant: "go1", status: http.StatusOK, body: "go1"},
+				{variant: "go2", status: http.StatusOK, body: "go2"},
+				{variant: "go3", status: http.StatusNotFound},
+				{variant: "", status: http.StatusNotFound},
+			} {
+				t.Run(tc.variant, func(t *testing.T) {
+					req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/api/packages/%s/download?variant=%s", ts.URL, pkg.Name, tc.variant), nil)
+					require.NoError(t, err)
+					addAuth(req)
+
+					resp, err := ts.Client().Do(req)
+					require.NoError(t, err)
+					defer resp.Body.Close()
+
+					assert.Equal(t, tc.status, resp.StatusCode)
+					if tc.status == http.StatusOK {
+						body, err := ioutil.ReadAll(resp.Body)
+						require.NoError(t, err)
+						assert.Equal(t, tc.body, string(body))
+					}
+				})
+			}
+		})
+	})
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodPost, "/api/auth/rotate", nil)
+	})
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := NewAPIHandler(db.NewMockDB(ctrl), nil, WithAPIKeys([]string{"old", "new"}), WithRunnerKeys([]string{"runner"}))
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	do := func(t *testing.T, method, path, key string) int {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth(testUserAgent, key)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, "/api/packages", "old"))
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, "/api/packages", "new"))
+	assert.Equal(t, http.StatusUnauthorized, do(t, http.MethodGet, "/api/packages", "invalid"))
+	assert.Equal(t, http.StatusUnauthorized, do(t, http.MethodPost, "/api/auth/rotate", "invalid"))
+
+	// Only the newest key can rotate out the oldest.
+	assert.Equal(t, http.StatusForbidden, do(t, http.MethodPost, "/api/auth/rotate", "old"))
+	assert.Equal(t, http.StatusForbidden, do(t, http.MethodPost, "/api/auth/rotate", "runner"))
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, "/api/packages", "old"))
+
+	assert.Equal(t, http.StatusOK, do(t, http.MethodPost, "/api/auth/rotate", "new"))
+	assert.Equal(t, http.StatusUnauthorized, do(t, http.MethodGet, "/api/packages", "old"))
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, "/api/packages", "new"))
+
+	assert.Equal(t, http.StatusConflict, do(t, http.MethodPost, "/api/auth/rotate", "new"))
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, "/api/packages", "new"))
+}
+
+func TestRunnerKeyAuth(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := db.NewMockDB(ctrl)
+	api := NewAPIHandler(mockDB, []*tester.Package{{Name: "pkg"}}, WithAPIKey(testKey), WithRunnerKeys([]string{"runner"}))
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	do := func(t *testing.T, method, path, key string) int {
+		req, err := http.NewRequest(method, ts.URL+path, nil)
+		require.NoError(t, err)
+		req.SetBasicAuth(testUserAgent, key)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// Runner keys are accepted for the routes runners use.
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, "/api/packages/pkg", "runner"))
+	mockDB.EXPECT().GetRunMetadata(gomock.Any(), gomock.Any()).Return(nil, db.ErrNotFound)
+	assert.Equal(t, http.StatusInternalServerError, do(t, http.MethodPost, fmt.Sprintf("/api/runs/%s/complete", uuid.New()), "runner"))
+
+	// But not for managing packages or api keys.
+	for _, route := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/api/packages"},
+		{http.MethodPost, "/api/packages"},
+		{http.MethodPut, "/api/packages/pkg"},
+		{http.MethodDelete, "/api/packages/pkg"},
+		{http.MethodPut, "/api/packages/pkg/disable"},
+		{http.MethodPost, "/api/packages/pkg/env"},
+		{http.MethodPost, "/api/auth/rotate"},
+	} {
+		assert.Equal(t, http.StatusForbidden, do(t, route.method, route.path, "runner"), "%s %s", route.method, route.path)
+	}
+	assert.Equal(t, http.StatusOK, do(t, http.MethodGet, "/api/packages", testKey))
+}
+
+func TestRequestBodyLogging(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	// Only what is logged matters, not how the requests are handled.
+	mockDB := db.NewMockDB(ctrl)
+	mockDB.EXPECT().AddPackage(gomock.Any(), gomock.Any()).Return(nil).AnyTimes()
+	mockDB.EXPECT().GetPackage(gomock.Any(), gomock.Any()).Return(nil, db.ErrNotFound).AnyTimes()
+	api := NewAPIHandler(mockDB, []*tester.Package{{Name: "pkg"}}, WithAPIKey(testKey), WithRequestBodyLogging(1<<10), WithLogger(logger))
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	do := func(t *testing.T, method, path string, body interface{}) {
+		reqBody, err := json.Marshal(body)
+		require.NoError(t, err)
+		req, err := http.NewRequest(method, ts.URL+path, bytes.NewReader(reqBody))
+		require.NoError(t, err)
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	// Package definitions include the values of secrets, so their bodies
+	// aren't logged.
+	pkg := &tester.Package{
+		Name:        "other",
+		Path:        "/missing.test",
+		Environment: []tester.EnvVar{{Name: "TOKEN", Value: "hunter2", Secret: true}},
+	}
+	do(t, http.MethodPost, "/api/packages", pkg)
+	do(t, http.MethodPut, "/api/packages/pkg", pkg)
+	do(t, http.MethodPost, "/api/packages/pkg/env", pkg.Environment)
+	assert.Assert(t, !strings.Contains(logs.String(), "hunter2"), logs.String())
+	assert.Assert(t, !strings.Contains(logs.String(), `"msg":"request body"`), logs.String())
+
+	do(t, http.MethodPost, "/api/tests", &tester.Test{Package: "pkg"})
+	assert.Assert(t, strings.Contains(logs.String(), `"msg":"request body"`), logs.String())
+}
+
+func TestRunnerStatus(t *testing.T) {
+	t.Run("api auth", func(t *testing.T) {
+		assertAPIAuth(t, http.MethodGet, "/api/runner/status", nil)
+	})
+
+	t.Run("tracks runners", func(t *testing.T) {
+		withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+			now := time.Now().UTC().Round(time.Second)
+			api.runners.now = func() time.Time { return now }
+			api.packages.Reload([]*tester.Package{{Name: "pkg"}})
+
+			do := func(method, path, userAgent string, body io.Reader) *http.Response {
+				req, err := http.NewRequest(method, fmt.Sprintf("%s%s", ts.URL, path), body)
+				require.NoError(t, err)
+				addAuth(req)
+				if userAgent != "" {
+					req.Header.Set("User-Agent", userAgent)
+				}
+
+				resp, err := ts.Client().Do(req)
+				require.NoError(t, err)
+				return resp
+			}
+			status := func() []RunnerStatus {
+				resp := do(http.MethodGet, "/api/runner/status", "", nil)
+				defer resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+				var runners []RunnerStatus
+				require.NoError(t, json.NewDecoder(resp.Body).Decode(&runners))
+				return runners
+			}
+
+			assert.DeepEqual(t, []RunnerStatus{}, status())
+
+			run := &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: now}
+			mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return([]*tester.Run{run}, nil)
+			mockDB.EXPECT().StartRun(gomock.Any(), run.ID, gomock.Any()).Return(nil)
+			reqBody, err := json.Marshal(&ClaimRunRequest{})
+			require.NoError(t, err)
+			resp := do(http.MethodPost, "/api/runs/claim", "runner-a", bytes.NewBuffer(reqBody))
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			// runner-b was last seen long enough ago to be inactive.
+			api.runners.now = func() time.Time { return now.Add(-time.Hour) }
+			mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return(nil, nil)
+			resp = do(http.MethodPost, "/api/runs/claim", "runner-b", bytes.NewBuffer(reqBody))
+			resp.Body.Close()
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+			api.runners.now = func() time.Time { return now }
+
+			assert.DeepEqual(t, []RunnerStatus{
+				{Name: "runner-a", LastSeen: now, CurrentRunID: &run.ID, State: RunnerStateActive},
+				{Name: "runner-b", LastSeen: now.Add(-time.Hour), State: RunnerStateInactive},
+			}, status())
+
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), run.ID).Return(run, nil)
+			mockDB.EXPECT().CompleteRun(gomock.Any(), run.ID).Return(nil)
+			resp = do(http.MethodPost, fmt.Sprintf("/api/runs/%s/complete", run.ID), "runner-a", nil)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			// Operators managing runs are not tracked as runners.
+			mockDB.EXPECT().GetRun(gomock.Any(), run.ID).Return(run, nil)
+			resp = do(http.MethodGet, fmt.Sprintf("/api/runs/%s", run.ID), "operator", nil)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+			assert.DeepEqual(t, []RunnerStatus{
+				{Name: "runner-a", LastSeen: now, State: RunnerStateActive},
+				{Name: "runner-b", LastSeen: now.Add(-time.Hour), State: RunnerStateInactive},
+			}, status())
 		})
 	})
 }
+
+func TestAPIHandler_basePath(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := NewAPIHandler(db.NewMockDB(ctrl), []*tester.Package{{Name: "pkg"}}, WithAPIKey(testKey), WithBasePath("tester"))
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	for _, tc := range []struct {
+		path   string
+		status int
+	}{
+		{path: "/tester/api/packages/pkg", status: http.StatusOK},
+		{path: "/api/packages/pkg", status: http.StatusNotFound},
+		{path: "/tester/api/runner/status", status: http.StatusOK},
+	} {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+tc.path, nil)
+		require.NoError(t, err)
+
+		addAuth(req)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		assert.Equal(t, tc.status, resp.StatusCode, tc.path)
+	}
+
+	// Only the request under the base path is attributed to the runner, and
+	// requests for the runner status are not.
+	runners := api.runners.List()
+	assert.Equal(t, 1, len(runners))
+	assert.Equal(t, testUserAgent, runners[0].Name)
+}
+
+func TestNormalizeBasePath(t *testing.T) {
+	for path, expected := range map[string]string{
+		"":         "",
+		"/":        "",
+		"tester":   "/tester",
+		"/tester/": "/tester",
+		"/a/b":     "/a/b",
+	} {
+		assert.Equal(t, expected, NormalizeBasePath(path), path)
+	}
+}
+
+func TestAPIHandler_gzip(t *testing.T) {
+	withAPIHandler(t, func(_ *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+		binPath := fmt.Sprintf("%s/pkg.test", t.TempDir())
+		bin := bytes.Repeat([]byte{0}, 64*1024)
+		require.NoError(t, ioutil.WriteFile(binPath, bin, 0755))
+
+		var packages []*tester.Package
+		for i := 0; i < 100; i++ {
+			packages = append(packages, &tester.Package{Name: fmt.Sprintf("pkg-%d", i), Path: binPath})
+		}
+		api.UpdatePackages(packages)
+
+		ts := httptest.NewServer(GzipMiddleware(api, DefaultGzipMinSize))
+		defer ts.Close()
+
+		// Disable the transport's transparent decompression to inspect the
+		// responses as sent.
+		client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+		get := func(path string) *http.Response {
+			req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+			require.NoError(t, err)
+			addAuth(req)
+			req.Header.Set("Accept-Encoding", "gzip")
+
+			resp, err := client.Do(req)
+			require.NoError(t, err)
+			t.Cleanup(func() { resp.Body.Close() })
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			return resp
+		}
+
+		resp := get("/api/packages")
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		var respPackages []*PackageResponse
+		require.NoError(t, json.NewDecoder(gz).Decode(&respPackages))
+		assert.Equal(t, 100, len(respPackages))
+
+		// Test binaries are downloaded as is.
+		resp = get("/api/packages/pkg-0/download")
+		assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, strconv.Itoa(len(bin)), resp.Header.Get("Content-Length"))
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.DeepEqual(t, bin, data)
+	})
+}
+
+func TestAPIHandler_requestBodyLogging(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	api := NewAPIHandler(db.NewMockDB(ctrl), nil, WithAPIKey(testKey), WithLogger(logger), WithRequestBodyLogging(8))
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/tests", ts.URL), strings.NewReader("not json at all"))
+	require.NoError(t, err)
+
+	addAuth(req)
+
+	resp, err := ts.Client().Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	// The handler still sees the whole body, which it fails to parse.
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Assert(t, strings.Contains(logs.String(), `msg="request body" method=POST path=/api/tests body="not json" truncated=true`), logs.String())
+}
+
+func TestAPIHandler_maxRequestBodySize(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := NewAPIHandler(db.NewMockDB(ctrl), nil, WithAPIKey(testKey), WithMaxRequestBodySize(1024))
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	test := &tester.Test{
+		ID:      uuid.New(),
+		Package: "pkg",
+		RunID:   uuid.New(),
+		Result:  &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}},
+		Logs:    []tester.TBLog{{Name: "TestA", Output: bytes.Repeat([]byte("x"), 2048)}},
+	}
+	reqBody, err := json.Marshal(test)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name string
+		body io.Reader
+	}{
+		{name: "declared length", body: bytes.NewReader(reqBody)},
+		// Bodies of unknown length are only rejected once they are read
+		// past the limit.
+		{name: "chunked", body: io.MultiReader(bytes.NewReader(reqBody))},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/tests", ts.URL), tc.body)
+			require.NoError(t, err)
+			addAuth(req)
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+		})
+	}
+}
+
+type alerterFunc func(context.Context, *alerting.Alert) error
+
+func (f alerterFunc) Fire(ctx context.Context, alert *alerting.Alert) error {
+	return f(ctx, alert)
+}
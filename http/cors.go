@@ -0,0 +1,82 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+)
+
+// CORSConfig configures which cross origin requests browsers are allowed to
+// make to the API.
+type CORSConfig struct {
+	// AllowedOrigins are the origins (eg. https://dashboard.example.com) that
+	// are allowed to make requests, or "*" for any origin.
+	AllowedOrigins []string
+	// AllowedMethods are the methods that are allowed, GET, POST, PUT and
+	// DELETE if not set.
+	AllowedMethods []string
+	// AllowedHeaders are the request headers that are allowed, Authorization
+	// and Content-Type if not set.
+	AllowedHeaders []string
+}
+
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type"}
+)
+
+func (c CORSConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORSMiddleware adds CORS headers to responses to requests from allowed
+// origins, and answers preflight requests. Preflight requests are answered
+// without calling the next handler, since browsers send them without
+// credentials.
+func CORSMiddleware(cfg CORSConfig) func(http.Handler) http.Handler {
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := cfg.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			allowed := cfg.allowsOrigin(origin)
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+				if !allowed {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			if allowed {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				w.Header().Set("Access-Control-Expose-Headers", RequestIDHeader)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/db"
+	"github.com/stretchr/testify/require"
+	"gotest.tools/assert"
+)
+
+func TestCORS(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := NewAPIHandler(db.NewMockDB(ctrl), []*tester.Package{{Name: "pkg"}},
+		WithAPIKey(testKey),
+		WithCORS(CORSConfig{AllowedOrigins: []string{"https://dashboard.example.com"}}),
+	)
+	ts := httptest.NewServer(api)
+	defer ts.Close()
+
+	do := func(t *testing.T, method, origin string, auth bool) *http.Response {
+		req, err := http.NewRequest(method, ts.URL+"/api/packages/pkg", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+			req.Header.Set("Access-Control-Request-Headers", "authorization")
+		}
+		if auth {
+			addAuth(req)
+		}
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("preflight allowed origin", func(t *testing.T) {
+		// Preflight requests are sent without credentials.
+		resp := do(t, http.MethodOptions, "https://dashboard.example.com", false)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Equal(t, "https://dashboard.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "GET, POST, PUT, DELETE", resp.Header.Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "Authorization, Content-Type", resp.Header.Get("Access-Control-Allow-Headers"))
+	})
+
+	t.Run("preflight disallowed origin", func(t *testing.T) {
+		resp := do(t, http.MethodOptions, "https://evil.example.com", false)
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Methods"))
+	})
+
+	t.Run("request allowed origin", func(t *testing.T) {
+		resp := do(t, http.MethodGet, "https://dashboard.example.com", true)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "https://dashboard.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, RequestIDHeader, resp.Header.Get("Access-Control-Expose-Headers"))
+		assert.Equal(t, "Origin", resp.Header.Get("Vary"))
+	})
+
+	t.Run("request disallowed origin", func(t *testing.T) {
+		resp := do(t, http.MethodGet, "https://evil.example.com", true)
+		// The request is still served, but browsers do not expose the
+		// response without the CORS headers.
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+	})
+
+	t.Run("request still requires auth", func(t *testing.T) {
+		resp := do(t, http.MethodGet, "https://dashboard.example.com", false)
+		assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+		assert.Equal(t, "https://dashboard.example.com", resp.Header.Get("Access-Control-Allow-Origin"))
+	})
+}
+
+func TestCORS_disabled(t *testing.T) {
+	withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+		req, err := http.NewRequest(http.MethodOptions, ts.URL+"/api/packages/pkg", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", "https://dashboard.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodGet)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, "", resp.Header.Get("Access-Control-Allow-Origin"))
+	})
+}
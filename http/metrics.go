@@ -1,6 +1,11 @@
 package http
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"errors"
+	"fmt"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
 
 const (
 	// RunDurationMetricName is the name of the metric for test and benchmark run
@@ -10,26 +15,66 @@ const (
 	// RunLastMetricName is the name of the metric for the test and benchmark last
 	// run timestamp.
 	RunLastMetricName = "run_last_timestamp"
+
+	// ResultsMetricName is the name of the metric for the number of submitted
+	// test and benchmark results.
+	ResultsMetricName = "results_total"
 )
 
+// DefaultRunDurationBuckets are the default buckets of RunDurationMetric in
+// seconds. They range from 100µs to ~56m so that percentiles are meaningful
+// for both fast unit tests and long e2e suites.
+var DefaultRunDurationBuckets = prometheus.ExponentialBuckets(0.0001, 2, 26)
+
 // RunDurationMetric is the the metric for test and benchmark run durations.
-var RunDurationMetric = prometheus.NewHistogramVec(
-	prometheus.HistogramOpts{
-		Namespace: "tester",
-		Subsystem: "tb",
-		Name:      RunDurationMetricName,
-		Help:      "Amount of time tests or benchmarks take.",
-		Buckets: []float64{
-			// TODO need to figure out more appropriate bucketing
-			0.001, 0.01,
-			0.05, 0.1, 0.15, 0.2, 0.25, 0.3, 0.35, 0.4, 0.45, 0.5, 0.6, 0.7, 0.8, 0.9, 1.0,
-			1.25, 1.5, 1.75, 2.0, 2.5, 3.0, 3.5, 4.0, 4.5, 5,
-			6, 7, 8, 9, 10, 15, 20, 25, 30, 40, 50, 60, 70, 80, 90, 100, 110, 120,
-			180, 240, 300, 360, 420, 480, 540, 600, 660, 720, 780, 840, 900,
+var RunDurationMetric = newRunDurationMetric(DefaultRunDurationBuckets)
+
+func newRunDurationMetric(buckets []float64) *prometheus.HistogramVec {
+	return prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "tester",
+			Subsystem: "tb",
+			Name:      RunDurationMetricName,
+			Help:      "Amount of time tests or benchmarks take.",
+			Buckets:   buckets,
 		},
-	},
-	[]string{"name", "state"},
-)
+		[]string{"name", "state"},
+	)
+}
+
+// ValidateRunDurationBuckets checks that the buckets, in seconds, can be used
+// for RunDurationMetric.
+func ValidateRunDurationBuckets(buckets []float64) error {
+	if len(buckets) == 0 {
+		return errors.New("no buckets")
+	}
+	for i, bucket := range buckets {
+		if bucket <= 0 {
+			return fmt.Errorf("bucket %v is not positive", bucket)
+		}
+		if i > 0 && bucket <= buckets[i-1] {
+			return fmt.Errorf("buckets are not in increasing order (%v follows %v)", bucket, buckets[i-1])
+		}
+	}
+	return nil
+}
+
+// SetRunDurationBuckets replaces RunDurationMetric with a metric using the
+// buckets, in seconds. Durations observed before it is called are discarded,
+// so it should be called before serving.
+func SetRunDurationBuckets(buckets []float64) error {
+	if err := ValidateRunDurationBuckets(buckets); err != nil {
+		return err
+	}
+
+	metric := newRunDurationMetric(buckets)
+	prometheus.Unregister(RunDurationMetric)
+	if err := prometheus.Register(metric); err != nil {
+		return fmt.Errorf("registering run duration metric: %w", err)
+	}
+	RunDurationMetric = metric
+	return nil
+}
 
 // RunLastMetric is the the metric for test and benchmark last run timestamps.
 var RunLastMetric = prometheus.NewGaugeVec(
@@ -42,7 +87,22 @@ var RunLastMetric = prometheus.NewGaugeVec(
 	[]string{"name", "state"},
 )
 
+// ResultsMetric is the metric for the number of submitted test and benchmark
+// results. It is labeled by package, top level test name and result state, so
+// its cardinality is bounded by the number of top level tests of the
+// configured packages times the number of result states.
+var ResultsMetric = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "tester",
+		Subsystem: "tb",
+		Name:      ResultsMetricName,
+		Help:      "Number of submitted test or benchmark results.",
+	},
+	[]string{"package", "name", "state"},
+)
+
 func init() {
 	prometheus.MustRegister(RunDurationMetric)
 	prometheus.MustRegister(RunLastMetric)
+	prometheus.MustRegister(ResultsMetric)
 }
@@ -0,0 +1,50 @@
+package http
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetRunDurationBuckets(t *testing.T) {
+	t.Cleanup(func() {
+		require.NoError(t, SetRunDurationBuckets(DefaultRunDurationBuckets))
+	})
+
+	for _, buckets := range [][]float64{
+		nil,
+		{0, 1},
+		{1, 2, 2},
+		{2, 1},
+	} {
+		assert.Error(t, SetRunDurationBuckets(buckets), "buckets %v", buckets)
+	}
+
+	require.NoError(t, SetRunDurationBuckets([]float64{0.5, 1, 2}))
+	RunDurationMetric.With(prometheus.Labels{"name": "TestA", "state": "passed"}).Observe(0.75)
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	require.NoError(t, err)
+	var histogram *dto.Histogram
+	for _, family := range families {
+		if family.GetName() == "tester_tb_"+RunDurationMetricName {
+			require.Len(t, family.Metric, 1)
+			histogram = family.Metric[0].GetHistogram()
+		}
+	}
+	require.NotNil(t, histogram, "run duration metric should be registered")
+
+	var (
+		bounds []float64
+		counts []uint64
+	)
+	for _, bucket := range histogram.Bucket {
+		bounds = append(bounds, bucket.GetUpperBound())
+		counts = append(counts, bucket.GetCumulativeCount())
+	}
+	assert.Equal(t, []float64{0.5, 1, 2}, bounds)
+	assert.Equal(t, []uint64{0, 1, 1}, counts)
+}
@@ -1,11 +1,53 @@
 package http
 
 import (
-	"log"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+// RequestIDHeader is the header used to correlate requests between runners
+// and the server.
+const RequestIDHeader = "X-Request-ID"
+
+// RunnerIDHeader is the header used by runners to identify themselves.
+const RunnerIDHeader = "X-Runner-ID"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of the context that carries the request ID.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID returns the request ID carried by the context if there is one.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// requestLogger returns the logger with the request ID of the request, and the
+// ID of the runner making it if any, so that handlers' logs can be correlated
+// with the request logged by LogHandlerFunc.
+func requestLogger(logger *slog.Logger, r *http.Request) *slog.Logger {
+	if requestID := RequestID(r.Context()); requestID != "" {
+		logger = logger.With("request_id", requestID)
+	}
+	if runnerID := r.Header.Get(RunnerIDHeader); runnerID != "" {
+		logger = logger.With("runner_id", runnerID)
+	}
+	return logger
+}
+
 // ResponseInspectingWriter is an http.ResponseWriter that captures response info.
 type ResponseInspectingWriter struct {
 	http.ResponseWriter
@@ -32,17 +74,242 @@ func (w *ResponseInspectingWriter) Write(p []byte) (int, error) {
 
 var _ http.ResponseWriter = &ResponseInspectingWriter{}
 
-// LogHandlerFunc logs request/response information.
-func LogHandlerFunc(next http.HandlerFunc) http.HandlerFunc {
+// LogHandlerFunc logs request/response information. Requests are tagged with
+// the request ID from the X-Request-ID header, or a newly generated one if not
+// present, and the ID is echoed back in the response.
+func LogHandlerFunc(logger *slog.Logger, next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		riw := &ResponseInspectingWriter{ResponseWriter: w}
 		start := time.Now()
 
-		log.Printf("received %s %s\n", r.Method, r.URL.String())
+		requestID := r.Header.Get(RequestIDHeader)
+		if requestID == "" {
+			requestID = uuid.New().String()
+		}
+		riw.Header().Set(RequestIDHeader, requestID)
+		r = r.WithContext(WithRequestID(r.Context(), requestID))
+		logger := requestLogger(logger, r)
+
+		logger.Debug("received request", "method", r.Method, "path", r.URL.String())
 
 		next.ServeHTTP(riw, r)
 
-		duration := time.Since(start).Seconds()
-		log.Printf("handled %s %s [%d] (%fs)\n", r.Method, r.URL.String(), riw.Status, duration)
+		logger.Info(
+			"handled request",
+			"method", r.Method,
+			"path", r.URL.String(),
+			"status", riw.Status,
+			"duration", time.Since(start).Seconds(),
+		)
+	})
+}
+
+// DefaultGzipMinSize is the size of response body below which compressing is
+// not worth the overhead, roughly what fits in a single packet.
+const DefaultGzipMinSize = 1400
+
+// incompressibleContentTypes are content types that are already compressed or
+// binary, eg. test binary downloads, which gain little from compressing.
+var incompressibleContentTypes = []string{
+	"application/octet-stream",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"image/",
+	"video/",
+}
+
+// RequestBodyLoggingMiddleware logs up to maxBytes of the body of requests at
+// debug level. Only the logged bytes are buffered, and they are replayed ahead
+// of the rest of the body, which the next handler reads as it streams in.
+func RequestBodyLoggingMiddleware(logger *slog.Logger, maxBytes int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody || !logger.Enabled(r.Context(), slog.LevelDebug) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Reading a byte more than is logged tells whether the body was
+			// truncated.
+			var buf bytes.Buffer
+			_, err := io.CopyN(&buf, r.Body, int64(maxBytes)+1)
+			body := buf.Bytes()
+			truncated := len(body) > maxBytes
+			if truncated {
+				body = body[:maxBytes]
+			}
+
+			args := []any{"method", r.Method, "path", r.URL.String(), "body", string(body), "truncated", truncated}
+			if requestID := r.Header.Get(RequestIDHeader); requestID != "" {
+				args = append(args, "request_id", requestID)
+			}
+			if err != nil && err != io.EOF {
+				args = append(args, "err", err)
+			}
+			logger.Debug("request body", args...)
+
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{
+				Reader: io.MultiReader(bytes.NewReader(buf.Bytes()), r.Body),
+				Closer: r.Body,
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// DefaultMaxRequestBodySize is the default limit on the size of API request
+// bodies. Submitted tests include their logs, so it leaves plenty of room.
+const DefaultMaxRequestBodySize = 32 << 20
+
+// MaxRequestBodySizeMiddleware limits request bodies to maxBytes. Requests
+// that declare a longer body are rejected with 413 up front, and reading past
+// the limit of a body without a declared length fails with an
+// *http.MaxBytesError, which handlers render as 413.
+func MaxRequestBodySizeMiddleware(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				renderAPIError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("request body too large, limit is %d bytes", maxBytes))
+				return
+			}
+			if r.Body != nil {
+				r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// gzipResponseWriter is an http.ResponseWriter that gzips response bodies.
+// The body is buffered until it reaches minSize, so responses that are
+// smaller, and responses without bodies, are left as is.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	minSize int
+
+	gz          *gzip.Writer
+	status      int
+	buf         []byte
+	wroteHeader bool
+	passthrough bool
+}
+
+// WriteHeader wraps the method to defer writing the header until it is known
+// whether the response is compressed, unless the response has no body, is
+// already encoded, is incompressible or is known to be too small.
+func (w *gzipResponseWriter) WriteHeader(s int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = s
+
+	header := w.Header()
+	if s == http.StatusNoContent || s == http.StatusNotModified || header.Get("Content-Encoding") != "" ||
+		incompressible(header.Get("Content-Type")) || contentLengthBelow(header.Get("Content-Length"), w.minSize) {
+		w.passthrough = true
+		w.writeHeader()
+	}
+}
+
+func (w *gzipResponseWriter) writeHeader() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// Write wraps the method to compress the response body.
+func (w *gzipResponseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.passthrough {
+		return w.ResponseWriter.Write(p)
+	}
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+
+	w.buf = append(w.buf, p...)
+	if len(w.buf) < w.minSize {
+		return len(p), nil
+	}
+
+	// The content type can no longer be sniffed from the body once it is
+	// compressed, and the length of the compressed body is not known up front.
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Encoding", "gzip")
+	w.writeHeader()
+	w.gz = gzip.NewWriter(w.ResponseWriter)
+	if _, err := w.gz.Write(w.buf); err != nil {
+		return 0, err
+	}
+	w.buf = nil
+	return len(p), nil
+}
+
+// close flushes any compressed data that is still buffered, or writes the
+// buffered body as is if it never reached the minimum size.
+func (w *gzipResponseWriter) close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	if w.status == 0 || w.passthrough {
+		return nil
+	}
+	w.writeHeader()
+	_, err := w.ResponseWriter.Write(w.buf)
+	return err
+}
+
+var _ http.ResponseWriter = &gzipResponseWriter{}
+
+func incompressible(contentType string) bool {
+	for _, t := range incompressibleContentTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func contentLengthBelow(contentLength string, size int) bool {
+	length, err := strconv.Atoi(contentLength)
+	return err == nil && length < size
+}
+
+// GzipMiddleware compresses responses with gzip for requests that accept it.
+// Responses with bodies smaller than minSize, and responses with content that
+// is already compressed or binary, are not compressed.
+func GzipMiddleware(next http.Handler, minSize int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gzw := &gzipResponseWriter{ResponseWriter: w, minSize: minSize}
+		defer gzw.close()
+		next.ServeHTTP(gzw, r)
 	})
 }
+
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding, params, _ := strings.Cut(strings.TrimSpace(encoding), ";")
+		if strings.TrimSpace(encoding) != "gzip" {
+			continue
+		}
+		return strings.ReplaceAll(params, " ", "") != "q=0"
+	}
+	return false
+}
@@ -0,0 +1,276 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gotest.tools/assert"
+)
+
+func TestLogHandlerFunc(t *testing.T) {
+	var handledRequestID string
+	handler := LogHandlerFunc(slog.Default(), func(w http.ResponseWriter, r *http.Request) {
+		handledRequestID = RequestID(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	t.Run("propagates request id", func(t *testing.T) {
+		ts := httptest.NewServer(handler)
+		defer ts.Close()
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+		req.Header.Set(RequestIDHeader, "request-id")
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "request-id", resp.Header.Get(RequestIDHeader))
+		assert.Equal(t, "request-id", handledRequestID)
+	})
+
+	t.Run("generates request id", func(t *testing.T) {
+		ts := httptest.NewServer(handler)
+		defer ts.Close()
+
+		req, err := http.NewRequest(http.MethodGet, ts.URL, nil)
+		require.NoError(t, err)
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Assert(t, resp.Header.Get(RequestIDHeader) != "")
+		assert.Equal(t, resp.Header.Get(RequestIDHeader), handledRequestID)
+	})
+}
+
+func TestRequestLogger(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(RunnerIDHeader, "runner-id")
+	req = req.WithContext(WithRequestID(req.Context(), "request-id"))
+	requestLogger(logger, req).Info("message")
+
+	var entry struct {
+		RequestID string `json:"request_id"`
+		RunnerID  string `json:"runner_id"`
+	}
+	require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+	assert.Equal(t, "request-id", entry.RequestID)
+	assert.Equal(t, "runner-id", entry.RunnerID)
+
+	logs.Reset()
+	requestLogger(logger, httptest.NewRequest(http.MethodGet, "/", nil)).Info("message")
+	assert.Assert(t, !strings.Contains(logs.String(), "request_id"))
+}
+
+func TestGzipMiddleware(t *testing.T) {
+	body := strings.Repeat(`{"name":"TestA","state":"passed"}`, 1000)
+	ts := httptest.NewServer(GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/not-modified":
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case "/small":
+			w.Header().Set("Content-Type", "application/json")
+			io.WriteString(w, `{"name":"TestA"}`)
+			return
+		case "/streamed":
+			// Without a content type or length, written in small chunks.
+			for i := 0; i < 1000; i++ {
+				io.WriteString(w, "<p>TestA passed</p>")
+			}
+			return
+		case "/binary":
+			w.Header().Set("Content-Type", "application/octet-stream")
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "br")
+		default:
+			w.Header().Set("Content-Type", "application/json")
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		io.WriteString(w, body)
+	}), 1024))
+	defer ts.Close()
+
+	// Disable the transport's transparent decompression to inspect the
+	// responses as sent.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	get := func(t *testing.T, path, acceptEncoding string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		require.NoError(t, err)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	t.Run("uncompressed", func(t *testing.T) {
+		resp := get(t, "/", "")
+		assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, strconv.Itoa(len(body)), resp.Header.Get("Content-Length"))
+
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(data))
+	})
+
+	t.Run("compressed", func(t *testing.T) {
+		resp := get(t, "/", "deflate, gzip")
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		// The handler's Content-Length is for the uncompressed body, so it must
+		// not be sent.
+		assert.Assert(t, resp.Header.Get("Content-Length") != strconv.Itoa(len(body)))
+		assert.Equal(t, "Accept-Encoding", resp.Header.Get("Vary"))
+
+		gz, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		data, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Equal(t, body, string(data))
+	})
+
+	t.Run("gzip not acceptable", func(t *testing.T) {
+		resp := get(t, "/", "gzip;q=0")
+		assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	})
+
+	t.Run("no body", func(t *testing.T) {
+		resp := get(t, "/not-modified", "gzip")
+		assert.Equal(t, http.StatusNotModified, resp.StatusCode)
+		assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+	})
+
+	t.Run("streamed", func(t *testing.T) {
+		resp := get(t, "/streamed", "gzip")
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, "text/html; charset=utf-8", resp.Header.Get("Content-Type"))
+
+		gz, err := gzip.NewReader(resp.Body)
+		require.NoError(t, err)
+		data, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Equal(t, strings.Repeat("<p>TestA passed</p>", 1000), string(data))
+	})
+
+	t.Run("below min size", func(t *testing.T) {
+		resp := get(t, "/small", "gzip")
+		assert.Equal(t, "", resp.Header.Get("Content-Encoding"))
+
+		data, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, `{"name":"TestA"}`, string(data))
+	})
+
+	for _, path := range []string{"/binary", "/encoded"} {
+		path := path
+		t.Run(path, func(t *testing.T) {
+			resp := get(t, path, "gzip")
+			assert.Assert(t, resp.Header.Get("Content-Encoding") != "gzip")
+			assert.Equal(t, strconv.Itoa(len(body)), resp.Header.Get("Content-Length"))
+
+			data, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, body, string(data))
+		})
+	}
+}
+
+func TestRequestBodyLoggingMiddleware(t *testing.T) {
+	body := strings.Repeat("0123456789", 100)
+
+	for _, tc := range []struct {
+		name      string
+		level     slog.Level
+		maxBytes  int
+		logged    string
+		truncated bool
+	}{
+		{name: "truncated", level: slog.LevelDebug, maxBytes: 16, logged: body[:16], truncated: true},
+		{name: "whole body", level: slog.LevelDebug, maxBytes: len(body), logged: body},
+		{name: "debug disabled", level: slog.LevelInfo, maxBytes: 16},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: tc.level}))
+
+			var received string
+			handler := RequestBodyLoggingMiddleware(logger, tc.maxBytes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				data, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				received = string(data)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/tests", strings.NewReader(body))
+			req.Header.Set(RequestIDHeader, "request-id")
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			// The handler reads the whole body regardless of what is logged.
+			assert.Equal(t, body, received)
+
+			if tc.logged == "" {
+				assert.Equal(t, "", logs.String())
+				return
+			}
+			var entry struct {
+				Msg       string `json:"msg"`
+				Body      string `json:"body"`
+				Truncated bool   `json:"truncated"`
+				RequestID string `json:"request_id"`
+			}
+			require.NoError(t, json.Unmarshal(logs.Bytes(), &entry))
+			assert.Equal(t, "request body", entry.Msg)
+			assert.Equal(t, tc.logged, entry.Body)
+			assert.Equal(t, tc.truncated, entry.Truncated)
+			assert.Equal(t, "request-id", entry.RequestID)
+		})
+	}
+}
+
+func TestMaxRequestBodySizeMiddleware(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		body   string
+		length int64
+		status int
+	}{
+		{name: "within limit", body: `"01234567"`, length: 10, status: http.StatusOK},
+		{name: "declared too large", body: `"012345678"`, length: 11, status: http.StatusRequestEntityTooLarge},
+		{name: "undeclared too large", body: `"012345678"`, length: -1, status: http.StatusRequestEntityTooLarge},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			handler := MaxRequestBodySizeMiddleware(10)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var v json.RawMessage
+				if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+					renderAPIError(w, decodeErrorStatus(err, http.StatusBadRequest), err)
+				}
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/tests", strings.NewReader(tc.body))
+			req.ContentLength = tc.length
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, tc.status, rec.Code)
+		})
+	}
+}
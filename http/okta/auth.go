@@ -27,10 +27,12 @@ type AuthHandler struct {
 	clientSecret string
 	issuer       string
 	redirectURI  string
-	errorWriter  func(w http.ResponseWriter, r *http.Request, err error, status int)
+	// homePath is redirected to once authenticated.
+	homePath    string
+	errorWriter func(w http.ResponseWriter, r *http.Request, err error, status int)
 }
 
-func NewAuthHandler(sessionKey []byte, clientID, clientSecret, issuer, redirectURI string, errorWriter func(w http.ResponseWriter, r *http.Request, err error, status int)) *AuthHandler {
+func NewAuthHandler(sessionKey []byte, clientID, clientSecret, issuer, redirectURI, homePath string, errorWriter func(w http.ResponseWriter, r *http.Request, err error, status int)) *AuthHandler {
 	return &AuthHandler{
 		sessionStore: sessions.NewCookieStore(sessionKey),
 		clientID:     clientID,
@@ -38,6 +40,7 @@ func NewAuthHandler(sessionKey []byte, clientID, clientSecret, issuer, redirectU
 		issuer:       issuer,
 		errorWriter:  errorWriter,
 		redirectURI:  redirectURI,
+		homePath:     homePath,
 	}
 }
 
@@ -127,7 +130,7 @@ func (h *AuthHandler) AuthCodeCallbackHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	http.Redirect(w, r, "/", http.StatusFound)
+	http.Redirect(w, r, h.homePath, http.StatusFound)
 }
 
 func generateNonce() (string, error) {
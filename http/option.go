@@ -1,6 +1,11 @@
 package http
 
 import (
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/nanzhong/tester"
 	"github.com/nanzhong/tester/alerting"
 	"github.com/nanzhong/tester/slack"
 )
@@ -11,7 +16,21 @@ type Option func(*options)
 type options struct {
 	alertManager *alerting.AlertManager
 	slackApp     *slack.App
-	apiKey       string
+	apiKeys      []string
+	runnerKeys   []string
+	flakyWindow  time.Duration
+	uiConfig     UIConfig
+	basePath     string
+	cors         *CORSConfig
+	// requestBodyLogBytes is the number of bytes of request bodies that are
+	// logged, 0 to not log them.
+	requestBodyLogBytes int
+	// maxRequestBodySize is the limit on the size of API request bodies, 0
+	// for no limit.
+	maxRequestBodySize int64
+	packageLoader      func(*tester.Package) (*tester.Package, error)
+	packagesChanged    func()
+	logger             *slog.Logger
 }
 
 // WithAlertManager allows configuring a custom alert manager.
@@ -30,7 +49,109 @@ func WithSlackApp(app *slack.App) Option {
 
 // WithAPIKey allows configuring a symmetric key for api auth.
 func WithAPIKey(key string) Option {
+	return WithAPIKeys([]string{key})
+}
+
+// WithAPIKeys allows configuring multiple symmetric keys for api auth, ordered
+// from oldest to newest. Any of the keys are accepted until they are rotated
+// out, oldest first.
+func WithAPIKeys(keys []string) Option {
+	return func(opts *options) {
+		opts.apiKeys = append(opts.apiKeys, keys...)
+	}
+}
+
+// WithRunnerKeys allows configuring symmetric keys for runners' api auth.
+// Runner keys are only accepted for the requests runners make, which are the
+// only ones that packages are returned to with the values of their secrets.
+func WithRunnerKeys(keys []string) Option {
+	return func(opts *options) {
+		opts.runnerKeys = append(opts.runnerKeys, keys...)
+	}
+}
+
+// WithFlakyWindow allows configuring the window of test history that submitted
+// tests are checked for flakiness in. Tests are not checked if it is 0.
+func WithFlakyWindow(d time.Duration) Option {
+	return func(opts *options) {
+		opts.flakyWindow = d
+	}
+}
+
+// WithUIConfig allows configuring what the UI presents. Fields that are not
+// set keep their defaults.
+func WithUIConfig(cfg UIConfig) Option {
+	return func(opts *options) {
+		opts.uiConfig = cfg
+	}
+}
+
+// WithBasePath allows configuring a path prefix (eg. /tester) that routes are
+// served under and that generated links include, for deployments behind a
+// reverse proxy that serves tester under a sub path.
+func WithBasePath(path string) Option {
+	return func(opts *options) {
+		opts.basePath = NormalizeBasePath(path)
+	}
+}
+
+// NormalizeBasePath returns the path with a leading slash and without a
+// trailing slash, or an empty path for the root.
+func NormalizeBasePath(path string) string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return ""
+	}
+	return "/" + path
+}
+
+// WithCORS allows configuring CORS for the API, so that browser based tools
+// served from other origins can call it. CORS is disabled by default.
+func WithCORS(cfg CORSConfig) Option {
+	return func(opts *options) {
+		opts.cors = &cfg
+	}
+}
+
+// WithRequestBodyLogging allows logging up to maxBytes of the body of API
+// requests at debug level, eg. to debug why submissions from a runner fail.
+func WithRequestBodyLogging(maxBytes int) Option {
+	return func(opts *options) {
+		opts.requestBodyLogBytes = maxBytes
+	}
+}
+
+// WithMaxRequestBodySize allows configuring the limit on the size of API
+// request bodies, DefaultMaxRequestBodySize by default. Requests with larger
+// bodies are rejected with 413. A limit of 0 disables it.
+func WithMaxRequestBodySize(maxBytes int64) Option {
+	return func(opts *options) {
+		opts.maxRequestBodySize = maxBytes
+	}
+}
+
+// WithPackageLoader allows configuring how package definitions created or
+// updated through the API are validated and turned into the packages that are
+// served, eg. by computing the sha256 sums of their test binaries. By default
+// definitions are served as they are, as long as they are named.
+func WithPackageLoader(fn func(pkg *tester.Package) (*tester.Package, error)) Option {
+	return func(opts *options) {
+		opts.packageLoader = fn
+	}
+}
+
+// WithPackagesChanged allows configuring a func that is called after packages
+// are created, updated or deleted through the API, eg. to refresh the packages
+// of the scheduler and UI.
+func WithPackagesChanged(fn func()) Option {
+	return func(opts *options) {
+		opts.packagesChanged = fn
+	}
+}
+
+// WithLogger allows configuring a custom logger.
+func WithLogger(logger *slog.Logger) Option {
 	return func(opts *options) {
-		opts.apiKey = key
+		opts.logger = logger
 	}
 }
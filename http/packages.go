@@ -0,0 +1,65 @@
+package http
+
+import (
+	"sync"
+
+	"github.com/nanzhong/tester"
+)
+
+// packageStore holds the packages served by the API, keyed by name. It is
+// safe for concurrent use. Packages are replaced rather than modified, so the
+// packages returned by the store can be used while it is reloaded.
+type packageStore struct {
+	mu       sync.RWMutex
+	packages map[string]*tester.Package
+}
+
+func newPackageStore(packages []*tester.Package) *packageStore {
+	s := &packageStore{}
+	s.Reload(packages)
+	return s
+}
+
+// Reload replaces all of the packages in the store.
+func (s *packageStore) Reload(packages []*tester.Package) {
+	pkgs := make(map[string]*tester.Package, len(packages))
+	for _, pkg := range packages {
+		pkgs[pkg.Name] = pkg
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.packages = pkgs
+}
+
+// Set adds the package to the store, replacing the package of the same name
+// if there is one.
+func (s *packageStore) Set(pkg *tester.Package) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pkgs := make(map[string]*tester.Package, len(s.packages)+1)
+	for name, p := range s.packages {
+		pkgs[name] = p
+	}
+	pkgs[pkg.Name] = pkg
+	s.packages = pkgs
+}
+
+// Get returns the package with the name, if there is one.
+func (s *packageStore) Get(name string) (*tester.Package, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pkg, ok := s.packages[name]
+	return pkg, ok
+}
+
+// List returns all of the packages in the store, in no particular order.
+func (s *packageStore) List() []*tester.Package {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	packages := make([]*tester.Package, 0, len(s.packages))
+	for _, pkg := range s.packages {
+		packages = append(packages, pkg)
+	}
+	return packages
+}
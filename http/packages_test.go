@@ -0,0 +1,76 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/db"
+	"gotest.tools/assert"
+)
+
+func TestPackageStore(t *testing.T) {
+	store := newPackageStore([]*tester.Package{{Name: "a"}, {Name: "b"}})
+
+	pkg, ok := store.Get("a")
+	assert.Assert(t, ok)
+	assert.Equal(t, "a", pkg.Name)
+	assert.Equal(t, 2, len(store.List()))
+
+	store.Set(&tester.Package{Name: "c"})
+	_, ok = store.Get("c")
+	assert.Assert(t, ok)
+	assert.Equal(t, 3, len(store.List()))
+
+	store.Reload([]*tester.Package{{Name: "d"}})
+	_, ok = store.Get("a")
+	assert.Assert(t, !ok)
+	_, ok = store.Get("d")
+	assert.Assert(t, ok)
+	assert.Equal(t, 1, len(store.List()))
+}
+
+// TestPackageStore_concurrent is meant to be run with the race detector.
+func TestPackageStore_concurrent(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	api := NewAPIHandler(db.NewMockDB(ctrl), []*tester.Package{{Name: "pkg"}}, WithAPIKey(testKey), WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if j%2 == 0 {
+					api.UpdatePackages([]*tester.Package{{Name: "pkg"}, {Name: fmt.Sprintf("pkg-%d-%d", i, j)}})
+				} else {
+					api.packages.Set(&tester.Package{Name: fmt.Sprintf("pkg-%d-%d", i, j)})
+				}
+			}
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				for _, path := range []string{"/api/packages", "/api/packages/pkg"} {
+					req := httptest.NewRequest(http.MethodGet, path, nil)
+					addAuth(req)
+					resp := httptest.NewRecorder()
+					api.ServeHTTP(resp, req)
+					assert.Equal(t, http.StatusOK, resp.Code)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
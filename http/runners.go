@@ -0,0 +1,136 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// runnerInactiveAfter is how long after a runner was last seen that it is
+// reported as inactive.
+const runnerInactiveAfter = 5 * time.Minute
+
+// Runner states reported by RunnerRegistry.
+const (
+	RunnerStateActive   = "active"
+	RunnerStateInactive = "inactive"
+)
+
+// RunnerStatus is the status of a runner as last seen by the server.
+type RunnerStatus struct {
+	Name string `json:"name"`
+	// ID is the stable ID of the runner, if it provides one.
+	ID           *uuid.UUID `json:"id,omitempty"`
+	LastSeen     time.Time  `json:"last_seen"`
+	CurrentRunID *uuid.UUID `json:"current_run_id,omitempty"`
+	State        string     `json:"state"`
+}
+
+type runnerEntry struct {
+	mu     sync.Mutex
+	status RunnerStatus
+}
+
+// RunnerRegistry records the runners that have made requests to the API.
+// Runners are identified by their runner ID, falling back to their user agent
+// for runners that don't provide one.
+type RunnerRegistry struct {
+	runners sync.Map // map[string]*runnerEntry
+	now     func() time.Time
+}
+
+// NewRunnerRegistry constructs a new empty `RunnerRegistry`.
+func NewRunnerRegistry() *RunnerRegistry {
+	return &RunnerRegistry{now: time.Now}
+}
+
+// runnerKey returns the key identifying the runner that made the request, or
+// an empty key if the request does not identify a runner.
+func runnerKey(r *http.Request) string {
+	if runnerID := r.Header.Get(RunnerIDHeader); runnerID != "" {
+		return runnerID
+	}
+	return r.Header.Get("User-Agent")
+}
+
+func (reg *RunnerRegistry) entry(r *http.Request) *runnerEntry {
+	key := runnerKey(r)
+	if key == "" {
+		return nil
+	}
+
+	value, _ := reg.runners.LoadOrStore(key, &runnerEntry{})
+	return value.(*runnerEntry)
+}
+
+// Seen records that the runner that made the request was seen.
+func (reg *RunnerRegistry) Seen(r *http.Request) {
+	entry := reg.entry(r)
+	if entry == nil {
+		return
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.status.Name = r.Header.Get("User-Agent")
+	if runnerID, err := uuid.Parse(r.Header.Get(RunnerIDHeader)); err == nil {
+		entry.status.ID = &runnerID
+	}
+	entry.status.LastSeen = reg.now()
+}
+
+// Claimed records that the runner that made the request claimed the run.
+func (reg *RunnerRegistry) Claimed(r *http.Request, runID uuid.UUID) {
+	entry := reg.entry(r)
+	if entry == nil {
+		return
+	}
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	entry.status.CurrentRunID = &runID
+}
+
+// Finished records that the run is no longer being run by whichever runner
+// claimed it.
+func (reg *RunnerRegistry) Finished(runID uuid.UUID) {
+	reg.runners.Range(func(_, value interface{}) bool {
+		entry := value.(*runnerEntry)
+		entry.mu.Lock()
+		defer entry.mu.Unlock()
+		if entry.status.CurrentRunID != nil && *entry.status.CurrentRunID == runID {
+			entry.status.CurrentRunID = nil
+		}
+		return true
+	})
+}
+
+// List returns the status of the runners that have been seen, ordered by
+// name.
+func (reg *RunnerRegistry) List() []RunnerStatus {
+	now := reg.now()
+	runners := []RunnerStatus{}
+	reg.runners.Range(func(_, value interface{}) bool {
+		entry := value.(*runnerEntry)
+		entry.mu.Lock()
+		status := entry.status
+		entry.mu.Unlock()
+
+		status.State = RunnerStateActive
+		if now.Sub(status.LastSeen) > runnerInactiveAfter {
+			status.State = RunnerStateInactive
+		}
+		runners = append(runners, status)
+		return true
+	})
+	sort.Slice(runners, func(i, j int) bool {
+		if runners[i].Name != runners[j].Name {
+			return runners[i].Name < runners[j].Name
+		}
+		return runners[i].LastSeen.After(runners[j].LastSeen)
+	})
+	return runners
+}
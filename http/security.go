@@ -0,0 +1,95 @@
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// CSPNonce is replaced in a content security policy with a nonce generated
+// for each response. The UI's inline scripts are tagged with the nonce, so a
+// policy that only allows scripts with the nonce still allows them.
+const CSPNonce = "{nonce}"
+
+// DefaultContentSecurityPolicy allows the UI's own resources, the CDN assets
+// it loads and its inline scripts, but no other scripts. Inline styles are
+// allowed since the templates size elements with style attributes.
+const DefaultContentSecurityPolicy = "default-src 'self'; " +
+	"script-src 'self' 'nonce-" + CSPNonce + "' https://cdn.jsdelivr.net https://*.fontawesome.com; " +
+	"style-src 'self' 'unsafe-inline' https://cdn.jsdelivr.net https://*.fontawesome.com; " +
+	"font-src 'self' https://*.fontawesome.com; " +
+	"connect-src 'self' https://*.fontawesome.com; " +
+	"img-src 'self' data:; " +
+	"base-uri 'self'; " +
+	"form-action 'self'; " +
+	"frame-ancestors 'none'"
+
+// SecurityHeaders configures the security headers set on UI responses.
+type SecurityHeaders struct {
+	// ContentSecurityPolicy is the Content-Security-Policy, in which CSPNonce
+	// is replaced with the response's nonce.
+	ContentSecurityPolicy string
+	// FrameOptions is the X-Frame-Options, DENY or SAMEORIGIN.
+	FrameOptions string
+	// ReferrerPolicy is the Referrer-Policy.
+	ReferrerPolicy string
+}
+
+// DefaultSecurityHeaders returns the default security headers.
+func DefaultSecurityHeaders() SecurityHeaders {
+	return SecurityHeaders{
+		ContentSecurityPolicy: DefaultContentSecurityPolicy,
+		FrameOptions:          "DENY",
+		ReferrerPolicy:        "same-origin",
+	}
+}
+
+type cspNonceKey struct{}
+
+// CSPNonceFromContext returns the content security policy nonce of the
+// response being served, if there is one.
+func CSPNonceFromContext(ctx context.Context) string {
+	nonce, _ := ctx.Value(cspNonceKey{}).(string)
+	return nonce
+}
+
+// SecurityHeadersMiddleware sets the security headers on responses, along
+// with X-Content-Type-Options: nosniff so that browsers don't interpret
+// responses (eg. test logs) as a different content type than they are served
+// as. A nonce is generated for each response and made available to the
+// handler through CSPNonceFromContext.
+func SecurityHeadersMiddleware(headers SecurityHeaders) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Content-Type-Options", "nosniff")
+			if headers.FrameOptions != "" {
+				w.Header().Set("X-Frame-Options", headers.FrameOptions)
+			}
+			if headers.ReferrerPolicy != "" {
+				w.Header().Set("Referrer-Policy", headers.ReferrerPolicy)
+			}
+			if headers.ContentSecurityPolicy != "" {
+				nonce, err := newCSPNonce()
+				if err != nil {
+					http.Error(w, "failed to generate csp nonce", http.StatusInternalServerError)
+					return
+				}
+				w.Header().Set("Content-Security-Policy", strings.ReplaceAll(headers.ContentSecurityPolicy, CSPNonce, nonce))
+				r = r.WithContext(context.WithValue(r.Context(), cspNonceKey{}, nonce))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// newCSPNonce returns a random nonce. It is URL safe base64 encoded so that
+// html/template doesn't escape it in the nonce attributes of scripts.
+func newCSPNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,174 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/db"
+)
+
+const (
+	defaultSlowTestsWindow = 7 * 24 * time.Hour
+	// maxSlowTestsWindow bounds the number of tests loaded to summarize
+	// durations, which is done in memory over both the window and the prior
+	// window.
+	maxSlowTestsWindow = 30 * 24 * time.Hour
+
+	defaultSlowTestsLimit = 20
+	maxSlowTestsLimit     = 100
+
+	// A test's duration has regressed when its median duration has grown by
+	// at least durationRegressionFactor and durationRegressionMinIncrease
+	// compared to the prior window. The minimum increase ignores fast tests
+	// that slowed down by a negligible amount.
+	durationRegressionFactor      = 1.5
+	durationRegressionMinIncrease = time.Second
+)
+
+// testDurations summarizes the durations of a test's results over a window.
+type testDurations struct {
+	Name   string
+	Count  int
+	Median time.Duration
+	P95    time.Duration
+	Max    time.Duration
+
+	// PriorCount and PriorMedian summarize the test's results over the prior
+	// window. PriorCount is 0 if the test didn't run in the prior window.
+	PriorCount  int
+	PriorMedian time.Duration
+	Regressed   bool
+}
+
+// Growth returns the ratio of the test's median duration to its median
+// duration in the prior window, or 0 if it didn't run in the prior window.
+func (d *testDurations) Growth() float64 {
+	if d.PriorCount == 0 || d.PriorMedian <= 0 {
+		return 0
+	}
+	return float64(d.Median) / float64(d.PriorMedian)
+}
+
+// parseSlowTestsWindow parses the window to summarize test durations over,
+// which defaults to 7d if value is empty.
+func parseSlowTestsWindow(value string) (time.Duration, error) {
+	if value == "" {
+		return defaultSlowTestsWindow, nil
+	}
+	window, err := parseDays(value)
+	if err != nil || window <= 0 {
+		return 0, fmt.Errorf("invalid window: %s", value)
+	}
+	if window > maxSlowTestsWindow {
+		return 0, fmt.Errorf("window must be at most %s", maxSlowTestsWindow)
+	}
+	return window, nil
+}
+
+// listSlowTests summarizes the durations of the package's tests over the
+// window ending at end, compared to the prior window of the same length.
+func listSlowTests(ctx context.Context, store db.DB, pkg string, end time.Time, window time.Duration) ([]*testDurations, error) {
+	begin := end.Add(-window)
+	tests, err := store.ListTestsForPackageInRange(ctx, pkg, begin.Add(-window), end)
+	if err != nil {
+		return nil, err
+	}
+
+	var current, prior []*tester.Test
+	for _, test := range tests {
+		if test.Result == nil {
+			continue
+		}
+		if test.Result.StartedAt.Before(begin) {
+			prior = append(prior, test)
+		} else {
+			current = append(current, test)
+		}
+	}
+	return summarizeTestDurations(current, prior), nil
+}
+
+// summarizeTestDurations summarizes the durations of the tests by name,
+// ordered from slowest to fastest by median and then p95 duration. The prior
+// tests are only used to detect duration regressions. Skipped tests are
+// ignored, as their durations aren't meaningful.
+func summarizeTestDurations(tests, priorTests []*tester.Test) []*testDurations {
+	priorDurations := durationsByName(priorTests)
+
+	var summaries []*testDurations
+	for name, durations := range durationsByName(tests) {
+		summary := &testDurations{
+			Name:   name,
+			Count:  len(durations),
+			Median: percentile(durations, 0.5),
+			P95:    percentile(durations, 0.95),
+			Max:    durations[len(durations)-1],
+		}
+		if prior, ok := priorDurations[name]; ok {
+			summary.PriorCount = len(prior)
+			summary.PriorMedian = percentile(prior, 0.5)
+			summary.Regressed = summary.Growth() >= durationRegressionFactor &&
+				summary.Median-summary.PriorMedian >= durationRegressionMinIncrease
+		}
+		summaries = append(summaries, summary)
+	}
+
+	sort.Slice(summaries, func(i, j int) bool {
+		a, b := summaries[i], summaries[j]
+		if a.Median != b.Median {
+			return a.Median > b.Median
+		}
+		if a.P95 != b.P95 {
+			return a.P95 > b.P95
+		}
+		return a.Name < b.Name
+	})
+	return summaries
+}
+
+// durationRegressions returns the summaries of the tests whose durations have
+// regressed, ordered by how much they have grown.
+func durationRegressions(summaries []*testDurations) []*testDurations {
+	var regressions []*testDurations
+	for _, summary := range summaries {
+		if summary.Regressed {
+			regressions = append(regressions, summary)
+		}
+	}
+	sort.SliceStable(regressions, func(i, j int) bool {
+		return regressions[i].Growth() > regressions[j].Growth()
+	})
+	return regressions
+}
+
+// durationsByName returns the sorted durations of the tests by name.
+func durationsByName(tests []*tester.Test) map[string][]time.Duration {
+	durations := make(map[string][]time.Duration)
+	for _, test := range tests {
+		if test.Result == nil || test.Result.State == tester.TBStateSkipped {
+			continue
+		}
+		durations[test.Result.Name] = append(durations[test.Result.Name], test.Result.Duration())
+	}
+	for _, d := range durations {
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+	}
+	return durations
+}
+
+// percentile returns the pth percentile (0 < p <= 1) of the sorted durations
+// using the nearest rank method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
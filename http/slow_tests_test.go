@@ -0,0 +1,123 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nanzhong/tester"
+	"gotest.tools/assert"
+)
+
+// durationTests returns a test of the name for each of the durations.
+func durationTests(name string, state tester.TBState, durations ...time.Duration) []*tester.Test {
+	startedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var tests []*tester.Test
+	for _, d := range durations {
+		tests = append(tests, &tester.Test{
+			Package: "pkg",
+			Result: &tester.T{TB: tester.TB{
+				Name:       name,
+				StartedAt:  startedAt,
+				FinishedAt: startedAt.Add(d),
+				State:      state,
+			}},
+		})
+	}
+	return tests
+}
+
+func TestPercentile(t *testing.T) {
+	var durations []time.Duration
+	for i := 1; i <= 20; i++ {
+		durations = append(durations, time.Duration(i)*time.Second)
+	}
+
+	assert.Equal(t, time.Duration(0), percentile(nil, 0.5))
+	assert.Equal(t, 10*time.Second, percentile(durations, 0.5))
+	assert.Equal(t, 19*time.Second, percentile(durations, 0.95))
+	assert.Equal(t, 20*time.Second, percentile(durations, 1))
+	assert.Equal(t, time.Second, percentile(durations, 0.01))
+	assert.Equal(t, 3*time.Second, percentile(durations[:3], 0.95))
+	assert.Equal(t, 2*time.Second, percentile(durations[:3], 0.5))
+}
+
+func TestSummarizeTestDurations(t *testing.T) {
+	const (
+		ms = time.Millisecond
+		s  = time.Second
+	)
+
+	var tests []*tester.Test
+	// TestA has the highest p95, but a lower median than TestB.
+	tests = append(tests, durationTests("TestA", tester.TBStatePassed, 1*s, 1*s, 2*s, 2*s, 60*s)...)
+	tests = append(tests, durationTests("TestB", tester.TBStatePassed, 5*s, 5*s, 5*s)...)
+	tests = append(tests, durationTests("TestB", tester.TBStateFailed, 6*s)...)
+	// TestC ties TestB's median, but has a lower p95.
+	tests = append(tests, durationTests("TestC", tester.TBStatePassed, 5*s, 5*s)...)
+	// Skipped results are ignored.
+	tests = append(tests, durationTests("TestC", tester.TBStateSkipped, 100*s)...)
+	tests = append(tests, durationTests("TestD", tester.TBStateSkipped, 100*s)...)
+	tests = append(tests, durationTests("TestE", tester.TBStatePassed, 600*ms)...)
+
+	var prior []*tester.Test
+	// TestA's median has doubled.
+	prior = append(prior, durationTests("TestA", tester.TBStatePassed, 1*s, 1*s, 1*s)...)
+	// TestB's median has grown by 2.5x.
+	prior = append(prior, durationTests("TestB", tester.TBStatePassed, 2*s, 2*s, 3*s)...)
+	// TestC's median has grown, but by less than 1.5x.
+	prior = append(prior, durationTests("TestC", tester.TBStatePassed, 4*s)...)
+	// TestE's median has tripled, but only by 400ms.
+	prior = append(prior, durationTests("TestE", tester.TBStatePassed, 200*ms)...)
+
+	summaries := summarizeTestDurations(tests, prior)
+	var names []string
+	for _, summary := range summaries {
+		names = append(names, summary.Name)
+	}
+	assert.DeepEqual(t, []string{"TestB", "TestC", "TestA", "TestE"}, names)
+
+	b, c, a, e := summaries[0], summaries[1], summaries[2], summaries[3]
+	assert.DeepEqual(t, &testDurations{
+		Name:        "TestB",
+		Count:       4,
+		Median:      5 * time.Second,
+		P95:         6 * time.Second,
+		Max:         6 * time.Second,
+		PriorCount:  3,
+		PriorMedian: 2 * time.Second,
+		Regressed:   true,
+	}, b)
+	assert.Equal(t, 2.5, b.Growth())
+
+	assert.Equal(t, 2, c.Count, "skipped results should be ignored")
+	assert.Equal(t, 5*time.Second, c.P95)
+	assert.Assert(t, !c.Regressed)
+
+	assert.Equal(t, 2*time.Second, a.Median)
+	assert.Equal(t, 60*time.Second, a.P95)
+	assert.Equal(t, 2.0, a.Growth())
+	assert.Assert(t, a.Regressed)
+
+	assert.Equal(t, 3.0, e.Growth())
+	assert.Assert(t, !e.Regressed, "growth below the minimum increase should not regress")
+
+	regressions := durationRegressions(summaries)
+	assert.Equal(t, 2, len(regressions))
+	assert.Equal(t, "TestB", regressions[0].Name, "regressions should be ordered by growth")
+	assert.Equal(t, "TestA", regressions[1].Name)
+}
+
+func TestParseSlowTestsWindow(t *testing.T) {
+	window, err := parseSlowTestsWindow("")
+	assert.NilError(t, err)
+	assert.Equal(t, 7*24*time.Hour, window)
+
+	window, err = parseSlowTestsWindow("12h")
+	assert.NilError(t, err)
+	assert.Equal(t, 12*time.Hour, window)
+
+	for _, value := range []string{"soon", "-1d", "0s", "31d"} {
+		_, err := parseSlowTestsWindow(value)
+		assert.Assert(t, err != nil, value)
+	}
+}
@@ -1,26 +1,24 @@
 package http
 
 import (
+	"embed"
 	"fmt"
 	"html/template"
 	"io"
-	"io/ioutil"
-	"os"
+	"io/fs"
 	"strings"
 	"time"
 
-	"github.com/markbates/pkger"
 	"github.com/nanzhong/tester"
 )
 
+//go:embed templates
+var templatesFS embed.FS
+
 type errTemplateNotFound struct {
 	path string
 }
 
-func init() {
-	pkger.Include("/http/templates")
-}
-
 func (e *errTemplateNotFound) Error() string {
 	return fmt.Sprintf("template not found: %s", e.path)
 }
@@ -35,37 +33,35 @@ func (e *errTemplateInvalid) Error() string {
 
 // ExecuteTemplate runs the given template with the value
 func (s *UIHandler) ExecuteTemplate(name string, w io.Writer, value interface{}) error {
-	defaultLayoutPath := "/http/templates/layouts/default.html"
-	file, err := pkger.Open(defaultLayoutPath)
+	return s.executeTemplate(name, w, value, nil, "")
+}
+
+// executeTemplate runs the given template with the value. Inline scripts are
+// tagged with the cspNonce, if there is one.
+func (s *UIHandler) executeTemplate(name string, w io.Writer, value interface{}, quarantined quarantineSet, cspNonce string) error {
+	defaultLayoutPath := "templates/layouts/default.html"
+	layoutContent, err := fs.ReadFile(templatesFS, defaultLayoutPath)
 	if err != nil {
 		return &errTemplateNotFound{defaultLayoutPath}
 	}
-	layoutContent, err := ioutil.ReadAll(file)
-	if err != nil {
-		return &errTemplateInvalid{defaultLayoutPath}
-	}
 
-	layout, err := template.New("layout_default").Funcs(s.templateFuncs()).Parse(string(layoutContent))
+	layout, err := template.New("layout_default").Funcs(s.templateFuncs(quarantined, cspNonce)).Parse(string(layoutContent))
 	if err != nil {
 		return err
 	}
 
-	err = pkger.Walk("/http/templates/shared", func(path string, fileInfo os.FileInfo, err error) error {
+	err = fs.WalkDir(templatesFS, "templates/shared", func(path string, entry fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
-		if fileInfo.IsDir() {
+		if entry.IsDir() {
 			return nil
 		}
 
-		file, err := pkger.Open(path)
-		if err != nil {
-			return &errTemplateNotFound{path}
-		}
-		templateData, err := ioutil.ReadAll(file)
+		templateData, err := fs.ReadFile(templatesFS, path)
 		if err != nil {
-			return &errTemplateInvalid{defaultLayoutPath}
+			return &errTemplateInvalid{path}
 		}
 
 		layout, err = parseTemplate(layout, string(templateData))
@@ -75,12 +71,13 @@ func (s *UIHandler) ExecuteTemplate(name string, w io.Writer, value interface{})
 		return fmt.Errorf("loading shared partial: %w", err)
 	}
 
-	templatePath := "/http/templates/" + name + ".html"
-	file, err = pkger.Open(templatePath)
+	templatePath := "templates/" + name + ".html"
+	file, err := templatesFS.Open(templatePath)
 	if err != nil {
 		return &errTemplateNotFound{templatePath}
 	}
-	templateData, err := ioutil.ReadAll(file)
+	defer file.Close()
+	templateData, err := io.ReadAll(file)
 	if err != nil {
 		return &errTemplateInvalid{templatePath}
 	}
@@ -110,8 +107,29 @@ type subTest struct {
 	NextLevel  int
 }
 
-func (s *UIHandler) templateFuncs() template.FuncMap {
+// quarantineSet is the set of quarantined tests keyed by package and test
+// name.
+type quarantineSet map[[2]string]bool
+
+func newQuarantineSet(quarantined []*tester.QuarantinedTest) quarantineSet {
+	set := make(quarantineSet, len(quarantined))
+	for _, q := range quarantined {
+		set[[2]string{q.Package, q.Name}] = true
+	}
+	return set
+}
+
+func (s *UIHandler) templateFuncs(quarantined quarantineSet, cspNonce string) template.FuncMap {
 	return template.FuncMap{
+		"basePath": func() string {
+			return s.basePath
+		},
+		"cspNonce": func() string {
+			return cspNonce
+		},
+		"quarantined": func(pkg, name string) bool {
+			return quarantined[[2]string{pkg, name}]
+		},
 		"asSubTest": func(parent *tester.T, level int, test *tester.T) subTest {
 			return subTest{
 				ParentTest: parent,
@@ -157,6 +175,9 @@ func (s *UIHandler) templateFuncs() template.FuncMap {
 		"formatLogTime": func(t time.Time) string {
 			return t.Format("15:04:05")
 		},
+		// Log output comes from arbitrary test binaries, so it must be
+		// returned as a string for html/template to escape, never as
+		// template.HTML.
 		"formatLogOutput": func(o []byte) string {
 			return string(o)
 		},
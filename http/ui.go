@@ -3,8 +3,10 @@ package http
 import (
 	"bytes"
 	"context"
+	"encoding/csv"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"sort"
 	"strconv"
@@ -22,8 +24,12 @@ import (
 type UIHandler struct {
 	http.Handler
 
-	db       db.DB
-	packages []*tester.Package
+	db         db.DB
+	packagesMu sync.RWMutex
+	packages   []*tester.Package
+	cfg        UIConfig
+	basePath   string
+	logger     *slog.Logger
 
 	mu                 sync.Mutex
 	hourSummaries      []*tester.RunSummary
@@ -33,53 +39,82 @@ type UIHandler struct {
 }
 
 // NewUIHandler constructs a new `UIHandler`.
-func NewUIHandler(db db.DB, packages []*tester.Package) *UIHandler {
+func NewUIHandler(db db.DB, packages []*tester.Package, opts ...Option) *UIHandler {
+	defOpts := &options{
+		logger: slog.Default(),
+	}
+
+	for _, opt := range opts {
+		opt(defOpts)
+	}
+
 	handler := &UIHandler{
 		db:       db,
 		packages: packages,
-	}
-
-	r := mux.NewRouter()
-	r.HandleFunc("/", LogHandlerFunc(handler.dashboard)).Methods(http.MethodGet)
-	r.HandleFunc("/packages", LogHandlerFunc(handler.listPackages)).Methods(http.MethodGet)
-	r.HandleFunc("/packages/{package}", LogHandlerFunc(handler.getPackage)).Methods(http.MethodGet)
-	r.HandleFunc("/tests/{test_id}", LogHandlerFunc(handler.getTest)).Methods(http.MethodGet)
-	r.HandleFunc("/runs", LogHandlerFunc(handler.listRuns)).Methods(http.MethodGet)
-	r.HandleFunc("/runs/{run_id}", LogHandlerFunc(handler.getRun)).Methods(http.MethodGet)
-	r.HandleFunc("/run_summary", LogHandlerFunc(handler.getRunSummary)).Methods(http.MethodGet)
-	handler.Handler = r
+		cfg:      defOpts.uiConfig.withDefaults(),
+		basePath: defOpts.basePath,
+		logger:   defOpts.logger,
+	}
+
+	root := mux.NewRouter()
+	r := root
+	if handler.basePath != "" {
+		root.Handle(handler.basePath, http.RedirectHandler(handler.basePath+"/", http.StatusMovedPermanently))
+		r = root.PathPrefix(handler.basePath).Subrouter()
+	}
+	r.HandleFunc("/", LogHandlerFunc(handler.logger, handler.dashboard)).Methods(http.MethodGet)
+	r.HandleFunc("/packages", LogHandlerFunc(handler.logger, handler.listPackages)).Methods(http.MethodGet)
+	r.HandleFunc("/packages/{package}", LogHandlerFunc(handler.logger, handler.getPackage)).Methods(http.MethodGet)
+	r.HandleFunc("/packages/{package}/slow", LogHandlerFunc(handler.logger, handler.listSlowTests)).Methods(http.MethodGet)
+	r.HandleFunc("/tests/{test_id}", LogHandlerFunc(handler.logger, handler.getTest)).Methods(http.MethodGet)
+	r.HandleFunc("/runs", LogHandlerFunc(handler.logger, handler.listRuns)).Methods(http.MethodGet)
+	r.HandleFunc("/runs/{run_id}", LogHandlerFunc(handler.logger, handler.getRun)).Methods(http.MethodGet)
+	r.HandleFunc("/runs/{run_id}/reset", LogHandlerFunc(handler.logger, handler.resetRun)).Methods(http.MethodPost)
+	r.HandleFunc("/quarantine", LogHandlerFunc(handler.logger, handler.listQuarantined)).Methods(http.MethodGet)
+	r.HandleFunc("/quarantine", LogHandlerFunc(handler.logger, handler.quarantineTest)).Methods(http.MethodPost)
+	r.HandleFunc("/quarantine/delete", LogHandlerFunc(handler.logger, handler.unquarantineTest)).Methods(http.MethodPost)
+	r.HandleFunc("/run_summary", LogHandlerFunc(handler.logger, handler.getRunSummary)).Methods(http.MethodGet)
+	r.HandleFunc("/run_summary.csv", LogHandlerFunc(handler.logger, handler.exportRunSummary)).Methods(http.MethodGet)
+	handler.Handler = SecurityHeadersMiddleware(handler.cfg.SecurityHeaders)(root)
 
 	return handler
 }
 
+// UpdatePackages replaces the packages presented by the handler.
+func (h *UIHandler) UpdatePackages(packages []*tester.Package) {
+	h.packagesMu.Lock()
+	defer h.packagesMu.Unlock()
+	h.packages = packages
+}
+
 func (h *UIHandler) LoadSummaries(ctx context.Context) (packages []string, month, day, hour []*tester.RunSummary, err error) {
 	// Check to see if we need to refresh stale summary data.
 	h.mu.Lock()
 	diff := time.Now().Sub(h.summariesRefreshAt)
 	h.mu.Unlock()
-	if diff < 5*time.Minute {
+	if diff < h.cfg.HourSummaries.Bucket {
 		return uniquePackages(h.monthSummaries), h.monthSummaries, h.daySummaries, h.hourSummaries, nil
 	}
 
-	now := time.Now().Truncate(5 * time.Minute)
+	now := time.Now().Truncate(h.cfg.HourSummaries.Bucket)
 
-	lastHour := now.Add(-time.Hour)
-	lastDay := now.Add(-24 * time.Hour)
+	lastHour := now.Add(-h.cfg.HourSummaries.Range)
+	lastDay := now.Add(-h.cfg.DaySummaries.Range)
 
 	eg, ctx := errgroup.WithContext(ctx)
 	eg.Go(func() error {
 		var err error
-		hour, err = h.db.ListRunSummariesInRange(ctx, lastHour, now, 5*time.Minute)
+		hour, err = h.db.ListRunSummariesInRange(ctx, lastHour, now, h.cfg.HourSummaries.Bucket)
 		return err
 	})
 	eg.Go(func() error {
 		var err error
-		day, err = h.db.ListRunSummariesInRange(ctx, lastDay, lastHour, time.Hour)
+		day, err = h.db.ListRunSummariesInRange(ctx, lastDay, lastHour, h.cfg.DaySummaries.Bucket)
 		return err
 	})
 	eg.Go(func() error {
 		var err error
-		month, err = h.db.ListRunSummariesInRange(ctx, now.Add(-30*24*time.Hour), lastDay, 12*time.Hour)
+		month, err = h.db.ListRunSummariesInRange(ctx, now.Add(-h.cfg.MonthSummaries.Range), lastDay, h.cfg.MonthSummaries.Bucket)
 		return err
 	})
 	if err := eg.Wait(); err != nil {
@@ -112,14 +147,26 @@ type monthlyRunSummary struct {
 
 type monthlyPackageRunSummary struct {
 	Name           string
+	Labels         tester.Labels
+	Variants       []string
 	HourSummaries  []*tester.RunSummary
 	DaySummaries   []*tester.RunSummary
 	MonthSummaries []*tester.RunSummary
+	// TestCount and FailedTestCount count all of the package's tests and
+	// its failed tests.
+	TestCount       int64
+	FailedTestCount int64
 
 	Height     int
 	HeightDiff int
 }
 
+// variantRuns are the runs of one of a package's variants.
+type variantRuns struct {
+	Variant string
+	Runs    []*tester.Run
+}
+
 type dailyPackageRunSummary struct {
 	Name          string
 	HourSummaries []*tester.RunSummary
@@ -129,7 +176,33 @@ type dailyPackageRunSummary struct {
 	HeightDiff int
 }
 
+// filterPackages returns the packages matching the labels in the request's
+// label query parameters.
+func (h *UIHandler) filterPackages(r *http.Request) ([]*tester.Package, tester.Labels, error) {
+	labels, err := tester.ParseLabels(r.URL.Query()["label"])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h.packagesMu.RLock()
+	defer h.packagesMu.RUnlock()
+
+	var packages []*tester.Package
+	for _, pkg := range h.packages {
+		if pkg.Labels.Matches(labels) {
+			packages = append(packages, pkg)
+		}
+	}
+	return packages, labels, nil
+}
+
 func (h *UIHandler) dashboard(w http.ResponseWriter, r *http.Request) {
+	packages, labels, err := h.filterPackages(r)
+	if err != nil {
+		h.RenderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
 	_, monthSummaries, daySummaries, hourSummaries, err := h.LoadSummaries(r.Context())
 	if err != nil {
 		h.RenderError(w, r, err, http.StatusInternalServerError)
@@ -138,7 +211,7 @@ func (h *UIHandler) dashboard(w http.ResponseWriter, r *http.Request) {
 
 	dailyPackageRunSummaries := make(map[string]*dailyPackageRunSummary)
 
-	for _, pkg := range h.packages {
+	for _, pkg := range packages {
 		dailyPackageRunSummaries[pkg.Name] = &dailyPackageRunSummary{
 			Name:          pkg.Name,
 			HourSummaries: hourSummaries,
@@ -151,10 +224,12 @@ func (h *UIHandler) dashboard(w http.ResponseWriter, r *http.Request) {
 
 	value := &struct {
 		Packages                 []*tester.Package
+		Labels                   tester.Labels
 		OverallMonthlyRunSummary *monthlyRunSummary
 		DailyPackageRunSummaries map[string]*dailyPackageRunSummary
 	}{
-		Packages: h.packages,
+		Packages: packages,
+		Labels:   labels,
 		OverallMonthlyRunSummary: &monthlyRunSummary{
 			HourSummaries:  hourSummaries,
 			DaySummaries:   daySummaries,
@@ -170,20 +245,45 @@ func (h *UIHandler) dashboard(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *UIHandler) listPackages(w http.ResponseWriter, r *http.Request) {
+	packages, _, err := h.filterPackages(r)
+	if err != nil {
+		h.RenderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
 	_, monthSummaries, daySummaries, hourSummaries, err := h.LoadSummaries(r.Context())
 	if err != nil {
 		h.RenderError(w, r, err, http.StatusInternalServerError)
 		return
 	}
 
-	monthlyPackageRunSummaries := make([]*monthlyPackageRunSummary, len(h.packages))
+	monthlyPackageRunSummaries := make([]*monthlyPackageRunSummary, len(packages))
+
+	for i, pkg := range packages {
+		var variants []string
+		if len(pkg.Variants) > 0 {
+			variants = pkg.VariantNames()
+		}
+		testCount, err := h.db.CountTestsForPackage(r.Context(), pkg.Name, "")
+		if err != nil {
+			h.RenderError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		failedTestCount, err := h.db.CountTestsForPackage(r.Context(), pkg.Name, tester.TBStateFailed)
+		if err != nil {
+			h.RenderError(w, r, err, http.StatusInternalServerError)
+			return
+		}
 
-	for i, pkg := range h.packages {
 		monthlyPackageRunSummaries[i] = &monthlyPackageRunSummary{
-			Name:           pkg.Name,
-			HourSummaries:  hourSummaries,
-			DaySummaries:   daySummaries,
-			MonthSummaries: monthSummaries,
+			Name:            pkg.Name,
+			Labels:          pkg.Labels,
+			Variants:        variants,
+			HourSummaries:   hourSummaries,
+			DaySummaries:    daySummaries,
+			MonthSummaries:  monthSummaries,
+			TestCount:       testCount,
+			FailedTestCount: failedTestCount,
 
 			Height:     60,
 			HeightDiff: 10,
@@ -197,11 +297,54 @@ func (h *UIHandler) getPackage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	pkg := vars["package"]
 
-	latestRuns, err := h.db.ListRunsForPackage(r.Context(), pkg, 5)
+	// Runs of packages with variants are grouped by variant, with the latest
+	// runs shown for each.
+	variants := []string{""}
+	h.packagesMu.RLock()
+	for _, p := range h.packages {
+		if p.Name == pkg {
+			variants = p.VariantNames()
+			break
+		}
+	}
+	h.packagesMu.RUnlock()
+
+	runs, err := h.db.ListRunsForPackage(r.Context(), pkg, 5*len(variants), 0)
 	if err != nil {
 		h.RenderError(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	var latestRuns []*variantRuns
+	if len(runs) > 0 {
+		runsByVariant := make(map[string][]*tester.Run)
+		for _, run := range runs {
+			if len(runsByVariant[run.Variant]) < 5 {
+				runsByVariant[run.Variant] = append(runsByVariant[run.Variant], run)
+			}
+		}
+		var runIDs []uuid.UUID
+		for _, variant := range variants {
+			if runs, ok := runsByVariant[variant]; ok {
+				latestRuns = append(latestRuns, &variantRuns{Variant: variant, Runs: runs})
+				for _, run := range runs {
+					runIDs = append(runIDs, run.ID)
+				}
+			}
+		}
+
+		// The run cards summarize the results of the runs' tests, which
+		// listing runs does not load.
+		testsByRunID, err := h.db.GetTestsByRunID(r.Context(), runIDs)
+		if err != nil {
+			h.RenderError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		for _, variantRuns := range latestRuns {
+			for _, run := range variantRuns.Runs {
+				run.Tests = testsByRunID[run.ID]
+			}
+		}
+	}
 
 	now := time.Now().UTC()
 	lastWeek := now.Add(-7 * 24 * time.Hour).UTC()
@@ -211,9 +354,22 @@ func (h *UIHandler) getPackage(w http.ResponseWriter, r *http.Request) {
 		h.RenderError(w, r, err, http.StatusInternalServerError)
 		return
 	}
+	state := tester.TBStateFailed
+	if s := r.URL.Query().Get("state"); s != "" {
+		state = tester.TBState(s)
+		if !state.Valid() {
+			h.RenderError(w, r, fmt.Errorf("invalid state: %s", state), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var stateTests []*tester.Test
 	monthlyTestsByName := make(map[string][]*tester.Test)
 	for _, test := range monthlyTests {
 		monthlyTestsByName[test.Result.Name] = append(monthlyTestsByName[test.Result.Name], test)
+		if test.Result.State == state {
+			stateTests = append(stateTests, test)
+		}
 	}
 
 	packages, monthSummaries, daySummaries, hourSummaries, err := h.LoadSummaries(r.Context())
@@ -241,7 +397,9 @@ func (h *UIHandler) getPackage(w http.ResponseWriter, r *http.Request) {
 	value := &struct {
 		Name                     string
 		MonthlyPackageRunSummary *monthlyPackageRunSummary
-		LatestRuns               []*tester.Run
+		LatestRuns               []*variantRuns
+		State                    tester.TBState
+		StateTests               []*tester.Test
 		TestsByName              map[string][]*tester.Test
 		Now                      time.Time
 		LastWeek                 time.Time
@@ -249,6 +407,8 @@ func (h *UIHandler) getPackage(w http.ResponseWriter, r *http.Request) {
 		Name:                     pkg,
 		MonthlyPackageRunSummary: monthlyRunSummary,
 		LatestRuns:               latestRuns,
+		State:                    state,
+		StateTests:               stateTests,
 		TestsByName:              monthlyTestsByName,
 		Now:                      now,
 		LastWeek:                 lastWeek,
@@ -257,6 +417,45 @@ func (h *UIHandler) getPackage(w http.ResponseWriter, r *http.Request) {
 	h.Render(w, r, "package_details", value)
 }
 
+func (h *UIHandler) listSlowTests(w http.ResponseWriter, r *http.Request) {
+	pkg := mux.Vars(r)["package"]
+
+	windowValue := r.URL.Query().Get("window")
+	window, err := parseSlowTestsWindow(windowValue)
+	if err != nil {
+		h.RenderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+	if windowValue == "" {
+		windowValue = "7d"
+	}
+
+	now := time.Now().UTC()
+	durations, err := listSlowTests(r.Context(), h.db, pkg, now, window)
+	if err != nil {
+		h.RenderError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	regressions := durationRegressions(durations)
+	if len(durations) > maxSlowTestsLimit {
+		durations = durations[:maxSlowTestsLimit]
+	}
+
+	value := &struct {
+		Name        string
+		Window      string
+		Tests       []*testDurations
+		Regressions []*testDurations
+	}{
+		Name:        pkg,
+		Window:      windowValue,
+		Tests:       durations,
+		Regressions: regressions,
+	}
+
+	h.Render(w, r, "package_slow_tests", value)
+}
+
 func (h *UIHandler) getTest(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	testID, err := uuid.Parse(vars["test_id"])
@@ -284,27 +483,120 @@ func (h *UIHandler) getTest(w http.ResponseWriter, r *http.Request) {
 	h.Render(w, r, "test_details", value)
 }
 
+// Run states that /runs can be filtered by.
+const (
+	runStatePending      = "pending"
+	runStateFinished     = "finished"
+	runStateDeadLettered = "dead_lettered"
+)
+
 func (h *UIHandler) listRuns(w http.ResponseWriter, r *http.Request) {
-	pendingRuns, err := h.db.ListPendingRuns(r.Context())
-	if err != nil {
-		log.Printf("failed to list runs: %s", err)
-		h.RenderError(w, r, err, http.StatusInternalServerError)
+	query := r.URL.Query()
+	pkg := query.Get("package")
+	state := query.Get("state")
+	switch state {
+	case "", runStatePending, runStateFinished, runStateDeadLettered:
+	default:
+		h.RenderError(w, r, fmt.Errorf("unknown run state: %s", state), http.StatusBadRequest)
 		return
 	}
+	page := 1
+	if p := query.Get("page"); p != "" {
+		var err error
+		page, err = strconv.Atoi(p)
+		if err != nil || page < 1 {
+			h.RenderError(w, r, fmt.Errorf("invalid page: %s", p), http.StatusBadRequest)
+			return
+		}
+	}
+	offset := (page - 1) * h.cfg.RunsPerPage
+
+	// One more run than is shown is listed to tell whether there is a next
+	// page.
+	var (
+		pendingRuns, finishedRuns, deadLetteredRuns []*tester.Run
+		hasNext                                     bool
+	)
+	paginate := func(runs []*tester.Run) []*tester.Run {
+		if len(runs) > h.cfg.RunsPerPage {
+			hasNext = true
+			return runs[:h.cfg.RunsPerPage]
+		}
+		return runs
+	}
 
-	finishedRuns, err := h.db.ListFinishedRuns(r.Context(), 50)
-	if err != nil {
-		log.Printf("failed to list runs: %s", err)
-		h.RenderError(w, r, err, http.StatusInternalServerError)
-		return
+	if state == "" || state == runStatePending {
+		var err error
+		if pkg != "" {
+			pendingRuns, err = h.db.ListPendingRunsForPackage(r.Context(), pkg)
+		} else {
+			pendingRuns, err = h.db.ListPendingRuns(r.Context())
+		}
+		if err != nil {
+			h.logger.Error("failed to list runs", "err", err)
+			h.RenderError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		// Pending runs are not paginated by the db, as they are expected to
+		// be few.
+		if offset < len(pendingRuns) {
+			pendingRuns = paginate(pendingRuns[offset:])
+		} else {
+			pendingRuns = nil
+		}
+	}
+
+	if state == "" || state == runStateFinished {
+		runs, err := h.db.ListFinishedRuns(r.Context(), pkg, h.cfg.RunsPerPage+1, offset)
+		if err != nil {
+			h.logger.Error("failed to list runs", "err", err)
+			h.RenderError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		finishedRuns = paginate(runs)
+	}
+
+	if state == "" || state == runStateDeadLettered {
+		runs, err := h.db.ListDeadLetteredRuns(r.Context(), pkg, h.cfg.RunsPerPage+1, offset)
+		if err != nil {
+			h.logger.Error("failed to list runs", "err", err)
+			h.RenderError(w, r, err, http.StatusInternalServerError)
+			return
+		}
+		deadLetteredRuns = paginate(runs)
+	}
+
+	h.packagesMu.RLock()
+	packages := make([]string, 0, len(h.packages))
+	for _, p := range h.packages {
+		packages = append(packages, p.Name)
 	}
+	h.packagesMu.RUnlock()
 
 	value := &struct {
-		PendingRuns  []*tester.Run
-		FinishedRuns []*tester.Run
+		PendingRuns      []*tester.Run
+		FinishedRuns     []*tester.Run
+		DeadLetteredRuns []*tester.Run
+		Packages         []string
+		Package          string
+		State            string
+		States           []string
+		Page             int
+		PrevPage         int
+		NextPage         int
+		HasNext          bool
 	}{
-		PendingRuns:  pendingRuns,
-		FinishedRuns: finishedRuns,
+		PendingRuns:      pendingRuns,
+		FinishedRuns:     finishedRuns,
+		DeadLetteredRuns: deadLetteredRuns,
+		Packages:         packages,
+		Package:          pkg,
+		State:            state,
+		States:           []string{runStatePending, runStateFinished, runStateDeadLettered},
+		Page:             page,
+		PrevPage:         page - 1,
+		NextPage:         page + 1,
+		HasNext:          hasNext,
 	}
 
 	h.Render(w, r, "runs", value)
@@ -337,21 +629,104 @@ func (h *UIHandler) getRun(w http.ResponseWriter, r *http.Request) {
 	h.Render(w, r, "run_details", value)
 }
 
-func (h *UIHandler) getRunSummary(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	begin, err := strconv.Atoi(r.URL.Query().Get("begin"))
+func (h *UIHandler) resetRun(w http.ResponseWriter, r *http.Request) {
+	runID, err := uuid.Parse(mux.Vars(r)["run_id"])
 	if err != nil {
-		h.RenderError(w, r, err, http.StatusBadRequest)
+		h.RenderError(w, r, err, http.StatusNotFound)
+		return
+	}
+
+	status, err := resetUnfinishedRun(r.Context(), h.db, runID)
+	if err != nil {
+		h.RenderError(w, r, err, status)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%s/runs/%s", h.basePath, runID), http.StatusSeeOther)
+}
+
+func (h *UIHandler) listQuarantined(w http.ResponseWriter, r *http.Request) {
+	quarantined, err := h.db.ListQuarantined(r.Context())
+	if err != nil {
+		h.RenderError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	h.packagesMu.RLock()
+	packages := make([]string, 0, len(h.packages))
+	for _, pkg := range h.packages {
+		packages = append(packages, pkg.Name)
+	}
+	h.packagesMu.RUnlock()
+	sort.Strings(packages)
+
+	value := &struct {
+		Packages    []string
+		Quarantined []*tester.QuarantinedTest
+	}{
+		Packages:    packages,
+		Quarantined: quarantined,
+	}
+
+	h.Render(w, r, "quarantine", value)
+}
+
+func (h *UIHandler) quarantineTest(w http.ResponseWriter, r *http.Request) {
+	pkgName, testName := r.PostFormValue("package"), r.PostFormValue("name")
+	if pkgName == "" || testName == "" {
+		h.RenderError(w, r, fmt.Errorf("package and test name are required"), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.Quarantine(r.Context(), pkgName, testName); err != nil {
+		h.RenderError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+	h.logger.Info("quarantined test", "package", pkgName, "test", testName)
+
+	http.Redirect(w, r, h.basePath+"/quarantine", http.StatusSeeOther)
+}
+
+func (h *UIHandler) unquarantineTest(w http.ResponseWriter, r *http.Request) {
+	pkgName, testName := r.PostFormValue("package"), r.PostFormValue("name")
+	if err := h.db.Unquarantine(r.Context(), pkgName, testName); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			h.RenderError(w, r, err, http.StatusNotFound)
+		} else {
+			h.RenderError(w, r, err, http.StatusInternalServerError)
+		}
 		return
 	}
-	beginTime := time.Unix(int64(begin), 0)
+	h.logger.Info("unquarantined test", "package", pkgName, "test", testName)
+
+	http.Redirect(w, r, h.basePath+"/quarantine", http.StatusSeeOther)
+}
+
+// parseSummaryWindow parses the begin (unix seconds) and window (seconds) query
+// params of run summary requests.
+func parseSummaryWindow(r *http.Request) (time.Time, time.Duration, error) {
+	begin, err := strconv.Atoi(r.URL.Query().Get("begin"))
+	if err != nil {
+		return time.Time{}, 0, err
+	}
 
 	window, err := strconv.ParseFloat(r.URL.Query().Get("window"), 64)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	if window <= 0 {
+		return time.Time{}, 0, fmt.Errorf("invalid window: %v", window)
+	}
+	return time.Unix(int64(begin), 0), time.Duration(window) * time.Second, nil
+}
+
+func (h *UIHandler) getRunSummary(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	beginTime, windowDuration, err := parseSummaryWindow(r)
 	if err != nil {
 		h.RenderError(w, r, err, http.StatusBadRequest)
 		return
 	}
-	windowDuration := time.Duration(window) * time.Second
 
 	summaries, err := h.db.ListRunSummariesInRange(ctx, beginTime, beginTime.Add(windowDuration), windowDuration)
 	if err != nil {
@@ -369,9 +744,71 @@ func (h *UIHandler) getRunSummary(w http.ResponseWriter, r *http.Request) {
 	h.Render(w, r, "run_summary", value)
 }
 
+// exportRunSummary renders the package summaries of a run summary window as
+// CSV for use in spreadsheets.
+func (h *UIHandler) exportRunSummary(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	beginTime, windowDuration, err := parseSummaryWindow(r)
+	if err != nil {
+		h.RenderError(w, r, err, http.StatusBadRequest)
+		return
+	}
+
+	summaries, err := h.db.ListRunSummariesInRange(ctx, beginTime, beginTime.Add(windowDuration), windowDuration)
+	if err != nil {
+		h.RenderError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	pkgSummaries := make([]*tester.PackageSummary, 0, len(summaries[0].PackageSummary))
+	for _, pkgSummary := range summaries[0].PackageSummary {
+		pkgSummaries = append(pkgSummaries, pkgSummary)
+	}
+	sort.Slice(pkgSummaries, func(i, j int) bool {
+		return pkgSummaries[i].Package < pkgSummaries[j].Package
+	})
+
+	var b bytes.Buffer
+	cw := csv.NewWriter(&b)
+	cw.Write([]string{"package", "runs", "error_runs", "passed_tests", "failed_tests", "skipped_tests", "pass_rate"})
+	for _, s := range pkgSummaries {
+		var passRate float64
+		if s.NumTotalTests() > 0 {
+			passRate = s.PercentPassedTests()
+		}
+		cw.Write([]string{
+			s.Package,
+			strconv.Itoa(len(s.RunIDs)),
+			strconv.Itoa(len(s.ErrorRunIDs)),
+			strconv.Itoa(s.NumPassedTests()),
+			strconv.Itoa(s.NumFailedTests()),
+			strconv.Itoa(s.NumSkippedTests()),
+			strconv.FormatFloat(passRate, 'f', 4, 64),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		h.RenderError(w, r, err, http.StatusInternalServerError)
+		return
+	}
+
+	filename := fmt.Sprintf("run_summary_%s_%s.csv", beginTime.UTC().Format("20060102T150405Z"), windowDuration)
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(http.StatusOK)
+	b.WriteTo(w)
+}
+
 func (h *UIHandler) Render(w http.ResponseWriter, r *http.Request, name string, value interface{}) {
+	// Failing to load the quarantine list only loses the badges, so render the
+	// page regardless.
+	quarantined, err := h.db.ListQuarantined(r.Context())
+	if err != nil {
+		h.logger.Error("failed to list quarantined tests", "err", err)
+	}
+
 	var b bytes.Buffer
-	if err := h.ExecuteTemplate(name, &b, value); err != nil {
+	if err := h.executeTemplate(name, &b, value, newQuarantineSet(quarantined), CSPNonceFromContext(r.Context())); err != nil {
 		h.RenderError(w, r, err, http.StatusInternalServerError)
 		return
 	}
@@ -391,7 +828,7 @@ func (h *UIHandler) RenderError(w http.ResponseWriter, r *http.Request, err erro
 	}
 
 	var b bytes.Buffer
-	if err := h.ExecuteTemplate("error", &b, value); err != nil {
+	if err := h.executeTemplate("error", &b, value, nil, CSPNonceFromContext(r.Context())); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		fmt.Fprintf(w, "%+v", err)
 		return
@@ -0,0 +1,106 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// SummaryWindow is a window of run summaries presented by the UI. A window
+// ends where the next more recent window begins.
+type SummaryWindow struct {
+	// Range is how far back from now the window extends.
+	Range time.Duration
+	// Bucket is the duration summarized by each of the window's summaries.
+	Bucket time.Duration
+}
+
+// UIConfig configures what the UI presents.
+type UIConfig struct {
+	// RunsPerPage is the number of runs of each state shown per page of
+	// /runs.
+	RunsPerPage int
+	// HourSummaries, DaySummaries and MonthSummaries are the windows of run
+	// summaries, from the most to the least recent. The summaries are
+	// refreshed every HourSummaries.Bucket.
+	HourSummaries  SummaryWindow
+	DaySummaries   SummaryWindow
+	MonthSummaries SummaryWindow
+	// SecurityHeaders are set on UI responses, fields that are not set keep
+	// their defaults.
+	SecurityHeaders SecurityHeaders
+}
+
+// DefaultUIConfig returns the default UI configuration.
+func DefaultUIConfig() UIConfig {
+	return UIConfig{
+		RunsPerPage:     50,
+		HourSummaries:   SummaryWindow{Range: time.Hour, Bucket: 5 * time.Minute},
+		DaySummaries:    SummaryWindow{Range: 24 * time.Hour, Bucket: time.Hour},
+		MonthSummaries:  SummaryWindow{Range: 30 * 24 * time.Hour, Bucket: 12 * time.Hour},
+		SecurityHeaders: DefaultSecurityHeaders(),
+	}
+}
+
+// withDefaults returns the config with the fields that are not set replaced
+// by their defaults.
+func (c UIConfig) withDefaults() UIConfig {
+	def := DefaultUIConfig()
+	if c.RunsPerPage == 0 {
+		c.RunsPerPage = def.RunsPerPage
+	}
+	if c.HourSummaries == (SummaryWindow{}) {
+		c.HourSummaries = def.HourSummaries
+	}
+	if c.DaySummaries == (SummaryWindow{}) {
+		c.DaySummaries = def.DaySummaries
+	}
+	if c.MonthSummaries == (SummaryWindow{}) {
+		c.MonthSummaries = def.MonthSummaries
+	}
+	if c.SecurityHeaders.ContentSecurityPolicy == "" {
+		c.SecurityHeaders.ContentSecurityPolicy = def.SecurityHeaders.ContentSecurityPolicy
+	}
+	if c.SecurityHeaders.FrameOptions == "" {
+		c.SecurityHeaders.FrameOptions = def.SecurityHeaders.FrameOptions
+	}
+	if c.SecurityHeaders.ReferrerPolicy == "" {
+		c.SecurityHeaders.ReferrerPolicy = def.SecurityHeaders.ReferrerPolicy
+	}
+	return c
+}
+
+// Validate checks that the config, with defaults for the fields that are not
+// set, is usable.
+func (c UIConfig) Validate() error {
+	c = c.withDefaults()
+
+	var errs []error
+	if c.RunsPerPage < 0 {
+		errs = append(errs, errors.New("runs per page must be positive"))
+	}
+	var prev SummaryWindow
+	for _, w := range []struct {
+		name   string
+		window SummaryWindow
+	}{
+		{"hour", c.HourSummaries},
+		{"day", c.DaySummaries},
+		{"month", c.MonthSummaries},
+	} {
+		if w.window.Bucket <= 0 {
+			errs = append(errs, fmt.Errorf("%s summaries: bucket must be positive", w.name))
+		}
+		if w.window.Range <= prev.Range {
+			errs = append(errs, fmt.Errorf("%s summaries: range must be longer than the previous window's", w.name))
+		}
+		prev = w.window
+	}
+	switch strings.ToUpper(c.SecurityHeaders.FrameOptions) {
+	case "DENY", "SAMEORIGIN":
+	default:
+		errs = append(errs, fmt.Errorf("security headers: frame options must be DENY or SAMEORIGIN, not %s", c.SecurityHeaders.FrameOptions))
+	}
+	return errors.Join(errs...)
+}
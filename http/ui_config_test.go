@@ -0,0 +1,28 @@
+package http
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gotest.tools/assert"
+)
+
+func TestUIConfig_Validate(t *testing.T) {
+	assert.NilError(t, UIConfig{}.Validate())
+	assert.NilError(t, DefaultUIConfig().Validate())
+
+	err := UIConfig{
+		RunsPerPage:    -1,
+		HourSummaries:  SummaryWindow{Range: 2 * time.Hour, Bucket: 10 * time.Minute},
+		DaySummaries:   SummaryWindow{Range: time.Hour, Bucket: time.Hour},
+		MonthSummaries: SummaryWindow{Range: 30 * 24 * time.Hour},
+	}.Validate()
+	require.Error(t, err)
+	assert.ErrorContains(t, err, "runs per page must be positive")
+	assert.ErrorContains(t, err, "day summaries: range must be longer")
+	assert.ErrorContains(t, err, "month summaries: bucket must be positive")
+
+	err = UIConfig{SecurityHeaders: SecurityHeaders{FrameOptions: "ALLOW-FROM https://example.com"}}.Validate()
+	assert.ErrorContains(t, err, "security headers: frame options must be DENY or SAMEORIGIN")
+}
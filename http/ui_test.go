@@ -0,0 +1,806 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/db"
+	"github.com/stretchr/testify/require"
+	"gotest.tools/assert"
+)
+
+func withUIHandler(t *testing.T, fn func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB)) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := db.NewMockDB(ctrl)
+	mockDB.EXPECT().ListQuarantined(gomock.Any()).Return(nil, nil).AnyTimes()
+	ui := NewUIHandler(mockDB, []*tester.Package{{
+		Name:   "pkg",
+		Labels: tester.Labels{"team": "payments"},
+	}})
+	ts := httptest.NewServer(ui)
+	defer ts.Close()
+
+	fn(ts, ui, mockDB)
+}
+
+func TestUIHandler_templates(t *testing.T) {
+	now := time.Now().UTC()
+	run := &tester.Run{
+		ID:         uuid.New(),
+		Package:    "pkg",
+		Args:       []string{"-test.run=TestA"},
+		Meta:       tester.RunMeta{Runner: "runner", Race: true},
+		EnqueuedAt: now.Add(-time.Minute),
+		StartedAt:  now.Add(-time.Minute),
+		FinishedAt: now,
+	}
+	test := &tester.Test{
+		ID:      uuid.New(),
+		Package: "pkg",
+		RunID:   run.ID,
+		Result: &tester.T{
+			TB: tester.TB{
+				Name:         "TestA",
+				StartedAt:    now.Add(-time.Minute),
+				FinishedAt:   now,
+				State:        tester.TBStateFailed,
+				ErrorMessage: "a_test.go:10: boom",
+			},
+			SubTs: []*tester.T{{
+				TB: tester.TB{
+					Name:       "TestA/sub",
+					StartedAt:  now.Add(-time.Minute),
+					FinishedAt: now,
+					State:      tester.TBStateFailed,
+				},
+				Logs: []tester.TBLog{{
+					Time:   now,
+					Name:   "TestA/sub",
+					Output: []byte("    a_test.go:12: sub boom\n"),
+				}},
+			}},
+		},
+		Logs: []tester.TBLog{{
+			Time:   now,
+			Name:   "TestA",
+			Output: []byte("    a_test.go:10: boom\n"),
+		}},
+	}
+	run.Tests = []*tester.Test{test}
+	deadLetteredRun := &tester.Run{
+		ID:               uuid.New(),
+		Package:          "pkg",
+		EnqueuedAt:       now.Add(-25 * time.Hour),
+		DeadLetteredAt:   now,
+		DeadLetterReason: "reason",
+	}
+
+	listRunSummaries := func(_ interface{}, begin, end time.Time, window time.Duration) ([]*tester.RunSummary, error) {
+		return []*tester.RunSummary{{
+			Time:     begin,
+			Duration: window,
+			PackageSummary: map[string]*tester.PackageSummary{
+				"pkg": {
+					Package:      "pkg",
+					RunIDs:       []uuid.UUID{run.ID},
+					FailedRunIDs: []uuid.UUID{run.ID},
+					PassedTests:  map[string][]uuid.UUID{},
+					FailedTests:  map[string][]uuid.UUID{"TestA": {test.ID}},
+					SkippedTests: map[string][]uuid.UUID{},
+				},
+			},
+		}}, nil
+	}
+
+	for _, tc := range []struct {
+		template string
+		path     string
+		expect   func(mockDB *db.MockDB)
+	}{
+		{
+			template: "dashboard",
+			path:     "/?label=team:payments",
+		},
+		{
+			template: "packages",
+			path:     "/packages",
+			expect: func(mockDB *db.MockDB) {
+				mockDB.EXPECT().CountTestsForPackage(gomock.Any(), "pkg", gomock.Any()).Return(int64(0), nil).Times(2)
+			},
+		},
+		{
+			template: "package_details",
+			path:     "/packages/pkg",
+			expect: func(mockDB *db.MockDB) {
+				mockDB.EXPECT().ListRunsForPackage(gomock.Any(), "pkg", 5, 0).Return([]*tester.Run{run}, nil)
+				mockDB.EXPECT().GetTestsByRunID(gomock.Any(), []uuid.UUID{run.ID}).Return(map[uuid.UUID][]*tester.Test{run.ID: {test}}, nil)
+				mockDB.EXPECT().ListTestsForPackageInRange(gomock.Any(), "pkg", gomock.Any(), gomock.Any()).Return([]*tester.Test{test}, nil)
+			},
+		},
+		{
+			template: "package_slow_tests",
+			path:     "/packages/pkg/slow?window=1d",
+			expect: func(mockDB *db.MockDB) {
+				mockDB.EXPECT().ListTestsForPackageInRange(gomock.Any(), "pkg", gomock.Any(), gomock.Any()).Return([]*tester.Test{test}, nil)
+			},
+		},
+		{
+			template: "test_details",
+			path:     fmt.Sprintf("/tests/%s", test.ID),
+			expect: func(mockDB *db.MockDB) {
+				mockDB.EXPECT().GetTest(gomock.Any(), test.ID).Return(test, nil)
+			},
+		},
+		{
+			template: "runs",
+			path:     "/runs",
+			expect: func(mockDB *db.MockDB) {
+				mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return([]*tester.Run{{ID: uuid.New(), Package: "pkg", EnqueuedAt: now}}, nil)
+				mockDB.EXPECT().ListFinishedRuns(gomock.Any(), "", 51, 0).Return([]*tester.Run{run}, nil)
+				mockDB.EXPECT().ListDeadLetteredRuns(gomock.Any(), "", 51, 0).Return([]*tester.Run{deadLetteredRun}, nil)
+			},
+		},
+		{
+			template: "run_details",
+			path:     fmt.Sprintf("/runs/%s", run.ID),
+			expect: func(mockDB *db.MockDB) {
+				mockDB.EXPECT().GetRun(gomock.Any(), run.ID).Return(run, nil)
+			},
+		},
+		{
+			template: "run_summary",
+			path:     fmt.Sprintf("/run_summary?begin=%d&window=3600", now.Unix()),
+		},
+		{
+			template: "quarantine",
+			path:     "/quarantine",
+		},
+	} {
+		tc := tc
+		t.Run(tc.template, func(t *testing.T) {
+			withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+				mockDB.EXPECT().ListRunSummariesInRange(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(listRunSummaries).AnyTimes()
+				if tc.expect != nil {
+					tc.expect(mockDB)
+				}
+
+				resp, err := ts.Client().Get(ts.URL + tc.path)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				body, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+			})
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			resp, err := ts.Client().Get(ts.URL + "/tests/invalid")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+		})
+	})
+
+	t.Run("custom summary windows", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := db.NewMockDB(ctrl)
+		ui := NewUIHandler(mockDB, nil, WithUIConfig(UIConfig{
+			HourSummaries:  SummaryWindow{Range: 2 * time.Hour, Bucket: 10 * time.Minute},
+			DaySummaries:   SummaryWindow{Range: 7 * 24 * time.Hour, Bucket: 6 * time.Hour},
+			MonthSummaries: SummaryWindow{Range: 90 * 24 * time.Hour, Bucket: 24 * time.Hour},
+		}))
+
+		type window struct{ span, bucket time.Duration }
+		var (
+			mu      sync.Mutex
+			windows []window
+			ends    = map[time.Duration]time.Time{}
+		)
+		mockDB.EXPECT().ListRunSummariesInRange(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(_ interface{}, begin, end time.Time, bucket time.Duration) ([]*tester.RunSummary, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			windows = append(windows, window{end.Sub(begin), bucket})
+			ends[bucket] = end
+			return nil, nil
+		}).Times(3)
+
+		_, _, _, _, err := ui.LoadSummaries(context.Background())
+		require.NoError(t, err)
+
+		for _, w := range []window{
+			{2 * time.Hour, 10 * time.Minute},
+			{7*24*time.Hour - 2*time.Hour, 6 * time.Hour},
+			{90*24*time.Hour - 7*24*time.Hour, 24 * time.Hour},
+		} {
+			found := false
+			for _, got := range windows {
+				found = found || got == w
+			}
+			assert.Assert(t, found, "missing window %v in %v", w, windows)
+		}
+		now := ends[10*time.Minute]
+		assert.Equal(t, now, now.Truncate(10*time.Minute))
+		assert.Equal(t, now.Add(-2*time.Hour), ends[6*time.Hour])
+		assert.Equal(t, now.Add(-7*24*time.Hour), ends[24*time.Hour])
+		assert.Equal(t, 50, ui.cfg.RunsPerPage, "unset fields should keep their defaults")
+	})
+
+	t.Run("run_summary csv", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			begin := time.Unix(1600000000, 0)
+			mockDB.EXPECT().ListRunSummariesInRange(gomock.Any(), begin, begin.Add(time.Hour), time.Hour).Return([]*tester.RunSummary{{
+				Time:     begin,
+				Duration: time.Hour,
+				PackageSummary: map[string]*tester.PackageSummary{
+					"pkg-b": {
+						Package:      "pkg-b",
+						RunIDs:       []uuid.UUID{uuid.New(), uuid.New()},
+						ErrorRunIDs:  []uuid.UUID{uuid.New()},
+						PassedTests:  map[string][]uuid.UUID{"TestA": {uuid.New(), uuid.New()}},
+						FailedTests:  map[string][]uuid.UUID{"TestB": {uuid.New()}},
+						SkippedTests: map[string][]uuid.UUID{"TestC": {uuid.New()}},
+					},
+					"pkg-a": {
+						Package: "pkg-a",
+						RunIDs:  []uuid.UUID{uuid.New()},
+					},
+				},
+			}}, nil)
+
+			resp, err := ts.Client().Get(ts.URL + "/run_summary.csv?begin=1600000000&window=3600")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+			assert.Equal(t, "text/csv; charset=utf-8", resp.Header.Get("Content-Type"))
+			assert.Equal(t, `attachment; filename="run_summary_20200913T122640Z_1h0m0s.csv"`, resp.Header.Get("Content-Disposition"))
+			assert.Equal(t, "package,runs,error_runs,passed_tests,failed_tests,skipped_tests,pass_rate\n"+
+				"pkg-a,1,0,0,0,0,0.0000\n"+
+				"pkg-b,2,1,2,1,1,0.5000\n", string(body))
+		})
+	})
+
+	t.Run("run_summary csv invalid window", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			resp, err := ts.Client().Get(ts.URL + "/run_summary.csv?begin=1600000000&window=0")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+		})
+	})
+
+	t.Run("runs filters", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			finishedRuns := make([]*tester.Run, 51)
+			for i := range finishedRuns {
+				finishedRuns[i] = &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: now, FinishedAt: now}
+			}
+			mockDB.EXPECT().ListFinishedRuns(gomock.Any(), "pkg", 51, 50).Return(finishedRuns, nil)
+
+			resp, err := ts.Client().Get(ts.URL + "/runs?page=2&package=pkg&state=finished")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+			assert.Assert(t, !strings.Contains(string(body), "Pending Runs"))
+			assert.Assert(t, !strings.Contains(string(body), finishedRuns[50].ID.String()), "extra run should only be used to detect the next page")
+			assert.Assert(t, strings.Contains(string(body), `href="/runs?page=1&package=pkg&state=finished"`))
+			assert.Assert(t, strings.Contains(string(body), `href="/runs?page=3&package=pkg&state=finished"`))
+		})
+	})
+
+	t.Run("runs pending for package", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), "pkg").Return([]*tester.Run{{ID: uuid.New(), Package: "pkg", EnqueuedAt: now}}, nil)
+
+			resp, err := ts.Client().Get(ts.URL + "/runs?package=pkg&state=pending")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+		})
+	})
+
+	t.Run("runs invalid filters", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			for _, query := range []string{"state=running", "page=0", "page=next"} {
+				resp, err := ts.Client().Get(ts.URL + "/runs?" + query)
+				require.NoError(t, err)
+				resp.Body.Close()
+
+				assert.Equal(t, http.StatusBadRequest, resp.StatusCode, query)
+			}
+		})
+	})
+
+	t.Run("run_details reset", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			running := &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: now, StartedAt: now}
+			mockDB.EXPECT().GetRun(gomock.Any(), running.ID).Return(running, nil)
+
+			resp, err := ts.Client().Get(fmt.Sprintf("%s/runs/%s", ts.URL, running.ID))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+			assert.Assert(t, strings.Contains(string(body), fmt.Sprintf(`action="/runs/%s/reset"`, running.ID)))
+
+			mockDB.EXPECT().GetRunMetadata(gomock.Any(), running.ID).Return(running, nil)
+			mockDB.EXPECT().ResetRun(gomock.Any(), running.ID).Return(nil)
+			client := ts.Client()
+			client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+			resp, err = client.Post(fmt.Sprintf("%s/runs/%s/reset", ts.URL, running.ID), "", nil)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+			assert.Equal(t, fmt.Sprintf("/runs/%s", running.ID), resp.Header.Get("Location"))
+		})
+	})
+
+	t.Run("quarantine", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := db.NewMockDB(ctrl)
+		ts := httptest.NewServer(NewUIHandler(mockDB, []*tester.Package{{Name: "pkg"}}))
+		defer ts.Close()
+
+		mockDB.EXPECT().ListQuarantined(gomock.Any()).Return([]*tester.QuarantinedTest{
+			{Package: "pkg", Name: "TestA", QuarantinedAt: now},
+		}, nil).AnyTimes()
+		mockDB.EXPECT().GetTest(gomock.Any(), test.ID).Return(test, nil)
+
+		resp, err := ts.Client().Get(fmt.Sprintf("%s/tests/%s", ts.URL, test.ID))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		assert.Assert(t, strings.Contains(string(body), `<span class="badge bg-secondary">quarantined</span>`))
+		assert.Assert(t, strings.Contains(string(body), `action="/quarantine/delete"`))
+
+		client := ts.Client()
+		client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+		mockDB.EXPECT().Quarantine(gomock.Any(), "pkg", "TestB").Return(nil)
+		resp, err = client.PostForm(ts.URL+"/quarantine", url.Values{"package": {"pkg"}, "name": {"TestB"}})
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+		assert.Equal(t, "/quarantine", resp.Header.Get("Location"))
+
+		resp, err = client.PostForm(ts.URL+"/quarantine", url.Values{"package": {"pkg"}})
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		mockDB.EXPECT().Unquarantine(gomock.Any(), "pkg", "TestA").Return(nil)
+		resp, err = client.PostForm(ts.URL+"/quarantine/delete", url.Values{"package": {"pkg"}, "name": {"TestA"}})
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+
+		mockDB.EXPECT().Unquarantine(gomock.Any(), "pkg", "TestC").Return(db.ErrNotFound)
+		resp, err = client.PostForm(ts.URL+"/quarantine/delete", url.Values{"package": {"pkg"}, "name": {"TestC"}})
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	t.Run("package_details variants", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			ui.UpdatePackages([]*tester.Package{{
+				Name:     "pkg",
+				Variants: []tester.Variant{{Name: "go1"}, {Name: "go2"}},
+			}})
+
+			var runs []*tester.Run
+			for _, variant := range []string{"go2", "go1", "go2"} {
+				runs = append(runs, &tester.Run{ID: uuid.New(), Package: "pkg", Variant: variant, EnqueuedAt: now})
+			}
+			mockDB.EXPECT().ListRunSummariesInRange(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(listRunSummaries).AnyTimes()
+			mockDB.EXPECT().ListRunsForPackage(gomock.Any(), "pkg", 10, 0).Return(runs, nil)
+			// The tests of the runs are fetched in one batch to summarize them.
+			passed := &tester.Test{ID: uuid.New(), RunID: runs[1].ID, Result: &tester.T{TB: tester.TB{Name: "TestA", State: tester.TBStatePassed}}}
+			mockDB.EXPECT().GetTestsByRunID(gomock.Any(), []uuid.UUID{runs[1].ID, runs[0].ID, runs[2].ID}).
+				Return(map[uuid.UUID][]*tester.Test{runs[1].ID: {passed}}, nil)
+			mockDB.EXPECT().ListTestsForPackageInRange(gomock.Any(), "pkg", gomock.Any(), gomock.Any()).Return(nil, nil)
+
+			resp, err := ts.Client().Get(ts.URL + "/packages/pkg")
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+			go1 := strings.Index(string(body), `<h3 class="h5 mt-3">go1</h3>`)
+			go2 := strings.Index(string(body), `<h3 class="h5 mt-3">go2</h3>`)
+			assert.Assert(t, go1 >= 0 && go2 > go1, "variants should be grouped in configured order")
+			assert.Assert(t, strings.Contains(string(body), "100.0%"), "run card should summarize the run's tests")
+		})
+	})
+}
+
+func TestUIHandler_listPackages_testCounts(t *testing.T) {
+	withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+		mockDB.EXPECT().ListRunSummariesInRange(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+		mockDB.EXPECT().CountTestsForPackage(gomock.Any(), "pkg", tester.TBState("")).Return(int64(42), nil)
+		mockDB.EXPECT().CountTestsForPackage(gomock.Any(), "pkg", tester.TBStateFailed).Return(int64(3), nil)
+
+		resp, err := ts.Client().Get(ts.URL + "/packages")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		assert.Assert(t, strings.Contains(string(body), "42 tests"))
+		assert.Assert(t, strings.Contains(string(body), "3 failed"))
+	})
+}
+
+func TestUIHandler_listSlowTests(t *testing.T) {
+	withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+		now := time.Now().UTC()
+		newTest := func(name string, startedAt time.Time, d time.Duration) *tester.Test {
+			return &tester.Test{
+				ID:      uuid.New(),
+				Package: "pkg",
+				Result: &tester.T{TB: tester.TB{
+					Name:       name,
+					StartedAt:  startedAt,
+					FinishedAt: startedAt.Add(d),
+					State:      tester.TBStatePassed,
+				}},
+			}
+		}
+		mockDB.EXPECT().ListTestsForPackageInRange(gomock.Any(), "pkg", gomock.Any(), gomock.Any()).Return([]*tester.Test{
+			newTest("TestFast", now.Add(-time.Hour), time.Second),
+			newTest("TestSlow", now.Add(-time.Hour), 50*time.Second),
+			newTest("TestSlow", now.Add(-8*24*time.Hour), 20*time.Second),
+		}, nil)
+
+		resp, err := ts.Client().Get(ts.URL + "/packages/pkg/slow")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		assert.Assert(t, strings.Contains(string(body), "2.5x"), "regression should show its growth")
+		slow := strings.LastIndex(string(body), "TestSlow")
+		fast := strings.LastIndex(string(body), "TestFast")
+		assert.Assert(t, fast >= 0 && slow >= 0 && slow < fast, "tests should be ordered from slowest")
+
+		resp, err = ts.Client().Get(ts.URL + "/packages/pkg/slow?window=soon")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestUIHandler_basePath(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := db.NewMockDB(ctrl)
+	ts := httptest.NewServer(NewUIHandler(mockDB, []*tester.Package{{Name: "pkg"}}, WithBasePath("/tester/")))
+	defer ts.Close()
+
+	client := ts.Client()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+
+	mockDB.EXPECT().ListQuarantined(gomock.Any()).Return([]*tester.QuarantinedTest{
+		{Package: "pkg", Name: "TestA", QuarantinedAt: time.Now()},
+	}, nil).AnyTimes()
+
+	resp, err := client.Get(ts.URL + "/tester/quarantine")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+	for _, link := range []string{
+		`href="/tester/"`,
+		`href="/tester/runs"`,
+		`href="/tester/packages/pkg"`,
+		`action="/tester/quarantine/delete"`,
+	} {
+		assert.Assert(t, strings.Contains(string(body), link), link)
+	}
+
+	resp, err = client.Get(ts.URL + "/quarantine")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, err = client.Get(ts.URL + "/tester")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusMovedPermanently, resp.StatusCode)
+	assert.Equal(t, "/tester/", resp.Header.Get("Location"))
+
+	mockDB.EXPECT().Unquarantine(gomock.Any(), "pkg", "TestA").Return(nil)
+	resp, err = client.PostForm(ts.URL+"/tester/quarantine/delete", url.Values{"package": {"pkg"}, "name": {"TestA"}})
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusSeeOther, resp.StatusCode)
+	assert.Equal(t, "/tester/quarantine", resp.Header.Get("Location"))
+}
+
+func TestUIHandler_securityHeaders(t *testing.T) {
+	now := time.Now().UTC()
+	test := &tester.Test{
+		ID:      uuid.New(),
+		Package: "pkg",
+		RunID:   uuid.New(),
+		Result: &tester.T{
+			TB: tester.TB{
+				Name:       "TestA",
+				StartedAt:  now,
+				FinishedAt: now,
+				State:      tester.TBStateFailed,
+			},
+		},
+		Logs: []tester.TBLog{{
+			Time:   now,
+			Name:   "TestA",
+			Output: []byte("    a_test.go:10: boom\n"),
+		}},
+	}
+
+	t.Run("defaults", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			mockDB.EXPECT().GetTest(gomock.Any(), test.ID).Return(test, nil)
+
+			resp, err := ts.Client().Get(fmt.Sprintf("%s/tests/%s", ts.URL, test.ID))
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+			assert.Equal(t, "nosniff", resp.Header.Get("X-Content-Type-Options"))
+			assert.Equal(t, "DENY", resp.Header.Get("X-Frame-Options"))
+			assert.Equal(t, "same-origin", resp.Header.Get("Referrer-Policy"))
+
+			// The inline scripts are tagged with the response's nonce.
+			csp := resp.Header.Get("Content-Security-Policy")
+			nonce := regexp.MustCompile(`'nonce-([^']+)'`).FindStringSubmatch(csp)
+			require.Len(t, nonce, 2, csp)
+			assert.Assert(t, strings.Contains(string(body), fmt.Sprintf(`<script nonce="%s">`, nonce[1])))
+
+		})
+	})
+
+	t.Run("nonce per response", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			var csps []string
+			for i := 0; i < 2; i++ {
+				resp, err := ts.Client().Get(ts.URL + "/quarantine")
+				require.NoError(t, err)
+				resp.Body.Close()
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				csps = append(csps, resp.Header.Get("Content-Security-Policy"))
+			}
+			assert.Assert(t, csps[0] != csps[1])
+		})
+	})
+
+	t.Run("configured", func(t *testing.T) {
+		ctrl := gomock.NewController(t)
+		defer ctrl.Finish()
+
+		mockDB := db.NewMockDB(ctrl)
+		mockDB.EXPECT().ListQuarantined(gomock.Any()).Return(nil, nil).AnyTimes()
+		ts := httptest.NewServer(NewUIHandler(mockDB, []*tester.Package{{Name: "pkg"}}, WithUIConfig(UIConfig{
+			SecurityHeaders: SecurityHeaders{
+				ContentSecurityPolicy: "default-src 'self'; script-src 'nonce-" + CSPNonce + "'",
+				FrameOptions:          "SAMEORIGIN",
+			},
+		})))
+		defer ts.Close()
+
+		resp, err := ts.Client().Get(ts.URL + "/quarantine")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Assert(t, regexp.MustCompile(`^default-src 'self'; script-src 'nonce-[A-Za-z0-9_-]+'$`).MatchString(resp.Header.Get("Content-Security-Policy")))
+		assert.Equal(t, "SAMEORIGIN", resp.Header.Get("X-Frame-Options"))
+		assert.Equal(t, "same-origin", resp.Header.Get("Referrer-Policy"))
+	})
+}
+
+func TestUIHandler_errorMessages(t *testing.T) {
+	now := time.Now().UTC()
+	newTest := func(runID uuid.UUID, errorMessage string) *tester.Test {
+		return &tester.Test{
+			ID:      uuid.New(),
+			Package: "pkg",
+			RunID:   runID,
+			Result: &tester.T{TB: tester.TB{
+				Name:         "TestA",
+				StartedAt:    now.Add(-time.Second),
+				FinishedAt:   now,
+				State:        tester.TBStateFailed,
+				ErrorMessage: errorMessage,
+			}},
+			Logs: []tester.TBLog{{Time: now, Name: "TestA", Output: []byte("    a_test.go:10: boom\n")}},
+		}
+	}
+	get := func(t *testing.T, ts *httptest.Server, path string) string {
+		resp, err := ts.Client().Get(ts.URL + path)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+		return string(body)
+	}
+	const errorAlert = `<div class="alert alert-danger" role="alert">`
+
+	t.Run("test details", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			test := newTest(uuid.New(), "a_test.go:10: boom")
+			mockDB.EXPECT().GetTest(gomock.Any(), test.ID).Return(test, nil)
+
+			body := get(t, ts, fmt.Sprintf("/tests/%s", test.ID))
+			assert.Assert(t, strings.Contains(body, errorAlert+"\n      <code>a_test.go:10: boom</code>"), body)
+		})
+	})
+
+	t.Run("test details without error message", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			test := newTest(uuid.New(), "")
+			mockDB.EXPECT().GetTest(gomock.Any(), test.ID).Return(test, nil)
+
+			body := get(t, ts, fmt.Sprintf("/tests/%s", test.ID))
+			assert.Assert(t, !strings.Contains(body, errorAlert), body)
+		})
+	})
+
+	t.Run("run with failed test", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			run := &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: now, StartedAt: now, FinishedAt: now}
+			run.Tests = []*tester.Test{newTest(run.ID, "a_test.go:10: boom")}
+			mockDB.EXPECT().GetRun(gomock.Any(), run.ID).Return(run, nil)
+
+			body := get(t, ts, fmt.Sprintf("/runs/%s", run.ID))
+			assert.Assert(t, strings.Contains(body, "Run completed"), body)
+			assert.Assert(t, strings.Contains(body, "/tests/"+run.Tests[0].ID.String()), "run should link to its failed test")
+			assert.Assert(t, strings.Contains(body, "a_test.go:10: boom"), "run should show the failed test's logs")
+		})
+	})
+
+	t.Run("failed run", func(t *testing.T) {
+		withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+			run := &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: now, StartedAt: now, FinishedAt: now, Error: "test binary exited: exit status 2"}
+			mockDB.EXPECT().GetRun(gomock.Any(), run.ID).Return(run, nil)
+
+			body := get(t, ts, fmt.Sprintf("/runs/%s", run.ID))
+			assert.Assert(t, strings.Contains(body, "Run failed"), body)
+			assert.Assert(t, strings.Contains(body, "<pre><code>test binary exited: exit status 2</code></pre>"), body)
+		})
+	})
+}
+
+func TestUIHandler_logsEscaped(t *testing.T) {
+	const script = "<script>alert(1)</script>"
+	now := time.Now().UTC()
+	runID := uuid.New()
+
+	// Submit a test whose logs, including its sub test's, and error contain
+	// html, as a test binary could, capturing what would be stored.
+	var submitted *tester.Test
+	withAPIHandler(t, func(ts *httptest.Server, api *APIHandler, mockDB *db.MockDB) {
+		test := &tester.Test{
+			ID:      uuid.New(),
+			Package: "pkg",
+			RunID:   runID,
+			Result: &tester.T{
+				TB: tester.TB{
+					Name:         "TestA",
+					StartedAt:    now,
+					FinishedAt:   now,
+					State:        tester.TBStatePassed,
+					ErrorMessage: "a_test.go:10: " + script,
+				},
+				SubTs: []*tester.T{{
+					TB:   tester.TB{Name: "TestA/sub", StartedAt: now, FinishedAt: now, State: tester.TBStatePassed},
+					Logs: []tester.TBLog{{Time: now, Name: "TestA/sub", Output: []byte("    a_test.go:12: " + script + "\n")}},
+				}},
+			},
+			Logs: []tester.TBLog{{Time: now, Name: "TestA", Output: []byte("    a_test.go:10: " + script + "\n")}},
+		}
+		reqBody, err := json.Marshal(test)
+		require.NoError(t, err)
+		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s/api/tests", ts.URL), bytes.NewBuffer(reqBody))
+		require.NoError(t, err)
+		addAuth(req)
+
+		mockDB.EXPECT().GetRunMetadata(gomock.Any(), runID).Return(&tester.Run{ID: runID, Package: "pkg"}, nil)
+		mockDB.EXPECT().AddTest(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, test *tester.Test) error {
+			submitted = test
+			return nil
+		})
+
+		resp, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+	})
+	require.NotNil(t, submitted)
+
+	for _, tc := range []struct {
+		name   string
+		path   string
+		expect func(mockDB *db.MockDB)
+	}{
+		{
+			name: "test details",
+			path: fmt.Sprintf("/tests/%s", submitted.ID),
+			expect: func(mockDB *db.MockDB) {
+				mockDB.EXPECT().GetTest(gomock.Any(), submitted.ID).Return(submitted, nil)
+			},
+		},
+		{
+			name: "run details",
+			path: fmt.Sprintf("/runs/%s", runID),
+			expect: func(mockDB *db.MockDB) {
+				run := &tester.Run{ID: runID, Package: "pkg", EnqueuedAt: now, StartedAt: now, FinishedAt: now, Tests: []*tester.Test{submitted}}
+				mockDB.EXPECT().GetRun(gomock.Any(), runID).Return(run, nil)
+			},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			withUIHandler(t, func(ts *httptest.Server, ui *UIHandler, mockDB *db.MockDB) {
+				tc.expect(mockDB)
+
+				resp, err := ts.Client().Get(ts.URL + tc.path)
+				require.NoError(t, err)
+				defer resp.Body.Close()
+
+				body, err := ioutil.ReadAll(resp.Body)
+				require.NoError(t, err)
+				assert.Equal(t, http.StatusOK, resp.StatusCode, string(body))
+				assert.Assert(t, !strings.Contains(string(body), script), "logs should not be rendered as html")
+				assert.Assert(t, strings.Contains(string(body), "a_test.go:10: &lt;script&gt;alert(1)&lt;/script&gt;"))
+				assert.Assert(t, strings.Contains(string(body), "<pre><code>"))
+			})
+		})
+	}
+}
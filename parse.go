@@ -0,0 +1,216 @@
+package tester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ParseTestOutput parses the newline delimited JSON output of `go test -json`
+// or `go tool test2json` into the tests it reports, in the order they were
+// started. The package of tests run via test2json, which does not report
+// packages, is left empty.
+func ParseTestOutput(ctx context.Context, jsonOutput []byte) ([]*Test, error) {
+	events, err := parseEvents(jsonOutput)
+	if err != nil {
+		return nil, err
+	}
+	return processEvents(ctx, events)
+}
+
+// testKey identifies a test within the output of a test binary. Test binaries
+// that include multiple packages may contain tests of the same name in
+// different packages.
+type testKey struct {
+	pkg  string
+	name string
+}
+
+// parseEvents parses the newline delimited test2json output.
+func parseEvents(data []byte) ([]*testEvent, error) {
+	data = bytes.Trim(data, " \n")
+	if len(data) == 0 {
+		return nil, nil
+	}
+
+	var events []*testEvent
+	for _, eventData := range bytes.Split(data, []byte("\n")) {
+		var event testEvent
+		err := json.Unmarshal(eventData, &event)
+		if err != nil {
+			return nil, fmt.Errorf("parsing test event: %w", err)
+		}
+		events = append(events, &event)
+	}
+	return events, nil
+}
+
+// processEvents builds the tests from the events. Test binaries that include
+// multiple packages may report events for different packages, which result in
+// tests for their respective package.
+func processEvents(ctx context.Context, events []*testEvent) ([]*Test, error) {
+	var (
+		tests   []*Test
+		testMap = make(map[*T]*Test)
+		tMap    = make(map[testKey]*T)
+		// lastMessages are the most recent messages logged by each t. For
+		// failed ts it is the failure message, since ts usually log before
+		// they fail (t.Fatal) or fail last (t.Error), and for skipped ts it
+		// is the skip reason.
+		lastMessages = make(map[*T]string)
+		// open tracks the ts that have started but not yet finished.
+		open = make(map[*T]bool)
+		// Parallel ts pause until their parent's function returns. The
+		// elapsed time they report excludes the time spent paused, so their
+		// results are timed from when they continued, less the time they ran
+		// before pausing.
+		ranBeforePause = make(map[*T]time.Duration)
+		continuedAt    = make(map[*T]time.Time)
+	)
+
+	for _, event := range events {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// TODO revisit when adding support for benchmarks
+		if event.Test == "" {
+			continue
+		}
+
+		key := testKey{pkg: event.Package, name: event.Test}
+		switch event.Action {
+		case "run":
+			// test2json only identifies tests by name, so a name may occur
+			// multiple times in a stream (eg. when using -test.count or when
+			// retrying). A run for a name that has finished starts a new t
+			// that later events belong to, while a repeated run for a name
+			// that is still running is a duplicate of the existing t.
+			if existing, ok := tMap[key]; ok && open[existing] {
+				continue
+			}
+
+			t := &T{
+				TB: TB{
+					Name:      event.Test,
+					StartedAt: event.Time,
+				},
+			}
+			tMap[key] = t
+			open[t] = true
+
+			if event.TopLevel() {
+				test := &Test{
+					ID:      uuid.New(),
+					Package: event.Package,
+					Result:  t,
+				}
+				testMap[t] = test
+				tests = append(tests, test)
+			} else {
+				parentT, ok := tMap[testKey{pkg: event.Package, name: event.ParentTest()}]
+				if !ok {
+					return nil, fmt.Errorf("missing parent t %s for sub t %s", event.ParentTest(), event.Test)
+				}
+				parentT.SubTs = append(parentT.SubTs, t)
+			}
+		case "pause":
+			t, ok := tMap[key]
+			if !ok {
+				return nil, fmt.Errorf("missing t: %s", event.Test)
+			}
+			ranBeforePause[t] = event.Time.Sub(t.StartedAt)
+		case "cont":
+			t, ok := tMap[key]
+			if !ok {
+				return nil, fmt.Errorf("missing t: %s", event.Test)
+			}
+			continuedAt[t] = event.Time
+		case "pass", "fail", "skip":
+			t, ok := tMap[key]
+			if !ok {
+				return nil, fmt.Errorf("missing t: %s", event.Test)
+			}
+			// The elapsed time reported by the test is more accurate than the
+			// event time, which is when test2json saw the result line (eg.
+			// parallel tests report their results late).
+			t.FinishedAt = event.Time
+			if event.Elapsed > 0 {
+				startedAt := t.StartedAt
+				if continued, ok := continuedAt[t]; ok {
+					startedAt = continued.Add(-ranBeforePause[t])
+				}
+				t.FinishedAt = startedAt.Add(time.Duration(event.Elapsed * float64(time.Second)))
+			}
+			// A t does not finish until its sub ts do, but the elapsed time
+			// of a t excludes waiting for its parallel sub ts.
+			for _, subT := range t.SubTs {
+				if subT.FinishedAt.After(t.FinishedAt) {
+					t.FinishedAt = subT.FinishedAt
+				}
+			}
+			delete(open, t)
+			switch event.Action {
+			case "pass":
+				t.State = TBStatePassed
+			case "fail":
+				t.State = TBStateFailed
+				t.ErrorMessage = lastMessages[t]
+			case "skip":
+				t.State = TBStateSkipped
+				t.SkipReason = lastMessages[t]
+			}
+		case "output":
+			t, ok := tMap[testKey{pkg: event.Package, name: event.TopLevelTest()}]
+			if !ok {
+				return nil, fmt.Errorf("missing t: %s", event.Test)
+			}
+
+			test, ok := testMap[t]
+			if !ok {
+				return nil, fmt.Errorf("missing test: %s", t.Name)
+			}
+
+			test.Logs = append(test.Logs, TBLog{
+				Time:   event.Time,
+				Name:   event.Test,
+				Output: event.Output.Bytes(),
+			})
+
+			if subT, ok := tMap[key]; ok {
+				subT.Logs = append(subT.Logs, TBLog{
+					Time:   event.Time,
+					Name:   event.Test,
+					Output: event.Output.Bytes(),
+				})
+				if message, ok := errorMessage(event.Output.Bytes()); ok {
+					lastMessages[subT] = message
+				}
+			}
+		}
+	}
+
+	return tests, nil
+}
+
+// messagePattern matches output lines logged via t.Log, t.Error, t.Fatal,
+// t.Skip, etc., which are indented and prefixed with the source location, eg.
+// "    foo_test.go:12: message". The further lines of multi-line messages are
+// not prefixed with the location, so they do not match.
+var messagePattern = regexp.MustCompile(`^(?:    |\t)+\S+\.go:\d+: `)
+
+// errorMessage returns the message of an output line logged via t.Error,
+// t.Fatal, t.Skip, etc.
+func errorMessage(output []byte) (string, bool) {
+	line := string(output)
+	if !messagePattern.MatchString(line) {
+		return "", false
+	}
+	return strings.TrimSpace(line), true
+}
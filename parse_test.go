@@ -0,0 +1,164 @@
+package tester
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+// goldenTest is the representation of a test in golden files, which omits
+// generated IDs and renders output as text.
+type goldenTest struct {
+	Package string   `json:"package"`
+	Result  *goldenT `json:"result"`
+	Logs    []string `json:"logs"`
+}
+
+type goldenT struct {
+	Name         string     `json:"name"`
+	State        TBState    `json:"state"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	SkipReason   string     `json:"skip_reason,omitempty"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   time.Time  `json:"finished_at"`
+	Logs         []string   `json:"logs,omitempty"`
+	SubTs        []*goldenT `json:"sub_ts,omitempty"`
+}
+
+func newGoldenT(t *T) *goldenT {
+	g := &goldenT{
+		Name:         t.Name,
+		State:        t.State,
+		ErrorMessage: t.ErrorMessage,
+		SkipReason:   t.SkipReason,
+		StartedAt:    t.StartedAt,
+		FinishedAt:   t.FinishedAt,
+		Logs:         logOutput(t.Logs),
+	}
+	for _, subT := range t.SubTs {
+		g.SubTs = append(g.SubTs, newGoldenT(subT))
+	}
+	return g
+}
+
+func logOutput(logs []TBLog) []string {
+	var output []string
+	for _, log := range logs {
+		output = append(output, string(log.Output))
+	}
+	return output
+}
+
+func TestParseTestOutput(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "parse", "*.json"))
+	require.NoError(t, err)
+
+	for _, input := range inputs {
+		if strings.HasSuffix(input, ".golden.json") {
+			continue
+		}
+
+		input := input
+		t.Run(strings.TrimSuffix(filepath.Base(input), ".json"), func(t *testing.T) {
+			output, err := ioutil.ReadFile(input)
+			require.NoError(t, err)
+
+			tests, err := ParseTestOutput(context.Background(), output)
+			require.NoError(t, err)
+
+			var golden []*goldenTest
+			for _, test := range tests {
+				assert.NotEmpty(t, test.ID)
+				golden = append(golden, &goldenTest{
+					Package: test.Package,
+					Result:  newGoldenT(test.Result),
+					Logs:    logOutput(test.Logs),
+				})
+			}
+			actual, err := json.MarshalIndent(golden, "", "  ")
+			require.NoError(t, err)
+
+			goldenPath := strings.TrimSuffix(input, ".json") + ".golden.json"
+			if *update {
+				require.NoError(t, ioutil.WriteFile(goldenPath, append(actual, '\n'), 0644))
+			}
+			expected, err := ioutil.ReadFile(goldenPath)
+			require.NoError(t, err)
+			assert.JSONEq(t, string(expected), string(actual))
+		})
+	}
+}
+
+func TestParseTestOutput_count(t *testing.T) {
+	output, err := ioutil.ReadFile(filepath.Join("testdata", "parse", "repeated_with_count.json"))
+	require.NoError(t, err)
+
+	tests, err := ParseTestOutput(context.Background(), output)
+	require.NoError(t, err)
+
+	// Each repetition of a test is a distinct test record.
+	require.Len(t, tests, 2)
+	assert.Equal(t, tests[0].Result.Name, tests[1].Result.Name)
+	assert.NotEqual(t, tests[0].ID, tests[1].ID)
+	assert.NotSame(t, tests[0].Result, tests[1].Result)
+	assert.Equal(t, TBStateFailed, tests[0].Result.State)
+	assert.Equal(t, TBStatePassed, tests[1].Result.State)
+}
+
+func TestParseTestOutput_parallel(t *testing.T) {
+	output, err := ioutil.ReadFile(filepath.Join("testdata", "parse", "parallel_subtests.json"))
+	require.NoError(t, err)
+
+	tests, err := ParseTestOutput(context.Background(), output)
+	require.NoError(t, err)
+	require.Len(t, tests, 2)
+
+	parent := tests[0].Result
+	require.Len(t, parent.SubTs, 2)
+	x, y := parent.SubTs[0], parent.SubTs[1]
+	assert.Equal(t, "TestA/x", x.Name)
+	assert.Equal(t, "TestA/y", y.Name)
+
+	// The elapsed time of parallel ts excludes the time they were paused.
+	assert.Equal(t, 3*time.Second, x.Duration())
+	assert.Equal(t, 1500*time.Millisecond, y.Duration())
+	// The parent does not finish before its parallel sub ts.
+	assert.Equal(t, x.FinishedAt, parent.FinishedAt)
+	assert.Equal(t, TBStateFailed, y.State)
+	assert.Equal(t, "a_test.go:12: y", y.ErrorMessage)
+}
+
+func TestParseTestOutput_errors(t *testing.T) {
+	t.Run("empty", func(t *testing.T) {
+		tests, err := ParseTestOutput(context.Background(), []byte("\n"))
+		require.NoError(t, err)
+		assert.Empty(t, tests)
+	})
+
+	t.Run("invalid json", func(t *testing.T) {
+		_, err := ParseTestOutput(context.Background(), []byte("=== RUN   TestA\n"))
+		assert.Error(t, err)
+	})
+
+	t.Run("missing t", func(t *testing.T) {
+		_, err := ParseTestOutput(context.Background(), []byte(`{"Time":"2020-01-01T00:00:00Z","Action":"pass","Test":"TestA"}`))
+		assert.Error(t, err)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := ParseTestOutput(ctx, []byte(`{"Time":"2020-01-01T00:00:00Z","Action":"run","Test":"TestA"}`))
+		assert.Equal(t, context.Canceled, err)
+	})
+}
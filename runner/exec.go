@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/nanzhong/tester"
+)
+
+// ExecOptions configures how Exec runs a test binary.
+type ExecOptions struct {
+	// Args are passed to the test binary after -test.v.
+	Args []string
+	// Env is added to the environment of the test binary.
+	Env []string
+	// MaxOutputBytes is the maximum number of bytes of test output that is
+	// retained, 0 for no limit.
+	MaxOutputBytes int64
+	// Verbose, if set, receives the test output as the tests run.
+	Verbose io.Writer
+}
+
+// ExecResult is the outcome of running a test binary with Exec.
+type ExecResult struct {
+	Tests []*tester.Test
+	// Truncated is the number of bytes of test output that were discarded
+	// after exceeding MaxOutputBytes.
+	Truncated int64
+}
+
+// ExecError is returned by Exec when the test binary exits abnormally, ie.
+// with an exit code other than 1, which only indicates that tests failed.
+type ExecError struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+
+	err *exec.ExitError
+}
+
+func (e *ExecError) Error() string {
+	return fmt.Sprintf("Test run failed: %s\nExit Code: %d\nstdout:\n%s\nstderr:\n%s", e.err.String(), e.ExitCode, e.Stdout, e.Stderr)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.err
+}
+
+// Exec runs the test binary at path, converting its output with test2json and
+// parsing the results of the tests.
+func Exec(ctx context.Context, path string, opts ExecOptions) (*ExecResult, error) {
+	var (
+		stdout      = newCappedBuffer(opts.MaxOutputBytes, false)
+		stderr      = newCappedBuffer(opts.MaxOutputBytes, false)
+		eventStdout = newCappedBuffer(opts.MaxOutputBytes, true)
+	)
+
+	args := append([]string{"-test.v"}, opts.Args...)
+
+	reader, writer := io.Pipe()
+	teeReader := io.TeeReader(reader, stdout)
+
+	testCmd := exec.CommandContext(ctx, path, args...)
+	testCmd.Stdout = writer
+	if opts.Verbose != nil {
+		testCmd.Stdout = io.MultiWriter(writer, opts.Verbose)
+	}
+	testCmd.Stderr = stderr
+	if len(opts.Env) > 0 {
+		testCmd.Env = append(os.Environ(), opts.Env...)
+	}
+
+	jsonCmd := exec.CommandContext(ctx, "go", "tool", "test2json", "-t")
+	jsonCmd.Stdin = teeReader
+	jsonCmd.Stdout = eventStdout
+	jsonCmd.Stderr = os.Stderr
+
+	if err := testCmd.Start(); err != nil {
+		return nil, fmt.Errorf("starting test binary: %w", err)
+	}
+	if err := jsonCmd.Start(); err != nil {
+		reader.Close()
+		testCmd.Process.Kill()
+		testCmd.Wait()
+		return nil, fmt.Errorf("starting test2json: %w", err)
+	}
+
+	err := testCmd.Wait()
+	writer.Close()
+	if err != nil {
+		exitErr, ok := err.(*exec.ExitError)
+		if !ok {
+			return nil, fmt.Errorf("running: %w", err)
+		}
+
+		// non 0 exit statuses are okay.
+		// eg. failed tests will result in exit status 1.
+		if exitErr.ExitCode() != 1 {
+			jsonCmd.Wait()
+			return nil, &ExecError{
+				ExitCode: exitErr.ExitCode(),
+				Stdout:   stdout.String(),
+				Stderr:   stderr.String(),
+				err:      exitErr,
+			}
+		}
+	}
+
+	if err := jsonCmd.Wait(); err != nil {
+		return nil, fmt.Errorf("parsing test output: %w", err)
+	}
+
+	tests, err := tester.ParseTestOutput(ctx, eventStdout.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("processing test output: %w", err)
+	}
+	if truncated := eventStdout.Truncated(); truncated > 0 {
+		markTruncated(tests, truncated)
+	}
+
+	return &ExecResult{
+		Tests:     tests,
+		Truncated: eventStdout.Truncated(),
+	}, nil
+}
@@ -0,0 +1,203 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/nanzhong/tester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExec(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found, skipping exec. test2json is needed to run tests.")
+	}
+
+	writeScript := func(t *testing.T, script string) string {
+		path := filepath.Join(t.TempDir(), "pkg.test")
+		require.NoError(t, ioutil.WriteFile(path, []byte(script), 0755))
+		return path
+	}
+
+	t.Run("parses results", func(t *testing.T) {
+		// The script stands in for the test binary, printing the output of
+		// -test.v along with the args and env it was run with.
+		path := writeScript(t, `#!/bin/sh
+echo "=== RUN   TestA"
+echo "    a_test.go:10: args: $*"
+echo "    a_test.go:11: env: $TESTER_EXEC"
+echo "--- PASS: TestA (0.50s)"
+echo "=== RUN   TestB"
+echo "    b_test.go:10: boom"
+echo "--- FAIL: TestB (0.00s)"
+echo "FAIL"
+exit 1
+`)
+
+		result, err := Exec(context.Background(), path, ExecOptions{
+			Args: []string{"-test.run=TestA|TestB"},
+			Env:  []string{"TESTER_EXEC=1"},
+		})
+		require.NoError(t, err)
+		assert.Zero(t, result.Truncated)
+		require.Len(t, result.Tests, 2)
+
+		testA, testB := result.Tests[0], result.Tests[1]
+		assert.Equal(t, "TestA", testA.Result.Name)
+		assert.Equal(t, tester.TBStatePassed, testA.Result.State)
+		var logs string
+		for _, log := range testA.Logs {
+			logs += string(log.Output)
+		}
+		assert.Contains(t, logs, "args: -test.v -test.run=TestA|TestB")
+		assert.Contains(t, logs, "env: 1")
+
+		assert.Equal(t, "TestB", testB.Result.Name)
+		assert.Equal(t, tester.TBStateFailed, testB.Result.State)
+		assert.Contains(t, testB.Result.ErrorMessage, "boom")
+	})
+
+	t.Run("abnormal exit", func(t *testing.T) {
+		path := writeScript(t, `#!/bin/sh
+echo "=== RUN   TestA"
+echo "panic: boom" >&2
+exit 2
+`)
+
+		_, err := Exec(context.Background(), path, ExecOptions{})
+		var execErr *ExecError
+		require.True(t, errors.As(err, &execErr), "unexpected error: %v", err)
+		assert.Equal(t, 2, execErr.ExitCode)
+		assert.Contains(t, execErr.Stdout, "=== RUN   TestA")
+		assert.Contains(t, execErr.Stderr, "panic: boom")
+		assert.Contains(t, execErr.Error(), "Exit Code: 2")
+	})
+
+	t.Run("missing binary", func(t *testing.T) {
+		_, err := Exec(context.Background(), filepath.Join(t.TempDir(), "missing.test"), ExecOptions{})
+		require.Error(t, err)
+		var execErr *ExecError
+		assert.False(t, errors.As(err, &execErr))
+	})
+}
+
+// buildExample builds the test binary of the example package in
+// testdata/example, which is gated behind the example build tag.
+func buildExample(t *testing.T) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "example.test")
+	out, err := exec.Command("go", "test", "-c", "-tags", "example", "-o", path, "./testdata/example").CombinedOutput()
+	require.NoError(t, err, string(out))
+	return path
+}
+
+func TestExec_example(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found, skipping exec. go is needed to build the example test binary.")
+	}
+	bin := buildExample(t)
+
+	states := func(tests []*tester.Test) map[string]tester.TBState {
+		states := map[string]tester.TBState{}
+		var add func(t *tester.T)
+		add = func(t *tester.T) {
+			states[t.Name] = t.State
+			for _, sub := range t.SubTs {
+				add(sub)
+			}
+		}
+		for _, test := range tests {
+			add(test.Result)
+		}
+		return states
+	}
+
+	t.Run("all tests", func(t *testing.T) {
+		result, err := Exec(context.Background(), bin, ExecOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, map[string]tester.TBState{
+			"TestPass":            tester.TBStatePassed,
+			"TestFail":            tester.TBStateFailed,
+			"TestSkip":            tester.TBStateSkipped,
+			"TestSubTests":        tester.TBStateFailed,
+			"TestSubTests/pass":   tester.TBStatePassed,
+			"TestSubTests/fail":   tester.TBStateFailed,
+			"TestEnv":             tester.TBStatePassed,
+			"TestDeferredLog":     tester.TBStatePassed,
+			"TestDeferredLog/sub": tester.TBStatePassed,
+			"TestNested":          tester.TBStatePassed,
+			"TestNested/a":        tester.TBStatePassed,
+			"TestNested/a/b":      tester.TBStateSkipped,
+			"TestNested/a/c":      tester.TBStatePassed,
+			"TestParallel":        tester.TBStatePassed,
+			"TestParallel/x":      tester.TBStatePassed,
+			"TestParallel/y":      tester.TBStatePassed,
+			"TestParallel/z":      tester.TBStatePassed,
+		}, states(result.Tests))
+
+		for _, test := range result.Tests {
+			switch test.Result.Name {
+			case "TestFail":
+				assert.Contains(t, test.Result.ErrorMessage, "boom")
+			case "TestSkip":
+				assert.Contains(t, test.Result.SkipReason, "not today")
+			case "TestNested":
+				require.Len(t, test.Result.SubTs, 1)
+				a := test.Result.SubTs[0]
+				assert.Equal(t, "TestNested/a", a.Name)
+				require.Len(t, a.SubTs, 2)
+				assert.Equal(t, "TestNested/a/b", a.SubTs[0].Name)
+				assert.Contains(t, a.SubTs[0].SkipReason, "nested skip")
+				assert.Equal(t, "TestNested/a/c", a.SubTs[1].Name)
+			case "TestParallel":
+				assert.Len(t, test.Result.SubTs, 3)
+				for _, sub := range test.Result.SubTs {
+					var output string
+					for _, log := range sub.Logs {
+						output += string(log.Output)
+					}
+					assert.Contains(t, output, "parallel\n", sub.Name)
+					assert.False(t, sub.FinishedAt.After(test.Result.FinishedAt), sub.Name)
+				}
+			case "TestDeferredLog":
+				// Logs of the sub test, including deferred ones, belong to
+				// both the sub test and the top level test.
+				require.Len(t, test.Result.SubTs, 1)
+				for _, logs := range [][]tester.TBLog{test.Logs, test.Result.SubTs[0].Logs} {
+					var output string
+					for _, log := range logs {
+						output += string(log.Output)
+					}
+					assert.Contains(t, output, "logged\n")
+					assert.Contains(t, output, "deferred\n")
+				}
+			}
+		}
+	})
+
+	t.Run("args and env", func(t *testing.T) {
+		result, err := Exec(context.Background(), bin, ExecOptions{
+			Args: []string{"-test.run=TestEnv", "-test.count=2"},
+			Env:  []string{"EXAMPLE_FAIL=1"},
+		})
+		require.NoError(t, err)
+		require.Len(t, result.Tests, 2)
+		for _, test := range result.Tests {
+			assert.Equal(t, "TestEnv", test.Result.Name)
+			assert.Equal(t, tester.TBStateFailed, test.Result.State)
+		}
+	})
+
+	t.Run("truncated output", func(t *testing.T) {
+		result, err := Exec(context.Background(), bin, ExecOptions{MaxOutputBytes: 256})
+		require.NoError(t, err)
+		assert.Greater(t, result.Truncated, int64(0))
+		assert.Less(t, len(result.Tests), 8)
+	})
+}
@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// cappedBuffer is a bytes.Buffer that stops retaining data once it holds max
+// bytes, counting the bytes that were discarded instead. Writes never fail so
+// that the writer is not interrupted when the cap is reached.
+type cappedBuffer struct {
+	buf bytes.Buffer
+	max int64
+	// lines makes the buffer truncate at line boundaries, so that it only
+	// ever holds complete lines.
+	lines     bool
+	truncated int64
+}
+
+func newCappedBuffer(max int64, lines bool) *cappedBuffer {
+	return &cappedBuffer{max: max, lines: lines}
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.max <= 0 {
+		return b.buf.Write(p)
+	}
+	if b.truncated > 0 {
+		b.truncated += int64(len(p))
+		return len(p), nil
+	}
+
+	keep := p
+	if remaining := b.max - int64(b.buf.Len()); int64(len(p)) > remaining {
+		keep = p[:remaining]
+		if b.lines {
+			// Drop the trailing partial line, which may have begun in an
+			// earlier write.
+			if i := bytes.LastIndexByte(keep, '\n'); i >= 0 {
+				keep = keep[:i+1]
+			} else {
+				keep = nil
+				if i := bytes.LastIndexByte(b.buf.Bytes(), '\n'); i < b.buf.Len()-1 {
+					b.truncated += int64(b.buf.Len() - i - 1)
+					b.buf.Truncate(i + 1)
+				}
+			}
+		}
+		b.truncated += int64(len(p) - len(keep))
+	}
+	b.buf.Write(keep)
+	return len(p), nil
+}
+
+// Bytes returns the retained data.
+func (b *cappedBuffer) Bytes() []byte {
+	return b.buf.Bytes()
+}
+
+// Truncated returns the number of bytes that were discarded.
+func (b *cappedBuffer) Truncated() int64 {
+	return b.truncated
+}
+
+// String returns the retained data followed by a marker noting how many bytes
+// were discarded, if any.
+func (b *cappedBuffer) String() string {
+	if b.truncated == 0 {
+		return b.buf.String()
+	}
+	return b.buf.String() + truncatedMarker(b.truncated)
+}
+
+func truncatedMarker(n int64) string {
+	return fmt.Sprintf("\n[truncated %d bytes]\n", n)
+}
@@ -0,0 +1,95 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nanzhong/tester"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCappedBuffer(t *testing.T) {
+	t.Run("unlimited", func(t *testing.T) {
+		buf := newCappedBuffer(0, false)
+		buf.Write([]byte(strings.Repeat("a", 1024)))
+		assert.Len(t, buf.Bytes(), 1024)
+		assert.Equal(t, int64(0), buf.Truncated())
+		assert.Equal(t, strings.Repeat("a", 1024), buf.String())
+	})
+
+	t.Run("truncates at cap", func(t *testing.T) {
+		buf := newCappedBuffer(10, false)
+		for i := 0; i < 4; i++ {
+			n, err := buf.Write([]byte("abcdef"))
+			require.NoError(t, err)
+			assert.Equal(t, 6, n)
+		}
+		assert.Equal(t, "abcdefabcd", string(buf.Bytes()))
+		assert.Equal(t, int64(14), buf.Truncated())
+		assert.Equal(t, "abcdefabcd\n[truncated 14 bytes]\n", buf.String())
+	})
+
+	t.Run("truncates at line boundary", func(t *testing.T) {
+		buf := newCappedBuffer(10, true)
+		buf.Write([]byte("abc\nde"))
+		buf.Write([]byte("f\nghi"))
+		assert.Equal(t, "abc\ndef\n", string(buf.Bytes()))
+		assert.Equal(t, int64(3), buf.Truncated())
+
+		// The partial line from the earlier write is dropped.
+		buf = newCappedBuffer(10, true)
+		buf.Write([]byte("abc\nde"))
+		buf.Write([]byte("fghijk"))
+		assert.Equal(t, "abc\n", string(buf.Bytes()))
+		assert.Equal(t, int64(8), buf.Truncated())
+
+		buf = newCappedBuffer(8, true)
+		buf.Write([]byte("abc\n"))
+		buf.Write([]byte("def\n"))
+		buf.Write([]byte("g"))
+		assert.Equal(t, "abc\ndef\n", string(buf.Bytes()))
+		assert.Equal(t, int64(1), buf.Truncated())
+	})
+
+	t.Run("oversized event output", func(t *testing.T) {
+		var stream strings.Builder
+		stream.WriteString(`{"Time":"2020-01-01T00:00:00Z","Action":"run","Test":"TestA"}` + "\n")
+		stream.WriteString(`{"Time":"2020-01-01T00:00:01Z","Action":"pass","Test":"TestA"}` + "\n")
+		stream.WriteString(`{"Time":"2020-01-01T00:00:01Z","Action":"run","Test":"TestB"}` + "\n")
+		for i := 0; i < 1000; i++ {
+			fmt.Fprintf(&stream, `{"Time":"2020-01-01T00:00:01Z","Action":"output","Test":"TestB","Output":"line %d\n"}`+"\n", i)
+		}
+		stream.WriteString(`{"Time":"2020-01-01T00:00:02Z","Action":"fail","Test":"TestB"}` + "\n")
+
+		const max = 4096
+		buf := newCappedBuffer(max, true)
+		// Write in chunks that do not align with lines, like a pipe would.
+		data := []byte(stream.String())
+		for len(data) > 0 {
+			n := 100
+			if n > len(data) {
+				n = len(data)
+			}
+			buf.Write(data[:n])
+			data = data[n:]
+		}
+		assert.LessOrEqual(t, len(buf.Bytes()), max)
+		assert.Equal(t, int64(stream.Len()-len(buf.Bytes())), buf.Truncated())
+		assert.True(t, strings.HasSuffix(buf.String(), fmt.Sprintf("[truncated %d bytes]\n", buf.Truncated())))
+
+		tests, err := tester.ParseTestOutput(context.Background(), buf.Bytes())
+		require.NoError(t, err)
+		markTruncated(tests, buf.Truncated())
+
+		require.Len(t, tests, 2)
+		assert.Equal(t, tester.TBStatePassed, tests[0].Result.State)
+		assert.Empty(t, tests[0].Result.ErrorMessage)
+		assert.Equal(t, tester.TBState(""), tests[1].Result.State)
+		assert.Contains(t, tests[1].Result.ErrorMessage, "output truncated")
+		lastLog := tests[1].Logs[len(tests[1].Logs)-1]
+		assert.Equal(t, truncatedMarker(buf.Truncated()), string(lastLog.Output))
+	})
+}
@@ -0,0 +1,187 @@
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nanzhong/tester"
+)
+
+// retainedManifestName is the name of the manifest written to the artifact
+// directory of a retained run.
+const retainedManifestName = "manifest.json"
+
+// retainedRun is the manifest of a retained run, which records everything
+// needed to run its test binary again exactly as the run did.
+type retainedRun struct {
+	RunID   uuid.UUID `json:"run_id"`
+	Package string    `json:"package"`
+	Variant string    `json:"variant,omitempty"`
+	// Binary is the path of the retained copy of the test binary.
+	Binary string `json:"binary"`
+	// Args are all of the args the test binary was run with, including
+	// -test.v.
+	Args []string `json:"args"`
+	// Env is the complete environment the test binary was run with.
+	Env []string `json:"env"`
+	// Error is the error the run failed with, if the test binary exited
+	// abnormally.
+	Error string `json:"error,omitempty"`
+	// FailedTests are the names of the tests that failed.
+	FailedTests []string  `json:"failed_tests,omitempty"`
+	RetainedAt  time.Time `json:"retained_at"`
+}
+
+// runArtifactsPath returns the directory the artifacts of the run are staged
+// in while it runs and retained in if it fails.
+func (r *Runner) runArtifactsPath(runID uuid.UUID) string {
+	return filepath.Join(r.retainPath, runID.String())
+}
+
+// stageRunArtifacts preserves the test binary at binPath for the run, so that
+// it can be retained exactly if the run fails even if the binary is replaced
+// in the meantime. It returns the path of the preserved binary, which should
+// be run in place of binPath, or an empty path if retention is disabled.
+func (r *Runner) stageRunArtifacts(runID uuid.UUID, binPath string) (string, error) {
+	if r.retainPath == "" {
+		return "", nil
+	}
+
+	dir := r.runArtifactsPath(runID)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("creating run artifacts directory: %w", err)
+	}
+
+	stagedPath := filepath.Join(dir, filepath.Base(binPath))
+	// Test binaries are replaced by renaming, so a hard link keeps the
+	// binary that is run without copying it.
+	if err := os.Link(binPath, stagedPath); err != nil {
+		if err := copyFile(binPath, stagedPath, 0755); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("preserving test binary: %w", err)
+		}
+	}
+	return stagedPath, nil
+}
+
+// finishRunArtifacts retains the staged artifacts of the run along with a
+// manifest if the run failed, pruning the oldest retained runs beyond the
+// configured maximum, and removes them otherwise.
+func (r *Runner) finishRunArtifacts(run *tester.Run, stagedPath string, args, env []string, result *ExecResult, execErr error) error {
+	if stagedPath == "" {
+		return nil
+	}
+	dir := r.runArtifactsPath(run.ID)
+
+	manifest := &retainedRun{
+		RunID:      run.ID,
+		Package:    run.Package,
+		Variant:    run.Variant,
+		Binary:     stagedPath,
+		Args:       args,
+		Env:        env,
+		RetainedAt: time.Now(),
+	}
+	if execErr != nil {
+		manifest.Error = execErr.Error()
+	}
+	if result != nil {
+		for _, test := range result.Tests {
+			if test.Result != nil && test.Result.State == tester.TBStateFailed {
+				manifest.FailedTests = append(manifest.FailedTests, test.Result.Name)
+			}
+		}
+	}
+	if manifest.Error == "" && len(manifest.FailedTests) == 0 {
+		if err := os.RemoveAll(dir); err != nil {
+			return fmt.Errorf("removing run artifacts: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshaling manifest: %w", err)
+	}
+	// The environment may include secrets, so the manifest is only readable
+	// by the runner's user.
+	if err := ioutil.WriteFile(filepath.Join(dir, retainedManifestName), data, 0600); err != nil {
+		return fmt.Errorf("writing manifest: %w", err)
+	}
+	r.logger.Info("retained failed run artifacts", "run_id", run.ID, "package", run.Package, "path", dir)
+
+	return r.pruneRetainedRuns()
+}
+
+// pruneRetainedRuns removes the oldest retained runs beyond the configured
+// maximum. Runs whose artifacts are still staged, ie. that have no manifest,
+// are left alone.
+func (r *Runner) pruneRetainedRuns() error {
+	r.retainMu.Lock()
+	defer r.retainMu.Unlock()
+
+	entries, err := ioutil.ReadDir(r.retainPath)
+	if err != nil {
+		return fmt.Errorf("listing retained runs: %w", err)
+	}
+
+	type retained struct {
+		dir        string
+		retainedAt time.Time
+	}
+	var runs []retained
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(r.retainPath, entry.Name())
+		info, err := os.Stat(filepath.Join(dir, retainedManifestName))
+		if err != nil {
+			continue
+		}
+		runs = append(runs, retained{dir: dir, retainedAt: info.ModTime()})
+	}
+	if len(runs) <= r.maxRetainedRuns {
+		return nil
+	}
+
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].retainedAt.Before(runs[j].retainedAt)
+	})
+	var errs []error
+	for _, run := range runs[:len(runs)-r.maxRetainedRuns] {
+		if err := os.RemoveAll(run.dir); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		r.logger.Info("pruned retained run artifacts", "path", run.dir)
+	}
+	return errors.Join(errs...)
+}
+
+// copyFile copies the file at src to dst, which is created with perm.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
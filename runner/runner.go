@@ -9,12 +9,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"math/rand"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
@@ -26,6 +28,9 @@ var (
 	// ErrTestBinMissing is returned when an expected test binary could not be
 	// found.
 	ErrTestBinMissing = errors.New("test binary not found")
+	// ErrIdleTimeout is returned by Run when the runner stops because it has
+	// not claimed a run within its idle timeout.
+	ErrIdleTimeout = errors.New("no run claimed within idle timeout")
 
 	resultSubmissionTimeout = 60 * time.Second
 )
@@ -91,6 +96,112 @@ func WithLocalTestBinsOnly() Option {
 	}
 }
 
+// WithLogger allows configuring a custom logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(runner *Runner) {
+		runner.logger = logger
+	}
+}
+
+// WithMaxSubmissionAttempts allows configuring the maximum number of attempts
+// made to submit results to the server.
+func WithMaxSubmissionAttempts(n int) Option {
+	return func(runner *Runner) {
+		runner.maxSubmissionAttempts = n
+	}
+}
+
+// WithSubmissionConcurrency allows configuring the number of test results that
+// are submitted to the server concurrently.
+func WithSubmissionConcurrency(n int) Option {
+	return func(runner *Runner) {
+		runner.submissionConcurrency = n
+	}
+}
+
+// WithConcurrency allows configuring the number of runs that are claimed and
+// run concurrently.
+func WithConcurrency(n int) Option {
+	return func(runner *Runner) {
+		runner.concurrency = n
+	}
+}
+
+// WithMaxOutputBytes allows configuring the maximum number of bytes of test
+// output that is retained for a run. Output beyond the limit is discarded.
+func WithMaxOutputBytes(n int64) Option {
+	return func(runner *Runner) {
+		runner.maxOutputBytes = n
+	}
+}
+
+// WithResultFilter allows configuring which test results are submitted to the
+// server. Tests for which fn returns false are not submitted, although the run
+// is still reported.
+func WithResultFilter(fn func(*tester.Test) bool) Option {
+	return func(runner *Runner) {
+		runner.resultFilter = fn
+	}
+}
+
+// SubmitOnlyFailed returns a result filter for WithResultFilter that only
+// keeps failed tests.
+func SubmitOnlyFailed() func(*tester.Test) bool {
+	return func(test *tester.Test) bool {
+		return test.Result.State == tester.TBStateFailed
+	}
+}
+
+// WithVerboseOutput allows configuring a writer that test output is streamed
+// to as tests run, in addition to being processed for results.
+func WithVerboseOutput(w io.Writer) Option {
+	return func(runner *Runner) {
+		runner.verboseOutput = w
+	}
+}
+
+// WithSpoolPath allows configuring the path where results that could not be
+// submitted are stored until they can be resubmitted.
+func WithSpoolPath(path string) Option {
+	return func(runner *Runner) {
+		runner.spoolPath = path
+	}
+}
+
+// WithMaxRetryDelay allows configuring the maximum delay between retries,
+// which grow exponentially, of claiming runs and submitting results.
+func WithMaxRetryDelay(d time.Duration) Option {
+	return func(runner *Runner) {
+		runner.maxRetryDelay = d
+	}
+}
+
+// WithIdleTimeout allows configuring the runner to stop when it has not claimed
+// a run within the duration, so that a process supervisor can restart it. Run
+// then returns ErrIdleTimeout. A duration of 0 disables the idle timeout.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(runner *Runner) {
+		runner.idleTimeout = d
+	}
+}
+
+// WithRetainPath allows configuring a path where the test binary, args and
+// environment of failed runs are retained, so that failures can be reproduced
+// exactly. Artifacts of runs that don't fail are removed.
+func WithRetainPath(path string) Option {
+	return func(runner *Runner) {
+		runner.retainPath = path
+	}
+}
+
+// WithMaxRetainedRuns allows configuring the maximum number of failed runs
+// whose artifacts are retained, after which the oldest are removed.
+func WithMaxRetainedRuns(n int) Option {
+	return func(runner *Runner) {
+		runner.maxRetainedRuns = n
+	}
+}
+
 // Runner is the implementation of the test runner.
 type Runner struct {
 	testerAddr        string
@@ -98,24 +209,83 @@ type Runner struct {
 	packageWhitelist  []string
 	packageBlacklist  []string
 	testBinsPath      string
+	spoolPath         string
+	retainPath        string
+	maxRetainedRuns   int
 	localTestBinsOnly bool
+	concurrency       int
+	maxOutputBytes    int64
+	resultFilter      func(*tester.Test) bool
+	verboseOutput     io.Writer
+	logger            *slog.Logger
+	id                uuid.UUID
+
+	maxSubmissionAttempts int
+	submissionRetryDelay  time.Duration
+	submissionConcurrency int
+
+	claimRetryDelay time.Duration
+	maxRetryDelay   time.Duration
+	idleTimeout     time.Duration
+	// activeRuns is the number of runs being run, and lastActive the time in
+	// unix nanoseconds that the runner last claimed or finished a run, which
+	// together determine whether the runner is idle.
+	activeRuns atomic.Int32
+	lastActive atomic.Int64
+
+	// testBinsMu serialises verifying and downloading test binaries between
+	// concurrent runs.
+	testBinsMu sync.Mutex
+	// spoolMu ensures that only one run drains the spool at a time so that
+	// spooled results are not submitted more than once.
+	spoolMu sync.Mutex
+	// retainMu serialises pruning retained runs between concurrent runs.
+	retainMu sync.Mutex
 
 	stop     chan struct{}
+	idle     chan struct{}
+	idleOnce sync.Once
 	finished chan struct{}
+	ctx      context.Context
 	kill     context.CancelFunc
 }
 
 func New(opts ...Option) (*Runner, error) {
 	runner := &Runner{
-		testerAddr: "0.0.0.0:8080",
+		testerAddr:     "0.0.0.0:8080",
+		concurrency:    1,
+		maxOutputBytes: 128 << 20,
+		logger:         slog.Default(),
+
+		maxSubmissionAttempts: 5,
+		submissionRetryDelay:  time.Second,
+		submissionConcurrency: 4,
+
+		claimRetryDelay: time.Second,
+		maxRetryDelay:   30 * time.Second,
+		maxRetainedRuns: 10,
 
 		stop:     make(chan struct{}),
+		idle:     make(chan struct{}),
 		finished: make(chan struct{}),
 	}
 
 	for _, opt := range opts {
 		opt(runner)
 	}
+	if runner.submissionConcurrency < 1 {
+		runner.submissionConcurrency = 1
+	}
+	if runner.concurrency < 1 {
+		runner.concurrency = 1
+	}
+	if runner.maxRetryDelay <= 0 {
+		runner.maxRetryDelay = 30 * time.Second
+	}
+	if runner.maxRetainedRuns < 1 {
+		runner.maxRetainedRuns = 1
+	}
+	runner.ctx, runner.kill = context.WithCancel(context.Background())
 
 	if runner.testBinsPath == "" {
 		var err error
@@ -125,27 +295,147 @@ func New(opts ...Option) (*Runner, error) {
 		}
 	}
 
+	if err := os.MkdirAll(runner.testBinsPath, 0755); err != nil {
+		return nil, fmt.Errorf("creating directory for storing test binaries: %w", err)
+	}
+
+	if runner.spoolPath == "" {
+		runner.spoolPath = fmt.Sprintf("%s/spool", runner.testBinsPath)
+	}
+	if err := os.MkdirAll(runner.spoolPath, 0755); err != nil {
+		return nil, fmt.Errorf("creating directory for spooling results: %w", err)
+	}
+
+	if runner.retainPath != "" {
+		if err := os.MkdirAll(runner.retainPath, 0700); err != nil {
+			return nil, fmt.Errorf("creating directory for retaining failed runs: %w", err)
+		}
+	}
+
+	id, err := loadRunnerID(fmt.Sprintf("%s/.runner_id", runner.testBinsPath))
+	if err != nil {
+		return nil, fmt.Errorf("loading runner id: %w", err)
+	}
+	runner.id = id
+	runner.logger = runner.logger.With("runner", runnerName(), "runner_id", id)
+
 	return runner, nil
 }
 
-func (r *Runner) Run() {
-	wait := 0 * time.Second
+// ID returns the stable ID identifying the runner.
+func (r *Runner) ID() uuid.UUID {
+	return r.id
+}
+
+// loadRunnerID reads the runner ID persisted at path, generating and
+// persisting a new one if it does not exist yet.
+func loadRunnerID(path string) (uuid.UUID, error) {
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		return uuid.ParseBytes(bytes.TrimSpace(data))
+	}
+	if !os.IsNotExist(err) {
+		return uuid.Nil, err
+	}
+
+	id := uuid.New()
+	if err := ioutil.WriteFile(path, []byte(id.String()), 0644); err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
+
+// Run claims and runs tests with the configured concurrency until the runner
+// is stopped, or until it is idle for longer than its idle timeout, in which
+// case ErrIdleTimeout is returned.
+func (r *Runner) Run() error {
+	r.lastActive.Store(time.Now().UnixNano())
+
+	var wg sync.WaitGroup
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r.work()
+		}()
+	}
+	wg.Wait()
+	close(r.finished)
+
+	select {
+	case <-r.idle:
+		return ErrIdleTimeout
+	default:
+		return nil
+	}
+}
+
+// work claims and runs runs until the runner is stopped or idle. After
+// failing to claim a run, either because none were available or because of
+// an error, it backs off exponentially up to the max retry delay.
+func (r *Runner) work() {
+	var (
+		wait     time.Duration
+		failures int
+	)
 	for {
+		if r.idleTimeout > 0 {
+			remaining := r.idleTimeout - r.idleFor()
+			if remaining <= 0 {
+				r.idleOnce.Do(func() {
+					r.logger.Warn("stopping idle runner", "idle_timeout", r.idleTimeout)
+					close(r.idle)
+				})
+				return
+			}
+			if wait > remaining {
+				wait = remaining
+			}
+		}
+
 		select {
 		case <-r.stop:
-			close(r.finished)
+			return
+		case <-r.idle:
 			return
 		case <-time.After(wait):
 		}
-		wait = time.Duration((rand.Int() % 10)) * time.Second
-		ctx, cancel := context.WithCancel(context.Background())
-		r.kill = cancel
 
-		err := r.runOnce(ctx)
+		claimed, err := r.runOnce(r.ctx)
 		if err != nil {
-			log.Printf("error running: %s\n", err)
+			r.logger.Error("error running", "err", err)
+		}
+		if claimed {
+			failures = 0
+			wait = 0
+			continue
 		}
+		wait = r.retryDelay(r.claimRetryDelay, failures)
+		failures++
+	}
+}
+
+// idleFor returns how long the runner has been idle, which is 0 while it is
+// running any runs.
+func (r *Runner) idleFor() time.Duration {
+	if r.activeRuns.Load() > 0 {
+		return 0
+	}
+	return time.Since(time.Unix(0, r.lastActive.Load()))
+}
+
+// retryDelay returns the delay before the retry following the given number
+// of failed attempts, which doubles with each attempt up to the max retry
+// delay and is jittered to spread out retries.
+func (r *Runner) retryDelay(base time.Duration, failures int) time.Duration {
+	delay := base
+	for i := 0; i < failures && delay < r.maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > r.maxRetryDelay {
+		delay = r.maxRetryDelay
 	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
 }
 
 func (r *Runner) Stop(ctx context.Context) {
@@ -156,11 +446,16 @@ func (r *Runner) Stop(ctx context.Context) {
 		r.kill()
 	}
 	if err := os.Remove(r.testBinsPath); err != nil {
-		log.Printf("failed to cleanup test bin dir: %s", err)
+		r.logger.Error("failed to cleanup test bin dir", "err", err)
 	}
 }
 
-func (r *Runner) testBinaryPath(pkg string) string {
+// testBinaryPath returns the local path of the test binary for the package's
+// variant, which is empty for packages without variants.
+func (r *Runner) testBinaryPath(pkg, variant string) string {
+	if variant != "" {
+		return fmt.Sprintf("%s/%s@%s", r.testBinsPath, pkg, variant)
+	}
 	return fmt.Sprintf("%s/%s", r.testBinsPath, pkg)
 }
 
@@ -194,17 +489,22 @@ func (r *Runner) getPackageInfo(ctx context.Context, pkg string) (*tester.Packag
 	return &packageInfo, nil
 }
 
-func (r *Runner) downloadTestBinary(ctx context.Context, pkg *tester.Package) error {
-	req, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodGet,
-		fmt.Sprintf("%s/api/packages/%s/download", r.testerAddr, pkg.Name),
-		nil,
-	)
+// downloadTestBinary downloads the test binary for the package's variant,
+// unless the server reports that the local test binary with the given sha256
+// sum is current. pkg must already be configured for the variant.
+func (r *Runner) downloadTestBinary(ctx context.Context, pkg *tester.Package, variant, localSHA256Sum string) error {
+	downloadURL := fmt.Sprintf("%s/api/packages/%s/download", r.testerAddr, pkg.Name)
+	if variant != "" {
+		downloadURL += "?variant=" + url.QueryEscape(variant)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadURL, nil)
 	if err != nil {
 		return fmt.Errorf("constructing download request: %w", err)
 	}
 	r.authAPIRequest(req)
+	if localSHA256Sum != "" {
+		req.Header.Set("If-None-Match", fmt.Sprintf("%q", localSHA256Sum))
+	}
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
@@ -212,15 +512,23 @@ func (r *Runner) downloadTestBinary(ctx context.Context, pkg *tester.Package) er
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		r.logger.Info("local test binary is current", "package", pkg.Name, "sha256sum", localSHA256Sum)
+		return nil
+	}
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("received unexpected status code downloading test binary: %d", resp.StatusCode)
 	}
 
+	// Download to a temporary file that replaces the test binary once it is
+	// verified, so that concurrent runs still executing the previous test
+	// binary are unaffected.
 	hash := sha256.New()
-	bin, err := os.Create(r.testBinaryPath(pkg.Name))
+	bin, err := ioutil.TempFile(r.testBinsPath, ".download-*")
 	if err != nil {
 		return fmt.Errorf("creating test binary: %w", err)
 	}
+	defer os.Remove(bin.Name())
 	defer bin.Close()
 
 	multiWriter := io.MultiWriter(hash, bin)
@@ -233,30 +541,57 @@ func (r *Runner) downloadTestBinary(ctx context.Context, pkg *tester.Package) er
 		return fmt.Errorf("downloaded test binary is invalid: %s (expected) != %s (actual)", pkg.SHA256Sum, downloadedSHA256Sum)
 	}
 
-	finfo, err := bin.Stat()
+	if err := bin.Chmod(0755); err != nil {
+		return fmt.Errorf("making test binary executable: %w", err)
+	}
+	if err := os.Rename(bin.Name(), r.testBinaryPath(pkg.Name, variant)); err != nil {
+		return fmt.Errorf("replacing test binary: %w", err)
+	}
+	return nil
+}
+
+// ensureTestBinary makes sure that the local test binary for the package's
+// variant is current, downloading it if needed.
+func (r *Runner) ensureTestBinary(ctx context.Context, pkg *tester.Package, variant string) error {
+	r.testBinsMu.Lock()
+	defer r.testBinsMu.Unlock()
+
+	localSHA256Sum, err := r.localTestBinarySHA256Sum(pkg, variant)
 	if err != nil {
-		return fmt.Errorf("stating test binary: %w", err)
+		return fmt.Errorf("verifying local test binary: %w", err)
 	}
-	if err := os.Chmod(r.testBinaryPath(pkg.Name), finfo.Mode().Perm()|0100); err != nil {
-		return fmt.Errorf("making test binary executable: %w", err)
+	if r.localTestBinsOnly {
+		if localSHA256Sum == pkg.SHA256Sum {
+			return nil
+		}
+		return fmt.Errorf("local test binary not found and remote download of test binaries disabled")
+	}
+
+	// The download is conditional on the local test binary's sum, so the
+	// server only sends the test binary if the local one isn't current.
+	if err := r.downloadTestBinary(ctx, pkg, variant, localSHA256Sum); err != nil {
+		return fmt.Errorf("downloading test binary: %w", err)
 	}
 	return nil
 }
 
-func (r *Runner) verifyLocalTestBinary(ctx context.Context, pkg *tester.Package) (bool, error) {
-	bin, err := os.Open(r.testBinaryPath(pkg.Name))
+// localTestBinarySHA256Sum returns the sha256 sum of the local test binary for
+// the package's variant, or an empty string if there is no local test binary.
+func (r *Runner) localTestBinarySHA256Sum(pkg *tester.Package, variant string) (string, error) {
+	bin, err := os.Open(r.testBinaryPath(pkg.Name, variant))
 	if err != nil {
 		if os.IsNotExist(err) {
-			return false, nil
+			return "", nil
 		}
-		return false, fmt.Errorf("opening test binary for verification: %w", err)
+		return "", fmt.Errorf("opening test binary for verification: %w", err)
 	}
 	defer bin.Close()
 
 	hash := sha256.New()
-	io.Copy(hash, bin)
-
-	return pkg.SHA256Sum == fmt.Sprintf("%x", hash.Sum(nil)), nil
+	if _, err := io.Copy(hash, bin); err != nil {
+		return "", fmt.Errorf("reading test binary for verification: %w", err)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
 }
 
 func (r *Runner) claimRun(ctx context.Context) (*tester.Run, error) {
@@ -303,223 +638,324 @@ func (r *Runner) claimRun(ctx context.Context) (*tester.Run, error) {
 	}
 }
 
-func (r *Runner) runOnce(ctx context.Context) error {
+// runOnce claims and runs a run, returning whether a run was claimed.
+func (r *Runner) runOnce(ctx context.Context) (bool, error) {
+	// All requests made for this run share a request ID so that they can be
+	// correlated with the server logs.
+	requestID := uuid.New().String()
+	ctx = testerhttp.WithRequestID(ctx, requestID)
+
+	if r.spoolMu.TryLock() {
+		err := r.drainSpool(ctx)
+		r.spoolMu.Unlock()
+		if err != nil {
+			r.logger.Error("failed to drain spooled results", "request_id", requestID, "err", err)
+		}
+	}
+
 	run, err := r.claimRun(ctx)
 	if err != nil {
-		return fmt.Errorf("claiming run: %w", err)
+		return false, fmt.Errorf("claiming run: %w", err)
 	}
 	if run == nil {
-		return nil
+		return false, nil
 	}
 
+	r.activeRuns.Add(1)
+	defer func() {
+		r.lastActive.Store(time.Now().UnixNano())
+		r.activeRuns.Add(-1)
+	}()
+
 	pkg, err := r.getPackageInfo(ctx, run.Package)
 	if err != nil {
-		return fmt.Errorf("getting package info: %w", err)
+		return true, fmt.Errorf("getting package info: %w", err)
 	}
 
-	valid, err := r.verifyLocalTestBinary(ctx, pkg)
+	// The run's variant determines which of the package's test binaries is
+	// run, and so which is downloaded.
+	pkg, err = pkg.Variant(run.Variant)
 	if err != nil {
-		return fmt.Errorf("verifying local test binary: %w", err)
+		return true, fmt.Errorf("selecting package variant: %w", err)
 	}
-	if !valid {
-		if r.localTestBinsOnly {
-			return fmt.Errorf("local test binary not found and remote download of test binaries disabled")
-		}
 
-		if err := r.downloadTestBinary(ctx, pkg); err != nil {
-			return fmt.Errorf("downloading test binary: %w", err)
+	logger := r.logger.With("request_id", requestID, "run_id", run.ID, "package", run.Package)
+
+	// Running the test binary without the values of its secrets would only
+	// have its tests fail for reasons that aren't apparent, so the run is
+	// failed instead.
+	if secrets := pkg.RedactedSecrets(); len(secrets) > 0 {
+		err := fmt.Errorf("the values of secrets %s were redacted by the server, runners must authenticate with a runner key to be sent them", strings.Join(secrets, ", "))
+		logger.Error("failing run", "err", err)
+		result := &runResult{
+			RunID:   run.ID,
+			Package: run.Package,
+			Error:   err.Error(),
 		}
+		if err := r.reportResult(ctx, result); err != nil {
+			logger.Error("failed to mark run failed", "err", err)
+		}
+		return true, err
 	}
 
-	log.Printf("starting run for %s (%s) with options: %s", run.Package, run.ID, strings.Join(run.Args, " "))
-	var (
-		stdout       bytes.Buffer
-		stderr       bytes.Buffer
-		eventStdout  bytes.Buffer
-		errorMessage string
-	)
-
-	runArgs := []string{
-		"-test.v",
+	if err := r.ensureTestBinary(ctx, pkg, run.Variant); err != nil {
+		return true, err
 	}
 
-	for _, arg := range run.Args {
-		runArgs = append(runArgs, arg)
+	// The environment is logged with the values of secrets redacted.
+	env := make([]string, len(pkg.Environment))
+	for i, v := range pkg.Environment {
+		env[i] = v.String()
 	}
+	logger.Info("starting run", "args", strings.Join(run.Args, " "), "race", pkg.Race, "count", run.Count, "variant", run.Variant, "env", strings.Join(env, " "))
 
-	reader, writer := io.Pipe()
-	teeReader := io.TeeReader(reader, &stdout)
-
-	testCmd := exec.CommandContext(ctx, r.testBinaryPath(pkg.Name), runArgs...)
-	testCmd.Stdout = writer
-	testCmd.Stderr = &stderr
-
-	jsonCmd := exec.CommandContext(ctx, "go", "tool", "test2json", "-t")
-	jsonCmd.Stdin = teeReader
-	jsonCmd.Stdout = &eventStdout
-	jsonCmd.Stderr = os.Stderr
+	opts := ExecOptions{
+		Args:           append([]string{}, run.Args...),
+		MaxOutputBytes: r.maxOutputBytes,
+		Verbose:        r.verboseOutput,
+	}
+	if run.Count > 1 {
+		opts.Args = append(opts.Args, fmt.Sprintf("-test.count=%d", run.Count))
+	}
+	opts.Env = pkg.Env()
+	if pkg.Race && pkg.GORACE != "" {
+		opts.Env = append(opts.Env, fmt.Sprintf("GORACE=%s", pkg.GORACE))
+	}
 
-	testCmd.Start()
-	jsonCmd.Start()
+	binPath := r.testBinaryPath(pkg.Name, run.Variant)
+	stagedPath, err := r.stageRunArtifacts(run.ID, binPath)
+	if err != nil {
+		logger.Error("failed to stage run artifacts", "err", err)
+	}
+	if stagedPath != "" {
+		binPath = stagedPath
+	}
 
-	err = testCmd.Wait()
-	writer.Close()
+	execResult, err := Exec(ctx, binPath, opts)
+	retainErr := r.finishRunArtifacts(run, stagedPath, append([]string{"-test.v"}, opts.Args...), append(os.Environ(), opts.Env...), execResult, err)
+	if retainErr != nil {
+		logger.Error("failed to retain run artifacts", "err", retainErr)
+	}
 	if err != nil {
-		exitErr, ok := err.(*exec.ExitError)
-		if !ok {
-			return fmt.Errorf("running: %w", err)
+		var execErr *ExecError
+		if !errors.As(err, &execErr) {
+			return true, err
 		}
 
-		switch exitErr.ExitCode() {
-		// non 0 exit statuses are okay.
-		// eg. failed tests will result in exit status 1.
-		case 1:
-		default:
-			errorMessage = fmt.Sprintf("Test run failed: %s\nExit Code: %d\nstdout:\n%s\nstderr:\n%s", exitErr.String(), exitErr.ExitCode(), stdout.Bytes(), stderr.Bytes())
-			if err := r.failRun(run.ID, errorMessage); err != nil {
-				log.Printf("failed to mark run failed: %s", err)
-			}
-			return exitErr
+		logger.Info("failing run", "exit_code", execErr.ExitCode)
+		result := &runResult{
+			RunID:   run.ID,
+			Package: run.Package,
+			Error:   execErr.Error(),
 		}
+		if err := r.reportResult(ctx, result); err != nil {
+			logger.Error("failed to mark run failed", "err", err)
+		}
+		return true, execErr
 	}
 
-	if err := jsonCmd.Wait(); err != nil {
-		return fmt.Errorf("parsing test output: %w", err)
+	tests := execResult.Tests
+	if execResult.Truncated > 0 {
+		logger.Warn("truncated test output", "max_output_bytes", r.maxOutputBytes, "truncated_bytes", execResult.Truncated)
 	}
 
-	eventBytes := bytes.Split(bytes.Trim(eventStdout.Bytes(), " \n"), []byte("\n"))
-	var events []*testEvent
-	for _, eventData := range eventBytes {
-		var event testEvent
-		err := json.Unmarshal(eventData, &event)
-		if err != nil {
-			return fmt.Errorf("parsing test event: %w", err)
+	for _, test := range tests {
+		// Events only report their package for test binaries that include
+		// multiple packages.
+		if test.Package == "" {
+			test.Package = run.Package
 		}
-		events = append(events, &event)
+		test.RunID = run.ID
+		logger.Info("test finished", "test_package", test.Package, "test", test.Result.Name, "state", string(test.Result.State), "duration", test.Result.Duration())
+	}
+	if filtered := r.filterTests(tests); len(filtered) != len(tests) {
+		logger.Info("filtered test results", "submitted", len(filtered), "skipped", len(tests)-len(filtered))
+		tests = filtered
 	}
 
-	tests, err := processEvents(events)
-	if err != nil {
-		return fmt.Errorf("processing events: %w", err)
+	result := &runResult{
+		RunID:   run.ID,
+		Package: run.Package,
+		Tests:   tests,
+	}
+	if err := r.reportResult(ctx, result); err != nil {
+		logger.Error("failed to submit results", "err", err)
 	}
 
-	var testIDs []uuid.UUID
-	for _, test := range tests {
-		test.RunID = run.ID
-		test.Package = run.Package
-		log.Printf("Test: %s - %s - %s", test.Result.Name, string(test.Result.State), test.Result.Duration().String())
-		testIDs = append(testIDs, test.ID)
-		if r.testerAddr != "" {
-			err := r.submitTestResult(test, run)
-			if err != nil {
-				log.Printf("failed to submit result: %s", err)
-			}
+	logger.Info("finished run")
+	return true, nil
+}
 
-		}
-	}
-	err = r.completeRun(run.ID)
-	if err != nil {
-		log.Printf("failed to mark run complete: %s", err)
+// filterTests returns the tests that pass the configured result filter.
+func (r *Runner) filterTests(tests []*tester.Test) []*tester.Test {
+	if r.resultFilter == nil {
+		return tests
 	}
 
-	log.Printf("finished run for %s", run.Package)
-	return nil
+	var filtered []*tester.Test
+	for _, test := range tests {
+		if r.resultFilter(test) {
+			filtered = append(filtered, test)
+		}
+	}
+	return filtered
 }
 
-func (r *Runner) submitTestResult(test *tester.Test, run *tester.Run) error {
+func (r *Runner) submitTestResult(ctx context.Context, test *tester.Test) error {
 	jsonTest, err := json.Marshal(test)
 	if err != nil {
 		return fmt.Errorf("marshaling json test: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), resultSubmissionTimeout)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resultSubmissionTimeout)
 	defer cancel()
-	req, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodPost,
-		fmt.Sprintf("%s/api/tests", r.testerAddr),
-		bytes.NewBuffer(jsonTest),
-	)
-	if err != nil {
-		return fmt.Errorf("constructing request: %w", err)
+	err = r.submit(ctx, fmt.Sprintf("%s/api/tests", r.testerAddr), jsonTest, http.StatusAccepted)
+
+	// Tests whose logs exceed the server's maximum request body size are
+	// resubmitted without their logs, so that their results are kept.
+	var statusErr *statusError
+	if errors.As(err, &statusErr) && statusErr.code == http.StatusRequestEntityTooLarge && len(test.Logs) > 0 {
+		r.logger.Warn("test too large, resubmitting without logs", "run_id", test.RunID, "test", test.Result.Name, "bytes", len(jsonTest))
+		jsonTest, err = json.Marshal(withoutLogs(test, len(jsonTest)))
+		if err != nil {
+			return fmt.Errorf("marshaling json test: %w", err)
+		}
+		err = r.submit(ctx, fmt.Sprintf("%s/api/tests", r.testerAddr), jsonTest, http.StatusAccepted)
 	}
-	r.authAPIRequest(req)
-	req.Header.Set("Content-Type", "application/json")
-
-	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return fmt.Errorf("submitting test: %w", err)
 	}
-	if resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("received unexpected status code: %d", resp.StatusCode)
-	}
 	return nil
 }
 
-func (r *Runner) failRun(runID uuid.UUID, errorMessage string) error {
-	log.Printf("failing run")
+// withoutLogs returns a copy of the test with the logs of it and its sub ts
+// replaced by a note that they were dropped from the submission of size
+// bytes.
+func withoutLogs(test *tester.Test, size int) *tester.Test {
+	var strip func(t *tester.T) *tester.T
+	strip = func(t *tester.T) *tester.T {
+		c := *t
+		c.Logs = nil
+		c.SubTs = nil
+		for _, sub := range t.SubTs {
+			c.SubTs = append(c.SubTs, strip(sub))
+		}
+		return &c
+	}
+
+	c := *test
+	c.Result = strip(test.Result)
+	c.Logs = []tester.TBLog{{
+		Time:   time.Now(),
+		Name:   test.Result.Name,
+		Output: []byte(fmt.Sprintf("logs dropped after the test (%d bytes) exceeded the server's maximum request size\n", size)),
+	}}
+	return &c
+}
+
+func (r *Runner) failRun(ctx context.Context, runID uuid.UUID, errorMessage string) error {
 	jsonError, err := json.Marshal(errorMessage)
 	if err != nil {
 		return fmt.Errorf("marshaling error message: %w", err)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), resultSubmissionTimeout)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resultSubmissionTimeout)
 	defer cancel()
-	req, err := http.NewRequestWithContext(
-		ctx,
-		http.MethodPost,
-		fmt.Sprintf("%s/api/runs/%s/fail", r.testerAddr, runID),
-		bytes.NewBuffer(jsonError),
-	)
+	err = r.submit(ctx, fmt.Sprintf("%s/api/runs/%s/fail", r.testerAddr, runID), jsonError, http.StatusOK)
 	if err != nil {
-		return fmt.Errorf("constructing request: %w", err)
+		return fmt.Errorf("failing run: %w", err)
 	}
-	r.authAPIRequest(req)
-	req.Header.Set("Content-Type", "application/json")
+	return nil
+}
 
-	resp, err := http.DefaultClient.Do(req)
+func (r *Runner) completeRun(ctx context.Context, runID uuid.UUID) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), resultSubmissionTimeout)
+	defer cancel()
+	err := r.submit(ctx, fmt.Sprintf("%s/api/runs/%s/complete", r.testerAddr, runID), nil, http.StatusOK)
 	if err != nil {
-		return fmt.Errorf("failing run: %w", err)
-	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("received unexpected status code: %d", resp.StatusCode)
+		return fmt.Errorf("completing run: %w", err)
 	}
 	return nil
 }
 
-func (r *Runner) completeRun(runID uuid.UUID) error {
-	ctx, cancel := context.WithTimeout(context.Background(), resultSubmissionTimeout)
-	defer cancel()
+// submit POSTs the body to the url, retrying with exponential backoff and
+// jitter on network errors and server errors until either the expected status
+// is received, the maximum number of attempts is reached or the context is
+// done.
+func (r *Runner) submit(ctx context.Context, url string, body []byte, expectedStatus int) error {
+	attempts := r.maxSubmissionAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			delay := r.retryDelay(r.submissionRetryDelay, attempt-1)
+
+			r.logger.Warn("retrying submission", "url", url, "attempt", attempt+1, "delay", delay, "err", err)
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+			}
+		}
+
+		var retry bool
+		retry, err = r.submitOnce(ctx, url, body, expectedStatus)
+		if err == nil {
+			return nil
+		}
+		if !retry {
+			return err
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", attempts, err)
+}
+
+func (r *Runner) submitOnce(ctx context.Context, url string, body []byte, expectedStatus int) (bool, error) {
 	req, err := http.NewRequestWithContext(
 		ctx,
 		http.MethodPost,
-		fmt.Sprintf("%s/api/runs/%s/complete", r.testerAddr, runID),
-		nil,
+		url,
+		bytes.NewReader(body),
 	)
 	if err != nil {
-		return fmt.Errorf("constructing request: %w", err)
+		return false, fmt.Errorf("constructing request: %w", err)
 	}
 	r.authAPIRequest(req)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("completing run: %w", err)
+		return ctx.Err() == nil, err
 	}
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("received unexpected status code: %d", resp.StatusCode)
+	defer resp.Body.Close()
+
+	if resp.StatusCode != expectedStatus {
+		return resp.StatusCode >= http.StatusInternalServerError, &statusError{code: resp.StatusCode}
 	}
-	return nil
+	return false, nil
+}
+
+// statusError is returned when a request receives an unexpected status code.
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("received unexpected status code: %d", e.code)
 }
 
 func (r *Runner) authAPIRequest(req *http.Request) {
-	// TODO make this configurable
-	name, err := os.Hostname()
-	// If getting hostname fails, use the generic "runner" name.
-	if err != nil {
-		name = "runner"
-	}
+	name := runnerName()
 	req.Header.Set("User-Agent", name)
+	req.Header.Set(testerhttp.RunnerIDHeader, r.id.String())
+	if requestID := testerhttp.RequestID(req.Context()); requestID != "" {
+		req.Header.Set(testerhttp.RequestIDHeader, requestID)
+	}
+	// Accept-Encoding is left unset so that the transport requests gzip
+	// compressed responses and transparently decompresses them.
 
 	if r.apiKey == "" {
 		return
@@ -528,76 +964,28 @@ func (r *Runner) authAPIRequest(req *http.Request) {
 	req.SetBasicAuth(name, r.apiKey)
 }
 
-func processEvents(events []*testEvent) ([]*tester.Test, error) {
-	var (
-		testMap = make(map[*tester.T]*tester.Test)
-		tMap    = make(map[string]*tester.T)
-	)
+func runnerName() string {
+	// TODO make this configurable
+	name, err := os.Hostname()
+	// If getting hostname fails, use the generic "runner" name.
+	if err != nil {
+		name = "runner"
+	}
+	return name
+}
 
-	for _, event := range events {
-		// TODO revisit when adding support for benchmarks
-		if event.Test == "" {
+// markTruncated records that output was truncated on the tests that did not
+// finish before the truncation, since their remaining events were lost.
+func markTruncated(tests []*tester.Test, truncated int64) {
+	for _, test := range tests {
+		if test.Result.State != "" {
 			continue
 		}
-
-		switch event.Action {
-		case "run":
-			t := &tester.T{
-				TB: tester.TB{
-					Name:      event.Test,
-					StartedAt: event.Time,
-				},
-			}
-			tMap[event.Test] = t
-
-			if event.TopLevel() {
-				testMap[t] = &tester.Test{
-					ID:     uuid.New(),
-					Result: t,
-				}
-			} else {
-				parentT, ok := tMap[event.ParentTest()]
-				if !ok {
-					return nil, fmt.Errorf("missing parent t %s for sub t %s", event.ParentTest(), event.Test)
-				}
-				parentT.SubTs = append(parentT.SubTs, t)
-			}
-		case "pass", "fail", "skip":
-			t, ok := tMap[event.Test]
-			if !ok {
-				return nil, fmt.Errorf("missing t: %s", event.Test)
-			}
-			t.FinishedAt = event.Time
-			switch event.Action {
-			case "pass":
-				t.State = tester.TBStatePassed
-			case "fail":
-				t.State = tester.TBStateFailed
-			case "skip":
-				t.State = tester.TBStateSkipped
-			}
-		case "output":
-			t, ok := tMap[event.TopLevelTest()]
-			if !ok {
-				return nil, fmt.Errorf("missing t: %s", event.Test)
-			}
-
-			test, ok := testMap[t]
-			if !ok {
-				return nil, fmt.Errorf("missing test: %s", t.Name)
-			}
-
-			test.Logs = append(test.Logs, tester.TBLog{
-				Time:   event.Time,
-				Name:   event.Test,
-				Output: event.Output.Bytes(),
-			})
-		}
-	}
-
-	var tests []*tester.Test
-	for _, test := range testMap {
-		tests = append(tests, test)
+		test.Result.ErrorMessage = fmt.Sprintf("output truncated after exceeding the maximum output size, %d bytes discarded", truncated)
+		test.Logs = append(test.Logs, tester.TBLog{
+			Time:   time.Now(),
+			Name:   test.Result.Name,
+			Output: []byte(truncatedMarker(truncated)),
+		})
 	}
-	return tests, nil
 }
@@ -0,0 +1,1223 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nanzhong/tester"
+	testerhttp "github.com/nanzhong/tester/http"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func withRunner(t *testing.T, handler http.HandlerFunc, fn func(r *Runner)) {
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	r, err := New(WithTesterAddr(ts.URL), WithTestBinsPath(t.TempDir()))
+	require.NoError(t, err)
+	r.submissionRetryDelay = time.Millisecond
+	r.claimRetryDelay = time.Millisecond
+
+	fn(r)
+}
+
+func TestRunner_ID(t *testing.T) {
+	t.Run("persists across restarts", func(t *testing.T) {
+		testBinsPath := t.TempDir()
+
+		r1, err := New(WithTestBinsPath(testBinsPath))
+		require.NoError(t, err)
+		assert.NotEqual(t, uuid.Nil, r1.ID())
+
+		r2, err := New(WithTestBinsPath(testBinsPath))
+		require.NoError(t, err)
+		assert.Equal(t, r1.ID(), r2.ID())
+	})
+
+	t.Run("unique per path", func(t *testing.T) {
+		r1, err := New(WithTestBinsPath(t.TempDir()))
+		require.NoError(t, err)
+
+		r2, err := New(WithTestBinsPath(t.TempDir()))
+		require.NoError(t, err)
+		assert.NotEqual(t, r1.ID(), r2.ID())
+	})
+
+	t.Run("sent with api requests", func(t *testing.T) {
+		var runnerID string
+		withRunner(t, func(w http.ResponseWriter, r *http.Request) {
+			runnerID = r.Header.Get(testerhttp.RunnerIDHeader)
+			w.WriteHeader(http.StatusOK)
+		}, func(r *Runner) {
+			err := r.completeRun(context.Background(), uuid.New())
+			require.NoError(t, err)
+			assert.Equal(t, r.ID().String(), runnerID)
+		})
+	})
+}
+
+func TestRunner_getPackageInfo(t *testing.T) {
+	pkg := &tester.Package{Name: "pkg", Options: []tester.Option{{Name: "test.run", Default: strings.Repeat("TestA|", 1000)}}}
+	var acceptEncoding string
+	handler := testerhttp.GzipMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		acceptEncoding = r.Header.Get("Accept-Encoding")
+		require.NoError(t, json.NewEncoder(w).Encode(pkg))
+	}), testerhttp.DefaultGzipMinSize)
+
+	withRunner(t, handler.ServeHTTP, func(r *Runner) {
+		got, err := r.getPackageInfo(context.Background(), "pkg")
+		require.NoError(t, err)
+		assert.Equal(t, "gzip", acceptEncoding)
+		assert.Equal(t, pkg, got)
+	})
+}
+
+func TestRunner_submit(t *testing.T) {
+	failingHandler := func(failures int32, status int, attempts *int32) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			if atomic.AddInt32(attempts, 1) <= failures {
+				w.WriteHeader(status)
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+
+	t.Run("succeeds after retries", func(t *testing.T) {
+		var attempts int32
+		withRunner(t, failingHandler(3, http.StatusServiceUnavailable, &attempts), func(r *Runner) {
+			err := r.completeRun(context.Background(), uuid.New())
+			require.NoError(t, err)
+			assert.EqualValues(t, 4, atomic.LoadInt32(&attempts))
+		})
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		var attempts int32
+		withRunner(t, failingHandler(10, http.StatusServiceUnavailable, &attempts), func(r *Runner) {
+			r.maxSubmissionAttempts = 3
+
+			err := r.completeRun(context.Background(), uuid.New())
+			require.Error(t, err)
+			assert.EqualValues(t, 3, atomic.LoadInt32(&attempts))
+		})
+	})
+
+	t.Run("does not retry client errors", func(t *testing.T) {
+		var attempts int32
+		withRunner(t, failingHandler(1, http.StatusBadRequest, &attempts), func(r *Runner) {
+			err := r.completeRun(context.Background(), uuid.New())
+			require.Error(t, err)
+			assert.EqualValues(t, 1, atomic.LoadInt32(&attempts))
+		})
+	})
+
+	t.Run("respects context", func(t *testing.T) {
+		var attempts int32
+		withRunner(t, failingHandler(10, http.StatusServiceUnavailable, &attempts), func(r *Runner) {
+			r.submissionRetryDelay = time.Minute
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			err := r.submit(ctx, r.testerAddr, nil, http.StatusOK)
+			require.Error(t, err)
+			assert.EqualValues(t, 1, atomic.LoadInt32(&attempts))
+		})
+	})
+}
+
+func TestRunner_spool(t *testing.T) {
+	var (
+		down     int32 = 1
+		mu       sync.Mutex
+		received []string
+	)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		mu.Lock()
+		received = append(received, r.URL.Path)
+		mu.Unlock()
+
+		if r.URL.Path == "/api/tests" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+
+	withRunner(t, handler, func(r *Runner) {
+		r.maxSubmissionAttempts = 1
+
+		runID := uuid.New()
+		test := &tester.Test{
+			ID:      uuid.New(),
+			RunID:   runID,
+			Package: "pkg",
+			Result: &tester.T{
+				TB: tester.TB{Name: "TestA", State: tester.TBStatePassed},
+			},
+		}
+
+		err := r.reportResult(context.Background(), &runResult{
+			RunID:   runID,
+			Package: "pkg",
+			Tests:   []*tester.Test{test},
+		})
+		require.NoError(t, err)
+		assert.FileExists(t, r.spoolFilePath(runID))
+
+		t.Run("server still down", func(t *testing.T) {
+			err := r.drainSpool(context.Background())
+			require.Error(t, err)
+			assert.FileExists(t, r.spoolFilePath(runID))
+		})
+
+		t.Run("server back up", func(t *testing.T) {
+			atomic.StoreInt32(&down, 0)
+
+			err := r.drainSpool(context.Background())
+			require.NoError(t, err)
+			assert.NoFileExists(t, r.spoolFilePath(runID))
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, []string{
+				"/api/tests",
+				fmt.Sprintf("/api/runs/%s/complete", runID),
+			}, received)
+		})
+	})
+}
+
+func TestRunner_reportResult_rejected(t *testing.T) {
+	var (
+		down     int32
+		mu       sync.Mutex
+		finished string
+		runError string
+	)
+	// The server rejects tests named TestRejected, and is otherwise
+	// unavailable while down is set.
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tests" {
+			var test tester.Test
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&test))
+			if test.Result.Name == "TestRejected" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+		}
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		if r.URL.Path == "/api/tests" {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		finished = r.URL.Path
+		if strings.HasSuffix(r.URL.Path, "/fail") {
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&runError))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	newTest := func(runID uuid.UUID, name string) *tester.Test {
+		return &tester.Test{
+			ID:      uuid.New(),
+			RunID:   runID,
+			Package: "pkg",
+			Result:  &tester.T{TB: tester.TB{Name: name, State: tester.TBStatePassed}},
+		}
+	}
+
+	t.Run("all tests rejected", func(t *testing.T) {
+		withRunner(t, handler, func(r *Runner) {
+			r.maxSubmissionAttempts = 1
+
+			runID := uuid.New()
+			err := r.reportResult(context.Background(), &runResult{
+				RunID:   runID,
+				Package: "pkg",
+				Tests:   []*tester.Test{newTest(runID, "TestRejected"), newTest(runID, "TestRejected")},
+			})
+			require.NoError(t, err)
+			assert.NoFileExists(t, r.spoolFilePath(runID))
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, fmt.Sprintf("/api/runs/%s/fail", runID), finished, "run should be failed rather than left unfinished")
+			assert.Contains(t, runError, "2 test result(s) rejected")
+			assert.Contains(t, runError, "400")
+		})
+	})
+
+	t.Run("rejection kept when spooled", func(t *testing.T) {
+		withRunner(t, handler, func(r *Runner) {
+			r.maxSubmissionAttempts = 1
+			atomic.StoreInt32(&down, 1)
+
+			runID := uuid.New()
+			err := r.reportResult(context.Background(), &runResult{
+				RunID:   runID,
+				Package: "pkg",
+				Tests:   []*tester.Test{newTest(runID, "TestRejected"), newTest(runID, "TestA")},
+			})
+			require.NoError(t, err)
+			assert.FileExists(t, r.spoolFilePath(runID))
+
+			atomic.StoreInt32(&down, 0)
+			require.NoError(t, r.drainSpool(context.Background()))
+			assert.NoFileExists(t, r.spoolFilePath(runID))
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, fmt.Sprintf("/api/runs/%s/fail", runID), finished)
+			assert.Contains(t, runError, "1 test result(s) rejected")
+		})
+	})
+}
+
+func TestRunner_reportResult_tooLarge(t *testing.T) {
+	const maxBytes = 4 << 10
+
+	var (
+		mu        sync.Mutex
+		submitted []*tester.Test
+		finished  string
+		runError  string
+	)
+	// The server rejects submissions larger than maxBytes, as it does with
+	// --max-request-body-bytes.
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		if len(body) > maxBytes {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		if r.URL.Path == "/api/tests" {
+			var test tester.Test
+			require.NoError(t, json.Unmarshal(body, &test))
+			submitted = append(submitted, &test)
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		finished = r.URL.Path
+		if strings.HasSuffix(r.URL.Path, "/fail") {
+			require.NoError(t, json.Unmarshal(body, &runError))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	newTest := func(runID uuid.UUID, name string) *tester.Test {
+		log := tester.TBLog{Name: name, Output: bytes.Repeat([]byte("x"), 2*maxBytes)}
+		return &tester.Test{
+			ID:      uuid.New(),
+			RunID:   runID,
+			Package: "pkg",
+			Result: &tester.T{
+				TB:    tester.TB{Name: name, State: tester.TBStateFailed},
+				Logs:  []tester.TBLog{log},
+				SubTs: []*tester.T{{TB: tester.TB{Name: name + "/sub", State: tester.TBStateFailed}, Logs: []tester.TBLog{log}}},
+			},
+			Logs: []tester.TBLog{log, log},
+		}
+	}
+
+	t.Run("resubmitted without logs", func(t *testing.T) {
+		withRunner(t, handler, func(r *Runner) {
+			runID := uuid.New()
+			err := r.reportResult(context.Background(), &runResult{
+				RunID:   runID,
+				Package: "pkg",
+				Tests:   []*tester.Test{newTest(runID, "TestLarge")},
+			})
+			require.NoError(t, err)
+			assert.NoFileExists(t, r.spoolFilePath(runID))
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, fmt.Sprintf("/api/runs/%s/complete", runID), finished)
+			require.Len(t, submitted, 1)
+			test := submitted[0]
+			assert.Equal(t, "TestLarge", test.Result.Name)
+			assert.Equal(t, tester.TBStateFailed, test.Result.State)
+			assert.Empty(t, test.Result.Logs)
+			require.Len(t, test.Result.SubTs, 1)
+			assert.Equal(t, "TestLarge/sub", test.Result.SubTs[0].Name)
+			assert.Empty(t, test.Result.SubTs[0].Logs)
+			require.Len(t, test.Logs, 1)
+			assert.Contains(t, string(test.Logs[0].Output), "logs dropped")
+		})
+	})
+
+	t.Run("run failed when still too large", func(t *testing.T) {
+		withRunner(t, handler, func(r *Runner) {
+			runID := uuid.New()
+			test := newTest(runID, "TestLarge")
+			test.Result.ErrorMessage = string(bytes.Repeat([]byte("x"), 2*maxBytes))
+			err := r.reportResult(context.Background(), &runResult{
+				RunID:   runID,
+				Package: "pkg",
+				Tests:   []*tester.Test{test},
+			})
+			require.NoError(t, err)
+			assert.NoFileExists(t, r.spoolFilePath(runID))
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, fmt.Sprintf("/api/runs/%s/fail", runID), finished, "run should be failed rather than left unfinished")
+			assert.Contains(t, runError, "1 test result(s) rejected")
+			assert.Contains(t, runError, "413")
+		})
+	})
+}
+
+func TestRunner_downloadTestBinary(t *testing.T) {
+	binPath := filepath.Join(t.TempDir(), "pkg.test")
+	require.NoError(t, ioutil.WriteFile(binPath, []byte("binary"), 0644))
+	pkg := &tester.Package{Name: "pkg", Path: binPath}
+	sha256Sum, err := pkg.ComputeSHA256Sum()
+	require.NoError(t, err)
+	pkg.SHA256Sum = sha256Sum
+
+	matrix := &tester.Package{Name: "matrix"}
+	for _, name := range []string{"go1", "go2"} {
+		path := filepath.Join(t.TempDir(), name+".test")
+		require.NoError(t, ioutil.WriteFile(path, []byte(name), 0644))
+		sha256Sum, err := (&tester.Package{Path: path}).ComputeSHA256Sum()
+		require.NoError(t, err)
+		matrix.Variants = append(matrix.Variants, tester.Variant{Name: name, Path: path, SHA256Sum: sha256Sum})
+	}
+
+	var (
+		mu       sync.Mutex
+		statuses []int
+	)
+	api := testerhttp.NewAPIHandler(nil, []*tester.Package{pkg, matrix})
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		rec := httptest.NewRecorder()
+		api.ServeHTTP(rec, r)
+		mu.Lock()
+		statuses = append(statuses, rec.Code)
+		mu.Unlock()
+
+		for k, v := range rec.Header() {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(rec.Code)
+		w.Write(rec.Body.Bytes())
+	}
+
+	withRunner(t, handler, func(r *Runner) {
+		t.Run("downloads missing binary", func(t *testing.T) {
+			localSHA256Sum, err := r.localTestBinarySHA256Sum(pkg, "")
+			require.NoError(t, err)
+			assert.Empty(t, localSHA256Sum)
+
+			err = r.downloadTestBinary(context.Background(), pkg, "", localSHA256Sum)
+			require.NoError(t, err)
+
+			localSHA256Sum, err = r.localTestBinarySHA256Sum(pkg, "")
+			require.NoError(t, err)
+			assert.Equal(t, pkg.SHA256Sum, localSHA256Sum)
+		})
+
+		t.Run("skips current binary", func(t *testing.T) {
+			err := r.downloadTestBinary(context.Background(), pkg, "", pkg.SHA256Sum)
+			require.NoError(t, err)
+		})
+
+		t.Run("downloads variant binary", func(t *testing.T) {
+			for _, name := range matrix.VariantNames() {
+				variant, err := matrix.Variant(name)
+				require.NoError(t, err)
+
+				err = r.downloadTestBinary(context.Background(), variant, name, "")
+				require.NoError(t, err)
+
+				localSHA256Sum, err := r.localTestBinarySHA256Sum(variant, name)
+				require.NoError(t, err)
+				assert.Equal(t, variant.SHA256Sum, localSHA256Sum)
+			}
+		})
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.Equal(t, []int{http.StatusOK, http.StatusNotModified, http.StatusOK, http.StatusOK}, statuses)
+	})
+}
+
+func TestRunner_runOnce_notModified(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found, skipping run. test2json is needed to run tests.")
+	}
+
+	script := []byte(`#!/bin/sh
+echo "=== RUN   TestA"
+echo "--- PASS: TestA (0.00s)"
+echo "PASS"
+`)
+	binPath := filepath.Join(t.TempDir(), "pkg.test")
+	require.NoError(t, ioutil.WriteFile(binPath, script, 0755))
+	pkg := &tester.Package{Name: "pkg", Path: binPath, SHA256Sum: fmt.Sprintf("%x", sha256.Sum256(script))}
+
+	var (
+		mu        sync.Mutex
+		downloads []int
+		completed bool
+	)
+	api := testerhttp.NewAPIHandler(nil, []*tester.Package{pkg})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/api/runs/claim":
+			json.NewEncoder(w).Encode(&tester.Run{ID: uuid.New(), Package: "pkg"})
+		case strings.HasPrefix(req.URL.Path, "/api/packages/"):
+			rec := httptest.NewRecorder()
+			api.ServeHTTP(rec, req)
+			if strings.HasSuffix(req.URL.Path, "/download") {
+				mu.Lock()
+				downloads = append(downloads, rec.Code)
+				mu.Unlock()
+			}
+			for k, v := range rec.Header() {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(rec.Code)
+			w.Write(rec.Body.Bytes())
+		case req.URL.Path == "/api/tests":
+			w.WriteHeader(http.StatusAccepted)
+		case strings.HasSuffix(req.URL.Path, "/complete"):
+			mu.Lock()
+			completed = true
+			mu.Unlock()
+		}
+	}))
+	defer ts.Close()
+
+	r, err := New(WithTestBinsPath(t.TempDir()), WithTesterAddr(ts.URL))
+	require.NoError(t, err)
+
+	// The first run downloads the test binary, after which the server
+	// reports that the local test binary is current.
+	for i := 0; i < 2; i++ {
+		claimed, err := r.runOnce(context.Background())
+		require.NoError(t, err)
+		require.True(t, claimed)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []int{http.StatusOK, http.StatusNotModified}, downloads)
+	assert.True(t, completed)
+}
+
+func TestRunner_runOnce_variant(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found, skipping run. test2json is needed to run tests.")
+	}
+
+	pkg := &tester.Package{Name: "matrix"}
+	for _, name := range []string{"go1", "go2"} {
+		script := []byte(fmt.Sprintf(`#!/bin/sh
+echo "=== RUN   Test_%[1]s"
+echo "--- PASS: Test_%[1]s (0.00s)"
+echo "PASS"
+`, name))
+		path := filepath.Join(t.TempDir(), name+".test")
+		require.NoError(t, ioutil.WriteFile(path, script, 0755))
+		pkg.Variants = append(pkg.Variants, tester.Variant{Name: name, Path: path, SHA256Sum: fmt.Sprintf("%x", sha256.Sum256(script))})
+	}
+
+	var (
+		mu        sync.Mutex
+		submitted []string
+		completed bool
+	)
+	// Package info and test binaries are served by the API handler, so that
+	// the runner gets the package as the server represents it.
+	api := testerhttp.NewAPIHandler(nil, []*tester.Package{pkg})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/api/runs/claim":
+			json.NewEncoder(w).Encode(&tester.Run{ID: uuid.New(), Package: "matrix", Variant: "go2"})
+		case strings.HasPrefix(req.URL.Path, "/api/packages/"):
+			api.ServeHTTP(w, req)
+		case req.URL.Path == "/api/tests":
+			var test tester.Test
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&test))
+			mu.Lock()
+			submitted = append(submitted, test.Result.Name)
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		case strings.HasSuffix(req.URL.Path, "/complete"):
+			mu.Lock()
+			completed = true
+			mu.Unlock()
+		default:
+			t.Errorf("unexpected request: %s", req.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	r, err := New(WithTestBinsPath(t.TempDir()), WithTesterAddr(ts.URL))
+	require.NoError(t, err)
+
+	claimed, err := r.runOnce(context.Background())
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []string{"Test_go2"}, submitted)
+	assert.True(t, completed)
+}
+
+func TestRunner_submitTestResults(t *testing.T) {
+	newTests := func(n int) []*tester.Test {
+		var tests []*tester.Test
+		for i := 0; i < n; i++ {
+			tests = append(tests, &tester.Test{
+				ID:      uuid.New(),
+				Package: "pkg",
+				Result: &tester.T{
+					TB: tester.TB{Name: fmt.Sprintf("Test%d", i), State: tester.TBStatePassed},
+				},
+			})
+		}
+		return tests
+	}
+
+	t.Run("submits all with bounded concurrency", func(t *testing.T) {
+		var (
+			inFlight    int32
+			maxInFlight int32
+			mu          sync.Mutex
+			received    = make(map[uuid.UUID]bool)
+		)
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				max := atomic.LoadInt32(&maxInFlight)
+				if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+
+			var test tester.Test
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&test))
+			mu.Lock()
+			received[test.ID] = true
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		}
+
+		withRunner(t, handler, func(r *Runner) {
+			r.submissionConcurrency = 3
+
+			tests := newTests(20)
+			remaining, err := r.submitTestResults(context.Background(), tests)
+			require.NoError(t, err)
+			assert.Empty(t, remaining)
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Len(t, received, len(tests))
+			for _, test := range tests {
+				assert.True(t, received[test.ID])
+			}
+			assert.LessOrEqual(t, atomic.LoadInt32(&maxInFlight), int32(3))
+		})
+	})
+
+	t.Run("aggregates errors", func(t *testing.T) {
+		tests := newTests(5)
+		statuses := map[uuid.UUID]int{
+			tests[1].ID: http.StatusBadRequest,
+			tests[3].ID: http.StatusServiceUnavailable,
+		}
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			var test tester.Test
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&test))
+			if status, ok := statuses[test.ID]; ok {
+				w.WriteHeader(status)
+				return
+			}
+			w.WriteHeader(http.StatusAccepted)
+		}
+
+		withRunner(t, handler, func(r *Runner) {
+			r.maxSubmissionAttempts = 1
+
+			remaining, err := r.submitTestResults(context.Background(), tests)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "400")
+			assert.Contains(t, err.Error(), "503")
+			assert.False(t, isPermanent(err))
+
+			// Only the test that may succeed when resubmitted remains.
+			assert.Equal(t, []*tester.Test{tests[3]}, remaining)
+		})
+	})
+}
+
+func TestRunner_filterTests(t *testing.T) {
+	var tests []*tester.Test
+	for i, state := range []tester.TBState{tester.TBStatePassed, tester.TBStateFailed, tester.TBStateSkipped, tester.TBStateFailed} {
+		tests = append(tests, &tester.Test{
+			ID:      uuid.New(),
+			Package: "pkg",
+			Result: &tester.T{
+				TB: tester.TB{Name: fmt.Sprintf("Test%d", i), State: state},
+			},
+		})
+	}
+
+	var (
+		mu        sync.Mutex
+		submitted []uuid.UUID
+		completed bool
+	)
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/api/tests":
+			var test tester.Test
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&test))
+			submitted = append(submitted, test.ID)
+			w.WriteHeader(http.StatusAccepted)
+		case strings.HasSuffix(r.URL.Path, "/complete"):
+			completed = true
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+
+	t.Run("no filter", func(t *testing.T) {
+		withRunner(t, handler, func(r *Runner) {
+			assert.Equal(t, tests, r.filterTests(tests))
+		})
+	})
+
+	t.Run("submit only failed", func(t *testing.T) {
+		withRunner(t, handler, func(r *Runner) {
+			WithResultFilter(SubmitOnlyFailed())(r)
+			filtered := r.filterTests(tests)
+			assert.Equal(t, []*tester.Test{tests[1], tests[3]}, filtered)
+
+			mu.Lock()
+			submitted, completed = nil, false
+			mu.Unlock()
+			err := r.reportResult(context.Background(), &runResult{RunID: uuid.New(), Package: "pkg", Tests: filtered})
+			require.NoError(t, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.ElementsMatch(t, []uuid.UUID{tests[1].ID, tests[3].ID}, submitted)
+			assert.True(t, completed)
+		})
+	})
+
+	t.Run("run completes without submitted tests", func(t *testing.T) {
+		withRunner(t, handler, func(r *Runner) {
+			WithResultFilter(func(*tester.Test) bool { return false })(r)
+			filtered := r.filterTests(tests)
+			assert.Empty(t, filtered)
+
+			mu.Lock()
+			submitted, completed = nil, false
+			mu.Unlock()
+			err := r.reportResult(context.Background(), &runResult{RunID: uuid.New(), Package: "pkg", Tests: filtered})
+			require.NoError(t, err)
+
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Empty(t, submitted)
+			assert.True(t, completed)
+		})
+	})
+}
+
+func TestRunner_runOnce_verbose(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found, skipping run. test2json is needed to run tests.")
+	}
+
+	// A script stands in for the test binary, printing the output of
+	// -test.v.
+	script := []byte(`#!/bin/sh
+echo "=== RUN   TestA"
+echo "    a_test.go:10: hello"
+echo "--- PASS: TestA (0.50s)"
+echo "=== RUN   TestB"
+echo "    b_test.go:10: boom"
+echo "--- FAIL: TestB (0.00s)"
+echo "FAIL"
+exit 1
+`)
+	sha256Sum := fmt.Sprintf("%x", sha256.Sum256(script))
+	run := &tester.Run{ID: uuid.New(), Package: "pkg"}
+
+	var (
+		mu        sync.Mutex
+		submitted []*tester.Test
+		completed bool
+	)
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/runs/claim":
+			json.NewEncoder(w).Encode(run)
+		case "/api/packages/pkg":
+			json.NewEncoder(w).Encode(&tester.Package{Name: "pkg", SHA256Sum: sha256Sum})
+		case "/api/tests":
+			var test tester.Test
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&test))
+			mu.Lock()
+			submitted = append(submitted, &test)
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		case fmt.Sprintf("/api/runs/%s/complete", run.ID):
+			mu.Lock()
+			completed = true
+			mu.Unlock()
+		default:
+			t.Errorf("unexpected request: %s %s", req.Method, req.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+
+	withRunner(t, handler, func(r *Runner) {
+		var verbose bytes.Buffer
+		WithVerboseOutput(&verbose)(r)
+		r.localTestBinsOnly = true
+		require.NoError(t, ioutil.WriteFile(r.testBinaryPath("pkg", ""), script, 0755))
+
+		claimed, err := r.runOnce(context.Background())
+		require.NoError(t, err)
+		assert.True(t, claimed)
+
+		assert.Contains(t, verbose.String(), "=== RUN   TestA\n")
+		assert.Contains(t, verbose.String(), "--- FAIL: TestB (0.00s)\n")
+
+		mu.Lock()
+		defer mu.Unlock()
+		assert.True(t, completed)
+		require.Len(t, submitted, 2)
+		states := map[string]tester.TBState{}
+		for _, test := range submitted {
+			assert.Equal(t, run.ID, test.RunID)
+			assert.Equal(t, run.Package, test.Package)
+			states[test.Result.Name] = test.Result.State
+		}
+		assert.Equal(t, map[string]tester.TBState{
+			"TestA": tester.TBStatePassed,
+			"TestB": tester.TBStateFailed,
+		}, states)
+	})
+}
+
+func TestRunner_Run_concurrency(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found, skipping run. test2json is needed to run tests.")
+	}
+
+	const concurrency = 3
+
+	// The script stands in for the test binary. Each invocation waits for all
+	// of the runs to have started, which only happens when they are running
+	// concurrently.
+	started := t.TempDir()
+	script := []byte(fmt.Sprintf(`#!/bin/sh
+touch %[1]s/$$
+i=0
+while [ $(ls %[1]s | wc -l) -lt %[2]d ]; do
+  i=$((i+1))
+  if [ $i -gt 500 ]; then
+    echo "timed out waiting for concurrent runs" >&2
+    exit 2
+  fi
+  sleep 0.01
+done
+echo "=== RUN   TestA"
+echo "--- PASS: TestA (0.00s)"
+echo "PASS"
+`, started, concurrency))
+	sha256Sum := fmt.Sprintf("%x", sha256.Sum256(script))
+
+	var runs []*tester.Run
+	for i := 0; i < concurrency; i++ {
+		runs = append(runs, &tester.Run{ID: uuid.New(), Package: "pkg"})
+	}
+
+	var (
+		claimed   int32
+		mu        sync.Mutex
+		submitted = map[uuid.UUID]int{}
+		completed = map[uuid.UUID]bool{}
+		done      = make(chan struct{})
+	)
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		switch {
+		case req.URL.Path == "/api/runs/claim":
+			i := int(atomic.AddInt32(&claimed, 1)) - 1
+			if i >= len(runs) {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			json.NewEncoder(w).Encode(runs[i])
+		case req.URL.Path == "/api/packages/pkg":
+			json.NewEncoder(w).Encode(&tester.Package{Name: "pkg", SHA256Sum: sha256Sum})
+		case req.URL.Path == "/api/tests":
+			var test tester.Test
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&test))
+			mu.Lock()
+			submitted[test.RunID]++
+			mu.Unlock()
+			w.WriteHeader(http.StatusAccepted)
+		case strings.HasSuffix(req.URL.Path, "/complete"):
+			runID, err := uuid.Parse(strings.Split(req.URL.Path, "/")[3])
+			require.NoError(t, err)
+			mu.Lock()
+			completed[runID] = true
+			if len(completed) == len(runs) {
+				close(done)
+			}
+			mu.Unlock()
+		default:
+			t.Errorf("unexpected request: %s %s", req.Method, req.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+
+	withRunner(t, handler, func(r *Runner) {
+		WithConcurrency(concurrency)(r)
+		r.localTestBinsOnly = true
+		require.NoError(t, ioutil.WriteFile(r.testBinaryPath("pkg", ""), script, 0755))
+
+		go r.Run()
+		select {
+		case <-done:
+		case <-time.After(30 * time.Second):
+			t.Fatal("timed out waiting for runs to complete")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		r.Stop(ctx)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, run := range runs {
+			assert.True(t, completed[run.ID])
+			assert.Equal(t, 1, submitted[run.ID])
+		}
+	})
+}
+
+func TestRunner_Run_idleTimeout(t *testing.T) {
+	var claims int32
+	handler := func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/api/runs/claim" {
+			t.Errorf("unexpected request: %s %s", req.Method, req.URL.Path)
+		}
+		atomic.AddInt32(&claims, 1)
+		w.WriteHeader(http.StatusNotFound)
+	}
+
+	t.Run("returns when idle", func(t *testing.T) {
+		withRunner(t, handler, func(r *Runner) {
+			WithIdleTimeout(200 * time.Millisecond)(r)
+			WithMaxRetryDelay(20 * time.Millisecond)(r)
+			WithConcurrency(2)(r)
+
+			start := time.Now()
+			errs := make(chan error, 1)
+			go func() { errs <- r.Run() }()
+
+			select {
+			case err := <-errs:
+				assert.Equal(t, ErrIdleTimeout, err)
+				assert.True(t, time.Since(start) >= 200*time.Millisecond)
+				assert.Greater(t, atomic.LoadInt32(&claims), int32(2))
+			case <-time.After(10 * time.Second):
+				t.Fatal("timed out waiting for idle runner to return")
+			}
+		})
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		withRunner(t, handler, func(r *Runner) {
+			WithMaxRetryDelay(20 * time.Millisecond)(r)
+
+			errs := make(chan error, 1)
+			go func() { errs <- r.Run() }()
+
+			select {
+			case err := <-errs:
+				t.Fatalf("runner returned while running: %v", err)
+			case <-time.After(300 * time.Millisecond):
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			r.Stop(ctx)
+			assert.NoError(t, <-errs)
+		})
+	})
+}
+
+func TestRunner_retryDelay(t *testing.T) {
+	r, err := New(WithTestBinsPath(t.TempDir()), WithMaxRetryDelay(time.Second))
+	require.NoError(t, err)
+
+	for failures, base := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+		base *= time.Millisecond
+		delay := r.retryDelay(100*time.Millisecond, failures)
+		assert.True(t, delay >= base/2 && delay <= base, "failures: %d, delay: %s", failures, delay)
+	}
+
+	// The delay is capped without overflowing after many failures.
+	delay := r.retryDelay(100*time.Millisecond, 1000)
+	assert.True(t, delay > 0 && delay <= time.Second, "delay: %s", delay)
+}
+
+func TestRunner_runOnce_retain(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found, skipping run. test2json is needed to run tests.")
+	}
+
+	passing := []byte(`#!/bin/sh
+echo "=== RUN   TestA"
+echo "--- PASS: TestA (0.00s)"
+echo "PASS"
+`)
+	failing := []byte(`#!/bin/sh
+echo "=== RUN   TestA"
+echo "--- FAIL: TestA (0.00s)"
+echo "FAIL"
+exit 1
+`)
+
+	// runScript runs a run of the script as the package's test binary,
+	// returning the run.
+	runScript := func(t *testing.T, r *Runner, script []byte, args []string) *tester.Run {
+		run := &tester.Run{ID: uuid.New(), Package: "pkg", Args: args}
+		sha256Sum := fmt.Sprintf("%x", sha256.Sum256(script))
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			switch req.URL.Path {
+			case "/api/runs/claim":
+				json.NewEncoder(w).Encode(run)
+			case "/api/packages/pkg":
+				json.NewEncoder(w).Encode(&tester.Package{Name: "pkg", SHA256Sum: sha256Sum})
+			case "/api/tests":
+				w.WriteHeader(http.StatusAccepted)
+			}
+		}))
+		defer ts.Close()
+
+		r.testerAddr = ts.URL
+		require.NoError(t, ioutil.WriteFile(r.testBinaryPath("pkg", ""), script, 0755))
+		claimed, err := r.runOnce(context.Background())
+		require.NoError(t, err)
+		require.True(t, claimed)
+		return run
+	}
+
+	newRunner := func(t *testing.T, opts ...Option) *Runner {
+		r, err := New(append([]Option{WithTestBinsPath(t.TempDir()), WithRetainPath(t.TempDir())}, opts...)...)
+		require.NoError(t, err)
+		r.localTestBinsOnly = true
+		return r
+	}
+
+	t.Run("retained on failure", func(t *testing.T) {
+		r := newRunner(t)
+		run := runScript(t, r, failing, []string{"-test.run=TestA"})
+
+		dir := r.runArtifactsPath(run.ID)
+		data, err := ioutil.ReadFile(filepath.Join(dir, retainedManifestName))
+		require.NoError(t, err)
+		var manifest retainedRun
+		require.NoError(t, json.Unmarshal(data, &manifest))
+		assert.Equal(t, run.ID, manifest.RunID)
+		assert.Equal(t, "pkg", manifest.Package)
+		assert.Equal(t, []string{"-test.v", "-test.run=TestA"}, manifest.Args)
+		assert.NotEmpty(t, manifest.Env)
+		assert.Equal(t, []string{"TestA"}, manifest.FailedTests)
+
+		// The retained binary is the one that ran, even once the package's
+		// binary is replaced.
+		require.NoError(t, ioutil.WriteFile(r.testBinaryPath("pkg", "")+".new", passing, 0755))
+		require.NoError(t, os.Rename(r.testBinaryPath("pkg", "")+".new", r.testBinaryPath("pkg", "")))
+		bin, err := ioutil.ReadFile(manifest.Binary)
+		require.NoError(t, err)
+		assert.Equal(t, failing, bin)
+	})
+
+	t.Run("cleaned up on success", func(t *testing.T) {
+		r := newRunner(t)
+		run := runScript(t, r, passing, nil)
+
+		_, err := os.Stat(r.runArtifactsPath(run.ID))
+		assert.True(t, os.IsNotExist(err))
+		entries, err := ioutil.ReadDir(r.retainPath)
+		require.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+
+	t.Run("prunes oldest beyond max", func(t *testing.T) {
+		r := newRunner(t, WithMaxRetainedRuns(2))
+		var runs []*tester.Run
+		for i := 0; i < 3; i++ {
+			runs = append(runs, runScript(t, r, failing, nil))
+		}
+
+		_, err := os.Stat(r.runArtifactsPath(runs[0].ID))
+		assert.True(t, os.IsNotExist(err))
+		for _, run := range runs[1:] {
+			_, err := os.Stat(filepath.Join(r.runArtifactsPath(run.ID), retainedManifestName))
+			assert.NoError(t, err)
+		}
+	})
+}
+
+func TestRunner_runOnce_redactedSecrets(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "ran")
+	script := []byte(fmt.Sprintf("#!/bin/sh\ntouch %s\n", out))
+	pkg := &tester.Package{
+		Name:      "pkg",
+		SHA256Sum: fmt.Sprintf("%x", sha256.Sum256(script)),
+		Environment: []tester.EnvVar{
+			{Name: "TESTER_DB_URL", Value: "postgres://db"},
+			{Name: "TESTER_TOKEN", Value: "hunter2", Secret: true},
+		},
+	}
+	run := &tester.Run{ID: uuid.New(), Package: "pkg"}
+
+	var (
+		mu       sync.Mutex
+		runError string
+	)
+	// The package is served as it is to clients that don't authenticate
+	// with a runner key.
+	api := testerhttp.NewAPIHandler(nil, []*tester.Package{pkg})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/runs/claim":
+			json.NewEncoder(w).Encode(run)
+		case "/api/packages/pkg":
+			api.ServeHTTP(w, req)
+		case fmt.Sprintf("/api/runs/%s/fail", run.ID):
+			mu.Lock()
+			defer mu.Unlock()
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&runError))
+		default:
+			t.Errorf("unexpected request: %s", req.URL)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	r, err := New(WithTestBinsPath(t.TempDir()), WithTesterAddr(ts.URL), WithLocalTestBinsOnly())
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(r.testBinaryPath("pkg", ""), script, 0755))
+
+	claimed, err := r.runOnce(context.Background())
+	require.Error(t, err)
+	assert.True(t, claimed)
+	assert.NoFileExists(t, out, "test binary should not be run without its secrets")
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Contains(t, runError, "secrets TESTER_TOKEN were redacted")
+	assert.Contains(t, runError, "runner key")
+}
+
+func TestRunner_runOnce_environment(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go not found, skipping run. test2json is needed to run tests.")
+	}
+
+	script := []byte(`#!/bin/sh
+echo "$TESTER_DB_URL $TESTER_TOKEN" > "$TESTER_OUT"
+echo "=== RUN   TestA"
+echo "--- PASS: TestA (0.00s)"
+echo "PASS"
+`)
+	out := filepath.Join(t.TempDir(), "env")
+	pkg := &tester.Package{
+		Name:      "pkg",
+		SHA256Sum: fmt.Sprintf("%x", sha256.Sum256(script)),
+		Environment: []tester.EnvVar{
+			{Name: "TESTER_OUT", Value: out},
+			{Name: "TESTER_DB_URL", Value: "postgres://db"},
+			{Name: "TESTER_TOKEN", Value: "hunter2", Secret: true},
+		},
+	}
+	run := &tester.Run{ID: uuid.New(), Package: "pkg"}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Path {
+		case "/api/runs/claim":
+			json.NewEncoder(w).Encode(run)
+		case "/api/packages/pkg":
+			json.NewEncoder(w).Encode(pkg)
+		case "/api/tests":
+			w.WriteHeader(http.StatusAccepted)
+		}
+	}))
+	defer ts.Close()
+
+	var logs bytes.Buffer
+	r, err := New(
+		WithTestBinsPath(t.TempDir()),
+		WithTesterAddr(ts.URL),
+		WithLocalTestBinsOnly(),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+	)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(r.testBinaryPath("pkg", ""), script, 0755))
+
+	claimed, err := r.runOnce(context.Background())
+	require.NoError(t, err)
+	require.True(t, claimed)
+
+	env, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "postgres://db hunter2\n", string(env))
+
+	assert.Contains(t, logs.String(), "TESTER_DB_URL=postgres://db")
+	assert.Contains(t, logs.String(), "TESTER_TOKEN=<redacted>")
+	assert.NotContains(t, logs.String(), "hunter2")
+}
@@ -0,0 +1,228 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/nanzhong/tester"
+)
+
+// runResult is the outcome of a run that is to be reported to the server.
+type runResult struct {
+	RunID   uuid.UUID      `json:"run_id"`
+	Package string         `json:"package"`
+	Tests   []*tester.Test `json:"tests"`
+	// Error is set when the run failed, in which case the run is marked as
+	// failed instead of completed.
+	Error string `json:"error"`
+}
+
+// reportResult submits the result to the server. If the submission fails, the
+// remaining unsubmitted parts of the result are spooled to disk to be
+// resubmitted later.
+func (r *Runner) reportResult(ctx context.Context, result *runResult) error {
+	err := r.submitResult(ctx, result)
+	if err == nil {
+		return nil
+	}
+	if isPermanent(err) {
+		return err
+	}
+
+	if spoolErr := r.spoolResult(result); spoolErr != nil {
+		return fmt.Errorf("spooling result: %s: %w", spoolErr, err)
+	}
+	r.logger.Warn("spooled result for later submission", "run_id", result.RunID, "package", result.Package, "err", err)
+	return nil
+}
+
+// submitResult submits the tests of the result followed by either completing
+// or failing the run. Tests are removed from the result as they are
+// submitted.
+//
+// Tests rejected by the server are dropped, and the run is failed with the
+// reason they were rejected. Leaving the run unfinished instead would only
+// have it reset and rerun with the same results once it times out.
+func (r *Runner) submitResult(ctx context.Context, result *runResult) error {
+	var err error
+	result.Tests, err = r.submitTestResults(ctx, result.Tests)
+	if rejected := rejections(err); len(rejected) > 0 {
+		r.logger.Warn("test results rejected", "run_id", result.RunID, "package", result.Package, "rejected", len(rejected), "err", errors.Join(rejected...))
+		// The reason is kept on the result so that it isn't lost if the
+		// remaining tests are spooled.
+		reason := fmt.Sprintf("%d test result(s) rejected: %s", len(rejected), rejected[0])
+		if result.Error == "" {
+			result.Error = reason
+		} else {
+			result.Error += "\n" + reason
+		}
+	}
+	if err != nil && !isPermanent(err) {
+		return err
+	}
+
+	if result.Error != "" {
+		return r.failRun(ctx, result.RunID, result.Error)
+	}
+	return r.completeRun(ctx, result.RunID)
+}
+
+// submitTestResults submits the tests with up to the configured submission
+// concurrency in flight. It returns the tests that should be resubmitted
+// along with the errors encountered. Tests rejected by the server are not
+// returned since resubmitting them will not help.
+func (r *Runner) submitTestResults(ctx context.Context, tests []*tester.Test) ([]*tester.Test, error) {
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		sem       = make(chan struct{}, r.submissionConcurrency)
+		remaining []*tester.Test
+		errs      []error
+	)
+	for _, test := range tests {
+		test := test
+		sem <- struct{}{}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			err := r.submitTestResult(ctx, test)
+			if err == nil {
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			errs = append(errs, err)
+			if !isPermanent(err) {
+				remaining = append(remaining, test)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Preserve the original order of the tests to resubmit.
+	order := make(map[*tester.Test]int, len(tests))
+	for i, test := range tests {
+		order[test] = i
+	}
+	sort.Slice(remaining, func(i, j int) bool {
+		return order[remaining[i]] < order[remaining[j]]
+	})
+	return remaining, errors.Join(errs...)
+}
+
+func (r *Runner) spoolFilePath(runID uuid.UUID) string {
+	return filepath.Join(r.spoolPath, fmt.Sprintf("%s.json", runID))
+}
+
+func (r *Runner) spoolResult(result *runResult) error {
+	data, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("marshaling result: %w", err)
+	}
+
+	// Write to a temporary file first so that partially written results are
+	// never drained.
+	tmpPath := r.spoolFilePath(result.RunID) + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0644); err != nil {
+		return fmt.Errorf("writing result: %w", err)
+	}
+	return os.Rename(tmpPath, r.spoolFilePath(result.RunID))
+}
+
+// drainSpool resubmits spooled results. Draining stops at the first result
+// that fails to submit since the server is likely still unavailable.
+func (r *Runner) drainSpool(ctx context.Context) error {
+	files, err := ioutil.ReadDir(r.spoolPath)
+	if err != nil {
+		return fmt.Errorf("listing spooled results: %w", err)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(r.spoolPath, file.Name())
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("reading spooled result: %w", err)
+		}
+
+		var result runResult
+		if err := json.Unmarshal(data, &result); err != nil {
+			r.logger.Error("discarding invalid spooled result", "path", path, "err", err)
+			os.Remove(path)
+			continue
+		}
+
+		err = r.submitResult(ctx, &result)
+		if err != nil && !isPermanent(err) {
+			// Persist any progress that was made before giving up.
+			if spoolErr := r.spoolResult(&result); spoolErr != nil {
+				return fmt.Errorf("respooling result: %s: %w", spoolErr, err)
+			}
+			return fmt.Errorf("submitting spooled result for run %s: %w", result.RunID, err)
+		}
+		if err != nil {
+			r.logger.Error("discarding rejected spooled result", "run_id", result.RunID, "package", result.Package, "err", err)
+		} else {
+			r.logger.Info("submitted spooled result", "run_id", result.RunID, "package", result.Package)
+		}
+
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("removing spooled result: %w", err)
+		}
+	}
+	return nil
+}
+
+// rejections returns the errors, of those joined in err, that are due to the
+// server rejecting the request.
+func rejections(err error) []error {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		var rejected []error
+		for _, err := range joined.Unwrap() {
+			rejected = append(rejected, rejections(err)...)
+		}
+		return rejected
+	}
+	if err != nil && isPermanent(err) {
+		return []error{err}
+	}
+	return nil
+}
+
+// isPermanent returns whether the error is due to the server rejecting the
+// request, in which case retrying the request will not help. Joined errors
+// are only permanent if all of them are.
+func isPermanent(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			if !isPermanent(err) {
+				return false
+			}
+		}
+		return len(joined.Unwrap()) > 0
+	}
+
+	var statusErr *statusError
+	return errors.As(err, &statusErr) && statusErr.code < http.StatusInternalServerError
+}
@@ -0,0 +1,62 @@
+//go:build example
+// +build example
+
+// Package example contains tests with known results that are built into the
+// test binary used to test running test binaries. Some of the tests fail on
+// purpose, so the package is only built with the example build tag.
+package example
+
+import (
+	"os"
+	"testing"
+)
+
+func TestPass(t *testing.T) {
+	t.Log("passing")
+}
+
+func TestFail(t *testing.T) {
+	t.Error("boom")
+}
+
+func TestSkip(t *testing.T) {
+	t.Skip("not today")
+}
+
+func TestSubTests(t *testing.T) {
+	t.Run("pass", func(t *testing.T) {})
+	t.Run("fail", func(t *testing.T) {
+		t.Error("sub boom")
+	})
+}
+
+func TestEnv(t *testing.T) {
+	if os.Getenv("EXAMPLE_FAIL") != "" {
+		t.Error("EXAMPLE_FAIL is set")
+	}
+}
+
+func TestDeferredLog(t *testing.T) {
+	t.Run("sub", func(t *testing.T) {
+		defer t.Log("deferred")
+		t.Log("logged")
+	})
+}
+
+func TestNested(t *testing.T) {
+	t.Run("a", func(t *testing.T) {
+		t.Run("b", func(t *testing.T) {
+			t.Skip("nested skip")
+		})
+		t.Run("c", func(t *testing.T) {})
+	})
+}
+
+func TestParallel(t *testing.T) {
+	for _, name := range []string{"x", "y", "z"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			t.Log("parallel")
+		})
+	}
+}
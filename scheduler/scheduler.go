@@ -4,9 +4,10 @@ import (
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,6 +16,12 @@ import (
 	"golang.org/x/sync/errgroup"
 )
 
+var scheduleHookTimeout = 30 * time.Second
+
+// unprocessableRunTimeout is how long a run may go unclaimed before it is dead
+// lettered.
+const unprocessableRunTimeout = 24 * time.Hour
+
 // Option is used to inject dependencies into a Scheduler on creation.
 type Option func(*Scheduler)
 
@@ -33,17 +40,48 @@ func WithRunTimeout(d time.Duration) Option {
 	}
 }
 
+// WithLogger allows configuring a custom logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(s *Scheduler) {
+		s.logger = logger
+	}
+}
+
+// WithScheduleHook allows configuring a hook that is called whenever a run is
+// scheduled.
+func WithScheduleHook(fn func(run *tester.Run)) Option {
+	return func(s *Scheduler) {
+		s.scheduleHook = fn
+	}
+}
+
 // Scheduler schedules runs.
 type Scheduler struct {
-	Packages map[string]*tester.Package
-
-	stop            chan struct{}
+	// packagesMu guards Packages, which may be replaced by UpdatePackages.
+	packagesMu sync.RWMutex
+	Packages   map[string]*tester.Package
+
+	stop chan struct{}
+	// mu guards stopped, which prevents new iterations from being tracked by
+	// wg once stopping has started.
+	mu              sync.Mutex
+	stopped         bool
+	wg              sync.WaitGroup
 	lastScheduledAt map[string]time.Time
 	runDelay        time.Duration
-	runTimeout      time.Duration
 	db              db.DB
+	logger          *slog.Logger
+	scheduleHook    func(run *tester.Run)
+
+	// runTimeoutMu guards runTimeout, which may be changed by SetRunTimeout.
+	runTimeoutMu sync.RWMutex
+	runTimeout   time.Duration
 }
 
+// DefaultRunTimeout is how long runs can run for before they are reset, unless
+// configured otherwise.
+const DefaultRunTimeout = 15 * time.Minute
+
 // NewScheduler constructs a new scheduler.
 func NewScheduler(db db.DB, packages []*tester.Package, opts ...Option) *Scheduler {
 	scheduler := &Scheduler{
@@ -52,7 +90,8 @@ func NewScheduler(db db.DB, packages []*tester.Package, opts ...Option) *Schedul
 		lastScheduledAt: make(map[string]time.Time),
 		stop:            make(chan struct{}),
 		runDelay:        5 * time.Minute,
-		runTimeout:      15 * time.Minute,
+		runTimeout:      DefaultRunTimeout,
+		logger:          slog.Default(),
 	}
 	for _, pkg := range packages {
 		scheduler.Packages[pkg.Name] = pkg
@@ -65,8 +104,56 @@ func NewScheduler(db db.DB, packages []*tester.Package, opts ...Option) *Schedul
 	return scheduler
 }
 
+// UpdatePackages replaces the packages runs are scheduled for.
+func (s *Scheduler) UpdatePackages(packages []*tester.Package) {
+	pkgs := make(map[string]*tester.Package, len(packages))
+	for _, pkg := range packages {
+		pkgs[pkg.Name] = pkg
+	}
+
+	s.packagesMu.Lock()
+	defer s.packagesMu.Unlock()
+	s.Packages = pkgs
+}
+
+// SetRunTimeout changes how long runs can run for before they are reset.
+func (s *Scheduler) SetRunTimeout(d time.Duration) {
+	s.runTimeoutMu.Lock()
+	defer s.runTimeoutMu.Unlock()
+	s.runTimeout = d
+}
+
+func (s *Scheduler) getRunTimeout() time.Duration {
+	s.runTimeoutMu.RLock()
+	defer s.runTimeoutMu.RUnlock()
+	return s.runTimeout
+}
+
 func (s *Scheduler) Schedule(ctx context.Context, packageName string, args ...string) (*tester.Run, error) {
+	return s.ScheduleWithOptions(ctx, packageName, ScheduleOptions{Args: args})
+}
+
+// ScheduleOptions configures a run scheduled with ScheduleWithOptions.
+type ScheduleOptions struct {
+	// Args are the package options for the run, eg. -test.run=TestA.
+	Args []string
+	// Priority orders the run ahead of pending runs with a lower priority.
+	Priority int
+	// Labels are added to the labels inherited from the package, replacing
+	// any with the same key.
+	Labels map[string]string
+	// EnqueuedBy records who or what scheduled the run.
+	EnqueuedBy string
+	// AlertChannels are the slack channels alerts for the run are sent to
+	// instead of the package's channels.
+	AlertChannels []string
+}
+
+// ScheduleWithOptions schedules a run of the package configured by opts.
+func (s *Scheduler) ScheduleWithOptions(ctx context.Context, packageName string, opts ScheduleOptions) (*tester.Run, error) {
+	s.packagesMu.RLock()
 	pkg, exists := s.Packages[packageName]
+	s.packagesMu.RUnlock()
 	if !exists {
 		return nil, fmt.Errorf("unknown package: %s", packageName)
 	}
@@ -76,7 +163,20 @@ func (s *Scheduler) Schedule(ctx context.Context, packageName string, args ...st
 	for _, option := range pkg.Options {
 		runPkgOptions[option.Name] = fs.String(option.Name, option.Default, option.Description)
 	}
-	err := fs.Parse(args)
+	// count is reserved for repeating tests via -test.count, unless the
+	// package defines its own option with the same name. It defaults to the
+	// package's count.
+	var count *int
+	if fs.Lookup("count") == nil {
+		count = fs.Int("count", pkg.Count, "number of times to run each test")
+	}
+	// variant is reserved for selecting the test binary of packages with
+	// variants.
+	var variant *string
+	if len(pkg.Variants) > 0 && fs.Lookup("variant") == nil {
+		variant = fs.String("variant", "", "variant of the package to run")
+	}
+	err := fs.Parse(opts.Args)
 	if err != nil {
 		return nil, fmt.Errorf("parsing run options: %w", err)
 	}
@@ -88,22 +188,81 @@ func (s *Scheduler) Schedule(ctx context.Context, packageName string, args ...st
 		}
 
 	}
+	if err := pkg.ValidateArgs(runArgs); err != nil {
+		return nil, fmt.Errorf("invalid run args: %w", err)
+	}
+
+	var variantName string
+	if variant != nil {
+		variantName = *variant
+	}
+	if _, err := pkg.Variant(variantName); err != nil {
+		return nil, err
+	}
+
+	labels := pkg.Labels
+	if len(opts.Labels) > 0 {
+		labels = make(tester.Labels, len(pkg.Labels)+len(opts.Labels))
+		for k, v := range pkg.Labels {
+			labels[k] = v
+		}
+		for k, v := range opts.Labels {
+			labels[k] = v
+		}
+	}
 
 	run := &tester.Run{
-		ID:         uuid.New(),
-		Package:    pkg.Name,
-		Args:       runArgs,
-		EnqueuedAt: time.Now(),
+		ID:            uuid.New(),
+		Package:       pkg.Name,
+		Args:          runArgs,
+		EnqueuedAt:    time.Now(),
+		Labels:        labels,
+		Variant:       variantName,
+		Priority:      opts.Priority,
+		EnqueuedBy:    opts.EnqueuedBy,
+		AlertChannels: opts.AlertChannels,
+	}
+	if count != nil {
+		run.Count = *count
 	}
 	err = s.db.EnqueueRun(ctx, run)
 	if err != nil {
 		return nil, fmt.Errorf("scheduling package: %w", err)
 	}
 
-	log.Printf("scheduled run %s with args: %q", pkg.Name, strings.Join(runArgs, ", "))
+	s.logger.Info("scheduled run", "run_id", run.ID, "package", pkg.Name, "args", strings.Join(runArgs, ", "))
+	s.notifyScheduled(run)
 	return run, nil
 }
 
+// notifyScheduled calls the schedule hook, if configured, in the background.
+// Hooks that panic or fail to return within the timeout are logged and
+// otherwise ignored.
+func (s *Scheduler) notifyScheduled(run *tester.Run) {
+	if s.scheduleHook == nil {
+		return
+	}
+
+	go func() {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			defer func() {
+				if r := recover(); r != nil {
+					s.logger.Error("schedule hook panicked", "run_id", run.ID, "package", run.Package, "panic", r)
+				}
+			}()
+			s.scheduleHook(run)
+		}()
+
+		select {
+		case <-done:
+		case <-time.After(scheduleHookTimeout):
+			s.logger.Warn("schedule hook timed out", "run_id", run.ID, "package", run.Package)
+		}
+	}()
+}
+
 // Run starts the scheduler.
 func (s *Scheduler) Run() {
 	wait := 0 * time.Second
@@ -115,72 +274,129 @@ func (s *Scheduler) Run() {
 		}
 		wait = time.Duration((rand.Int() % 10)) * time.Second
 
-		ctx := context.Background()
-		var eg errgroup.Group
-		eg.Go(func() error {
-			return s.scheduleRuns(ctx)
-		})
-		eg.Go(func() error {
-			return s.resetStaleRuns(ctx)
-		})
-		eg.Go(func() error {
-			return s.cleanupUnprocessableRuns(ctx)
-		})
-		err := eg.Wait()
-		if err != nil {
-			log.Printf("scheduling error: %s", err)
+		if !s.runIteration(context.Background()) {
+			return
 		}
 	}
 }
 
-// Stop stops the scheduler.
-func (s *Scheduler) Stop() {
+// runIteration runs one iteration of scheduling, returning false if the
+// scheduler was stopped before the iteration could start.
+func (s *Scheduler) runIteration(ctx context.Context) bool {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return false
+	}
+	s.wg.Add(3)
+	s.mu.Unlock()
+
+	var eg errgroup.Group
+	eg.Go(func() error {
+		defer s.wg.Done()
+		return s.scheduleRuns(ctx)
+	})
+	eg.Go(func() error {
+		defer s.wg.Done()
+		return s.resetStaleRuns(ctx)
+	})
+	eg.Go(func() error {
+		defer s.wg.Done()
+		return s.cleanupUnprocessableRuns(ctx)
+	})
+	err := eg.Wait()
+	if err != nil {
+		s.logger.Error("scheduling error", "err", err)
+	}
+	return true
+}
+
+// Stop stops the scheduler and waits for any in progress scheduling to
+// finish, or for the context to be done.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
 	close(s.stop)
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("waiting for scheduling to finish: %w", ctx.Err())
+	}
 }
 
 func (s *Scheduler) scheduleRuns(ctx context.Context) error {
-	runs, err := s.db.ListPendingRuns(ctx)
-	if err != nil {
-		return err
+	s.packagesMu.RLock()
+	packages := make([]*tester.Package, 0, len(s.Packages))
+	for _, pkg := range s.Packages {
+		packages = append(packages, pkg)
 	}
+	s.packagesMu.RUnlock()
 
-	pendingRuns := make(map[string]*tester.Run)
-	for _, run := range runs {
-		if !run.FinishedAt.IsZero() {
+	for _, pkg := range packages {
+		if !pkg.IsEnabled() {
 			continue
 		}
-		pendingRuns[run.Package] = run
-	}
 
-	for _, pkg := range s.Packages {
 		runDelay := s.runDelay
 		if pkg.RunDelay > 0 {
 			runDelay = pkg.RunDelay
 		}
-		if _, exists := pendingRuns[pkg.Name]; !exists {
-			last, ran := s.lastScheduledAt[pkg.Name]
-			if ran && time.Since(last) < runDelay {
+		last, ran := s.lastScheduledAt[pkg.Name]
+		if ran && time.Since(last) < runDelay {
+			continue
+		}
+
+		pendingRuns, err := s.db.ListPendingRunsForPackage(ctx, pkg.Name)
+		if err != nil {
+			return err
+		}
+		pendingVariants := make(map[string]bool)
+		for _, run := range pendingRuns {
+			pendingVariants[run.Variant] = true
+		}
+
+		args := pkg.DefaultArgs()
+		if err := pkg.ValidateArgs(args); err != nil {
+			// Wait for the run delay before trying again to avoid
+			// repeatedly logging the same error.
+			s.lastScheduledAt[pkg.Name] = time.Now()
+			s.logger.Error("invalid run args", "package", pkg.Name, "args", strings.Join(args, ", "), "err", err)
+			continue
+		}
+
+		// A run is scheduled for each of the package's variants that does not
+		// already have one pending.
+		for _, variant := range pkg.VariantNames() {
+			if pendingVariants[variant] {
 				continue
 			}
 
-			var args []string
-			for _, option := range pkg.Options {
-				if option.Default != "" {
-					o := tester.Option{
-						Name:  option.Name,
-						Value: option.Default,
-					}
-					args = append(args, o.String())
-				}
-			}
-			err = s.db.EnqueueRun(ctx, &tester.Run{
+			run := &tester.Run{
 				ID:         uuid.New(),
 				Package:    pkg.Name,
 				Args:       args,
 				EnqueuedAt: time.Now(),
-			})
+				Labels:     pkg.Labels,
+				Count:      pkg.Count,
+				Variant:    variant,
+			}
+			err = s.db.EnqueueRun(ctx, run)
 			s.lastScheduledAt[pkg.Name] = time.Now()
-			log.Printf("scheduled run %s", pkg.Name)
+			if err != nil {
+				s.logger.Error("failed to schedule run", "package", pkg.Name, "variant", variant, "err", err)
+				continue
+			}
+			s.logger.Info("scheduled run", "run_id", run.ID, "package", pkg.Name, "variant", variant)
+			s.notifyScheduled(run)
 		}
 	}
 
@@ -194,16 +410,22 @@ func (s *Scheduler) cleanupUnprocessableRuns(ctx context.Context) error {
 	}
 
 	for _, run := range runs {
-		// Cleanup runs that haven't been picked up for 1 day.
-		// This usually indicates an old run/package that is no longer runnable.
-		if !run.StartedAt.IsZero() || time.Now().Sub(run.EnqueuedAt) < 24*time.Hour {
+		// Dead letter runs that haven't been picked up for 1 day.
+		// This usually indicates an old run/package that is no longer runnable,
+		// eg. no runners claim runs for the package.
+		if !run.StartedAt.IsZero() || time.Now().Sub(run.EnqueuedAt) < unprocessableRunTimeout {
 			continue
 		}
 
-		err := s.db.DeleteRun(ctx, run.ID)
+		reason := fmt.Sprintf("not claimed by a runner within %.0f hours of being enqueued", unprocessableRunTimeout.Hours())
+		err := s.db.DeadLetterRun(ctx, run.ID, reason)
 		if err != nil {
+			if err == db.ErrNotFound {
+				continue
+			}
 			return err
 		}
+		s.logger.Warn("dead lettered run", "run_id", run.ID, "package", run.Package, "reason", reason)
 	}
 
 	return nil
@@ -215,12 +437,13 @@ func (s *Scheduler) resetStaleRuns(ctx context.Context) error {
 		return err
 	}
 
+	runTimeout := s.getRunTimeout()
 	for _, run := range runs {
 		if run.StartedAt.IsZero() || !run.FinishedAt.IsZero() {
 			continue
 		}
 
-		if time.Now().Sub(run.StartedAt) > s.runTimeout {
+		if time.Now().Sub(run.StartedAt) > runTimeout {
 			err = s.db.ResetRun(ctx, run.ID)
 			if err != nil {
 				if err == db.ErrNotFound {
@@ -228,7 +451,7 @@ func (s *Scheduler) resetStaleRuns(ctx context.Context) error {
 				}
 				return err
 			}
-			log.Printf("reset run %s", run.Package)
+			s.logger.Info("reset run", "run_id", run.ID, "package", run.Package)
 		}
 	}
 
@@ -0,0 +1,438 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/google/uuid"
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func withScheduler(t *testing.T, opts []Option, fn func(s *Scheduler, mockDB *db.MockDB)) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	mockDB := db.NewMockDB(ctrl)
+	packages := []*tester.Package{{
+		Name:   "pkg",
+		Labels: tester.Labels{"team": "payments"},
+		Options: []tester.Option{{
+			Name:    "test.run",
+			Default: "TestA",
+		}},
+	}}
+	fn(NewScheduler(mockDB, packages, opts...), mockDB)
+}
+
+func TestScheduler_ScheduleHook(t *testing.T) {
+	t.Run("called on schedule", func(t *testing.T) {
+		hooked := make(chan *tester.Run, 1)
+		opts := []Option{WithScheduleHook(func(run *tester.Run) {
+			hooked <- run
+		})}
+
+		withScheduler(t, opts, func(s *Scheduler, mockDB *db.MockDB) {
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Return(nil)
+
+			run, err := s.Schedule(context.Background(), "pkg", "-test.run=TestB")
+			require.NoError(t, err)
+
+			select {
+			case hookedRun := <-hooked:
+				assert.Equal(t, run, hookedRun)
+				assert.Equal(t, []string{"-test.run=TestB"}, hookedRun.Args)
+				assert.Equal(t, tester.Labels{"team": "payments"}, hookedRun.Labels)
+			case <-time.After(time.Second):
+				t.Fatal("schedule hook not called")
+			}
+		})
+	})
+
+	t.Run("called on scheduled runs", func(t *testing.T) {
+		hooked := make(chan *tester.Run, 1)
+		opts := []Option{WithScheduleHook(func(run *tester.Run) {
+			hooked <- run
+		})}
+
+		withScheduler(t, opts, func(s *Scheduler, mockDB *db.MockDB) {
+			var enqueued *tester.Run
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), "pkg").Return(nil, nil)
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, run *tester.Run) error {
+				enqueued = run
+				return nil
+			})
+
+			err := s.scheduleRuns(context.Background())
+			require.NoError(t, err)
+
+			select {
+			case hookedRun := <-hooked:
+				assert.Equal(t, enqueued, hookedRun)
+				assert.Equal(t, "pkg", hookedRun.Package)
+				assert.Equal(t, []string{"-test.run=TestA"}, hookedRun.Args)
+				assert.Equal(t, tester.Labels{"team": "payments"}, hookedRun.Labels)
+			case <-time.After(time.Second):
+				t.Fatal("schedule hook not called")
+			}
+		})
+	})
+
+	t.Run("panicking hook", func(t *testing.T) {
+		var logs lockedBuffer
+		opts := []Option{
+			WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+			WithScheduleHook(func(run *tester.Run) {
+				panic("boom")
+			}),
+		}
+
+		withScheduler(t, opts, func(s *Scheduler, mockDB *db.MockDB) {
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Return(nil)
+
+			_, err := s.Schedule(context.Background(), "pkg")
+			require.NoError(t, err)
+
+			assert.Eventually(t, func() bool {
+				return strings.Contains(logs.String(), "schedule hook panicked")
+			}, time.Second, 10*time.Millisecond)
+		})
+	})
+}
+
+func TestScheduler_Schedule(t *testing.T) {
+	t.Run("count", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Return(nil)
+
+			run, err := s.Schedule(context.Background(), "pkg", "-count=10", "-test.run=TestB")
+			require.NoError(t, err)
+			assert.Equal(t, 10, run.Count)
+			assert.Equal(t, []string{"-test.run=TestB"}, run.Args)
+		})
+	})
+
+	t.Run("package count", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			s.Packages["pkg"].Count = 2
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Return(nil).Times(2)
+
+			run, err := s.Schedule(context.Background(), "pkg")
+			require.NoError(t, err)
+			assert.Equal(t, 2, run.Count)
+
+			run, err = s.Schedule(context.Background(), "pkg", "--count", "5")
+			require.NoError(t, err)
+			assert.Equal(t, 5, run.Count)
+		})
+	})
+
+	t.Run("invalid count", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			_, err := s.Schedule(context.Background(), "pkg", "-count=many")
+			require.Error(t, err)
+		})
+	})
+
+	t.Run("variant", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			s.Packages["pkg"].Variants = []tester.Variant{{Name: "go1"}, {Name: "go2"}}
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Return(nil)
+
+			run, err := s.Schedule(context.Background(), "pkg", "-variant=go2")
+			require.NoError(t, err)
+			assert.Equal(t, "go2", run.Variant)
+			assert.Equal(t, []string{"-test.run=TestA"}, run.Args)
+		})
+	})
+
+	t.Run("invalid variant", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			s.Packages["pkg"].Variants = []tester.Variant{{Name: "go1"}, {Name: "go2"}}
+
+			_, err := s.Schedule(context.Background(), "pkg")
+			require.Error(t, err)
+			_, err = s.Schedule(context.Background(), "pkg", "-variant=go3")
+			require.Error(t, err)
+		})
+	})
+
+	t.Run("options", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			s.Packages["pkg"].Labels = tester.Labels{"team": "payments", "tier": "1"}
+
+			var enqueued *tester.Run
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, run *tester.Run) error {
+				enqueued = run
+				return nil
+			})
+
+			run, err := s.ScheduleWithOptions(context.Background(), "pkg", ScheduleOptions{
+				Args:          []string{"-test.run=TestB"},
+				Priority:      10,
+				Labels:        map[string]string{"tier": "0", "trigger": "manual"},
+				EnqueuedBy:    "slack:U123",
+				AlertChannels: []string{"C123"},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, enqueued, run)
+			assert.Equal(t, "pkg", run.Package)
+			assert.Equal(t, []string{"-test.run=TestB"}, run.Args)
+			assert.Equal(t, 10, run.Priority)
+			assert.Equal(t, tester.Labels{"team": "payments", "tier": "0", "trigger": "manual"}, run.Labels)
+			assert.Equal(t, "slack:U123", run.EnqueuedBy)
+			assert.Equal(t, []string{"C123"}, run.AlertChannels)
+			assert.Equal(t, tester.Labels{"team": "payments", "tier": "1"}, s.Packages["pkg"].Labels, "package labels should not be modified")
+		})
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			_, err := s.ScheduleWithOptions(context.Background(), "pkg", ScheduleOptions{Args: []string{"-unknown=1"}})
+			require.Error(t, err)
+			_, err = s.ScheduleWithOptions(context.Background(), "unknown", ScheduleOptions{})
+			require.Error(t, err)
+		})
+	})
+}
+
+func TestScheduler_scheduleRuns(t *testing.T) {
+	t.Run("enabled package", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			enabled := true
+			s.Packages["pkg"].Enabled = &enabled
+
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), "pkg").Return(nil, nil)
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Return(nil)
+
+			err := s.scheduleRuns(context.Background())
+			require.NoError(t, err)
+		})
+	})
+
+	t.Run("pending run", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			pending := &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: time.Now()}
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), "pkg").Return([]*tester.Run{pending}, nil)
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Times(0)
+
+			err := s.scheduleRuns(context.Background())
+			require.NoError(t, err)
+		})
+	})
+
+	t.Run("variants", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			s.Packages["pkg"].Variants = []tester.Variant{{Name: "go1"}, {Name: "go2"}, {Name: "go3"}}
+
+			pending := &tester.Run{ID: uuid.New(), Package: "pkg", Variant: "go2", EnqueuedAt: time.Now()}
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), "pkg").Return([]*tester.Run{pending}, nil)
+			var variants []string
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, run *tester.Run) error {
+				assert.Equal(t, "pkg", run.Package)
+				variants = append(variants, run.Variant)
+				return nil
+			}).Times(2)
+
+			err := s.scheduleRuns(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, []string{"go1", "go3"}, variants)
+		})
+	})
+
+	t.Run("count", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			s.Packages["pkg"].Count = 2
+
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), "pkg").Return(nil, nil)
+			var enqueued *tester.Run
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, run *tester.Run) error {
+				enqueued = run
+				return nil
+			})
+
+			err := s.scheduleRuns(context.Background())
+			require.NoError(t, err)
+			require.NotNil(t, enqueued)
+			assert.Equal(t, 2, enqueued.Count)
+		})
+	})
+
+	t.Run("list pending runs error", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), "pkg").Return(nil, errors.New("boom"))
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Times(0)
+
+			err := s.scheduleRuns(context.Background())
+			require.Error(t, err)
+		})
+	})
+
+	t.Run("disabled package", func(t *testing.T) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			enabled := false
+			s.Packages["pkg"].Enabled = &enabled
+
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), gomock.Any()).Times(0)
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Times(0)
+
+			err := s.scheduleRuns(context.Background())
+			require.NoError(t, err)
+		})
+	})
+
+	t.Run("invalid args", func(t *testing.T) {
+		var logs lockedBuffer
+		opts := []Option{WithLogger(slog.New(slog.NewTextHandler(&logs, nil)))}
+		withScheduler(t, opts, func(s *Scheduler, mockDB *db.MockDB) {
+			s.Packages["pkg"].Options = append(s.Packages["pkg"].Options, tester.Option{
+				Name:    "bad option",
+				Default: "value",
+			})
+
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), "pkg").Return(nil, nil)
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Times(0)
+
+			err := s.scheduleRuns(context.Background())
+			require.NoError(t, err)
+			assert.Contains(t, logs.String(), "invalid run args")
+		})
+	})
+}
+
+func TestScheduler_Stop(t *testing.T) {
+	withBlockedIteration := func(t *testing.T, fn func(s *Scheduler, release chan struct{})) {
+		withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+			var (
+				started = make(chan struct{}, 3)
+				release = make(chan struct{})
+			)
+			// The scheduling, cleanup and reset loops each block on listing
+			// pending runs.
+			mockDB.EXPECT().ListPendingRunsForPackage(gomock.Any(), "pkg").DoAndReturn(func(context.Context, string) ([]*tester.Run, error) {
+				started <- struct{}{}
+				<-release
+				return nil, nil
+			})
+			mockDB.EXPECT().ListPendingRuns(gomock.Any()).DoAndReturn(func(context.Context) ([]*tester.Run, error) {
+				started <- struct{}{}
+				<-release
+				return nil, nil
+			}).Times(2)
+			mockDB.EXPECT().EnqueueRun(gomock.Any(), gomock.Any()).Return(nil)
+
+			runDone := make(chan struct{})
+			go func() {
+				defer close(runDone)
+				s.Run()
+			}()
+
+			for i := 0; i < 3; i++ {
+				select {
+				case <-started:
+				case <-time.After(time.Second):
+					t.Fatal("scheduling iteration not started")
+				}
+			}
+
+			fn(s, release)
+
+			select {
+			case <-runDone:
+			case <-time.After(time.Second):
+				t.Fatal("run did not return")
+			}
+		})
+	}
+
+	t.Run("waits for in progress iteration", func(t *testing.T) {
+		withBlockedIteration(t, func(s *Scheduler, release chan struct{}) {
+			stopped := make(chan error, 1)
+			go func() {
+				stopped <- s.Stop(context.Background())
+			}()
+
+			select {
+			case <-stopped:
+				t.Fatal("stop returned before iteration finished")
+			case <-time.After(50 * time.Millisecond):
+			}
+
+			close(release)
+			select {
+			case err := <-stopped:
+				require.NoError(t, err)
+			case <-time.After(time.Second):
+				t.Fatal("stop did not return")
+			}
+		})
+	})
+
+	t.Run("respects context", func(t *testing.T) {
+		withBlockedIteration(t, func(s *Scheduler, release chan struct{}) {
+			defer close(release)
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			err := s.Stop(ctx)
+			assert.True(t, errors.Is(err, context.DeadlineExceeded))
+		})
+	})
+}
+
+func TestScheduler_cleanupUnprocessableRuns(t *testing.T) {
+	withScheduler(t, nil, func(s *Scheduler, mockDB *db.MockDB) {
+		var (
+			unclaimedRun = &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: time.Now().Add(-25 * time.Hour)}
+			startedRun   = &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: time.Now().Add(-25 * time.Hour), StartedAt: time.Now()}
+			recentRun    = &tester.Run{ID: uuid.New(), Package: "pkg", EnqueuedAt: time.Now()}
+		)
+		mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return([]*tester.Run{unclaimedRun, startedRun, recentRun}, nil)
+		mockDB.EXPECT().DeadLetterRun(gomock.Any(), unclaimedRun.ID, gomock.Any()).Return(nil)
+		mockDB.EXPECT().DeleteRun(gomock.Any(), gomock.Any()).Times(0)
+
+		err := s.cleanupUnprocessableRuns(context.Background())
+		require.NoError(t, err)
+	})
+}
+
+func TestScheduler_resetStaleRuns(t *testing.T) {
+	withScheduler(t, []Option{WithRunTimeout(time.Hour)}, func(s *Scheduler, mockDB *db.MockDB) {
+		staleRun := &tester.Run{ID: uuid.New(), Package: "pkg", StartedAt: time.Now().Add(-30 * time.Minute)}
+		mockDB.EXPECT().ListPendingRuns(gomock.Any()).Return([]*tester.Run{staleRun}, nil).Times(2)
+
+		require.NoError(t, s.resetStaleRuns(context.Background()))
+
+		// Runs are reset once they exceed the updated run timeout.
+		s.SetRunTimeout(10 * time.Minute)
+		mockDB.EXPECT().ResetRun(gomock.Any(), staleRun.ID).Return(nil)
+		require.NoError(t, s.resetStaleRuns(context.Background()))
+	})
+}
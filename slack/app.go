@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/nanzhong/tester"
 	"github.com/nanzhong/tester/alerting"
@@ -66,10 +67,13 @@ func WithScheduler(scheduler *scheduler.Scheduler) Option {
 }
 
 type App struct {
-	packages []*tester.Package
-
 	*options
 
+	// mu guards packages and usageMessage, which is derived from packages,
+	// as well as the alert channels, which may be replaced by
+	// UpdateChannels.
+	mu           sync.Mutex
+	packages     []*tester.Package
 	usageMessage *slack.Message
 }
 
@@ -87,6 +91,23 @@ func NewApp(packages []*tester.Package, opts ...Option) *App {
 	}
 }
 
+// UpdatePackages replaces the packages known to the app.
+func (a *App) UpdatePackages(packages []*tester.Package) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.packages = packages
+	a.usageMessage = nil
+}
+
+// UpdateChannels replaces the default channels alerts are sent to and the
+// custom channels of packages.
+func (a *App) UpdateChannels(defaultChannels []string, customChannels map[string][]string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.defaultChannels = defaultChannels
+	a.customChannels = customChannels
+}
+
 func (s *App) HandleSlackCommand(w http.ResponseWriter, r *http.Request) {
 	verifier, err := slack.NewSecretsVerifier(r.Header, s.signingSecret)
 	if err != nil {
@@ -155,7 +176,12 @@ func (s *App) HandleSlackCommand(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	run, err := s.scheduler.Schedule(r.Context(), packageName, args...)
+	run, err := s.scheduler.ScheduleWithOptions(r.Context(), packageName, scheduler.ScheduleOptions{
+		Args:       args,
+		EnqueuedBy: "slack:" + cmd.UserID,
+		// Alerts for ad-hoc runs go to the channel they were scheduled from.
+		AlertChannels: []string{cmd.ChannelID},
+	})
 	if err != nil {
 		message := &slack.Msg{
 			Text: fmt.Sprintf(":warning: Failed to schedule test run for package %s: *%s*", packageName, err),
@@ -208,12 +234,44 @@ func (s *App) HandleSlackCommand(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) Fire(ctx context.Context, alert *alerting.Alert) error {
-	testLink := fmt.Sprintf("%s/tests/%s", alert.BaseURL, alert.Test.ID)
+	pkg, err := a.getPackage(alert.Test.Package)
+	if err != nil {
+		return fmt.Errorf("firing slack alert: %w", err)
+	}
 
-	message := fmt.Sprintf(":warning: *FAIL* - %s\n%s", alert.Test.Result.Name, testLink)
+	message, testDetail := alertMessage(alert)
 	messageTextBlock := slack.NewTextBlockObject(slack.MarkdownType, message, false, false)
 	messageSection := slack.NewSectionBlock(messageTextBlock, nil, nil)
 
+	api := slack.New(a.accessToken)
+
+	var eg errgroup.Group
+	for _, channel := range a.alertChannels(alert.Run, alert.Owner, pkg.Name) {
+		channel := channel
+		eg.Go(func() error {
+			_, _, err := api.PostMessage(
+				channel,
+				slack.MsgOptionText(message, false),
+				slack.MsgOptionBlocks(messageSection),
+				slack.MsgOptionAttachments(testDetail),
+			)
+			return err
+		})
+	}
+	err = eg.Wait()
+	if err != nil {
+		return fmt.Errorf("firing slack alert: %w", err)
+	}
+	return nil
+}
+
+// alertMessage returns the text and test detail attachment of the message for
+// the alert. The detail includes the run's ID and args if the alert has a run.
+func alertMessage(alert *alerting.Alert) (string, slack.Attachment) {
+	testLink := fmt.Sprintf("%s/tests/%s", alert.BaseURL, alert.Test.ID)
+
+	message := fmt.Sprintf(":warning: *FAIL* - %s\n%s", alert.Test.Result.Name, testLink)
+
 	testDetail := slack.Attachment{
 		Color:     "#ff005f",
 		Title:     alert.Test.Result.Name,
@@ -236,12 +294,21 @@ func (a *App) Fire(ctx context.Context, alert *alerting.Alert) error {
 		Ts:         json.Number(strconv.FormatInt(alert.Test.Result.FinishedAt.Unix(), 10)),
 	}
 
-	pkg, err := a.getPackage(alert.Test.Package)
-	if err != nil {
-		return fmt.Errorf("firing slack alert: %w", err)
+	if alert.Run != nil {
+		testDetail.Fields = append(testDetail.Fields, slack.AttachmentField{
+			Title: "Run ID",
+			Value: fmt.Sprintf("<%s/runs/%s|%s>", alert.BaseURL, alert.Run.ID, alert.Run.ID),
+		})
 	}
 
-	if len(alert.Run.Args) > 0 {
+	if alert.Test.Result.ErrorMessage != "" {
+		testDetail.Fields = append(testDetail.Fields, slack.AttachmentField{
+			Title: "Error",
+			Value: fmt.Sprintf("`%s`", alert.Test.Result.ErrorMessage),
+		})
+	}
+
+	if alert.Run != nil && len(alert.Run.Args) > 0 {
 		var args []string
 		for _, a := range alert.Run.Args {
 			args = append(args, fmt.Sprintf("`%s`", a))
@@ -251,35 +318,33 @@ func (a *App) Fire(ctx context.Context, alert *alerting.Alert) error {
 			Value: strings.Join(args, "\n"),
 		})
 	}
+	return message, testDetail
+}
 
-	channels, ok := a.customChannels[pkg.Name]
-	if !ok {
-		channels = append(channels, a.defaultChannels...)
+// alertChannels returns the channels alerts for the run of the package are
+// sent to. Channels specified by the run take precedence over the channels of
+// the test's owner, then the package's custom channels and then the default
+// channels.
+func (a *App) alertChannels(run *tester.Run, owner *alerting.Owner, pkg string) []string {
+	if run != nil && len(run.AlertChannels) > 0 {
+		return run.AlertChannels
 	}
-
-	api := slack.New(a.accessToken)
-
-	var eg errgroup.Group
-	for _, channel := range channels {
-		channel := channel
-		eg.Go(func() error {
-			_, _, err := api.PostMessage(
-				channel,
-				slack.MsgOptionText(message, false),
-				slack.MsgOptionBlocks(messageSection),
-				slack.MsgOptionAttachments(testDetail),
-			)
-			return err
-		})
+	if owner != nil && len(owner.SlackChannels) > 0 {
+		return owner.SlackChannels
 	}
-	err = eg.Wait()
-	if err != nil {
-		return fmt.Errorf("firing slack alert: %w", err)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if channels, ok := a.customChannels[pkg]; ok {
+		return channels
 	}
-	return nil
+	return a.defaultChannels
 }
 
 func (a *App) helpMessage(command string) *slack.Message {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	if a.usageMessage != nil {
 		return a.usageMessage
 	}
@@ -296,9 +361,12 @@ func (a *App) helpMessage(command string) *slack.Message {
 		"  help                      print this help message",
 		"  test <package> [options]  trigger an e2e test",
 		"",
+		"Use -count=N (or --count N) to run each test N times.",
+		"Use -variant=NAME to select the variant of packages with variants.",
+		"",
 		"Test packages:",
 	}
-	for _, pkg := range a.scheduler.Packages {
+	for _, pkg := range a.packages {
 		lines = append(lines, "", fmt.Sprintf("  %s", pkg.Name))
 		for _, option := range pkg.Options {
 			description := fmt.Sprintf("      %s", option.Description)
@@ -307,6 +375,9 @@ func (a *App) helpMessage(command string) *slack.Message {
 			}
 			lines = append(lines, fmt.Sprintf("    -%s", option.Name), description)
 		}
+		if len(pkg.Variants) > 0 {
+			lines = append(lines, "    -variant", fmt.Sprintf("      one of: %s", strings.Join(pkg.VariantNames(), ", ")))
+		}
 	}
 	lines = append(lines, "```")
 
@@ -316,6 +387,9 @@ func (a *App) helpMessage(command string) *slack.Message {
 }
 
 func (a *App) getPackage(name string) (*tester.Package, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
 	for _, p := range a.packages {
 		if p.Name == name {
 			return p, nil
@@ -0,0 +1,140 @@
+package slack
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nanzhong/tester"
+	"github.com/nanzhong/tester/alerting"
+	"github.com/slack-go/slack"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApp_alertChannels(t *testing.T) {
+	app := NewApp(
+		nil,
+		WithDefaultChannels([]string{"default"}),
+		WithCustomChannels(map[string][]string{"custom": {"custom-1", "custom-2"}}),
+	)
+
+	for _, tc := range []struct {
+		name     string
+		run      *tester.Run
+		owner    *alerting.Owner
+		pkg      string
+		channels []string
+	}{
+		{
+			name:     "default",
+			run:      &tester.Run{},
+			pkg:      "pkg",
+			channels: []string{"default"},
+		},
+		{
+			name:     "custom",
+			run:      &tester.Run{},
+			pkg:      "custom",
+			channels: []string{"custom-1", "custom-2"},
+		},
+		{
+			name:     "run overrides default",
+			run:      &tester.Run{AlertChannels: []string{"C123"}},
+			pkg:      "pkg",
+			channels: []string{"C123"},
+		},
+		{
+			name:     "run overrides custom",
+			run:      &tester.Run{AlertChannels: []string{"C123"}},
+			pkg:      "custom",
+			channels: []string{"C123"},
+		},
+		{
+			name:     "owner overrides custom",
+			run:      &tester.Run{},
+			owner:    &alerting.Owner{Name: "team", SlackChannels: []string{"team"}},
+			pkg:      "custom",
+			channels: []string{"team"},
+		},
+		{
+			name:     "owner without channels",
+			run:      &tester.Run{},
+			owner:    &alerting.Owner{Name: "team"},
+			pkg:      "custom",
+			channels: []string{"custom-1", "custom-2"},
+		},
+		{
+			name:     "run overrides owner",
+			run:      &tester.Run{AlertChannels: []string{"C123"}},
+			owner:    &alerting.Owner{Name: "team", SlackChannels: []string{"team"}},
+			pkg:      "custom",
+			channels: []string{"C123"},
+		},
+		{
+			name:     "no run",
+			pkg:      "custom",
+			channels: []string{"custom-1", "custom-2"},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.channels, app.alertChannels(tc.run, tc.owner, tc.pkg))
+		})
+	}
+}
+
+func TestAlertMessage(t *testing.T) {
+	alert := &alerting.Alert{
+		Run: &tester.Run{
+			ID:      uuid.New(),
+			Package: "pkg",
+			Args:    []string{"-test.run=TestA", "-test.v"},
+		},
+		Test: &tester.Test{
+			ID:      uuid.New(),
+			Package: "pkg",
+			Result: &tester.T{
+				TB: tester.TB{Name: "TestA", State: tester.TBStateFailed, ErrorMessage: "a_test.go:10: boom"},
+			},
+		},
+		BaseURL: "http://tester",
+	}
+
+	fields := func(attachment slack.Attachment) map[string]string {
+		values := make(map[string]string)
+		for _, f := range attachment.Fields {
+			values[f.Title] = f.Value
+		}
+		return values
+	}
+
+	message, detail := alertMessage(alert)
+	assert.Equal(t, ":warning: *FAIL* - TestA\nhttp://tester/tests/"+alert.Test.ID.String(), message)
+	assert.Equal(t, map[string]string{
+		"Test ID":  alert.Test.ID.String(),
+		"Duration": "0s",
+		"Run ID":   "<http://tester/runs/" + alert.Run.ID.String() + "|" + alert.Run.ID.String() + ">",
+		"Error":    "`a_test.go:10: boom`",
+		"Args":     "`-test.run=TestA`\n`-test.v`",
+	}, fields(detail))
+
+	alert.Run = nil
+	_, detail = alertMessage(alert)
+	assert.NotContains(t, fields(detail), "Run ID")
+	assert.NotContains(t, fields(detail), "Args")
+
+	// Tests without an error message, eg. ones that failed because of a
+	// failed sub test, have no error field.
+	alert.Test.Result.ErrorMessage = ""
+	message, detail = alertMessage(alert)
+	assert.Equal(t, ":warning: *FAIL* - TestA\nhttp://tester/tests/"+alert.Test.ID.String(), message)
+	assert.NotContains(t, fields(detail), "Error")
+}
+
+func TestApp_UpdateChannels(t *testing.T) {
+	app := NewApp(nil, WithDefaultChannels([]string{"default"}))
+	assert.Equal(t, []string{"default"}, app.alertChannels(nil, nil, "pkg"))
+
+	app.UpdateChannels([]string{"alerts"}, map[string][]string{"pkg": {"pkg-alerts"}})
+	assert.Equal(t, []string{"pkg-alerts"}, app.alertChannels(nil, nil, "pkg"))
+	assert.Equal(t, []string{"alerts"}, app.alertChannels(nil, nil, "other"))
+}
@@ -1,4 +1,4 @@
-package runner
+package tester
 
 import (
 	"strings"
@@ -6,10 +6,14 @@ import (
 )
 
 type testEvent struct {
-	Time   time.Time  `json:"time"`
-	Action string     `json:"Action"`
-	Test   string     `json:"Test"`
-	Output *textBytes `json:"Output"`
+	Time    time.Time  `json:"time"`
+	Action  string     `json:"Action"`
+	Package string     `json:"Package"`
+	Test    string     `json:"Test"`
+	Output  *textBytes `json:"Output"`
+	// Elapsed is the duration of the test in seconds, set on pass, fail and
+	// skip events.
+	Elapsed float64 `json:"Elapsed"`
 }
 
 func (e *testEvent) TopLevel() bool {
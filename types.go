@@ -1,7 +1,13 @@
 package tester
 
 import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -19,12 +25,25 @@ const (
 	TBStateSkipped TBState = "skipped"
 )
 
+// Valid returns whether the state is one of the known TBStates.
+func (s TBState) Valid() bool {
+	switch s {
+	case TBStatePassed, TBStateFailed, TBStateSkipped:
+		return true
+	default:
+		return false
+	}
+}
+
 // TB is the representation of the common fields of a testing.TB.
 type TB struct {
-	Name       string    `json:"name"`
-	StartedAt  time.Time `json:"started_at"`
-	FinishedAt time.Time `json:"finished_at"`
-	State      TBState   `json:"state"`
+	Name         string    `json:"name"`
+	StartedAt    time.Time `json:"started_at"`
+	FinishedAt   time.Time `json:"finished_at"`
+	State        TBState   `json:"state"`
+	ErrorMessage string    `json:"error_message"`
+	// SkipReason is the message the test was skipped with, if any.
+	SkipReason string `json:"skip_reason,omitempty"`
 }
 
 // Duration returns the run duration the Test.
@@ -43,6 +62,10 @@ type T struct {
 	TB
 
 	SubTs []*T `json:"sub_ts"`
+	// Logs are the output of this t, excluding the output of its sub ts.
+	Logs []TBLog `json:"logs,omitempty"`
+	// Flaky is set when the test both passed and failed in recent runs.
+	Flaky bool `json:"flaky,omitempty"`
 }
 
 // Test is a run of a `testing.T`.
@@ -51,8 +74,20 @@ type Test struct {
 	Package string    `json:"package"`
 	RunID   uuid.UUID `json:"run_id"`
 
-	Result *T      `json:"result"`
-	Logs   []TBLog `json:"logs"`
+	Result *T `json:"result"`
+	// Logs are the output of the test, including the output of its sub ts.
+	Logs []TBLog `json:"logs"`
+	// Labels are inherited from the test's run when the test is submitted.
+	Labels Labels `json:"labels,omitempty"`
+}
+
+// QuarantinedTest is a test whose failures are recorded but not alerted on,
+// eg. a known flaky test that cannot be fixed yet.
+type QuarantinedTest struct {
+	Package string `json:"package"`
+	// Name is the name of the top level test.
+	Name          string    `json:"name"`
+	QuarantinedAt time.Time `json:"quarantined_at"`
 }
 
 // Run is the representation of a pending test or benchmark that has not
@@ -67,11 +102,34 @@ type Run struct {
 	FinishedAt time.Time `json:"finished_at"`
 	Tests      []*Test   `json:"tests"`
 	Error      string    `json:"error"`
+	// DeadLetteredAt is set when the run was given up on without ever being
+	// started, with DeadLetterReason recording why.
+	DeadLetteredAt   time.Time `json:"dead_lettered_at"`
+	DeadLetterReason string    `json:"dead_letter_reason"`
+	// Labels are inherited from the run's package when the run is scheduled.
+	Labels Labels `json:"labels,omitempty"`
+	// Count is the number of times each test is run, via -test.count. Counts
+	// of 1 or less run each test once.
+	Count int `json:"count,omitempty"`
+	// Variant is the name of the package variant whose test binary is run,
+	// empty for packages without variants.
+	Variant string `json:"variant,omitempty"`
+	// Priority orders pending runs, with higher priority runs claimed first.
+	Priority int `json:"priority,omitempty"`
+	// EnqueuedBy records who or what scheduled the run, eg. slack:<user id>.
+	EnqueuedBy string `json:"enqueued_by,omitempty"`
+	// AlertChannels are the slack channels alerts for the run are sent to
+	// instead of the package's channels, eg. the channel the run was
+	// scheduled from.
+	AlertChannels []string `json:"alert_channels,omitempty"`
 }
 
 // RunMeta is additional metadata associated with the run.
 type RunMeta struct {
-	Runner string `json:"runner"`
+	Runner   string    `json:"runner"`
+	RunnerID uuid.UUID `json:"runner_id"`
+	// Race indicates whether the run used a race enabled test binary.
+	Race bool `json:"race,omitempty"`
 }
 
 func (r *Run) Duration() time.Duration {
@@ -85,6 +143,220 @@ type Package struct {
 	SHA256Sum string        `json:"sha256sum"`
 	RunDelay  time.Duration `json:"run_delay"`
 	Options   []Option      `json:"options"`
+	// Enabled controls whether runs are scheduled for the package. Packages
+	// are enabled unless explicitly disabled.
+	Enabled *bool `json:"enabled,omitempty"`
+	// Labels are used to group and filter packages, and are inherited by the
+	// package's runs and tests.
+	Labels Labels `json:"labels,omitempty"`
+	// Race indicates that the test binary was built with the race detector,
+	// ie. using `go test -c -race`. The race detector can only be enabled at
+	// compile time, so this must match how the binary at Path was built.
+	Race bool `json:"race,omitempty"`
+	// GORACE is the value of the GORACE environment variable used to
+	// configure the race detector when running race enabled test binaries.
+	GORACE string `json:"gorace,omitempty"`
+	// Count is the number of times each test is run by the package's runs,
+	// via -test.count. Counts of 1 or less run each test once.
+	Count int `json:"count,omitempty"`
+	// Variants are alternative test binaries for the package, eg. built with
+	// different build tags or go versions. When set, a run is scheduled for
+	// each variant instead of for Path.
+	Variants []Variant `json:"variants,omitempty"`
+	// Environment is added to the environment of the package's test
+	// binaries.
+	Environment []EnvVar `json:"environment,omitempty"`
+}
+
+// EnvVar is an environment variable set for a package's test binaries.
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value,omitempty"`
+	// Secret indicates that the value must not be logged or returned by
+	// the API, except to runners.
+	Secret bool `json:"secret,omitempty"`
+	// Redacted is set on secrets whose value was removed, so that they can be
+	// told apart from secrets whose value is empty.
+	Redacted bool `json:"redacted,omitempty"`
+}
+
+// String returns the environment variable as NAME=VALUE, with the value of
+// secrets redacted so that it is safe to log.
+func (v EnvVar) String() string {
+	if v.Secret {
+		return v.Name + "=<redacted>"
+	}
+	return v.Name + "=" + v.Value
+}
+
+// Variant is one of a package's alternative test binaries.
+type Variant struct {
+	Name      string `json:"name"`
+	Path      string `json:"path,omitempty"`
+	SHA256Sum string `json:"sha256sum"`
+}
+
+// VariantNames returns the names of the package's variants, or a single empty
+// name for packages without variants.
+func (p *Package) VariantNames() []string {
+	if len(p.Variants) == 0 {
+		return []string{""}
+	}
+
+	names := make([]string, len(p.Variants))
+	for i, variant := range p.Variants {
+		names[i] = variant.Name
+	}
+	return names
+}
+
+// Variant returns the package as configured to use the test binary of the
+// named variant. An empty name selects the package's own test binary, which is
+// only valid for packages without variants.
+func (p *Package) Variant(name string) (*Package, error) {
+	if name == "" {
+		if len(p.Variants) > 0 {
+			return nil, fmt.Errorf("package %s requires a variant, one of: %s", p.Name, strings.Join(p.VariantNames(), ", "))
+		}
+		return p, nil
+	}
+
+	for _, variant := range p.Variants {
+		if variant.Name == name {
+			pkg := *p
+			pkg.Path = variant.Path
+			pkg.SHA256Sum = variant.SHA256Sum
+			pkg.Variants = nil
+			return &pkg, nil
+		}
+	}
+	return nil, fmt.Errorf("package %s has no variant %s", p.Name, name)
+}
+
+// Env returns the package's environment as NAME=VALUE pairs, including the
+// values of secrets.
+func (p *Package) Env() []string {
+	env := make([]string, 0, len(p.Environment))
+	for _, v := range p.Environment {
+		env = append(env, v.Name+"="+v.Value)
+	}
+	return env
+}
+
+// RedactedEnvironment returns a copy of the package's environment with the
+// values of secrets removed and the secrets marked as redacted.
+func (p *Package) RedactedEnvironment() []EnvVar {
+	if p.Environment == nil {
+		return nil
+	}
+	env := make([]EnvVar, len(p.Environment))
+	for i, v := range p.Environment {
+		env[i] = v
+		if v.Secret {
+			env[i].Value = ""
+			env[i].Redacted = true
+		}
+	}
+	return env
+}
+
+// RedactedSecrets returns the names of the package's secrets whose values
+// were redacted.
+func (p *Package) RedactedSecrets() []string {
+	var names []string
+	for _, v := range p.Environment {
+		if v.Secret && v.Redacted {
+			names = append(names, v.Name)
+		}
+	}
+	return names
+}
+
+// IsEnabled returns whether runs should be scheduled for the package.
+func (p *Package) IsEnabled() bool {
+	return p.Enabled == nil || *p.Enabled
+}
+
+// DefaultArgs returns the run args for the package's options that have
+// defaults.
+func (p *Package) DefaultArgs() []string {
+	var args []string
+	for _, option := range p.Options {
+		if option.Default != "" {
+			o := Option{
+				Name:  option.Name,
+				Value: option.Default,
+			}
+			args = append(args, o.String())
+		}
+	}
+	return args
+}
+
+// ValidateArgs validates run args against the package's options, returning
+// the first error encountered parsing them.
+func (p *Package) ValidateArgs(args []string) error {
+	fs := flag.NewFlagSet(p.Name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	for _, option := range p.Options {
+		if option.Name == "" || strings.HasPrefix(option.Name, "-") || strings.ContainsAny(option.Name, "= \t") {
+			return fmt.Errorf("invalid option name %q", option.Name)
+		}
+		if fs.Lookup(option.Name) != nil {
+			return fmt.Errorf("duplicate option %q", option.Name)
+		}
+		fs.String(option.Name, option.Default, option.Description)
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	return nil
+}
+
+// ComputeSHA256Sum computes the sha256 sum of the package's test binary.
+func (p *Package) ComputeSHA256Sum() (string, error) {
+	bin, err := os.Open(p.Path)
+	if err != nil {
+		return "", fmt.Errorf("opening test binary: %w", err)
+	}
+	defer bin.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, bin); err != nil {
+		return "", fmt.Errorf("reading test binary: %w", err)
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+// Labels are key value pairs used for grouping and filtering packages, runs and
+// tests.
+type Labels map[string]string
+
+// ParseLabels parses labels of the form "key:value".
+func ParseLabels(labels []string) (Labels, error) {
+	parsed := make(Labels)
+	for _, label := range labels {
+		parts := strings.SplitN(label, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key:value", label)
+		}
+		parsed[parts[0]] = parts[1]
+	}
+	return parsed, nil
+}
+
+// Matches returns whether the labels include all of the selector's labels.
+func (l Labels) Matches(selector Labels) bool {
+	for key, value := range selector {
+		if v, ok := l[key]; !ok || v != value {
+			return false
+		}
+	}
+	return true
 }
 
 // Option represents an option for how a package can be run.
@@ -101,9 +373,42 @@ func (o *Option) String() string {
 }
 
 type RunSummary struct {
-	Time           time.Time
-	Duration       time.Duration
-	PackageSummary map[string]*PackageSummary
+	Time           time.Time                  `json:"time"`
+	Duration       time.Duration              `json:"duration"`
+	PackageSummary map[string]*PackageSummary `json:"package_summary"`
+}
+
+// MarshalJSON encodes the duration of the summary as a string (eg. "1h0m0s")
+// rather than nanoseconds.
+func (s RunSummary) MarshalJSON() ([]byte, error) {
+	type runSummary RunSummary
+	return json.Marshal(&struct {
+		*runSummary
+		Duration string `json:"duration"`
+	}{
+		runSummary: (*runSummary)(&s),
+		Duration:   s.Duration.String(),
+	})
+}
+
+func (s *RunSummary) UnmarshalJSON(data []byte) error {
+	type runSummary RunSummary
+	aux := &struct {
+		*runSummary
+		Duration string `json:"duration"`
+	}{
+		runSummary: (*runSummary)(s),
+	}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+
+	duration, err := time.ParseDuration(aux.Duration)
+	if err != nil {
+		return fmt.Errorf("parsing duration: %w", err)
+	}
+	s.Duration = duration
+	return nil
 }
 
 func (s *RunSummary) NumRuns() int {
@@ -122,6 +427,20 @@ func (s *RunSummary) NumErrorRuns() int {
 	return total
 }
 
+// NumFailedRuns returns the number of runs that completed with at least one
+// failed test. Unlike error runs, these runs completed successfully.
+func (s *RunSummary) NumFailedRuns() int {
+	var total int
+	for _, pkgSummary := range s.PackageSummary {
+		total += len(pkgSummary.FailedRunIDs)
+	}
+	return total
+}
+
+func (s *RunSummary) PercentFailedRuns() float64 {
+	return float64(s.NumFailedRuns()) / float64(s.NumRuns())
+}
+
 func (s *RunSummary) NumPassedTests() int {
 	var total int
 	for _, pkgSummary := range s.PackageSummary {
@@ -172,13 +491,28 @@ func (s *RunSummary) NumTotalTests() int {
 	return passed + failed + skipped
 }
 
+// PackageHistory is the outcome of the runs of a package started within a
+// bucket of time.
+type PackageHistory struct {
+	Time time.Time `json:"time"`
+	// PassRate is the fraction of runs that passed, 0 if there were no runs.
+	PassRate  float64 `json:"pass_rate"`
+	TotalRuns int     `json:"total_runs"`
+	// FailedRuns are the runs that errored or had at least one failed test.
+	FailedRuns int `json:"failed_runs"`
+}
+
 type PackageSummary struct {
-	Package      string
-	RunIDs       []uuid.UUID
-	ErrorRunIDs  []uuid.UUID
-	PassedTests  map[string][]uuid.UUID
-	FailedTests  map[string][]uuid.UUID
-	SkippedTests map[string][]uuid.UUID
+	Package     string      `json:"package"`
+	RunIDs      []uuid.UUID `json:"run_ids"`
+	ErrorRunIDs []uuid.UUID `json:"error_run_ids"`
+	// FailedRunIDs are the runs that completed with at least one failed test.
+	FailedRunIDs []uuid.UUID `json:"failed_run_ids"`
+	// PassedTests, FailedTests and SkippedTests are the IDs of the tests with
+	// the respective result by test name.
+	PassedTests  map[string][]uuid.UUID `json:"passed_tests"`
+	FailedTests  map[string][]uuid.UUID `json:"failed_tests"`
+	SkippedTests map[string][]uuid.UUID `json:"skipped_tests"`
 }
 
 func (s *PackageSummary) NumPassedTests() int {
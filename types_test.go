@@ -0,0 +1,200 @@
+package tester
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPackage_ValidateArgs(t *testing.T) {
+	pkg := &Package{
+		Name: "pkg",
+		Options: []Option{
+			{Name: "test.run", Default: "TestA"},
+			{Name: "env"},
+		},
+	}
+
+	for _, tc := range []struct {
+		name  string
+		pkg   *Package
+		args  []string
+		valid bool
+	}{
+		{
+			name:  "no args",
+			pkg:   pkg,
+			valid: true,
+		},
+		{
+			name:  "valid args",
+			pkg:   pkg,
+			args:  []string{"-test.run=TestA TestB", "-env=staging"},
+			valid: true,
+		},
+		{
+			name: "unknown flag",
+			pkg:  pkg,
+			args: []string{"-test.count=2"},
+		},
+		{
+			name: "missing value",
+			pkg:  pkg,
+			args: []string{"-env"},
+		},
+		{
+			name: "bad flag syntax",
+			pkg:  pkg,
+			args: []string{"---env=staging"},
+		},
+		{
+			name: "unexpected argument",
+			pkg:  pkg,
+			args: []string{"-env", "staging", "TestA"},
+		},
+		{
+			name: "invalid option name",
+			pkg: &Package{
+				Name:    "pkg",
+				Options: []Option{{Name: "test run"}},
+			},
+		},
+		{
+			name: "duplicate option",
+			pkg: &Package{
+				Name:    "pkg",
+				Options: []Option{{Name: "env"}, {Name: "env"}},
+			},
+		},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.pkg.ValidateArgs(tc.args)
+			if tc.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestPackage_Variant(t *testing.T) {
+	pkg := &Package{Name: "pkg", Path: "/bin/pkg.test", SHA256Sum: "sum"}
+	assert.Equal(t, []string{""}, pkg.VariantNames())
+
+	p, err := pkg.Variant("")
+	assert.NoError(t, err)
+	assert.Equal(t, pkg, p)
+	_, err = pkg.Variant("go1")
+	assert.Error(t, err)
+
+	matrix := &Package{
+		Name: "matrix",
+		Variants: []Variant{
+			{Name: "go1", Path: "/bin/go1.test", SHA256Sum: "go1-sum"},
+			{Name: "go2", Path: "/bin/go2.test", SHA256Sum: "go2-sum"},
+		},
+	}
+	assert.Equal(t, []string{"go1", "go2"}, matrix.VariantNames())
+
+	_, err = matrix.Variant("")
+	assert.Error(t, err)
+	_, err = matrix.Variant("go3")
+	assert.Error(t, err)
+
+	p, err = matrix.Variant("go2")
+	assert.NoError(t, err)
+	assert.Equal(t, "matrix", p.Name)
+	assert.Equal(t, "/bin/go2.test", p.Path)
+	assert.Equal(t, "go2-sum", p.SHA256Sum)
+	assert.Empty(t, p.Variants)
+	assert.Len(t, matrix.Variants, 2, "package should not be modified")
+}
+
+func TestPackage_Environment(t *testing.T) {
+	pkg := &Package{
+		Name: "pkg",
+		Environment: []EnvVar{
+			{Name: "DB_URL", Value: "postgres://db"},
+			{Name: "TOKEN", Value: "secret", Secret: true},
+		},
+	}
+	assert.Equal(t, []string{"DB_URL=postgres://db", "TOKEN=secret"}, pkg.Env())
+	assert.Equal(t, "DB_URL=postgres://db", pkg.Environment[0].String())
+	assert.Equal(t, "TOKEN=<redacted>", pkg.Environment[1].String())
+
+	assert.Equal(t, []EnvVar{
+		{Name: "DB_URL", Value: "postgres://db"},
+		{Name: "TOKEN", Secret: true, Redacted: true},
+	}, pkg.RedactedEnvironment())
+	assert.Equal(t, "secret", pkg.Environment[1].Value, "package should not be modified")
+	assert.Nil(t, (&Package{}).RedactedEnvironment())
+
+	assert.Empty(t, pkg.RedactedSecrets())
+	assert.Equal(t, []string{"TOKEN"}, (&Package{Environment: pkg.RedactedEnvironment()}).RedactedSecrets())
+}
+
+func TestRunSummary_JSON(t *testing.T) {
+	summary := &RunSummary{
+		Time:     time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		Duration: time.Hour,
+		PackageSummary: map[string]*PackageSummary{
+			"pkg": {
+				Package:      "pkg",
+				RunIDs:       []uuid.UUID{uuid.New()},
+				ErrorRunIDs:  []uuid.UUID{uuid.New()},
+				FailedRunIDs: []uuid.UUID{uuid.New()},
+				PassedTests:  map[string][]uuid.UUID{"TestA": {uuid.New()}},
+				FailedTests:  map[string][]uuid.UUID{"TestB": {uuid.New()}},
+				SkippedTests: map[string][]uuid.UUID{"TestC": {uuid.New()}},
+			},
+		},
+	}
+
+	data, err := json.Marshal(summary)
+	require.NoError(t, err)
+
+	var fields map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(data, &fields))
+	assert.Equal(t, `"1h0m0s"`, string(fields["duration"]))
+	assert.Contains(t, fields, "time")
+	assert.Contains(t, fields, "package_summary")
+
+	var decoded RunSummary
+	require.NoError(t, json.Unmarshal(data, &decoded))
+	assert.Equal(t, summary, &decoded)
+
+	assert.Error(t, json.Unmarshal([]byte(`{"duration":"soon"}`), &decoded))
+}
+
+func TestRunSummary_JSONDuration(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		encoded  string
+	}{
+		{0, `"0s"`},
+		{1500 * time.Millisecond, `"1.5s"`},
+		{5 * time.Minute, `"5m0s"`},
+		{24 * time.Hour, `"24h0m0s"`},
+		{90*time.Minute + 30*time.Second, `"1h30m30s"`},
+	}
+	for _, test := range tests {
+		t.Run(test.encoded, func(t *testing.T) {
+			data, err := json.Marshal(RunSummary{Duration: test.duration})
+			require.NoError(t, err)
+
+			var fields map[string]json.RawMessage
+			require.NoError(t, json.Unmarshal(data, &fields))
+			assert.Equal(t, test.encoded, string(fields["duration"]))
+
+			var decoded RunSummary
+			require.NoError(t, json.Unmarshal(data, &decoded))
+			assert.Equal(t, test.duration, decoded.Duration)
+		})
+	}
+}